@@ -1,6 +1,10 @@
 package main
 
 import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
 	"log"
 	"os"
 	"os/signal"
@@ -9,55 +13,84 @@ import (
 	"time"
 
 	"github.com/google/uuid"
-	"github.com/ohowland/cgc_core/internal/lib/asset/ess/virtualess"
-	"github.com/ohowland/cgc_core/internal/lib/asset/feeder/virtualfeeder"
-	"github.com/ohowland/cgc_core/internal/lib/asset/grid/virtualgrid"
-	"github.com/ohowland/cgc_core/internal/lib/bus/ac/virtualacbus"
 	"github.com/ohowland/cgc_core/internal/pkg/asset"
+	"github.com/ohowland/cgc_core/internal/pkg/asset/bms"
 	"github.com/ohowland/cgc_core/internal/pkg/asset/ess"
 	"github.com/ohowland/cgc_core/internal/pkg/asset/feeder"
 	"github.com/ohowland/cgc_core/internal/pkg/asset/grid"
+	"github.com/ohowland/cgc_core/internal/pkg/asset/pv"
 	"github.com/ohowland/cgc_core/internal/pkg/bus"
 	"github.com/ohowland/cgc_core/internal/pkg/bus/ac"
+	"github.com/ohowland/cgc_core/internal/pkg/bus/dc"
+	"github.com/ohowland/cgc_core/internal/pkg/datastreams/dnp3server"
+	"github.com/ohowland/cgc_core/internal/pkg/datastreams/modbusserver"
+	"github.com/ohowland/cgc_core/internal/pkg/datastreams/mongodb"
+	"github.com/ohowland/cgc_core/internal/pkg/datastreams/mqtt"
 	"github.com/ohowland/cgc_core/internal/pkg/datastreams/natshandler"
+	"github.com/ohowland/cgc_core/internal/pkg/datastreams/promexporter"
+	"github.com/ohowland/cgc_core/internal/pkg/datastreams/restapi"
+	"github.com/ohowland/cgc_core/internal/pkg/datastreams/sqldb"
+	"github.com/ohowland/cgc_core/internal/pkg/datastreams/wsstream"
 	"github.com/ohowland/cgc_core/internal/pkg/dispatch"
+	"github.com/ohowland/cgc_core/internal/pkg/dispatch/lpdispatch"
 	"github.com/ohowland/cgc_core/internal/pkg/dispatch/manualdispatch"
+	"github.com/ohowland/cgc_core/internal/pkg/driver"
 	"github.com/ohowland/cgc_core/internal/pkg/root"
+	"github.com/ohowland/cgc_core/internal/pkg/site"
+
+	// drivers register themselves with the archetype packages
+	_ "github.com/ohowland/cgc_core/internal/lib/asset/bms/modbusbms"
+	_ "github.com/ohowland/cgc_core/internal/lib/asset/bms/virtualbms"
+	_ "github.com/ohowland/cgc_core/internal/lib/asset/ess/modbusess"
+	_ "github.com/ohowland/cgc_core/internal/lib/asset/ess/sunspecess"
+	_ "github.com/ohowland/cgc_core/internal/lib/asset/ess/virtualess"
+	_ "github.com/ohowland/cgc_core/internal/lib/asset/feeder/modbusfeeder"
+	_ "github.com/ohowland/cgc_core/internal/lib/asset/feeder/virtualfeeder"
+	_ "github.com/ohowland/cgc_core/internal/lib/asset/grid/modbusgrid"
+	_ "github.com/ohowland/cgc_core/internal/lib/asset/grid/virtualgrid"
+	_ "github.com/ohowland/cgc_core/internal/lib/asset/pv/modbuspv"
+	_ "github.com/ohowland/cgc_core/internal/lib/asset/pv/sunspecpv"
+	_ "github.com/ohowland/cgc_core/internal/lib/asset/pv/virtualpv"
+	_ "github.com/ohowland/cgc_core/internal/lib/bus/ac/virtualacbus"
+	_ "github.com/ohowland/cgc_core/internal/lib/bus/dc/virtualdcbus"
 )
 
 func main() {
+	sitePath := flag.String("site", "./config/site.json", "path to the site configuration file")
+	flag.Parse()
+
 	log.Println("[Main] Starting CGC_Core v0.0.1")
 	sigs1 := make(chan os.Signal, 1)
 	sigs2 := make(chan os.Signal, 1)
 	signal.Notify(sigs1, syscall.SIGINT, syscall.SIGTERM)
 	signal.Notify(sigs2, syscall.SIGINT, syscall.SIGTERM)
 
-	log.Println("[Main] Building Buses")
-	buses, err := buildBuses()
+	log.Println("[Main] Reading Site Configuration", *sitePath)
+	cfg, err := site.ReadConfig(*sitePath)
 	if err != nil {
 		panic(err)
 	}
 
-	var bus *ac.Bus
-	for _, v := range buses {
-		bus = v.(*ac.Bus) // random root bus
-		break
+	log.Println("[Main] Building Buses")
+	buses, err := buildBuses(cfg.Buses)
+	if err != nil {
+		panic(err)
 	}
 
 	log.Println("[Main] Building Assets")
-	assets, err := buildAssets(bus)
+	assets, err := buildAssets(cfg.Assets, buses)
 	if err != nil {
 		panic(err)
 	}
 
 	log.Println("[Main] Assembling Bus Graph")
-	busGraph, err := buildBusGraph(bus, buses, assets)
+	busGraph, err := buildBusGraph(cfg, buses, assets)
 	if err != nil {
 		panic(err)
 	}
 
 	log.Println("[Main] Building Dispatcher")
-	dispatch, err := buildDispatch()
+	dispatch, err := buildDispatch(cfg.Dispatch)
 	if err != nil {
 		panic(err)
 	}
@@ -69,16 +102,13 @@ func main() {
 	}
 
 	log.Println("[Main] Starting Datastreams")
-	err = startDatastream(&system)
+	err = startDatastreams(cfg.Datastreams, &system)
 	if err != nil {
 		panic(err)
 	}
 
 	log.Println("[Main] Propigate Configuration")
 	propigateConfigurations(buses, assets)
-	if err != nil {
-		panic(err)
-	}
 
 	log.Println("[Main] Starting update loops")
 	var wg sync.WaitGroup
@@ -126,99 +156,236 @@ loop:
 	wg.Done()
 }
 
-func buildAssets(bus *ac.Bus) (map[uuid.UUID]asset.Asset, error) {
-	assets := make(map[uuid.UUID]asset.Asset)
-
-	grid := buildVirtualGridAsset(bus)
-	ess := buildVirtualESSAsset(bus)
-	feeder := buildVirtualFeederAsset(bus)
-
-	assets[ess.PID()] = ess
-	assets[grid.PID()] = grid
-	assets[feeder.PID()] = feeder
+// buildBuses returns the site buses indexed by name.
+func buildBuses(cfgs []site.BusConfig) (map[string]bus.Bus, error) {
+	buses := make(map[string]bus.Bus)
+	for _, cfg := range cfgs {
+		b, err := buildBus(cfg)
+		if err != nil {
+			return buses, err
+		}
 
-	return assets, nil
+		if b.Name() != cfg.Name {
+			err := fmt.Sprintf("bus configuration %v names bus %v, site expects %v", cfg.Config, b.Name(), cfg.Name)
+			return buses, errors.New(err)
+		}
+		buses[cfg.Name] = b
+	}
+	return buses, nil
 }
 
-func buildVirtualGridAsset(bus *ac.Bus) *grid.Asset {
-	grid, err := virtualgrid.New("./config/asset/virtualGrid.json")
+func buildBus(cfg site.BusConfig) (bus.Bus, error) {
+	jsonConfig, err := ioutil.ReadFile(cfg.Config)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 
-	vrBus := bus.Relayer().(*virtualacbus.VirtualACBus)
-	vrGrid := grid.DeviceController().(*virtualgrid.VirtualGrid)
-	vrBus.AddMember(vrGrid)
-
-	return &grid
+	switch cfg.Type {
+	case "ac":
+		b, err := ac.NewWithDriver(cfg.Driver, jsonConfig)
+		return &b, err
+	case "dc":
+		b, err := dc.NewWithDriver(cfg.Driver, jsonConfig)
+		return &b, err
+	}
+	err = errors.New(fmt.Sprintf("unsupported bus type %v", cfg.Type))
+	return nil, err
 }
 
-func buildVirtualESSAsset(bus *ac.Bus) *ess.Asset {
-	ess, err := virtualess.New("./config/asset/virtualESS.json")
-	if err != nil {
-		panic(err)
-	}
+// buildAssets returns the site assets indexed by PID. Virtual devices are linked
+// to the virtual system of the bus they are a member of.
+func buildAssets(cfgs []site.AssetConfig, buses map[string]bus.Bus) (map[uuid.UUID]asset.Asset, error) {
+	assets := make(map[uuid.UUID]asset.Asset)
+	for _, cfg := range cfgs {
+		a, device, err := buildAsset(cfg)
+		if err != nil {
+			return assets, err
+		}
 
-	vrBus := bus.Relayer().(*virtualacbus.VirtualACBus)
-	vrEss := ess.DeviceController().(*virtualess.VirtualESS)
-	vrBus.AddMember(vrEss)
+		b, ok := buses[a.BusName()]
+		if !ok {
+			err := fmt.Sprintf("asset %v is a member of unknown bus %v", a.Name(), a.BusName())
+			return assets, errors.New(err)
+		}
 
-	return &ess
+		err = linkVirtualDevice(b, device)
+		if err != nil {
+			return assets, err
+		}
+		assets[a.PID()] = a
+	}
+	return assets, nil
 }
 
-func buildVirtualFeederAsset(bus *ac.Bus) *feeder.Asset {
-	feeder, err := virtualfeeder.New("./config/asset/virtualFeeder.json")
+// buildAsset returns the asset and its device controller, as provided by the configured driver.
+func buildAsset(cfg site.AssetConfig) (asset.Asset, interface{}, error) {
+	jsonConfig, err := driver.ReadConfig(cfg.Config)
 	if err != nil {
-		panic(err)
+		return nil, nil, err
 	}
 
-	vrBus := bus.Relayer().(*virtualacbus.VirtualACBus)
-	vrFeeder := feeder.DeviceController().(*virtualfeeder.VirtualFeeder)
-	vrBus.AddMember(vrFeeder)
-
-	return &feeder
+	switch cfg.Archetype {
+	case "ess":
+		a, err := ess.NewWithDriver(cfg.Driver, jsonConfig)
+		return &a, a.DeviceController(), err
+	case "grid":
+		a, err := grid.NewWithDriver(cfg.Driver, jsonConfig)
+		return &a, a.DeviceController(), err
+	case "feeder":
+		a, err := feeder.NewWithDriver(cfg.Driver, jsonConfig)
+		return &a, a.DeviceController(), err
+	case "pv":
+		a, err := pv.NewWithDriver(cfg.Driver, jsonConfig)
+		return &a, a.DeviceController(), err
+	case "bms":
+		a, err := bms.NewWithDriver(cfg.Driver, jsonConfig)
+		return &a, a.DeviceController(), err
+	}
+	err = errors.New(fmt.Sprintf("unsupported asset archetype %v", cfg.Archetype))
+	return nil, nil, err
 }
 
-func buildBus() (ac.Bus, error) {
-	vrBus, err := virtualacbus.New("./config/bus/virtualACBus.json")
-	return vrBus, err
+// linkVirtualDevice joins a virtual device to the virtual system behind the bus relayer.
+// Devices that are not virtual are not linked.
+func linkVirtualDevice(b bus.Bus, device interface{}) error {
+	switch b := b.(type) {
+	case *ac.Bus:
+		vrDevice, ok := device.(asset.VirtualACAsset)
+		if !ok {
+			return nil
+		}
+		if vrBus, ok := b.Relayer().(ac.VirtualRelayer); ok {
+			vrBus.AddMember(vrDevice)
+			return nil
+		}
+	case *dc.Bus:
+		vrDevice, ok := device.(asset.VirtualDCAsset)
+		if !ok {
+			return nil
+		}
+		if vrBus, ok := b.Relayer().(dc.VirtualRelayer); ok {
+			vrBus.AddMember(vrDevice)
+			return nil
+		}
+	}
+	err := fmt.Sprintf("virtual device %T cannot be linked to bus %v", device, b.Name())
+	return errors.New(err)
 }
 
-func buildBuses() (map[uuid.UUID]bus.Bus, error) {
-	buses := make(map[uuid.UUID]bus.Bus)
-	vrBus1, err := virtualacbus.New("./config/bus/virtualACBus1.json")
-	buses[vrBus1.PID()] = &vrBus1
+// buildBusGraph links the buses according to the site topology, then joins assets to their buses.
+func buildBusGraph(cfg site.Config, buses map[string]bus.Bus, assets map[uuid.UUID]asset.Asset) (bus.BusGraph, error) {
+	g, err := bus.NewBusGraph()
+	if err != nil {
+		return bus.BusGraph{}, err
+	}
 
+	ordered, err := cfg.OrderedBuses()
 	if err != nil {
-		return buses, err
+		return bus.BusGraph{}, err
 	}
 
-	vrBus2, err := virtualacbus.New("./config/bus/virtualACBus2.json")
-	buses[vrBus2.PID()] = &vrBus2
+	for _, busCfg := range ordered {
+		if busCfg.Name == cfg.RootBus {
+			err = g.AddMember(buses[busCfg.Name])
+		} else {
+			err = g.AddChildBus(buses[busCfg.Parent], buses[busCfg.Name])
+		}
+		if err != nil {
+			return bus.BusGraph{}, err
+		}
+	}
 
-	return buses, err
-}
+	for _, asset := range assets {
+		err = g.AddMember(asset)
+		if err != nil {
+			return bus.BusGraph{}, err
+		}
+	}
 
-func buildBusGraph(rootBus bus.Bus, buses map[uuid.UUID]bus.Bus, assets map[uuid.UUID]asset.Asset) (bus.BusGraph, error) {
-	g, err := bus.BuildBusGraph(rootBus, buses, assets)
-	return g, err
+	return g, nil
 }
 
-func buildDispatch() (dispatch.Dispatcher, error) {
-	return manualdispatch.New("./config/dispatch/manualdispatch.json")
+func buildDispatch(cfg site.DispatchConfig) (dispatch.Dispatcher, error) {
+	switch cfg.Type {
+	case "manual":
+		return manualdispatch.New(cfg.Config)
+	case "lp":
+		return lpdispatch.New(cfg.Config)
+	}
+	err := fmt.Sprintf("unsupported dispatch type %v", cfg.Type)
+	return nil, errors.New(err)
 }
 
 func buildSystem(g *bus.BusGraph, d dispatch.Dispatcher) (root.System, error) {
 	return root.NewSystem(g, d)
 }
 
-func startDatastream(sys *root.System) error {
-	n, err := natshandler.New("./config/datastream/nats_config.json", sys)
-	go n.Process()
-	return err
+func startDatastreams(cfgs []site.DatastreamConfig, sys *root.System) error {
+	for _, cfg := range cfgs {
+		switch cfg.Type {
+		case "mongodb":
+			h, err := mongodb.New(cfg.Config, sys)
+			if err != nil {
+				return err
+			}
+			go h.Process()
+		case "sqldb":
+			h, err := sqldb.New(cfg.Config, sys)
+			if err != nil {
+				return err
+			}
+			go h.Process()
+		case "nats":
+			h, err := natshandler.New(cfg.Config, sys)
+			if err != nil {
+				return err
+			}
+			go h.Process()
+		case "mqtt":
+			h, err := mqtt.New(cfg.Config, sys)
+			if err != nil {
+				return err
+			}
+			go h.Process()
+		case "modbus":
+			h, err := modbusserver.New(cfg.Config, sys)
+			if err != nil {
+				return err
+			}
+			go h.Process()
+		case "dnp3":
+			h, err := dnp3server.New(cfg.Config, sys)
+			if err != nil {
+				return err
+			}
+			go h.Process()
+		case "rest":
+			h, err := restapi.New(cfg.Config, sys)
+			if err != nil {
+				return err
+			}
+			go h.Process()
+		case "websocket":
+			h, err := wsstream.New(cfg.Config, sys)
+			if err != nil {
+				return err
+			}
+			go h.Process()
+		case "prometheus":
+			h, err := promexporter.New(cfg.Config, sys)
+			if err != nil {
+				return err
+			}
+			go h.Process()
+		default:
+			err := fmt.Sprintf("unsupported datastream type %v", cfg.Type)
+			return errors.New(err)
+		}
+	}
+	return nil
 }
 
-func propigateConfigurations(buses map[uuid.UUID]bus.Bus, assets map[uuid.UUID]asset.Asset) {
+func propigateConfigurations(buses map[string]bus.Bus, assets map[uuid.UUID]asset.Asset) {
 	for _, bus := range buses {
 		bus.UpdateConfig()
 	}
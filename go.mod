@@ -3,11 +3,16 @@ module github.com/ohowland/cgc_core
 go 1.13
 
 require (
-	github.com/eclipse/paho.mqtt.golang v1.3.3 // indirect
+	github.com/eclipse/paho.mqtt.golang v1.3.3
 	github.com/go-sql-driver/mysql v1.5.0
 	github.com/goburrow/modbus v0.1.0
 	github.com/goburrow/serial v0.1.0 // indirect
 	github.com/google/uuid v1.2.0
+	github.com/gorilla/websocket v1.4.2
+	github.com/mattn/go-sqlite3 v1.14.6
+	github.com/nats-io/nats-server/v2 v2.2.1
+	github.com/nats-io/nats.go v1.10.1-0.20210330225420-a0b1f60162f8
+	github.com/tbrandon/mbserver v0.0.0-20170611213546-993e1772cc62
 	go.mongodb.org/mongo-driver v1.3.4
 	google.golang.org/protobuf v1.24.0 // indirect
 	gotest.tools v2.2.0+incompatible
@@ -0,0 +1,52 @@
+package modbusbms
+
+import (
+	"github.com/ohowland/cgc_core/internal/lib/asset/modbusdevice"
+	"github.com/ohowland/cgc_core/internal/pkg/asset/bms"
+	"github.com/ohowland/cgc_core/internal/pkg/driver"
+)
+
+// ModbusBMS is a bms.DeviceController for BMS hardware with a Modbus interface
+type ModbusBMS struct {
+	device *modbusdevice.Device
+}
+
+func init() {
+	bms.Register("modbus", newDevice)
+}
+
+// New returns an initialized ModbusBMS Asset; this is part of the Asset interface.
+func New(configPath string) (bms.Asset, error) {
+	jsonConfig, err := driver.ReadConfig(configPath)
+	if err != nil {
+		return bms.Asset{}, err
+	}
+
+	return bms.NewWithDriver("modbus", jsonConfig)
+}
+
+// newDevice is the bms.DeviceFactory for the modbus device
+func newDevice(jsonConfig []byte) (bms.DeviceController, error) {
+	device, err := modbusdevice.New(jsonConfig, bms.MachineStatus{}, bms.MachineControl{})
+	if err != nil {
+		return nil, err
+	}
+	return &ModbusBMS{device}, nil
+}
+
+// ReadDeviceStatus requests a physical device read over the communication interface
+func (d *ModbusBMS) ReadDeviceStatus() (bms.MachineStatus, error) {
+	status := bms.MachineStatus{}
+	err := d.device.ReadStatus(&status)
+	return status, err
+}
+
+// WriteDeviceControl requests a physical device write over the communication interface
+func (d *ModbusBMS) WriteDeviceControl(machineControl bms.MachineControl) error {
+	return d.device.WriteControl(machineControl)
+}
+
+// Stop closes the connection to the device
+func (d *ModbusBMS) Stop() error {
+	return d.device.Stop()
+}
@@ -103,6 +103,10 @@ func (a VirtualBMS) write(control Control) error {
 	return nil
 }
 
+func init() {
+	bms.Register("virtual", newDevice)
+}
+
 // New returns an initalized VirtualBMS Asset; this is part of the Asset interface.
 func New(configPath string) (bms.Asset, error) {
 	jsonConfig, err := ioutil.ReadFile(configPath)
@@ -110,9 +114,14 @@ func New(configPath string) (bms.Asset, error) {
 		return bms.Asset{}, err
 	}
 
+	return bms.NewWithDriver("virtual", jsonConfig)
+}
+
+// newDevice is the bms.DeviceFactory for the virtual device
+func newDevice(jsonConfig []byte) (bms.DeviceController, error) {
 	pid, err := uuid.NewUUID()
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 
 	device := VirtualBMS{
@@ -120,7 +129,7 @@ func New(configPath string) (bms.Asset, error) {
 		comm: virtualHardware{},
 	}
 
-	return bms.New(jsonConfig, &device)
+	return &device, nil
 }
 
 // Status maps bms.DeviceStatus to bms.Status
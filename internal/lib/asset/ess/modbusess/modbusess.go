@@ -0,0 +1,52 @@
+package modbusess
+
+import (
+	"github.com/ohowland/cgc_core/internal/lib/asset/modbusdevice"
+	"github.com/ohowland/cgc_core/internal/pkg/asset/ess"
+	"github.com/ohowland/cgc_core/internal/pkg/driver"
+)
+
+// ModbusESS is a ess.DeviceController for ESS hardware with a Modbus interface
+type ModbusESS struct {
+	device *modbusdevice.Device
+}
+
+func init() {
+	ess.Register("modbus", newDevice)
+}
+
+// New returns an initialized ModbusESS Asset; this is part of the Asset interface.
+func New(configPath string) (ess.Asset, error) {
+	jsonConfig, err := driver.ReadConfig(configPath)
+	if err != nil {
+		return ess.Asset{}, err
+	}
+
+	return ess.NewWithDriver("modbus", jsonConfig)
+}
+
+// newDevice is the ess.DeviceFactory for the modbus device
+func newDevice(jsonConfig []byte) (ess.DeviceController, error) {
+	device, err := modbusdevice.New(jsonConfig, ess.MachineStatus{}, ess.MachineControl{})
+	if err != nil {
+		return nil, err
+	}
+	return &ModbusESS{device}, nil
+}
+
+// ReadDeviceStatus requests a physical device read over the communication interface
+func (d *ModbusESS) ReadDeviceStatus() (ess.MachineStatus, error) {
+	status := ess.MachineStatus{}
+	err := d.device.ReadStatus(&status)
+	return status, err
+}
+
+// WriteDeviceControl requests a physical device write over the communication interface
+func (d *ModbusESS) WriteDeviceControl(machineControl ess.MachineControl) error {
+	return d.device.WriteControl(machineControl)
+}
+
+// Stop closes the connection to the device
+func (d *ModbusESS) Stop() error {
+	return d.device.Stop()
+}
@@ -0,0 +1,22 @@
+package modbusess
+
+import (
+	"testing"
+
+	"gotest.tools/assert"
+)
+
+func TestNew(t *testing.T) {
+	configPath := "modbusess_test_config.json"
+	ess, err := New(configPath)
+	assert.NilError(t, err)
+
+	assert.Assert(t, ess.Name() == "TEST_Modbus ESS")
+	_, ok := ess.DeviceController().(*ModbusESS)
+	assert.Assert(t, ok)
+}
+
+func TestNewBadMapping(t *testing.T) {
+	_, err := newDevice([]byte(`{"Modbus": {"Status": {"Watts": "W"}}}`))
+	assert.Error(t, err, "modbus: MachineStatus has no field Watts")
+}
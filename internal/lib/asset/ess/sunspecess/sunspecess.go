@@ -0,0 +1,138 @@
+package sunspecess
+
+import (
+	"io/ioutil"
+	"math"
+	"sync"
+
+	"github.com/ohowland/cgc_core/internal/pkg/asset"
+	"github.com/ohowland/cgc_core/internal/pkg/asset/ess"
+	"github.com/ohowland/cgc_core/internal/pkg/comm/modbuscomm"
+	"github.com/ohowland/cgc_core/internal/pkg/comm/modbuscomm/sunspec"
+)
+
+// StorCtl_Mod bits of the storage model
+const (
+	storCharge    = 1
+	storDischarge = 2
+)
+
+// SunSpecESS is an ess.DeviceController for SunSpec compliant storage inverters. Power
+// and state of charge are read from the inverter, storage (124) and battery (802)
+// models, the battery state of charge is preferred when the device has both.
+type SunSpecESS struct {
+	mux      *sync.Mutex
+	device   *sunspec.Device
+	kwMax    float64
+	kwChaMax float64
+}
+
+func init() {
+	ess.Register("sunspec", newDevice)
+}
+
+// New returns an initialized SunSpecESS Asset; this is part of the Asset interface.
+func New(configPath string) (ess.Asset, error) {
+	jsonConfig, err := ioutil.ReadFile(configPath)
+	if err != nil {
+		return ess.Asset{}, err
+	}
+
+	return ess.NewWithDriver("sunspec", jsonConfig)
+}
+
+// newDevice is the ess.DeviceFactory for the sunspec device
+func newDevice(jsonConfig []byte) (ess.DeviceController, error) {
+	device, err := sunspec.New(jsonConfig)
+	if err != nil {
+		return nil, err
+	}
+	return &SunSpecESS{mux: &sync.Mutex{}, device: device}, nil
+}
+
+// ReadDeviceStatus requests a physical device read over the communication interface.
+// An inverter that cannot be discovered reads as comm-fail.
+func (d *SunSpecESS) ReadDeviceStatus() (ess.MachineStatus, error) {
+	storage := []string{
+		sunspec.Name(sunspec.Storage, "WChaMax"),
+		sunspec.Name(sunspec.Storage, "ChaState"),
+		sunspec.Name(sunspec.Storage, "OutWRte"),
+		sunspec.Name(sunspec.Storage, "InWRte"),
+		sunspec.Name(sunspec.Battery, "SoC"),
+	}
+	inverter, points, err := d.device.ReadInverter(storage...)
+	if err != nil {
+		return ess.MachineStatus{Quality: asset.CommFail}, nil
+	}
+
+	status := ess.MachineStatus{
+		KW:                   inverter.KW,
+		KVAR:                 inverter.KVAR,
+		Hz:                   inverter.Hz,
+		Volts:                inverter.Volts,
+		RealPositiveCapacity: inverter.KWMax,
+		RealNegativeCapacity: inverter.KWMax,
+		Online:               inverter.Online,
+		Quality:              inverter.Quality,
+	}
+
+	if p, ok := points[sunspec.Name(sunspec.Storage, "WChaMax")]; ok && p.Quality == asset.Good {
+		status.RealNegativeCapacity = p.Value / 1000
+	}
+	if p, ok := soc(points); ok {
+		status.SOC = p.Value
+		status.Quality = asset.Worst(status.Quality, p.Quality)
+	}
+
+	d.mux.Lock()
+	d.kwMax = status.RealPositiveCapacity
+	d.kwChaMax = status.RealNegativeCapacity
+	d.mux.Unlock()
+	return status, nil
+}
+
+// soc returns the battery model state of charge, or the storage model state of charge
+// when the device has no battery model
+func soc(points map[string]modbuscomm.Point) (modbuscomm.Point, bool) {
+	if p, ok := points[sunspec.Name(sunspec.Battery, "SoC")]; ok {
+		return p, true
+	}
+	p, ok := points[sunspec.Name(sunspec.Storage, "ChaState")]
+	return p, ok
+}
+
+// WriteDeviceControl requests a physical device write over the communication
+// interface. Positive KW discharges and negative KW charges the storage, as a
+// percentage of the maximum discharge and charge power last read. Gridform has no
+// SunSpec point and is not written.
+func (d *SunSpecESS) WriteDeviceControl(machineControl ess.MachineControl) error {
+	d.mux.Lock()
+	kwMax, kwChaMax := d.kwMax, d.kwChaMax
+	d.mux.Unlock()
+
+	values := d.device.InverterControls(machineControl.Run, kwMax, machineControl.KVAR)
+	if d.device.Has(sunspec.Storage) && kwMax > 0 && kwChaMax > 0 {
+		if machineControl.KW >= 0 {
+			values[sunspec.Name(sunspec.Storage, "StorCtl_Mod")] = storDischarge
+			values[sunspec.Name(sunspec.Storage, "OutWRte")] = percent(machineControl.KW, kwMax)
+		} else {
+			values[sunspec.Name(sunspec.Storage, "StorCtl_Mod")] = storCharge
+			values[sunspec.Name(sunspec.Storage, "InWRte")] = percent(-machineControl.KW, kwChaMax)
+		}
+	}
+
+	if len(values) == 0 {
+		return nil
+	}
+	return d.device.Write(values)
+}
+
+// percent returns kw as a percentage of kwMax, limited to 0 through 100 percent
+func percent(kw float64, kwMax float64) float64 {
+	return math.Max(0, math.Min(100, kw/kwMax*100))
+}
+
+// Stop closes the connection to the device
+func (d *SunSpecESS) Stop() error {
+	return d.device.Close()
+}
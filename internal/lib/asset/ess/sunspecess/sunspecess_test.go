@@ -0,0 +1,93 @@
+package sunspecess
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/ohowland/cgc_core/internal/pkg/asset"
+	"github.com/ohowland/cgc_core/internal/pkg/asset/ess"
+	"github.com/ohowland/cgc_core/internal/pkg/comm/modbuscomm/sunspec/sunspectest"
+	"gotest.tools/assert"
+)
+
+// newTestDevice returns a SunSpecESS served the register image, and a function that
+// closes the device and server
+func newTestDevice(t *testing.T, imagePath string) (*SunSpecESS, func()) {
+	server, err := sunspectest.NewServer(imagePath)
+	assert.NilError(t, err)
+
+	jsonConfig, err := json.Marshal(map[string]interface{}{
+		"SunSpec": map[string]interface{}{"Poller": server.Config},
+	})
+	assert.NilError(t, err)
+	device, err := newDevice(jsonConfig)
+	assert.NilError(t, err)
+
+	d := device.(*SunSpecESS)
+	return d, func() {
+		d.Stop()
+		server.Close()
+	}
+}
+
+func TestNew(t *testing.T) {
+	configPath := "sunspecess_test_config.json"
+	ess, err := New(configPath)
+	assert.NilError(t, err)
+
+	assert.Assert(t, ess.Name() == "TEST_SunSpec ESS")
+	_, ok := ess.DeviceController().(*SunSpecESS)
+	assert.Assert(t, ok)
+}
+
+func TestReadDeviceStatus(t *testing.T) {
+	d, closeDevice := newTestDevice(t, sunspectest.Image("ess"))
+	defer closeDevice()
+
+	status, err := d.ReadDeviceStatus()
+	assert.NilError(t, err)
+	assert.Equal(t, status, ess.MachineStatus{
+		KW:                   -5,
+		KVAR:                 0.2,
+		Hz:                   60,
+		Volts:                277,
+		RealPositiveCapacity: 30,
+		RealNegativeCapacity: 25,
+		SOC:                  65,
+		Online:               true,
+		Quality:              asset.Good,
+	})
+}
+
+func TestReadDeviceStatusNoDevice(t *testing.T) {
+	d, closeDevice := newTestDevice(t, "")
+	defer closeDevice()
+
+	status, err := d.ReadDeviceStatus()
+	assert.NilError(t, err)
+	assert.Equal(t, status.Quality, asset.CommFail)
+}
+
+func TestWriteDeviceControl(t *testing.T) {
+	d, closeDevice := newTestDevice(t, sunspectest.Image("ess"))
+	defer closeDevice()
+
+	_, err := d.ReadDeviceStatus()
+	assert.NilError(t, err)
+
+	err = d.WriteDeviceControl(ess.MachineControl{Run: true, KW: -10, KVAR: 3})
+	assert.NilError(t, err)
+	points, err := d.device.Read("123.Conn", "123.VArWMaxPct", "124.StorCtl_Mod", "124.InWRte")
+	assert.NilError(t, err)
+	assert.Equal(t, points["123.Conn"].Value, 1.0)
+	assert.Equal(t, points["123.VArWMaxPct"].Value, 10.0)
+	assert.Equal(t, points["124.StorCtl_Mod"].Value, 1.0)
+	assert.Equal(t, points["124.InWRte"].Value, 40.0)
+
+	err = d.WriteDeviceControl(ess.MachineControl{Run: true, KW: 15})
+	assert.NilError(t, err)
+	points, err = d.device.Read("124.StorCtl_Mod", "124.OutWRte")
+	assert.NilError(t, err)
+	assert.Equal(t, points["124.StorCtl_Mod"].Value, 2.0)
+	assert.Equal(t, points["124.OutWRte"].Value, 50.0)
+}
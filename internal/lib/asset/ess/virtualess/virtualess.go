@@ -120,6 +120,10 @@ func (a VirtualESS) write(control Control) error {
 	return nil
 }
 
+func init() {
+	ess.Register("virtual", newDevice)
+}
+
 // New returns an initalized VirtualESS Asset; this is part of the Asset interface.
 func New(configPath string) (ess.Asset, error) {
 	jsonConfig, err := ioutil.ReadFile(configPath)
@@ -127,9 +131,14 @@ func New(configPath string) (ess.Asset, error) {
 		return ess.Asset{}, err
 	}
 
+	return ess.NewWithDriver("virtual", jsonConfig)
+}
+
+// newDevice is the ess.DeviceFactory for the virtual device
+func newDevice(jsonConfig []byte) (ess.DeviceController, error) {
 	pid, err := uuid.NewUUID()
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 
 	device := VirtualESS{
@@ -137,7 +146,7 @@ func New(configPath string) (ess.Asset, error) {
 		comm: virtualHardware{},
 	}
 
-	return ess.New(jsonConfig, &device)
+	return &device, nil
 }
 
 // Status maps ess.DeviceStatus to ess.Status
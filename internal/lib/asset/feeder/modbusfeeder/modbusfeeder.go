@@ -0,0 +1,52 @@
+package modbusfeeder
+
+import (
+	"github.com/ohowland/cgc_core/internal/lib/asset/modbusdevice"
+	"github.com/ohowland/cgc_core/internal/pkg/asset/feeder"
+	"github.com/ohowland/cgc_core/internal/pkg/driver"
+)
+
+// ModbusFeeder is a feeder.DeviceController for Feeder hardware with a Modbus interface
+type ModbusFeeder struct {
+	device *modbusdevice.Device
+}
+
+func init() {
+	feeder.Register("modbus", newDevice)
+}
+
+// New returns an initialized ModbusFeeder Asset; this is part of the Asset interface.
+func New(configPath string) (feeder.Asset, error) {
+	jsonConfig, err := driver.ReadConfig(configPath)
+	if err != nil {
+		return feeder.Asset{}, err
+	}
+
+	return feeder.NewWithDriver("modbus", jsonConfig)
+}
+
+// newDevice is the feeder.DeviceFactory for the modbus device
+func newDevice(jsonConfig []byte) (feeder.DeviceController, error) {
+	device, err := modbusdevice.New(jsonConfig, feeder.MachineStatus{}, feeder.MachineControl{})
+	if err != nil {
+		return nil, err
+	}
+	return &ModbusFeeder{device}, nil
+}
+
+// ReadDeviceStatus requests a physical device read over the communication interface
+func (d *ModbusFeeder) ReadDeviceStatus() (feeder.MachineStatus, error) {
+	status := feeder.MachineStatus{}
+	err := d.device.ReadStatus(&status)
+	return status, err
+}
+
+// WriteDeviceControl requests a physical device write over the communication interface
+func (d *ModbusFeeder) WriteDeviceControl(machineControl feeder.MachineControl) error {
+	return d.device.WriteControl(machineControl)
+}
+
+// Stop closes the connection to the device
+func (d *ModbusFeeder) Stop() error {
+	return d.device.Stop()
+}
@@ -131,6 +131,10 @@ func (a VirtualFeeder) write(control Control) error {
 	return nil
 }
 
+func init() {
+	feeder.Register("virtual", newDevice)
+}
+
 // New returns an initalized VirtualFeeder Asset; this is part of the Asset interface.
 func New(configPath string) (feeder.Asset, error) {
 	jsonConfig, err := ioutil.ReadFile(configPath)
@@ -138,14 +142,21 @@ func New(configPath string) (feeder.Asset, error) {
 		return feeder.Asset{}, err
 	}
 
+	return feeder.NewWithDriver("virtual", jsonConfig)
+}
+
+// newDevice is the feeder.DeviceFactory for the virtual device
+func newDevice(jsonConfig []byte) (feeder.DeviceController, error) {
 	load := virtualLoad{}
-	err = json.Unmarshal(jsonConfig, &load)
+	err := json.Unmarshal(jsonConfig, &load)
 	if err != nil {
-		log.Fatal("dang")
-		return feeder.Asset{}, err
+		return nil, err
 	}
 
 	pid, err := uuid.NewUUID()
+	if err != nil {
+		return nil, err
+	}
 
 	device := VirtualFeeder{
 		pid:  pid,
@@ -153,7 +164,7 @@ func New(configPath string) (feeder.Asset, error) {
 		load: load,
 	}
 
-	return feeder.New(jsonConfig, &device)
+	return &device, nil
 }
 
 // Status maps feeder.DeviceStatus to feeder.Status
@@ -0,0 +1,52 @@
+package modbusgrid
+
+import (
+	"github.com/ohowland/cgc_core/internal/lib/asset/modbusdevice"
+	"github.com/ohowland/cgc_core/internal/pkg/asset/grid"
+	"github.com/ohowland/cgc_core/internal/pkg/driver"
+)
+
+// ModbusGrid is a grid.DeviceController for Grid hardware with a Modbus interface
+type ModbusGrid struct {
+	device *modbusdevice.Device
+}
+
+func init() {
+	grid.Register("modbus", newDevice)
+}
+
+// New returns an initialized ModbusGrid Asset; this is part of the Asset interface.
+func New(configPath string) (grid.Asset, error) {
+	jsonConfig, err := driver.ReadConfig(configPath)
+	if err != nil {
+		return grid.Asset{}, err
+	}
+
+	return grid.NewWithDriver("modbus", jsonConfig)
+}
+
+// newDevice is the grid.DeviceFactory for the modbus device
+func newDevice(jsonConfig []byte) (grid.DeviceController, error) {
+	device, err := modbusdevice.New(jsonConfig, grid.MachineStatus{}, grid.MachineControl{})
+	if err != nil {
+		return nil, err
+	}
+	return &ModbusGrid{device}, nil
+}
+
+// ReadDeviceStatus requests a physical device read over the communication interface
+func (d *ModbusGrid) ReadDeviceStatus() (grid.MachineStatus, error) {
+	status := grid.MachineStatus{}
+	err := d.device.ReadStatus(&status)
+	return status, err
+}
+
+// WriteDeviceControl requests a physical device write over the communication interface
+func (d *ModbusGrid) WriteDeviceControl(machineControl grid.MachineControl) error {
+	return d.device.WriteControl(machineControl)
+}
+
+// Stop closes the connection to the device
+func (d *ModbusGrid) Stop() error {
+	return d.device.Stop()
+}
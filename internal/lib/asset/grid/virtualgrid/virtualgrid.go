@@ -112,21 +112,33 @@ func (a VirtualGrid) write(control Control) error {
 	return nil
 }
 
-// New returns an initalized virtualbus Asset; this is part of the Asset interface.
+func init() {
+	grid.Register("virtual", newDevice)
+}
+
+// New returns an initalized VirtualGrid Asset; this is part of the Asset interface.
 func New(configPath string) (grid.Asset, error) {
 	jsonConfig, err := ioutil.ReadFile(configPath)
 	if err != nil {
 		return grid.Asset{}, err
 	}
 
-	pid, _ := uuid.NewUUID()
+	return grid.NewWithDriver("virtual", jsonConfig)
+}
+
+// newDevice is the grid.DeviceFactory for the virtual device
+func newDevice(jsonConfig []byte) (grid.DeviceController, error) {
+	pid, err := uuid.NewUUID()
+	if err != nil {
+		return nil, err
+	}
 
 	device := VirtualGrid{
 		pid:  pid,
 		comm: virtualHardware{},
 	}
 
-	return grid.New(jsonConfig, &device)
+	return &device, nil
 }
 
 // Status maps grid.DeviceStatus to grid.Status
@@ -0,0 +1,228 @@
+package modbusdevice
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"sync"
+
+	"github.com/ohowland/cgc_core/internal/pkg/asset"
+	"github.com/ohowland/cgc_core/internal/pkg/comm/modbuscomm"
+)
+
+// Device maps the points of a Modbus register map onto the fields of an archetype's
+// MachineStatus and MachineControl. It is shared by the modbus drivers of each archetype.
+type Device struct {
+	mux       *sync.Mutex
+	comm      modbuscomm.ModbusComm
+	registers []modbuscomm.Register
+	reads     []modbuscomm.Register
+	status    map[string]string
+	control   map[string]string
+}
+
+// Config is the Modbus section of an asset configuration. Registers are read from the
+// RegisterMap file, a JSON array of registers, followed by any inline Registers. A
+// relative RegisterMap is resolved against the directory of the asset configuration,
+// as recorded by driver.ReadConfig. Status
+// and Control map MachineStatus and MachineControl field names to register names.
+type Config struct {
+	Poller      modbuscomm.PollerConfig `json:"Poller"`
+	RegisterMap string                  `json:"RegisterMap"`
+	Registers   []modbuscomm.Register   `json:"Registers"`
+	Status      map[string]string       `json:"Status"`
+	Control     map[string]string       `json:"Control"`
+}
+
+// New returns a Device configured from the Modbus section of the asset json
+// configuration. The status and control mappings are checked against the fields of
+// the zero value status and control structs.
+func New(jsonConfig []byte, status interface{}, control interface{}) (*Device, error) {
+	cfg, err := readConfig(jsonConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	poller, err := modbuscomm.NewPoller(cfg.Poller)
+	if err != nil {
+		return nil, err
+	}
+
+	return newDevice(cfg, poller, status, control)
+}
+
+func readConfig(jsonConfig []byte) (Config, error) {
+	wrapper := struct {
+		ConfigDir string  `json:"ConfigDir"`
+		Modbus    *Config `json:"Modbus"`
+	}{}
+	if err := json.Unmarshal(jsonConfig, &wrapper); err != nil {
+		return Config{}, err
+	}
+	if wrapper.Modbus == nil {
+		return Config{}, errors.New("modbus: asset configuration has no Modbus section")
+	}
+	cfg := *wrapper.Modbus
+
+	if cfg.RegisterMap != "" {
+		path := cfg.RegisterMap
+		if !filepath.IsAbs(path) && wrapper.ConfigDir != "" {
+			path = filepath.Join(wrapper.ConfigDir, path)
+		}
+		jsonMap, err := ioutil.ReadFile(path)
+		if err != nil {
+			return Config{}, err
+		}
+		registers := make([]modbuscomm.Register, 0)
+		if err := json.Unmarshal(jsonMap, &registers); err != nil {
+			return Config{}, err
+		}
+		cfg.Registers = append(registers, cfg.Registers...)
+	}
+	return cfg, nil
+}
+
+func newDevice(cfg Config, comm modbuscomm.ModbusComm, status interface{}, control interface{}) (*Device, error) {
+	byName := make(map[string]modbuscomm.Register)
+	for _, register := range cfg.Registers {
+		byName[register.Name] = register
+	}
+
+	if err := checkMapping(cfg.Status, reflect.TypeOf(status), byName); err != nil {
+		return nil, err
+	}
+	if err := checkMapping(cfg.Control, reflect.TypeOf(control), byName); err != nil {
+		return nil, err
+	}
+
+	// the status registers, and the scale factor registers they depend on
+	reads := make([]modbuscomm.Register, 0)
+	added := make(map[string]bool)
+	add := func(name string) {
+		if !added[name] {
+			reads = append(reads, byName[name])
+			added[name] = true
+		}
+	}
+	for _, name := range cfg.Status {
+		add(name)
+		if sf := byName[name].ScaleFactor; sf != "" {
+			if _, ok := byName[sf]; !ok {
+				err := fmt.Sprintf("modbus: register %v scale factor %v is not in the register map", name, sf)
+				return nil, errors.New(err)
+			}
+			add(sf)
+		}
+	}
+
+	return &Device{
+		mux:       &sync.Mutex{},
+		comm:      comm,
+		registers: cfg.Registers,
+		reads:     reads,
+		status:    cfg.Status,
+		control:   cfg.Control,
+	}, nil
+}
+
+// checkMapping returns an error if a mapped field is not a float64 or bool field of
+// the struct, or a mapped register is not in the register map
+func checkMapping(mapping map[string]string, t reflect.Type, registers map[string]modbuscomm.Register) error {
+	for field, name := range mapping {
+		f, ok := t.FieldByName(field)
+		if !ok {
+			err := fmt.Sprintf("modbus: %v has no field %v", t.Name(), field)
+			return errors.New(err)
+		}
+		if k := f.Type.Kind(); k != reflect.Float64 && k != reflect.Bool {
+			err := fmt.Sprintf("modbus: %v field %v cannot be mapped to a register", t.Name(), field)
+			return errors.New(err)
+		}
+		if _, ok := registers[name]; !ok {
+			err := fmt.Sprintf("modbus: %v field %v maps to unknown register %v", t.Name(), field, name)
+			return errors.New(err)
+		}
+	}
+	return nil
+}
+
+// ReadStatus polls the status registers into the mapped fields of status, a pointer
+// to a MachineStatus. The Quality field of status is set to the worst quality of the
+// mapped points. Failed reads are reported through the quality, so the error is only
+// returned when the poll response cannot be decoded.
+func (d *Device) ReadStatus(status interface{}) error {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+
+	resp, _ := d.comm.Read(d.reads)
+	points := make(map[string]modbuscomm.Point)
+	if err := json.Unmarshal(resp, &points); err != nil {
+		return err
+	}
+
+	v := reflect.ValueOf(status).Elem()
+	quality := asset.Good
+	for field, name := range d.status {
+		p, ok := points[name]
+		if !ok {
+			p.Quality = asset.CommFail
+		}
+		quality = asset.Worst(quality, p.Quality)
+
+		f := v.FieldByName(field)
+		switch f.Kind() {
+		case reflect.Float64:
+			f.SetFloat(p.Value)
+		case reflect.Bool:
+			f.SetBool(p.Value != 0)
+		}
+	}
+
+	if f := v.FieldByName("Quality"); f.IsValid() && f.Type() == reflect.TypeOf(quality) {
+		f.Set(reflect.ValueOf(quality))
+	}
+	return nil
+}
+
+// WriteControl writes the mapped fields of control, a MachineControl, to the device.
+// A bool field is written as 1 or 0.
+func (d *Device) WriteControl(control interface{}) error {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+
+	if len(d.control) == 0 {
+		return nil
+	}
+
+	v := reflect.ValueOf(control)
+	values := make(map[string]float64)
+	for field, name := range d.control {
+		f := v.FieldByName(field)
+		switch f.Kind() {
+		case reflect.Float64:
+			values[name] = f.Float()
+		case reflect.Bool:
+			if f.Bool() {
+				values[name] = 1
+			} else {
+				values[name] = 0
+			}
+		}
+	}
+
+	jsonValues, err := json.Marshal(values)
+	if err != nil {
+		return err
+	}
+	return d.comm.Write(d.registers, jsonValues)
+}
+
+// Stop closes the connection to the device
+func (d *Device) Stop() error {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	return d.comm.Close()
+}
@@ -0,0 +1,182 @@
+package modbusdevice
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ohowland/cgc_core/internal/pkg/asset"
+	"github.com/ohowland/cgc_core/internal/pkg/comm/modbuscomm"
+	"github.com/ohowland/cgc_core/internal/pkg/driver"
+	"gotest.tools/assert"
+)
+
+type testStatus struct {
+	KW      float64
+	Online  bool
+	Quality asset.Quality
+}
+
+type testControl struct {
+	Run bool
+	KW  float64
+}
+
+// fakeComm serves points by register name and records writes
+type fakeComm struct {
+	points  map[string]modbuscomm.Point
+	read    []string
+	written map[string]float64
+	closed  bool
+}
+
+func (c *fakeComm) Read(registers []modbuscomm.Register) ([]byte, error) {
+	c.read = nil
+	points := make(map[string]modbuscomm.Point)
+	for _, register := range registers {
+		c.read = append(c.read, register.Name)
+		p, ok := c.points[register.Name]
+		if !ok {
+			p = modbuscomm.Point{Quality: asset.CommFail}
+		}
+		points[register.Name] = p
+	}
+	return json.Marshal(points)
+}
+
+func (c *fakeComm) Write(registers []modbuscomm.Register, jsonWriteValues []byte) error {
+	return json.Unmarshal(jsonWriteValues, &c.written)
+}
+
+func (c *fakeComm) Close() error {
+	c.closed = true
+	return nil
+}
+
+func testConfig() Config {
+	return Config{
+		Registers: []modbuscomm.Register{
+			{Name: "W", Address: 0, DataType: "i16", ScaleFactor: "W_SF"},
+			{Name: "W_SF", Address: 1, DataType: "i16"},
+			{Name: "Conn", Address: 2, DataType: "u16"},
+			{Name: "WSet", Address: 3, DataType: "i16"},
+			{Name: "RunSet", Address: 4, DataType: "bool"},
+		},
+		Status:  map[string]string{"KW": "W", "Online": "Conn"},
+		Control: map[string]string{"KW": "WSet", "Run": "RunSet"},
+	}
+}
+
+func TestReadStatus(t *testing.T) {
+	comm := &fakeComm{points: map[string]modbuscomm.Point{
+		"W":    {Value: 12.5},
+		"W_SF": {Value: -1},
+		"Conn": {Value: 1},
+	}}
+	d, err := newDevice(testConfig(), comm, testStatus{}, testControl{})
+	assert.NilError(t, err)
+
+	status := testStatus{}
+	err = d.ReadStatus(&status)
+	assert.NilError(t, err)
+	assert.Equal(t, status, testStatus{KW: 12.5, Online: true, Quality: asset.Good})
+	assert.Equal(t, len(comm.read), 3, "the scale factor register is read with its value")
+}
+
+func TestReadStatusWorstQuality(t *testing.T) {
+	comm := &fakeComm{points: map[string]modbuscomm.Point{
+		"W":    {Value: 12.5, Quality: asset.Stale},
+		"W_SF": {Value: -1},
+	}}
+	d, err := newDevice(testConfig(), comm, testStatus{}, testControl{})
+	assert.NilError(t, err)
+
+	status := testStatus{}
+	err = d.ReadStatus(&status)
+	assert.NilError(t, err)
+	assert.Equal(t, status.Quality, asset.CommFail)
+}
+
+func TestWriteControl(t *testing.T) {
+	comm := &fakeComm{}
+	d, err := newDevice(testConfig(), comm, testStatus{}, testControl{})
+	assert.NilError(t, err)
+
+	err = d.WriteControl(testControl{Run: true, KW: -4})
+	assert.NilError(t, err)
+	assert.DeepEqual(t, comm.written, map[string]float64{"WSet": -4, "RunSet": 1})
+
+	assert.NilError(t, d.Stop())
+	assert.Assert(t, comm.closed)
+}
+
+func TestBadMapping(t *testing.T) {
+	cfg := testConfig()
+	cfg.Status = map[string]string{"Volts": "W"}
+	_, err := newDevice(cfg, &fakeComm{}, testStatus{}, testControl{})
+	assert.Error(t, err, "modbus: testStatus has no field Volts")
+
+	cfg = testConfig()
+	cfg.Status = map[string]string{"Quality": "W"}
+	_, err = newDevice(cfg, &fakeComm{}, testStatus{}, testControl{})
+	assert.Error(t, err, "modbus: testStatus field Quality cannot be mapped to a register")
+
+	cfg = testConfig()
+	cfg.Control = map[string]string{"KW": "Missing"}
+	_, err = newDevice(cfg, &fakeComm{}, testStatus{}, testControl{})
+	assert.Error(t, err, "modbus: testControl field KW maps to unknown register Missing")
+}
+
+func TestReadConfigRegisterMap(t *testing.T) {
+	dir, err := ioutil.TempDir("", "modbusdevice")
+	assert.NilError(t, err)
+	defer os.RemoveAll(dir)
+
+	mapPath := filepath.Join(dir, "map.json")
+	err = ioutil.WriteFile(mapPath, []byte(`[{"Name": "W", "Address": 40083, "DataType": "i16"}]`), 0644)
+	assert.NilError(t, err)
+
+	jsonConfig, _ := json.Marshal(map[string]interface{}{
+		"Name": "test",
+		"Modbus": map[string]interface{}{
+			"RegisterMap": mapPath,
+			"Registers":   []map[string]interface{}{{"Name": "Conn", "Address": 40100, "DataType": "u16"}},
+			"Status":      map[string]string{"KW": "W"},
+		},
+	})
+	cfg, err := readConfig(jsonConfig)
+	assert.NilError(t, err)
+	assert.Equal(t, len(cfg.Registers), 2)
+	assert.Equal(t, cfg.Registers[0].Address, uint16(40083))
+	assert.Equal(t, cfg.Registers[1].Name, "Conn")
+
+	_, err = readConfig([]byte(`{"Name": "test"}`))
+	assert.Error(t, err, "modbus: asset configuration has no Modbus section")
+}
+
+// the register map of an asset configuration is found from any working directory
+func TestReadConfigRelativeRegisterMap(t *testing.T) {
+	path, err := filepath.Abs("../../../../config/asset/modbusPV.json")
+	assert.NilError(t, err)
+
+	wd, err := os.Getwd()
+	assert.NilError(t, err)
+	dir, err := ioutil.TempDir("", "modbusdevice")
+	assert.NilError(t, err)
+	defer os.RemoveAll(dir)
+	assert.NilError(t, os.Chdir(dir))
+	defer os.Chdir(wd)
+
+	jsonConfig, err := driver.ReadConfig(path)
+	assert.NilError(t, err)
+	cfg, err := readConfig(jsonConfig)
+	assert.NilError(t, err)
+
+	found := false
+	for _, r := range cfg.Registers {
+		found = found || r.Name == cfg.Status["KW"]
+	}
+	assert.Assert(t, found, "register map was not read")
+}
@@ -0,0 +1,52 @@
+package modbuspv
+
+import (
+	"github.com/ohowland/cgc_core/internal/lib/asset/modbusdevice"
+	"github.com/ohowland/cgc_core/internal/pkg/asset/pv"
+	"github.com/ohowland/cgc_core/internal/pkg/driver"
+)
+
+// ModbusPV is a pv.DeviceController for PV hardware with a Modbus interface
+type ModbusPV struct {
+	device *modbusdevice.Device
+}
+
+func init() {
+	pv.Register("modbus", newDevice)
+}
+
+// New returns an initialized ModbusPV Asset; this is part of the Asset interface.
+func New(configPath string) (pv.Asset, error) {
+	jsonConfig, err := driver.ReadConfig(configPath)
+	if err != nil {
+		return pv.Asset{}, err
+	}
+
+	return pv.NewWithDriver("modbus", jsonConfig)
+}
+
+// newDevice is the pv.DeviceFactory for the modbus device
+func newDevice(jsonConfig []byte) (pv.DeviceController, error) {
+	device, err := modbusdevice.New(jsonConfig, pv.MachineStatus{}, pv.MachineControl{})
+	if err != nil {
+		return nil, err
+	}
+	return &ModbusPV{device}, nil
+}
+
+// ReadDeviceStatus requests a physical device read over the communication interface
+func (d *ModbusPV) ReadDeviceStatus() (pv.MachineStatus, error) {
+	status := pv.MachineStatus{}
+	err := d.device.ReadStatus(&status)
+	return status, err
+}
+
+// WriteDeviceControl requests a physical device write over the communication interface
+func (d *ModbusPV) WriteDeviceControl(machineControl pv.MachineControl) error {
+	return d.device.WriteControl(machineControl)
+}
+
+// Stop closes the connection to the device
+func (d *ModbusPV) Stop() error {
+	return d.device.Stop()
+}
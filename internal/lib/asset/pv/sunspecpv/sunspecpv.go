@@ -0,0 +1,65 @@
+package sunspecpv
+
+import (
+	"io/ioutil"
+
+	"github.com/ohowland/cgc_core/internal/pkg/asset"
+	"github.com/ohowland/cgc_core/internal/pkg/asset/pv"
+	"github.com/ohowland/cgc_core/internal/pkg/comm/modbuscomm/sunspec"
+)
+
+// SunSpecPV is a pv.DeviceController for SunSpec compliant PV inverters
+type SunSpecPV struct {
+	device *sunspec.Device
+}
+
+func init() {
+	pv.Register("sunspec", newDevice)
+}
+
+// New returns an initialized SunSpecPV Asset; this is part of the Asset interface.
+func New(configPath string) (pv.Asset, error) {
+	jsonConfig, err := ioutil.ReadFile(configPath)
+	if err != nil {
+		return pv.Asset{}, err
+	}
+
+	return pv.NewWithDriver("sunspec", jsonConfig)
+}
+
+// newDevice is the pv.DeviceFactory for the sunspec device
+func newDevice(jsonConfig []byte) (pv.DeviceController, error) {
+	device, err := sunspec.New(jsonConfig)
+	if err != nil {
+		return nil, err
+	}
+	return &SunSpecPV{device}, nil
+}
+
+// ReadDeviceStatus requests a physical device read over the communication interface.
+// An inverter that cannot be discovered reads as comm-fail.
+func (d *SunSpecPV) ReadDeviceStatus() (pv.MachineStatus, error) {
+	inverter, _, err := d.device.ReadInverter()
+	if err != nil {
+		return pv.MachineStatus{Quality: asset.CommFail}, nil
+	}
+
+	return pv.MachineStatus{
+		KW:      inverter.KW,
+		KVAR:    inverter.KVAR,
+		Hz:      inverter.Hz,
+		Volts:   inverter.Volts,
+		Online:  inverter.Online,
+		Quality: inverter.Quality,
+	}, nil
+}
+
+// WriteDeviceControl requests a physical device write over the communication interface
+func (d *SunSpecPV) WriteDeviceControl(machineControl pv.MachineControl) error {
+	return d.device.WriteInverter(machineControl.Run, machineControl.KWLimit, machineControl.KVAR)
+}
+
+// Stop closes the connection to the device
+func (d *SunSpecPV) Stop() error {
+	return d.device.Close()
+}
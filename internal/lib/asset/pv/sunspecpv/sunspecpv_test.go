@@ -0,0 +1,73 @@
+package sunspecpv
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/ohowland/cgc_core/internal/pkg/asset"
+	"github.com/ohowland/cgc_core/internal/pkg/asset/pv"
+	"github.com/ohowland/cgc_core/internal/pkg/comm/modbuscomm/sunspec/sunspectest"
+	"gotest.tools/assert"
+)
+
+// newTestDevice returns a SunSpecPV served the register image, and a function that
+// closes the device and server
+func newTestDevice(t *testing.T, imagePath string) (*SunSpecPV, func()) {
+	server, err := sunspectest.NewServer(imagePath)
+	assert.NilError(t, err)
+
+	jsonConfig, err := json.Marshal(map[string]interface{}{
+		"SunSpec": map[string]interface{}{"Poller": server.Config},
+	})
+	assert.NilError(t, err)
+	device, err := newDevice(jsonConfig)
+	assert.NilError(t, err)
+
+	d := device.(*SunSpecPV)
+	return d, func() {
+		d.Stop()
+		server.Close()
+	}
+}
+
+func TestNew(t *testing.T) {
+	configPath := "sunspecpv_test_config.json"
+	pv, err := New(configPath)
+	assert.NilError(t, err)
+
+	assert.Assert(t, pv.Name() == "TEST_SunSpec PV")
+	_, ok := pv.DeviceController().(*SunSpecPV)
+	assert.Assert(t, ok)
+}
+
+func TestReadDeviceStatus(t *testing.T) {
+	d, closeDevice := newTestDevice(t, sunspectest.Image("pv"))
+	defer closeDevice()
+
+	status, err := d.ReadDeviceStatus()
+	assert.NilError(t, err)
+	assert.Equal(t, status, pv.MachineStatus{
+		KW:      12.5,
+		KVAR:    -0.15,
+		Hz:      60.01,
+		Volts:   277,
+		Online:  true,
+		Quality: asset.Good,
+	})
+}
+
+func TestWriteDeviceControl(t *testing.T) {
+	d, closeDevice := newTestDevice(t, sunspectest.Image("pv"))
+	defer closeDevice()
+
+	_, err := d.ReadDeviceStatus()
+	assert.NilError(t, err)
+
+	err = d.WriteDeviceControl(pv.MachineControl{Run: true, KWLimit: 15, KVAR: 1})
+	assert.NilError(t, err)
+	points, err := d.device.Read("123.Conn", "123.WMaxLimPct", "123.VArWMaxPct")
+	assert.NilError(t, err)
+	assert.Equal(t, points["123.Conn"].Value, 1.0)
+	assert.Equal(t, points["123.WMaxLimPct"].Value, 75.0)
+	assert.Equal(t, points["123.VArWMaxPct"].Value, 5.0)
+}
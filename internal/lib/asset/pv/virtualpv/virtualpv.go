@@ -69,7 +69,7 @@ type Status struct {
 // Control data structure for the VirtualPV
 type Control struct {
 	Run     bool    `json:"Run"`
-	KWLimit float64 `json:"KWLimit`
+	KWLimit float64 `json:"KWLimit"`
 	KVAR    float64 `json:"KVAR"`
 }
 
@@ -105,6 +105,10 @@ func (a VirtualPV) write(control Control) error {
 	return nil
 }
 
+func init() {
+	pv.Register("virtual", newDevice)
+}
+
 // New returns an initalized VirtualPV Asset; this is part of the Asset interface.
 func New(configPath string) (pv.Asset, error) {
 	jsonConfig, err := ioutil.ReadFile(configPath)
@@ -112,9 +116,14 @@ func New(configPath string) (pv.Asset, error) {
 		return pv.Asset{}, err
 	}
 
+	return pv.NewWithDriver("virtual", jsonConfig)
+}
+
+// newDevice is the pv.DeviceFactory for the virtual device
+func newDevice(jsonConfig []byte) (pv.DeviceController, error) {
 	pid, err := uuid.NewUUID()
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 
 	device := VirtualPV{
@@ -122,7 +131,7 @@ func New(configPath string) (pv.Asset, error) {
 		comm: virtualHardware{},
 	}
 
-	return pv.New(jsonConfig, &device)
+	return &device, nil
 }
 
 // Status maps grid.DeviceStatus to grid.Status
@@ -21,6 +21,10 @@ type VirtualACBus struct {
 	stopProcess   chan bool
 }
 
+func init() {
+	ac.Register("virtual", newRelayer)
+}
+
 // New returns an initalized VirtualACBus Asset; this is part of the Asset interface.
 func New(configPath string) (ac.Bus, error) {
 	jsonConfig, err := ioutil.ReadFile(configPath)
@@ -28,7 +32,16 @@ func New(configPath string) (ac.Bus, error) {
 		return ac.Bus{}, err
 	}
 
-	id, _ := uuid.NewUUID()
+	return ac.NewWithDriver("virtual", jsonConfig)
+}
+
+// newRelayer is the ac.RelayerFactory for the virtual system
+func newRelayer(jsonConfig []byte) (ac.Relayer, error) {
+	id, err := uuid.NewUUID()
+	if err != nil {
+		return nil, err
+	}
+
 	virtualsystem := VirtualACBus{
 		mux:           &sync.Mutex{},
 		pid:           id,
@@ -38,7 +51,7 @@ func New(configPath string) (ac.Bus, error) {
 		stopProcess:   make(chan bool),
 	}
 
-	return ac.New(jsonConfig, &virtualsystem)
+	return &virtualsystem, nil
 }
 
 // PID is an accessor for the process id
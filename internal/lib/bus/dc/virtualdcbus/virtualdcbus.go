@@ -21,6 +21,10 @@ type VirtualDCBus struct {
 	stopProcess   chan bool
 }
 
+func init() {
+	dc.Register("virtual", newRelayer)
+}
+
 // New returns an initalized VirtualDCBus Asset; this is part of the Asset interface.
 func New(configPath string) (dc.Bus, error) {
 	jsonConfig, err := ioutil.ReadFile(configPath)
@@ -28,7 +32,16 @@ func New(configPath string) (dc.Bus, error) {
 		return dc.Bus{}, err
 	}
 
-	id, _ := uuid.NewUUID()
+	return dc.NewWithDriver("virtual", jsonConfig)
+}
+
+// newRelayer is the dc.RelayerFactory for the virtual system
+func newRelayer(jsonConfig []byte) (dc.Relayer, error) {
+	id, err := uuid.NewUUID()
+	if err != nil {
+		return nil, err
+	}
+
 	virtualsystem := VirtualDCBus{
 		mux:           &sync.Mutex{},
 		pid:           id,
@@ -38,7 +51,7 @@ func New(configPath string) (dc.Bus, error) {
 		stopProcess:   make(chan bool),
 	}
 
-	return dc.New(jsonConfig, &virtualsystem)
+	return &virtualsystem, nil
 }
 
 // PID is an accessor for the process id
@@ -1,9 +1,12 @@
 package asset
 
 import (
+	"errors"
+	"fmt"
 	"sync"
 
 	"github.com/google/uuid"
+	"github.com/ohowland/cgc_core/internal/pkg/control"
 	"github.com/ohowland/cgc_core/internal/pkg/msg"
 )
 
@@ -19,7 +22,8 @@ type Asset interface {
 type Controller interface {
 	UpdateStatus()
 	UpdateConfig()
-	RequestControl(uuid.UUID, <-chan msg.Msg) error
+	RequestControl(uuid.UUID, control.Priority, <-chan msg.Msg) error
+	ReleaseControl(uuid.UUID) error
 	Shutdown(*sync.WaitGroup) error
 }
 
@@ -29,6 +33,85 @@ type Config interface {
 	BusName() string
 }
 
+// ControlTimeout is the alarm raised when an asset stops receiving control messages
+const ControlTimeout = "ControlTimeout"
+
+// Alarm is published on an asset's status topic when an abnormal condition is
+// raised (Active) or cleared.
+type Alarm struct {
+	Name   string `json:"Name"`
+	Active bool   `json:"Active"`
+}
+
+// Quality of a measured value. The zero value is Good, so devices that do not report
+// quality are trusted. Dispatch does not act on values that are not Good.
+type Quality int
+
+// Constants of Quality, ordered from best to worst
+const (
+	Good       Quality = iota
+	Stale              // the last good value, held while the device is unreachable
+	OutOfRange         // read, but outside the plausible range of the point
+	CommFail           // not read
+)
+
+var qualityNames = map[Quality]string{
+	Good:       "good",
+	Stale:      "stale",
+	OutOfRange: "out-of-range",
+	CommFail:   "comm-fail",
+}
+
+func (q Quality) String() string {
+	if name, ok := qualityNames[q]; ok {
+		return name
+	}
+	return fmt.Sprintf("Quality(%d)", int(q))
+}
+
+// MarshalText encodes the quality by name
+func (q Quality) MarshalText() ([]byte, error) {
+	if _, ok := qualityNames[q]; !ok {
+		err := fmt.Sprintf("unknown quality %d", int(q))
+		return nil, errors.New(err)
+	}
+	return []byte(q.String()), nil
+}
+
+// UnmarshalText decodes a quality name
+func (q *Quality) UnmarshalText(text []byte) error {
+	for quality, name := range qualityNames {
+		if name == string(text) {
+			*q = quality
+			return nil
+		}
+	}
+	err := fmt.Sprintf("unknown quality %v", string(text))
+	return errors.New(err)
+}
+
+// Worst returns the worst of the qualities, Good when there are none
+func Worst(qualities ...Quality) Quality {
+	worst := Good
+	for _, q := range qualities {
+		if q > worst {
+			worst = q
+		}
+	}
+	return worst
+}
+
+// Qualified is implemented by status payloads that report measurement quality
+type Qualified interface {
+	Quality() Quality
+}
+
+// IsGood returns false when the status reports a quality other than Good
+func IsGood(status interface{}) bool {
+	q, ok := status.(Qualified)
+	return !ok || q.Quality() == Good
+}
+
 //
 type RealPower interface {
 	KW() float64
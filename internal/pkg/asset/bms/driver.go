@@ -0,0 +1,35 @@
+package bms
+
+import (
+	"github.com/ohowland/cgc_core/internal/pkg/driver"
+)
+
+// DeviceFactory returns a DeviceController configured from the asset json configuration
+type DeviceFactory func(jsonConfig []byte) (DeviceController, error)
+
+var drivers = driver.NewRegistry("bms")
+
+// Register makes a DeviceController driver available by the provided name.
+// Register panics if called twice with the same name or if factory is nil.
+func Register(name string, factory DeviceFactory) {
+	drivers.Register(name, factory)
+}
+
+// Drivers returns a sorted list of the names of the registered drivers.
+func Drivers() []string {
+	return drivers.Drivers()
+}
+
+// NewWithDriver returns a configured Asset backed by the named driver
+func NewWithDriver(name string, jsonConfig []byte) (Asset, error) {
+	factory, err := drivers.Factory(name)
+	if err != nil {
+		return Asset{}, err
+	}
+
+	device, err := factory.(DeviceFactory)(jsonConfig)
+	if err != nil {
+		return Asset{}, err
+	}
+	return New(jsonConfig, device)
+}
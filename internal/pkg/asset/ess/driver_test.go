@@ -0,0 +1,59 @@
+package ess
+
+import (
+	"errors"
+	"io/ioutil"
+	"testing"
+
+	"gotest.tools/assert"
+)
+
+func init() {
+	Register("TEST_dummy", func(jsonConfig []byte) (DeviceController, error) {
+		return &DummyDevice{}, nil
+	})
+	Register("TEST_broken", func(jsonConfig []byte) (DeviceController, error) {
+		return nil, errors.New("device unavailable")
+	})
+}
+
+func TestDrivers(t *testing.T) {
+	assert.DeepEqual(t, Drivers(), []string{"TEST_broken", "TEST_dummy"})
+}
+
+func TestNewWithDriver(t *testing.T) {
+	jsonConfig, err := ioutil.ReadFile("./ess_test_config.json")
+	assert.NilError(t, err)
+
+	ess, err := NewWithDriver("TEST_dummy", jsonConfig)
+	assert.NilError(t, err)
+	assert.Equal(t, ess.Name(), "TEST_Virtual ESS")
+
+	_, ok := ess.DeviceController().(*DummyDevice)
+	assert.Assert(t, ok)
+}
+
+func TestNewWithUnknownDriver(t *testing.T) {
+	jsonConfig, err := ioutil.ReadFile("./ess_test_config.json")
+	assert.NilError(t, err)
+
+	_, err = NewWithDriver("TEST_unknown", jsonConfig)
+	assert.Error(t, err, "ess: unknown driver TEST_unknown")
+}
+
+func TestNewWithDriverFactoryError(t *testing.T) {
+	jsonConfig, err := ioutil.ReadFile("./ess_test_config.json")
+	assert.NilError(t, err)
+
+	_, err = NewWithDriver("TEST_broken", jsonConfig)
+	assert.Error(t, err, "device unavailable")
+}
+
+func TestRegisterDuplicatePanics(t *testing.T) {
+	defer func() {
+		assert.Assert(t, recover() != nil)
+	}()
+	Register("TEST_dummy", func(jsonConfig []byte) (DeviceController, error) {
+		return &DummyDevice{}, nil
+	})
+}
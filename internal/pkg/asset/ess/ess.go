@@ -2,10 +2,14 @@ package ess
 
 import (
 	"encoding/json"
+	"errors"
 	"log"
 	"sync"
+	"time"
 
 	"github.com/google/uuid"
+	"github.com/ohowland/cgc_core/internal/pkg/asset"
+	"github.com/ohowland/cgc_core/internal/pkg/control"
 	"github.com/ohowland/cgc_core/internal/pkg/msg"
 )
 
@@ -18,13 +22,14 @@ type DeviceController interface {
 
 // Asset is a data structure for an ESS Asset
 type Asset struct {
-	mux          *sync.Mutex
-	pid          uuid.UUID
-	device       DeviceController
-	publisher    *msg.PubSub
-	controlOwner uuid.UUID
-	supervisory  SupervisoryControl
-	config       Config
+	mux         *sync.Mutex
+	pid         uuid.UUID
+	device      DeviceController
+	publisher   *msg.PubSub
+	arbiter     *control.Arbiter
+	watchdog    *control.Watchdog
+	supervisory SupervisoryControl
+	config      Config
 }
 
 // PID is a getter for the asset PID
@@ -53,25 +58,33 @@ func (a Asset) Subscribe(pid uuid.UUID, topic msg.Topic) (<-chan msg.Msg, error)
 	return ch, err
 }
 
+// SubscribeFiltered returns a channel on which the subscribed topics are broadcast
+// when they pass the subscription filter
+func (a Asset) SubscribeFiltered(pid uuid.UUID, s msg.Subscription) (<-chan msg.Msg, error) {
+	ch, err := a.publisher.SubscribeFiltered(pid, s)
+	return ch, err
+}
+
 // Unsubscribe pid from all topic broadcasts
 func (a *Asset) Unsubscribe(pid uuid.UUID) {
 	a.publisher.Unsubscribe(pid)
 }
 
 func (a *Asset) Stop() {
+	a.watchdog.Stop()
 	a.publisher.Stop()
 	a.device.Stop()
 }
 
 // RequestControl connects the asset control to the read only channel parameter.
-func (a *Asset) RequestControl(pid uuid.UUID, ch <-chan msg.Msg) error {
-	a.mux.Lock()
-	defer a.mux.Unlock()
-	// TODO: previous owner needs to stop. how to enforce?
-	a.controlOwner = pid
-	go a.controlHandler(ch)
+// Control is denied with control.ErrDenied while a higher priority request holds it.
+func (a *Asset) RequestControl(pid uuid.UUID, priority control.Priority, ch <-chan msg.Msg) error {
+	return a.arbiter.Request(pid, priority, ch)
+}
 
-	return nil
+// ReleaseControl gives up the control request held by pid.
+func (a *Asset) ReleaseControl(pid uuid.UUID) error {
+	return a.arbiter.Release(pid)
 }
 
 // UpdateStatus requests a physical device read, then broadcasts results
@@ -101,23 +114,20 @@ func transform(machineStatus MachineStatus) Status {
 func (a Asset) Shutdown(wg *sync.WaitGroup) error {
 	wg.Add(1)
 	defer wg.Done()
+	a.watchdog.Stop()
 	return a.device.Stop()
 }
 
-func (a *Asset) controlHandler(ch <-chan msg.Msg) {
-loop:
-	for {
-		data, ok := <-ch
-		if !ok {
-			log.Println("ESS controlHandler() stopping")
-			break loop
-		}
-		control, ok := data.Payload().(MachineControl)
+// controlHandler writes the control owner's messages to the device
+func controlHandler(device DeviceController, watchdog *control.Watchdog) control.Handler {
+	return func(data msg.Msg) {
+		machineControl, ok := data.Payload().(MachineControl)
 		if !ok {
 			log.Println("ESS controlHandler() bad type assertion")
-			continue
+			return
 		}
-		err := a.device.WriteDeviceControl(control)
+		watchdog.Kick()
+		err := device.WriteDeviceControl(machineControl)
 		if err != nil {
 			// TODO: Write Error Handler Path
 			log.Println("ESS controlHandler():", err)
@@ -125,6 +135,24 @@ loop:
 	}
 }
 
+// newWatchdog returns a watchdog that falls back to the fail-safe control and raises
+// the control timeout alarm when the asset stops receiving control messages.
+func newWatchdog(device DeviceController, publisher *msg.PubSub, config StaticConfig) *control.Watchdog {
+	timeout := time.Duration(config.ControlTimeout) * time.Millisecond
+	onExpire := func() {
+		log.Printf("ESS %v: control timeout, writing fail-safe control", config.Name)
+		err := device.WriteDeviceControl(*config.FailSafe)
+		if err != nil {
+			log.Println("ESS watchdog:", err)
+		}
+		publisher.Publish(msg.Status, asset.Alarm{Name: asset.ControlTimeout, Active: true})
+	}
+	onRecover := func() {
+		publisher.Publish(msg.Status, asset.Alarm{Name: asset.ControlTimeout, Active: false})
+	}
+	return control.NewWatchdog(timeout, onExpire, onRecover)
+}
+
 // Status wraps MachineStatus with mutex and state metadata
 type Status struct {
 	Calc    CalculatedStatus `json:"CalculatedStatus"`
@@ -137,15 +165,21 @@ type CalculatedStatus struct{}
 
 // MachineStatus is a data structure representing an architypical ESS status
 type MachineStatus struct {
-	KW                   float64 `json:"KW"`
-	KVAR                 float64 `json:"KVAR"`
-	Hz                   float64 `json:"Hz"`
-	Volts                float64 `json:"Volts"`
-	RealPositiveCapacity float64 `json:"RealPositiveCapacity"`
-	RealNegativeCapacity float64 `json:"RealNegativeCapacity"`
-	SOC                  float64 `json:"SOC"`
-	Gridforming          bool    `json:"Gridforming"`
-	Online               bool    `json:"Online"`
+	KW                   float64       `json:"KW"`
+	KVAR                 float64       `json:"KVAR"`
+	Hz                   float64       `json:"Hz"`
+	Volts                float64       `json:"Volts"`
+	RealPositiveCapacity float64       `json:"RealPositiveCapacity"`
+	RealNegativeCapacity float64       `json:"RealNegativeCapacity"`
+	SOC                  float64       `json:"SOC"`
+	Gridforming          bool          `json:"Gridforming"`
+	Online               bool          `json:"Online"`
+	Quality              asset.Quality `json:"Quality"`
+}
+
+// Quality returns the worst quality of the asset's measurements
+func (s Status) Quality() asset.Quality {
+	return s.Machine.Quality
 }
 
 // KW returns the asset's measured real power
@@ -187,14 +221,29 @@ type Config struct {
 	Dynamic DynamicConfig `json:"Dynamic"`
 }
 
+// Name returns the name of the asset
+func (c Config) Name() string {
+	return c.Static.Name
+}
+
+// BusName returns the name of the bus the asset is a member of
+func (c Config) BusName() string {
+	return c.Static.BusName
+}
+
 type DynamicConfig struct{}
 
-// StaticConfig holds the ESS asset configuration parameters
+// StaticConfig holds the ESS asset configuration parameters.
+// ControlTimeout is the time in milliseconds the asset waits for a control message
+// before it writes FailSafe and raises the control timeout alarm, zero disables the
+// watchdog. A FailSafe control is required when the ControlTimeout is set.
 type StaticConfig struct {
-	Name     string  `json:"Name"`
-	BusName  string  `json:"BusName"`
-	RatedKVA float64 `json:"RatedKVA"`
-	RatedAh  float64 `json:"RatedAh"`
+	Name           string          `json:"Name"`
+	BusName        string          `json:"BusName"`
+	RatedKVA       float64         `json:"RatedKVA"`
+	RatedAh        float64         `json:"RatedAh"`
+	ControlTimeout int             `json:"ControlTimeout"`
+	FailSafe       *MachineControl `json:"FailSafe"`
 }
 
 // New returns a configured Asset
@@ -204,6 +253,9 @@ func New(jsonConfig []byte, device DeviceController) (Asset, error) {
 	if err != nil {
 		return Asset{}, err
 	}
+	if staticConfig.ControlTimeout > 0 && staticConfig.FailSafe == nil {
+		return Asset{}, errors.New("ess: ControlTimeout requires a FailSafe control")
+	}
 
 	dynamicConfig := DynamicConfig{}
 
@@ -213,7 +265,9 @@ func New(jsonConfig []byte, device DeviceController) (Asset, error) {
 	}
 
 	publisher := msg.NewPublisher(pid)
-	controlOwner := uuid.UUID{}
+	watchdog := newWatchdog(device, publisher, staticConfig)
+	arbiter := control.NewArbiter(controlHandler(device, watchdog))
+	watchdog.SerializeWith(arbiter.Exclusive)
 	supervisory := SupervisoryControl{false}
 	config := Config{staticConfig, dynamicConfig}
 
@@ -222,7 +276,8 @@ func New(jsonConfig []byte, device DeviceController) (Asset, error) {
 			pid,
 			device,
 			publisher,
-			controlOwner,
+			arbiter,
+			watchdog,
 			supervisory,
 			config},
 		err
@@ -9,6 +9,8 @@ import (
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/ohowland/cgc_core/internal/pkg/asset"
+	"github.com/ohowland/cgc_core/internal/pkg/control"
 	"github.com/ohowland/cgc_core/internal/pkg/msg"
 	"gotest.tools/assert"
 )
@@ -20,7 +22,7 @@ type DummyDevice struct {
 
 // randMachineStatus returns a closure for random MachineStatus
 func randMachineStatus() func() MachineStatus {
-	status := MachineStatus{rand.Float64(), rand.Float64(), rand.Float64(), rand.Float64(), rand.Float64(), rand.Float64(), rand.Float64(), false, false}
+	status := MachineStatus{rand.Float64(), rand.Float64(), rand.Float64(), rand.Float64(), rand.Float64(), rand.Float64(), rand.Float64(), false, false, asset.Good}
 	return func() MachineStatus {
 		return status
 	}
@@ -64,7 +66,7 @@ func TestReadConfigFile(t *testing.T) {
 	err = json.Unmarshal(jsonConfig, &testConfig)
 	assert.NilError(t, err)
 
-	assertConfig := StaticConfig{"TEST_Virtual ESS", "Virtual Bus", 20, 50}
+	assertConfig := StaticConfig{"TEST_Virtual ESS", "Virtual Bus", 20, 50, 0, nil}
 	assert.Assert(t, testConfig == assertConfig)
 }
 
@@ -84,7 +86,26 @@ func TestRequestControl(t *testing.T) {
 	pid, _ := uuid.NewUUID()
 	write := make(chan msg.Msg)
 
-	err = ess.RequestControl(pid, write)
+	err = ess.RequestControl(pid, control.Operator, write)
+	assert.NilError(t, err)
+}
+
+func TestRequestControlDenied(t *testing.T) {
+	ess, err := newESS()
+	assert.NilError(t, err)
+
+	operator, _ := uuid.NewUUID()
+	err = ess.RequestControl(operator, control.Operator, make(chan msg.Msg))
+	assert.NilError(t, err)
+
+	dispatch, _ := uuid.NewUUID()
+	err = ess.RequestControl(dispatch, control.Dispatch, make(chan msg.Msg))
+	assert.Equal(t, err, control.ErrDenied)
+
+	err = ess.ReleaseControl(operator)
+	assert.NilError(t, err)
+
+	err = ess.RequestControl(dispatch, control.Dispatch, make(chan msg.Msg))
 	assert.NilError(t, err)
 }
 
@@ -94,7 +115,7 @@ func TestWriteControl(t *testing.T) {
 
 	pid, _ := uuid.NewUUID()
 	write := make(chan msg.Msg)
-	_ = ess.RequestControl(pid, write)
+	_ = ess.RequestControl(pid, control.Operator, write)
 
 	control := MachineControl{true, rand.Float64(), rand.Float64(), true}
 	write <- msg.New(pid, msg.Control, control)
@@ -119,6 +140,39 @@ func TestWriteControl(t *testing.T) {
 	close(write)
 }
 
+func TestControlTimeoutFailSafe(t *testing.T) {
+	jsonConfig := []byte(`{"Name": "TEST_Virtual ESS", "BusName": "Virtual Bus", "ControlTimeout": 1000, "FailSafe": {"Run": false, "KW": 0}}`)
+	ess, err := New(jsonConfig, &DummyDevice{})
+	assert.NilError(t, err)
+
+	pid, _ := uuid.NewUUID()
+	ch, err := ess.Subscribe(pid, msg.Status)
+	assert.NilError(t, err)
+
+	write := make(chan msg.Msg)
+	_ = ess.RequestControl(pid, control.Dispatch, write)
+	write <- msg.New(pid, msg.Control, MachineControl{Run: true, KW: 10})
+
+	select {
+	case m := <-ch:
+		alarm, ok := m.Payload().(asset.Alarm)
+		assert.Assert(t, ok, "expected an alarm on the status topic")
+		assert.Equal(t, alarm, asset.Alarm{Name: asset.ControlTimeout, Active: true})
+	case <-time.After(3 * time.Second):
+		t.Fatal("control timeout alarm was not published")
+	}
+
+	device := ess.DeviceController().(*DummyDevice)
+	assert.Equal(t, device.Run, false)
+	assert.Equal(t, device.KW, 0.0)
+}
+
+func TestControlTimeoutRequiresFailSafe(t *testing.T) {
+	jsonConfig := []byte(`{"Name": "TEST_Virtual ESS", "BusName": "Virtual Bus", "ControlTimeout": 1000}`)
+	_, err := New(jsonConfig, &DummyDevice{})
+	assert.Error(t, err, "ess: ControlTimeout requires a FailSafe control")
+}
+
 type subscriber struct {
 	pid uuid.UUID
 	ch  <-chan msg.Msg
@@ -202,7 +256,7 @@ func TestSubscribeToPublisherConfig(t *testing.T) {
 		subs[i] = subscriber{pid, ch}
 	}
 
-	assertConfig := Config{StaticConfig{"TEST_Virtual ESS", "Virtual Bus", 20, 50}, DynamicConfig{}}
+	assertConfig := Config{StaticConfig{"TEST_Virtual ESS", "Virtual Bus", 20, 50, 0, nil}, DynamicConfig{}}
 
 	var wg sync.WaitGroup
 	for _, sub := range subs {
@@ -9,6 +9,8 @@ import (
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/ohowland/cgc_core/internal/pkg/asset"
+	"github.com/ohowland/cgc_core/internal/pkg/control"
 	"github.com/ohowland/cgc_core/internal/pkg/msg"
 	"gotest.tools/assert"
 )
@@ -19,7 +21,7 @@ type DummyDevice struct {
 
 // randMachineStatus returns a closure for random MachineStatus
 func randMachineStatus() func() MachineStatus {
-	status := MachineStatus{rand.Float64(), rand.Float64(), rand.Float64(), rand.Float64(), false}
+	status := MachineStatus{rand.Float64(), rand.Float64(), rand.Float64(), rand.Float64(), false, asset.Good}
 	return func() MachineStatus {
 		return status
 	}
@@ -58,7 +60,7 @@ func TestReadConfigFile(t *testing.T) {
 	err = json.Unmarshal(jsonConfig, &testConfig)
 	assert.NilError(t, err)
 
-	assertConfig := StaticConfig{"TEST_Virtual Feeder", "Virtual Bus", 20, 18}
+	assertConfig := StaticConfig{"TEST_Virtual Feeder", "Virtual Bus", 20, 18, 0, nil}
 	assert.Assert(t, testConfig == assertConfig)
 }
 
@@ -78,7 +80,7 @@ func TestRequestControl(t *testing.T) {
 	pid, _ := uuid.NewUUID()
 	write := make(chan msg.Msg)
 
-	err = feeder.RequestControl(pid, write)
+	err = feeder.RequestControl(pid, control.Operator, write)
 	assert.NilError(t, err)
 }
 
@@ -88,7 +90,7 @@ func TestWriteControl(t *testing.T) {
 
 	pid, _ := uuid.NewUUID()
 	write := make(chan msg.Msg)
-	_ = feeder.RequestControl(pid, write)
+	_ = feeder.RequestControl(pid, control.Operator, write)
 
 	control := MachineControl{true}
 	write <- msg.New(pid, msg.Control, control)
@@ -194,7 +196,7 @@ func TestSubscribeToPublisherConfig(t *testing.T) {
 		subs[i] = subscriber{pid, ch}
 	}
 
-	assertConfig := StaticConfig{"TEST_Virtual Feeder", "Virtual Bus", 20, 18}
+	assertConfig := StaticConfig{"TEST_Virtual Feeder", "Virtual Bus", 20, 18, 0, nil}
 
 	var wg sync.WaitGroup
 	for _, sub := range subs {
@@ -2,10 +2,14 @@ package grid
 
 import (
 	"encoding/json"
+	"errors"
 	"log"
 	"sync"
+	"time"
 
 	"github.com/google/uuid"
+	"github.com/ohowland/cgc_core/internal/pkg/asset"
+	"github.com/ohowland/cgc_core/internal/pkg/control"
 	"github.com/ohowland/cgc_core/internal/pkg/msg"
 )
 
@@ -18,13 +22,14 @@ type DeviceController interface {
 
 // Asset is a datastructure for an Energy Storage System Asset
 type Asset struct {
-	mux          *sync.Mutex
-	pid          uuid.UUID
-	device       DeviceController
-	publisher    *msg.PubSub
-	controlOwner uuid.UUID
-	supervisory  SupervisoryControl
-	config       Config
+	mux         *sync.Mutex
+	pid         uuid.UUID
+	device      DeviceController
+	publisher   *msg.PubSub
+	arbiter     *control.Arbiter
+	watchdog    *control.Watchdog
+	supervisory SupervisoryControl
+	config      Config
 }
 
 // PID is a getter for the asset PID
@@ -53,20 +58,27 @@ func (a Asset) Subscribe(pid uuid.UUID, topic msg.Topic) (<-chan msg.Msg, error)
 	return ch, err
 }
 
+// SubscribeFiltered returns a channel on which the subscribed topics are broadcast
+// when they pass the subscription filter
+func (a Asset) SubscribeFiltered(pid uuid.UUID, s msg.Subscription) (<-chan msg.Msg, error) {
+	ch, err := a.publisher.SubscribeFiltered(pid, s)
+	return ch, err
+}
+
 // Unsubscribe pid from all topic broadcasts
 func (a Asset) Unsubscribe(pid uuid.UUID) {
 	a.publisher.Unsubscribe(pid)
 }
 
 // RequestControl connects the asset control to the read only channel parameter.
-func (a *Asset) RequestControl(pid uuid.UUID, ch <-chan msg.Msg) error {
-	a.mux.Lock()
-	defer a.mux.Unlock()
-	// TODO: previous owner needs to stop. how to enforce?
-	a.controlOwner = pid
-	go a.controlHandler(ch)
+// Control is denied with control.ErrDenied while a higher priority request holds it.
+func (a *Asset) RequestControl(pid uuid.UUID, priority control.Priority, ch <-chan msg.Msg) error {
+	return a.arbiter.Request(pid, priority, ch)
+}
 
-	return nil
+// ReleaseControl gives up the control request held by pid.
+func (a *Asset) ReleaseControl(pid uuid.UUID) error {
+	return a.arbiter.Release(pid)
 }
 
 // UpdateStatus requests a physical device read, then updates MachineStatus field.
@@ -96,29 +108,44 @@ func transform(machineStatus MachineStatus) Status {
 func (a Asset) Shutdown(wg *sync.WaitGroup) error {
 	wg.Add(1)
 	defer wg.Done()
+	a.watchdog.Stop()
 	return a.device.Stop()
 }
 
-func (a *Asset) controlHandler(ch <-chan msg.Msg) {
-loop:
-	for {
-		data, ok := <-ch
-		if !ok {
-			log.Println("Grid controlHandler() stopping")
-			break loop
-		}
-		control, ok := data.Payload().(MachineControl)
+// controlHandler writes the control owner's messages to the device
+func controlHandler(device DeviceController, watchdog *control.Watchdog) control.Handler {
+	return func(data msg.Msg) {
+		machineControl, ok := data.Payload().(MachineControl)
 		if !ok {
 			log.Println("Grid controlHandler() bad type assertion")
-			continue
+			return
 		}
-		err := a.device.WriteDeviceControl(control)
+		watchdog.Kick()
+		err := device.WriteDeviceControl(machineControl)
 		if err != nil {
 			log.Println("Grid controlHandler():", err)
 		}
 	}
 }
 
+// newWatchdog returns a watchdog that falls back to the fail-safe control and raises
+// the control timeout alarm when the asset stops receiving control messages.
+func newWatchdog(device DeviceController, publisher *msg.PubSub, config StaticConfig) *control.Watchdog {
+	timeout := time.Duration(config.ControlTimeout) * time.Millisecond
+	onExpire := func() {
+		log.Printf("Grid %v: control timeout, writing fail-safe control", config.Name)
+		err := device.WriteDeviceControl(*config.FailSafe)
+		if err != nil {
+			log.Println("Grid watchdog:", err)
+		}
+		publisher.Publish(msg.Status, asset.Alarm{Name: asset.ControlTimeout, Active: true})
+	}
+	onRecover := func() {
+		publisher.Publish(msg.Status, asset.Alarm{Name: asset.ControlTimeout, Active: false})
+	}
+	return control.NewWatchdog(timeout, onExpire, onRecover)
+}
+
 // Status wraps MachineStatus with mutex and state metadata
 type Status struct {
 	Calc    CalculatedStatus `json:"CalculatedStatus"`
@@ -131,13 +158,19 @@ type CalculatedStatus struct{}
 
 // MachineStatus is a data structure representing an architypical Grid Intertie status
 type MachineStatus struct {
-	KW                   float64 `json:"KW"`
-	KVAR                 float64 `json:"KVAR"`
-	Hz                   float64 `json:"Hz"`
-	Volts                float64 `json:"Volts"`
-	RealPositiveCapacity float64 `json:"RealPositiveCapacity"`
-	RealNegativeCapacity float64 `json:"RealNegativeCapacity"`
-	Online               bool    `json:"Online"`
+	KW                   float64       `json:"KW"`
+	KVAR                 float64       `json:"KVAR"`
+	Hz                   float64       `json:"Hz"`
+	Volts                float64       `json:"Volts"`
+	RealPositiveCapacity float64       `json:"RealPositiveCapacity"`
+	RealNegativeCapacity float64       `json:"RealNegativeCapacity"`
+	Online               bool          `json:"Online"`
+	Quality              asset.Quality `json:"Quality"`
+}
+
+// Quality returns the worst quality of the asset's measurements
+func (s Status) Quality() asset.Quality {
+	return s.Machine.Quality
 }
 
 // KW returns the asset's measured real power
@@ -176,12 +209,27 @@ type Config struct {
 	Dynamic DynamicConfig `json:"Dynamic"`
 }
 
-// StaticConfig holds the ESS asset configuration parameters
+// Name returns the name of the asset
+func (c Config) Name() string {
+	return c.Static.Name
+}
+
+// BusName returns the name of the bus the asset is a member of
+func (c Config) BusName() string {
+	return c.Static.BusName
+}
+
+// StaticConfig holds the Grid asset configuration parameters.
+// ControlTimeout is the time in milliseconds the asset waits for a control message
+// before it writes FailSafe and raises the control timeout alarm, zero disables the
+// watchdog. A FailSafe control is required when the ControlTimeout is set.
 type StaticConfig struct {
-	Name      string  `json:"Name"`
-	BusName   string  `json:"BusName"`
-	RatedKW   float64 `json:"RatedKW"`
-	RatedKVAR float64 `json:"RatedKVAR"`
+	Name           string          `json:"Name"`
+	BusName        string          `json:"BusName"`
+	RatedKW        float64         `json:"RatedKW"`
+	RatedKVAR      float64         `json:"RatedKVAR"`
+	ControlTimeout int             `json:"ControlTimeout"`
+	FailSafe       *MachineControl `json:"FailSafe"`
 }
 
 type DynamicConfig struct{}
@@ -193,6 +241,9 @@ func New(jsonConfig []byte, device DeviceController) (Asset, error) {
 	if err != nil {
 		return Asset{}, err
 	}
+	if staticConfig.ControlTimeout > 0 && staticConfig.FailSafe == nil {
+		return Asset{}, errors.New("grid: ControlTimeout requires a FailSafe control")
+	}
 
 	dynamicConfig := DynamicConfig{}
 
@@ -202,7 +253,9 @@ func New(jsonConfig []byte, device DeviceController) (Asset, error) {
 	}
 
 	publisher := msg.NewPublisher(pid)
-	controlOwner := uuid.UUID{}
+	watchdog := newWatchdog(device, publisher, staticConfig)
+	arbiter := control.NewArbiter(controlHandler(device, watchdog))
+	watchdog.SerializeWith(arbiter.Exclusive)
 	supervisory := SupervisoryControl{false}
 	config := Config{staticConfig, dynamicConfig}
 
@@ -211,7 +264,8 @@ func New(jsonConfig []byte, device DeviceController) (Asset, error) {
 			pid,
 			device,
 			publisher,
-			controlOwner,
+			arbiter,
+			watchdog,
 			supervisory,
 			config},
 		err
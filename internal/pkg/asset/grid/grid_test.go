@@ -9,6 +9,8 @@ import (
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/ohowland/cgc_core/internal/pkg/asset"
+	"github.com/ohowland/cgc_core/internal/pkg/control"
 	"github.com/ohowland/cgc_core/internal/pkg/msg"
 	"gotest.tools/assert"
 )
@@ -19,7 +21,7 @@ type DummyDevice struct {
 
 // randMachineStatus returns a closure for random MachineStatus
 func randMachineStatus() func() MachineStatus {
-	status := MachineStatus{rand.Float64(), rand.Float64(), rand.Float64(), rand.Float64(), rand.Float64(), rand.Float64(), false}
+	status := MachineStatus{rand.Float64(), rand.Float64(), rand.Float64(), rand.Float64(), rand.Float64(), rand.Float64(), false, asset.Good}
 	return func() MachineStatus {
 		return status
 	}
@@ -62,7 +64,7 @@ func TestReadConfigFile(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	assertConfig := StaticConfig{"TEST_Virtual Grid", "Virtual Bus", 20, 19}
+	assertConfig := StaticConfig{"TEST_Virtual Grid", "Virtual Bus", 20, 19, 0, nil}
 	assert.Assert(t, testConfig == assertConfig)
 }
 
@@ -81,7 +83,7 @@ func TestRequestControl(t *testing.T) {
 	pid, _ := uuid.NewUUID()
 	write := make(chan msg.Msg)
 
-	err = grid.RequestControl(pid, write)
+	err = grid.RequestControl(pid, control.Operator, write)
 	assert.NilError(t, err)
 
 }
@@ -92,7 +94,7 @@ func TestWriteControl(t *testing.T) {
 
 	pid, _ := uuid.NewUUID()
 	write := make(chan msg.Msg)
-	_ = grid.RequestControl(pid, write)
+	_ = grid.RequestControl(pid, control.Operator, write)
 
 	control := MachineControl{true}
 	write <- msg.New(pid, msg.Control, control)
@@ -198,7 +200,7 @@ func TestSubscribeToPublisherConfig(t *testing.T) {
 		subs[i] = subscriber{pid, ch}
 	}
 
-	assertConfig := StaticConfig{"TEST_Virtual Grid", "Virtual Bus", 20, 19}
+	assertConfig := StaticConfig{"TEST_Virtual Grid", "Virtual Bus", 20, 19, 0, nil}
 
 	var wg sync.WaitGroup
 	for _, sub := range subs {
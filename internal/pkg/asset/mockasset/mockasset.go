@@ -8,6 +8,7 @@ import (
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/ohowland/cgc_core/internal/pkg/control"
 	"github.com/ohowland/cgc_core/internal/pkg/msg"
 )
 
@@ -42,10 +43,10 @@ var AssertedControl = randMockControl()
 var AssertedConfig = randMockConfig()
 
 type Asset struct {
-	pid          uuid.UUID
-	publisher    *msg.PubSub
-	controlOwner uuid.UUID
-	Control      Control
+	pid       uuid.UUID
+	publisher *msg.PubSub
+	arbiter   *control.Arbiter
+	Control   Control
 }
 
 func (d Asset) Subscribe(pid uuid.UUID, topic msg.Topic) (<-chan msg.Msg, error) {
@@ -53,15 +54,30 @@ func (d Asset) Subscribe(pid uuid.UUID, topic msg.Topic) (<-chan msg.Msg, error)
 	return ch, err
 }
 
+// SubscribeFiltered returns a channel on which the subscribed topics are broadcast
+// when they pass the subscription filter
+func (d Asset) SubscribeFiltered(pid uuid.UUID, s msg.Subscription) (<-chan msg.Msg, error) {
+	ch, err := d.publisher.SubscribeFiltered(pid, s)
+	return ch, err
+}
+
 // Unsubscribe pid from all topic broadcasts
 func (d Asset) Unsubscribe(pid uuid.UUID) {
 	d.publisher.Unsubscribe(pid)
 }
 
-func (d *Asset) RequestControl(pid uuid.UUID, ch <-chan msg.Msg) error {
-	d.controlOwner = pid
-	go d.controlHandler(ch)
-	return nil
+func (d *Asset) RequestControl(pid uuid.UUID, priority control.Priority, ch <-chan msg.Msg) error {
+	if d.arbiter == nil {
+		d.arbiter = control.NewArbiter(d.controlHandler)
+	}
+	return d.arbiter.Request(pid, priority, ch)
+}
+
+func (d *Asset) ReleaseControl(pid uuid.UUID) error {
+	if d.arbiter == nil {
+		return nil
+	}
+	return d.arbiter.Release(pid)
 }
 
 // Shutdown asset processes and cleanup resources
@@ -69,21 +85,13 @@ func (d *Asset) Shutdown(*sync.WaitGroup) error {
 	return nil
 }
 
-func (d *Asset) controlHandler(ch <-chan msg.Msg) {
-loop:
-	for {
-		data, ok := <-ch
-		if !ok {
-			log.Println("MockAsset controlHandler() stopping")
-			break loop
-		}
-		control, ok := data.Payload().(Control)
-		if !ok {
-			log.Println("MockAsset controlHandler() bad type assertion")
-			break loop
-		}
-		d.Control = control
+func (d *Asset) controlHandler(data msg.Msg) {
+	control, ok := data.Payload().(Control)
+	if !ok {
+		log.Println("MockAsset controlHandler() bad type assertion")
+		return
 	}
+	d.Control = control
 }
 
 func (d Asset) PID() uuid.UUID {
@@ -141,7 +149,7 @@ type MachineStatus struct {
 func New() Asset {
 	pid, _ := uuid.NewUUID()
 	publisher := msg.NewPublisher(pid)
-	return Asset{pid, publisher, uuid.UUID{}, Control{}}
+	return Asset{pid, publisher, nil, Control{}}
 }
 
 func (s Status) KW() float64 {
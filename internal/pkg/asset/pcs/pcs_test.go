@@ -9,6 +9,8 @@ import (
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/ohowland/cgc_core/internal/pkg/asset"
+	"github.com/ohowland/cgc_core/internal/pkg/control"
 	"github.com/ohowland/cgc_core/internal/pkg/msg"
 	"gotest.tools/assert"
 )
@@ -20,7 +22,7 @@ type DummyDevice struct {
 
 // randMachineStatus returns a closure for random MachineStatus
 func randMachineStatus() func() MachineStatus {
-	status := MachineStatus{rand.Float64(), rand.Float64(), rand.Float64(), rand.Float64(), rand.Float64(), rand.Float64(), rand.Float64(), false, false}
+	status := MachineStatus{rand.Float64(), rand.Float64(), rand.Float64(), rand.Float64(), rand.Float64(), rand.Float64(), rand.Float64(), false, false, asset.Good}
 	return func() MachineStatus {
 		return status
 	}
@@ -63,7 +65,7 @@ func TestReadConfigFile(t *testing.T) {
 	err = json.Unmarshal(jsonConfig, &testConfig)
 	assert.NilError(t, err)
 
-	assertConfig := StaticConfig{"TEST_Virtual PCS", "Virtual AC Bus", "Virtual DC Bus", 20}
+	assertConfig := StaticConfig{"TEST_Virtual PCS", "Virtual AC Bus", "Virtual DC Bus", 20, 0, nil}
 	assert.Assert(t, testConfig == assertConfig)
 }
 
@@ -92,7 +94,7 @@ func TestRequestControl(t *testing.T) {
 	pid, _ := uuid.NewUUID()
 	write := make(chan msg.Msg)
 
-	err = pcs.RequestControl(pid, write)
+	err = pcs.RequestControl(pid, control.Operator, write)
 	assert.NilError(t, err)
 }
 
@@ -102,7 +104,7 @@ func TestWriteControl(t *testing.T) {
 
 	pid, _ := uuid.NewUUID()
 	write := make(chan msg.Msg)
-	_ = pcs.RequestControl(pid, write)
+	_ = pcs.RequestControl(pid, control.Operator, write)
 
 	control := MachineControl{true, rand.Float64(), rand.Float64(), true}
 	write <- msg.New(pid, msg.Control, control)
@@ -210,7 +212,7 @@ func TestSubscribeToPublisherConfig(t *testing.T) {
 		subs[i] = subscriber{pid, ch}
 	}
 
-	assertConfig := Config{StaticConfig{"TEST_Virtual PCS", "Virtual AC Bus", "Virtual DC Bus", 20}, DynamicConfig{}}
+	assertConfig := Config{StaticConfig{"TEST_Virtual PCS", "Virtual AC Bus", "Virtual DC Bus", 20, 0, nil}, DynamicConfig{}}
 
 	var wg sync.WaitGroup
 	for _, sub := range subs {
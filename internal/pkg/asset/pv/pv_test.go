@@ -9,6 +9,8 @@ import (
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/ohowland/cgc_core/internal/pkg/asset"
+	"github.com/ohowland/cgc_core/internal/pkg/control"
 	"github.com/ohowland/cgc_core/internal/pkg/msg"
 	"gotest.tools/assert"
 )
@@ -20,7 +22,7 @@ type DummyDevice struct {
 
 // randMachineStatus returns a closure for random MachineStatus
 func randMachineStatus() func() MachineStatus {
-	status := MachineStatus{rand.Float64(), rand.Float64(), rand.Float64(), rand.Float64(), false}
+	status := MachineStatus{rand.Float64(), rand.Float64(), rand.Float64(), rand.Float64(), false, asset.Good}
 	return func() MachineStatus {
 		return status
 	}
@@ -62,7 +64,7 @@ func TestReadConfigFile(t *testing.T) {
 	err = json.Unmarshal(jsonConfig, &testConfig)
 	assert.NilError(t, err)
 
-	assertConfig := StaticConfig{"TEST_Virtual PV", "Virtual Bus", 20, 10}
+	assertConfig := StaticConfig{"TEST_Virtual PV", "Virtual Bus", 20, 10, 0, nil}
 	assert.Assert(t, testConfig == assertConfig)
 }
 
@@ -82,7 +84,7 @@ func TestRequestControl(t *testing.T) {
 	pid, _ := uuid.NewUUID()
 	write := make(chan msg.Msg)
 
-	err = ess.RequestControl(pid, write)
+	err = ess.RequestControl(pid, control.Operator, write)
 	assert.NilError(t, err)
 }
 
@@ -92,7 +94,7 @@ func TestWriteControl(t *testing.T) {
 
 	pid, _ := uuid.NewUUID()
 	write := make(chan msg.Msg)
-	_ = ess.RequestControl(pid, write)
+	_ = ess.RequestControl(pid, control.Operator, write)
 
 	control := MachineControl{true, rand.Float64(), rand.Float64()}
 	write <- msg.New(pid, msg.Control, control)
@@ -200,7 +202,7 @@ func TestSubscribeToPublisherConfig(t *testing.T) {
 		subs[i] = subscriber{pid, ch}
 	}
 
-	assertConfig := StaticConfig{"TEST_Virtual PV", "Virtual Bus", 20, 10}
+	assertConfig := StaticConfig{"TEST_Virtual PV", "Virtual Bus", 20, 10, 0, nil}
 
 	var wg sync.WaitGroup
 	for _, sub := range subs {
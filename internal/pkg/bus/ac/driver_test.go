@@ -0,0 +1,32 @@
+package ac
+
+import (
+	"io/ioutil"
+	"testing"
+
+	"gotest.tools/assert"
+)
+
+func init() {
+	Register("TEST_dummy", func(jsonConfig []byte) (Relayer, error) {
+		return NewDummyRelay(), nil
+	})
+}
+
+func TestNewWithDriver(t *testing.T) {
+	jsonConfig, err := ioutil.ReadFile("./ac_test_config.json")
+	assert.NilError(t, err)
+
+	bus, err := NewWithDriver("TEST_dummy", jsonConfig)
+	assert.NilError(t, err)
+	assert.Equal(t, bus.Name(), "TEST_Virtual Bus")
+	assert.Equal(t, bus.Relayer(), NewDummyRelay())
+}
+
+func TestNewWithUnknownDriver(t *testing.T) {
+	jsonConfig, err := ioutil.ReadFile("./ac_test_config.json")
+	assert.NilError(t, err)
+
+	_, err = NewWithDriver("TEST_unknown", jsonConfig)
+	assert.Error(t, err, "ac: unknown driver TEST_unknown")
+}
@@ -7,3 +7,10 @@ type Relayer interface {
 	asset.Frequency
 	asset.Voltage
 }
+
+// VirtualRelayer is a Relayer backed by a virtual system. Virtual devices
+// on the bus must be linked to the virtual system.
+type VirtualRelayer interface {
+	Relayer
+	AddMember(asset.VirtualACAsset)
+}
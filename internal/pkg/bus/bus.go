@@ -3,9 +3,11 @@ package bus
 import (
 	"errors"
 	"fmt"
+	"sort"
 
 	"github.com/google/uuid"
 	"github.com/ohowland/cgc_core/internal/pkg/asset"
+	"github.com/ohowland/cgc_core/internal/pkg/control"
 	"github.com/ohowland/cgc_core/internal/pkg/msg"
 )
 
@@ -21,7 +23,9 @@ type Bus interface {
 
 type Controller interface {
 	UpdateConfig()
-	RequestControl(uuid.UUID, <-chan msg.Msg) error
+	RequestControl(uuid.UUID, control.Priority, <-chan msg.Msg) error
+	RequestTargetControl(uuid.UUID, control.Priority, uuid.UUID, <-chan msg.Msg) error
+	ReleaseControl(uuid.UUID) error
 }
 
 type Config interface {
@@ -46,6 +50,12 @@ func (bg BusGraph) Subscribe(pid uuid.UUID, topic msg.Topic) (<-chan msg.Msg, er
 	return bg.rootBus.Subscribe(pid, topic)
 }
 
+// SubscribeFiltered returns a channel on which the subscribed topics are broadcast
+// when they pass the subscription filter
+func (bg BusGraph) SubscribeFiltered(pid uuid.UUID, s msg.Subscription) (<-chan msg.Msg, error) {
+	return bg.rootBus.SubscribeFiltered(pid, s)
+}
+
 // Unsubscribe removes the listener's PID from the subscription list.
 func (bg BusGraph) Unsubscribe(pid uuid.UUID) {
 	bg.rootBus.Unsubscribe(pid)
@@ -53,8 +63,19 @@ func (bg BusGraph) Unsubscribe(pid uuid.UUID) {
 
 // RequestControl attempts to aquire the control channel for the root node of the
 // bus graph.
-func (bg BusGraph) RequestControl(pid uuid.UUID, ch <-chan msg.Msg) error {
-	return bg.rootBus.RequestControl(pid, ch)
+func (bg BusGraph) RequestControl(pid uuid.UUID, priority control.Priority, ch <-chan msg.Msg) error {
+	return bg.rootBus.RequestControl(pid, priority, ch)
+}
+
+// RequestTargetControl attempts to aquire control of the messages the root node of the
+// bus graph routes to the target.
+func (bg BusGraph) RequestTargetControl(pid uuid.UUID, priority control.Priority, target uuid.UUID, ch <-chan msg.Msg) error {
+	return bg.rootBus.RequestTargetControl(pid, priority, target, ch)
+}
+
+// ReleaseControl gives up control of the root node of the bus graph.
+func (bg BusGraph) ReleaseControl(pid uuid.UUID) error {
+	return bg.rootBus.ReleaseControl(pid)
 }
 
 // BuildBusGraph returns a network graph of buses in assets.
@@ -141,6 +162,108 @@ func (bg *BusGraph) AddMember(n Node) error {
 	return nil
 }
 
+// AddChildBus inserts a bus into the network graph as a member of the parent bus.
+// The parent bus must already be a member of the graph.
+func (bg *BusGraph) AddChildBus(parent Bus, child Bus) error {
+	if _, exists := bg.graph.adjacentcyList[parent]; !exists {
+		err := fmt.Sprintf("graph does not contain parent bus %v", parent.Name())
+		return errors.New(err)
+	}
+
+	err := bg.graph.AddNode(child)
+	if err != nil {
+		return err
+	}
+
+	err = bg.graph.AddDirectedEdge(parent, child)
+	if err != nil {
+		return err
+	}
+
+	return parent.AddMember(child) // link bus to bus
+}
+
+// Subtree returns the PIDs of the named bus and of every node below it in the graph.
+func (bg BusGraph) Subtree(busName string) ([]uuid.UUID, error) {
+	var root Node
+	for _, node := range bg.nodeList() {
+		if b, ok := node.(Bus); ok && b.Name() == busName {
+			root = b
+			break
+		}
+	}
+	if root == nil {
+		err := fmt.Sprintf("graph does not contain bus %v", busName)
+		return nil, errors.New(err)
+	}
+
+	pids := make([]uuid.UUID, 0)
+	visited := make(map[Node]bool)
+	stack := []Node{root}
+	for len(stack) > 0 {
+		node := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if visited[node] {
+			continue
+		}
+		visited[node] = true
+		pids = append(pids, node.PID())
+		stack = append(stack, bg.graph.Edges(node)...)
+	}
+	return pids, nil
+}
+
+// SubtreeFilter returns a filter that accepts messages sent by the named bus or by
+// any node below it. The members of the subtree are fixed when the filter is created.
+func (bg BusGraph) SubtreeFilter(busName string) (msg.Filter, error) {
+	pids, err := bg.Subtree(busName)
+	if err != nil {
+		return nil, err
+	}
+	return msg.FromSender(pids...), nil
+}
+
+// Topology is a node of the bus graph and the nodes below it
+type Topology struct {
+	PID     uuid.UUID  `json:"PID"`
+	Name    string     `json:"Name"`
+	Bus     bool       `json:"Bus"`
+	Members []Topology `json:"Members"`
+}
+
+// Topology returns the tree of the bus graph from the root bus. Members are ordered
+// by name.
+func (bg BusGraph) Topology() Topology {
+	if bg.rootBus == nil {
+		return Topology{Members: []Topology{}}
+	}
+	return bg.topology(bg.rootBus)
+}
+
+func (bg BusGraph) topology(n Node) Topology {
+	_, isBus := n.(Bus)
+	t := Topology{n.PID(), n.Name(), isBus, make([]Topology, 0)}
+	for _, member := range bg.graph.Edges(n) {
+		t.Members = append(t.Members, bg.topology(member))
+	}
+	sort.Slice(t.Members, func(i, j int) bool {
+		return t.Members[i].Name < t.Members[j].Name
+	})
+	return t
+}
+
+// Walk visits the node and every node below it, parents first. An empty topology
+// has no nodes.
+func (t Topology) Walk(visit func(Topology)) {
+	if t.PID == (uuid.UUID{}) {
+		return
+	}
+	visit(t)
+	for _, member := range t.Members {
+		member.Walk(visit)
+	}
+}
+
 func (bg *BusGraph) findAssetBus(a asset.Asset) (Bus, error) {
 	for _, node := range bg.nodeList() {
 		switch v := node.(type) {
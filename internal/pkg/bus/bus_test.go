@@ -141,6 +141,94 @@ func TestAddBusMember(t *testing.T) {
 	assert.Assert(t, g.rootBus.(*MockBus) == &bus1)
 }
 
+func TestAddChildBus(t *testing.T) {
+	g, _ := NewBusGraph()
+	bus1, _ := NewMockBus()
+	bus2, _ := NewMockBus()
+	bus3, _ := NewMockBus()
+
+	err := g.AddMember(&bus1)
+	assert.NilError(t, err)
+
+	err = g.AddChildBus(&bus1, &bus2)
+	assert.NilError(t, err)
+
+	err = g.AddChildBus(&bus2, &bus3)
+	assert.NilError(t, err)
+
+	assert.Assert(t, g.rootBus.(*MockBus) == &bus1)
+	assert.Assert(t, len(g.graph.Edges(&bus1)) == 1)
+	assert.Assert(t, g.graph.Edges(&bus1)[0] == &bus2)
+	assert.Assert(t, g.graph.Edges(&bus2)[0] == &bus3)
+
+	_, ok := bus2.config.dynamic.Members[bus3.PID()]
+	assert.Assert(t, ok, "bus3 is not a member of bus2")
+}
+
+func TestAddChildBusUnknownParent(t *testing.T) {
+	g, _ := NewBusGraph()
+	bus1, _ := NewMockBus()
+	bus2, _ := NewMockBus()
+	bus3, _ := NewMockBus()
+
+	g.AddMember(&bus1)
+
+	err := g.AddChildBus(&bus2, &bus3)
+	assert.Error(t, err, "graph does not contain parent bus MockBus")
+}
+
+func TestSubtree(t *testing.T) {
+	g, _ := NewBusGraph()
+	bus1, _ := NewMockBus()
+	asset1 := mockasset.New()
+
+	g.AddMember(&bus1)
+	g.AddMember(&asset1)
+
+	pids, err := g.Subtree("MockBus")
+	assert.NilError(t, err)
+	assert.Assert(t, len(pids) == 2)
+
+	filter, err := g.SubtreeFilter("MockBus")
+	assert.NilError(t, err)
+	assert.Assert(t, filter(msg.New(asset1.PID(), msg.Status, nil)))
+	assert.Assert(t, !filter(msg.New(uuid.New(), msg.Status, nil)))
+
+	_, err = g.Subtree("Bus-99")
+	assert.Error(t, err, "graph does not contain bus Bus-99")
+}
+
+func TestTopology(t *testing.T) {
+	g, _ := NewBusGraph()
+	assert.DeepEqual(t, g.Topology(), Topology{Members: []Topology{}})
+
+	bus1, _ := NewMockBus()
+	bus2, _ := NewMockBus()
+	asset1 := mockasset.New()
+
+	g.AddMember(&bus1)
+	g.AddChildBus(&bus1, &bus2)
+	g.AddMember(&asset1)
+
+	top := g.Topology()
+	assert.Equal(t, top.PID, bus1.PID())
+	assert.Equal(t, top.Name, "MockBus")
+	assert.Assert(t, top.Bus)
+	assert.Equal(t, len(top.Members), 2)
+
+	members := make(map[uuid.UUID]Topology)
+	for _, m := range top.Members {
+		members[m.PID] = m
+	}
+	assert.Assert(t, members[bus2.PID()].Bus)
+	assert.Equal(t, len(members[bus2.PID()].Members), 0)
+	assert.Assert(t, !members[asset1.PID()].Bus)
+
+	visited := 0
+	top.Walk(func(Topology) { visited++ })
+	assert.Equal(t, visited, 3)
+}
+
 func TestNodeList(t *testing.T) {
 	g, _ := NewBusGraph()
 	bus1, _ := NewMockBus()
@@ -13,6 +13,7 @@ import (
 	"github.com/google/uuid"
 	"github.com/ohowland/cgc_core/internal/pkg/asset"
 	"github.com/ohowland/cgc_core/internal/pkg/bus"
+	"github.com/ohowland/cgc_core/internal/pkg/control"
 	"github.com/ohowland/cgc_core/internal/pkg/msg"
 )
 
@@ -23,13 +24,14 @@ type Bus struct {
 	relay     Relayer
 	publisher *msg.PubSub
 	inbox     msgHandler
+	arbiter   *control.Arbiter
 	config    Config
 	stop      chan bool
 }
 
 type msgHandler struct {
 	status  chan msg.Msg
-	control <-chan msg.Msg
+	control chan msg.Msg
 }
 
 type member struct {
@@ -79,20 +81,31 @@ func New(jsonConfig []byte, relay Relayer) (Bus, error) {
 	publisher := msg.NewPublisher(pid)
 	MsgHandler := msgHandler{
 		make(chan msg.Msg),
-		make(<-chan msg.Msg),
+		make(chan msg.Msg),
 	}
 
-	return Bus{
+	// the control owner's messages are handled by the bus process
+	arbiter := control.NewArbiter(func(m msg.Msg) {
+		MsgHandler.control <- m
+	})
+
+	b := Bus{
 		&sync.Mutex{},
 		pid,
 		relay,
 		publisher,
 		MsgHandler,
+		arbiter,
 		Config{
 			staticConfig,
 			dynamicConfig,
 		},
-		stop}, nil
+		stop}
+
+	// the process runs for the life of the bus, so the control owner's messages are
+	// handled before the first member joins
+	go b.Process()
+	return b, nil
 }
 
 // Process is the Primary Go Routine for the bus. Process aggregates messages and forwards them to subscribers.
@@ -106,11 +119,7 @@ loop:
 				continue
 			}
 			b.publisher.Forward(m)
-		case m, ok := <-b.inbox.control:
-			if !ok {
-				// TODO: Lost Controller
-				continue
-			}
+		case m := <-b.inbox.control:
 			b.publishMemberControl(m)
 		case <-b.stop:
 			break loop
@@ -140,10 +149,6 @@ func (b *Bus) AddMember(a bus.Node) error {
 		b.config.Dynamic.MemberAssets[a.PID()] = member
 	}
 
-	if len(b.config.Dynamic.MemberAssets)+len(b.config.Dynamic.MemberBuses) == 1 { // if this is the first member, start the bus process.
-		go b.Process()
-	}
-
 	// Propigate change in bus dynamic config
 	b.UpdateConfig()
 	return nil
@@ -151,6 +156,7 @@ func (b *Bus) AddMember(a bus.Node) error {
 
 // UpdateConfig pushes bus configuration to PubSub network
 func (b Bus) UpdateConfig() {
+	b.config.Dynamic.ControlOwner, _ = b.arbiter.Owner()
 	b.publisher.Publish(msg.Config, b.config)
 }
 
@@ -160,33 +166,44 @@ func (b *Bus) Subscribe(pid uuid.UUID, topic msg.Topic) (<-chan msg.Msg, error)
 	return ch, err
 }
 
+// SubscribeFiltered returns a channel on which the subscribed topics are broadcast
+// when they pass the subscription filter
+func (b *Bus) SubscribeFiltered(pid uuid.UUID, s msg.Subscription) (<-chan msg.Msg, error) {
+	ch, err := b.publisher.SubscribeFiltered(pid, s)
+	return ch, err
+}
+
 // Unsubscribe pid from all topic broadcasts
 func (b *Bus) Unsubscribe(pid uuid.UUID) {
 	b.publisher.Unsubscribe(pid)
 }
 
-// RequestControl assigns a channel parameter to the bus control channel
-func (b *Bus) RequestControl(pid uuid.UUID, ch <-chan msg.Msg) error {
-	b.mux.Lock()
-	defer b.mux.Unlock()
-	// TODO: previous owner needs to stop. how to enforce?
-	b.config.Dynamic.ControlOwner = pid
-	b.inbox.control = ch
-	return nil
+// RequestControl assigns a channel parameter to the bus control channel.
+// Control is denied with control.ErrDenied while a higher priority request holds it.
+func (b *Bus) RequestControl(pid uuid.UUID, priority control.Priority, ch <-chan msg.Msg) error {
+	return b.arbiter.Request(pid, priority, ch)
+}
+
+// RequestTargetControl assigns a channel parameter to the control of the messages the
+// bus routes to the target.
+func (b *Bus) RequestTargetControl(pid uuid.UUID, priority control.Priority, target uuid.UUID, ch <-chan msg.Msg) error {
+	return b.arbiter.RequestTarget(pid, priority, target, ch)
+}
+
+// ReleaseControl gives up the control request held by pid.
+func (b *Bus) ReleaseControl(pid uuid.UUID) error {
+	return b.arbiter.Release(pid)
 }
 
 func (b Bus) publishMemberControl(m msg.Msg) {
-	// TODO: Control Messages are targeted for an asset.
-	m, ok := unwrap(m)
-	if !ok {
-		log.Println(m)
-		log.Printf("AC Bus %v: recieved message with no target address", b.PID())
+	if m.Target() == (uuid.UUID{}) {
+		log.Printf("DC Bus %v: recieved message with no target address", b.PID())
 		// TODO: Bad Control Message
 		return
 	}
 
-	if b.hasMember(m.PID()) {
-		b.config.Dynamic.MemberAssets[m.PID()].controller <- m
+	if member, ok := b.config.Dynamic.MemberAssets[m.Target()]; ok {
+		member.controller <- m
 	} else {
 		// forward on to member buses
 		for pid := range b.config.Dynamic.MemberBuses {
@@ -246,7 +263,7 @@ func (b *Bus) newMember(node bus.Node) (member, error) {
 
 func (b Bus) requestControl(node bus.Node) (chan<- msg.Msg, error) {
 	ch := make(chan msg.Msg)
-	err := node.RequestControl(b.pid, ch)
+	err := node.RequestControl(b.pid, control.Bus, ch)
 	return ch, err
 }
 
@@ -263,10 +280,6 @@ func (b *Bus) removeMember(pid uuid.UUID) {
 		delete(b.config.Dynamic.MemberBuses, pid)
 	}
 
-	if len(b.config.Dynamic.MemberAssets)+len(b.config.Dynamic.MemberBuses) < 1 {
-		go b.stopProcess()
-	}
-
 	// Propigate change in bus dynamic config
 	b.UpdateConfig()
 }
@@ -309,8 +322,3 @@ func redirectMsg(in <-chan msg.Msg, out chan<- msg.Msg) {
 		out <- ch
 	}
 }
-
-func unwrap(m msg.Msg) (msg.Msg, bool) {
-	unwrapped, ok := m.Payload().(msg.Msg)
-	return unwrapped, ok
-}
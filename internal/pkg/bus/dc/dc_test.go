@@ -9,6 +9,7 @@ import (
 
 	"github.com/google/uuid"
 	"github.com/ohowland/cgc_core/internal/pkg/asset/mockasset"
+	"github.com/ohowland/cgc_core/internal/pkg/control"
 	"github.com/ohowland/cgc_core/internal/pkg/msg"
 )
 
@@ -121,7 +122,7 @@ func TestPushControl(t *testing.T) {
 
 	pid, _ := uuid.NewUUID()
 	ch := make(chan msg.Msg)
-	bus.RequestControl(pid, ch)
+	bus.RequestControl(pid, control.Dispatch, ch)
 
 	asset1 := mockasset.New()
 	asset2 := mockasset.New()
@@ -130,7 +131,7 @@ func TestPushControl(t *testing.T) {
 
 	assertControl := mockasset.AssertedControl()
 
-	ch <- msg.New(pid, msg.Control, msg.New(asset1.PID(), msg.Control, assertControl))
+	ch <- msg.New(pid, msg.Control, assertControl).WithTarget(asset1.PID())
 
 	time.Sleep(100 * time.Millisecond)
 	assert.Assert(t, asset1.Control == assertControl, "Failed: %v != %v", asset1.Control, assertControl)
@@ -160,6 +161,23 @@ func TestEnergized(t *testing.T) {
 	}
 }
 
+func TestControlWithNoMembers(t *testing.T) {
+	bus := newDCBus()
+	defer bus.stopProcess()
+
+	ch := make(chan msg.Msg)
+	assert.NilError(t, bus.RequestControl(uuid.New(), control.Dispatch, ch))
+
+	// the arbiter is not blocked by a bus with no members to control
+	for i := 0; i < 2; i++ {
+		select {
+		case ch <- msg.New(uuid.New(), msg.Control, 0).WithTarget(uuid.New()):
+		case <-time.After(time.Second):
+			t.Fatal("control write blocked")
+		}
+	}
+}
+
 func TestStop(t *testing.T) {
 	bus := newDCBus()
 
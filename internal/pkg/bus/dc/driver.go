@@ -0,0 +1,35 @@
+package dc
+
+import (
+	"github.com/ohowland/cgc_core/internal/pkg/driver"
+)
+
+// RelayerFactory returns a Relayer configured from the bus json configuration
+type RelayerFactory func(jsonConfig []byte) (Relayer, error)
+
+var drivers = driver.NewRegistry("dc")
+
+// Register makes a Relayer driver available by the provided name.
+// Register panics if called twice with the same name or if factory is nil.
+func Register(name string, factory RelayerFactory) {
+	drivers.Register(name, factory)
+}
+
+// Drivers returns a sorted list of the names of the registered drivers.
+func Drivers() []string {
+	return drivers.Drivers()
+}
+
+// NewWithDriver returns a configured Bus whose Relayer is provided by the named driver
+func NewWithDriver(name string, jsonConfig []byte) (Bus, error) {
+	factory, err := drivers.Factory(name)
+	if err != nil {
+		return Bus{}, err
+	}
+
+	relay, err := factory.(RelayerFactory)(jsonConfig)
+	if err != nil {
+		return Bus{}, err
+	}
+	return New(jsonConfig, relay)
+}
@@ -6,3 +6,10 @@ import "github.com/ohowland/cgc_core/internal/pkg/asset"
 type Relayer interface {
 	asset.Voltage
 }
+
+// VirtualRelayer is a Relayer backed by a virtual system. Virtual devices
+// on the bus must be linked to the virtual system.
+type VirtualRelayer interface {
+	Relayer
+	AddMember(asset.VirtualDCAsset)
+}
@@ -6,13 +6,15 @@ import (
 	"strings"
 
 	"github.com/google/uuid"
+	"github.com/ohowland/cgc_core/internal/pkg/control"
 	"github.com/ohowland/cgc_core/internal/pkg/msg"
 )
 
 // Node types can be used as nodes in the Graph struct.
 type Node interface {
 	msg.Publisher
-	RequestControl(uuid.UUID, <-chan msg.Msg) error
+	RequestControl(uuid.UUID, control.Priority, <-chan msg.Msg) error
+	ReleaseControl(uuid.UUID) error
 	PID() uuid.UUID
 	Name() string
 }
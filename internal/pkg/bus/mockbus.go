@@ -4,6 +4,7 @@ import (
 	"sync"
 
 	"github.com/google/uuid"
+	"github.com/ohowland/cgc_core/internal/pkg/control"
 	"github.com/ohowland/cgc_core/internal/pkg/msg"
 )
 
@@ -12,6 +13,7 @@ type MockBus struct {
 	pid            uuid.UUID
 	LastControlMsg msg.Msg
 	publisher      *msg.PubSub
+	arbiter        *control.Arbiter
 	config         MockBusConfig
 }
 
@@ -48,7 +50,7 @@ func NewMockBus() (MockBus, error) {
 		},
 	}
 
-	return MockBus{&sync.Mutex{}, pid, msg.Msg{}, pub, config}, nil
+	return MockBus{&sync.Mutex{}, pid, msg.Msg{}, pub, nil, config}, nil
 }
 
 // AddMember links the asset parameter to the bus. Asset update status and update
@@ -78,26 +80,53 @@ func (b *MockBus) Subscribe(pid uuid.UUID, topic msg.Topic) (<-chan msg.Msg, err
 	return ch, err
 }
 
+// SubscribeFiltered returns a channel on which the subscribed topics are broadcast
+// when they pass the subscription filter
+func (b *MockBus) SubscribeFiltered(pid uuid.UUID, s msg.Subscription) (<-chan msg.Msg, error) {
+	ch, err := b.publisher.SubscribeFiltered(pid, s)
+	return ch, err
+}
+
 // Unsubscribe pid from all topic broadcasts
 func (b *MockBus) Unsubscribe(pid uuid.UUID) {
 	b.publisher.Unsubscribe(pid)
 }
 
 // RequestControl assigns a channel parameter to the bus control channel
-func (b *MockBus) RequestControl(pid uuid.UUID, ch <-chan msg.Msg) error {
+func (b *MockBus) RequestControl(pid uuid.UUID, priority control.Priority, ch <-chan msg.Msg) error {
 	b.mux.Lock()
 	defer b.mux.Unlock()
-	// TODO: previous owner needs to stop. how to enforce?
-	b.config.dynamic.ControlOwner = pid
-	go b.controlHandler(ch)
-	return nil
+	if b.arbiter == nil {
+		b.arbiter = control.NewArbiter(b.controlHandler)
+	}
+	err := b.arbiter.Request(pid, priority, ch)
+	b.config.dynamic.ControlOwner, _ = b.arbiter.Owner()
+	return err
+}
+
+// RequestTargetControl assigns a channel parameter to the control of the target
+func (b *MockBus) RequestTargetControl(pid uuid.UUID, priority control.Priority, target uuid.UUID, ch <-chan msg.Msg) error {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	if b.arbiter == nil {
+		b.arbiter = control.NewArbiter(b.controlHandler)
+	}
+	return b.arbiter.RequestTarget(pid, priority, target, ch)
 }
 
-func (b *MockBus) controlHandler(ch <-chan msg.Msg) {
-	ctrlMsg, ok := <-ch
-	if !ok {
-		return
+// ReleaseControl gives up the control request held by pid
+func (b *MockBus) ReleaseControl(pid uuid.UUID) error {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	if b.arbiter == nil {
+		return nil
 	}
+	err := b.arbiter.Release(pid)
+	b.config.dynamic.ControlOwner, _ = b.arbiter.Owner()
+	return err
+}
+
+func (b *MockBus) controlHandler(ctrlMsg msg.Msg) {
 	b.LastControlMsg = ctrlMsg
 }
 
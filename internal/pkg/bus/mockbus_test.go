@@ -6,6 +6,7 @@ import (
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/ohowland/cgc_core/internal/pkg/control"
 	"github.com/ohowland/cgc_core/internal/pkg/msg"
 	"gotest.tools/assert"
 )
@@ -58,7 +59,7 @@ func TestMockbusRequestControl(t *testing.T) {
 
 	pid, _ := uuid.NewUUID()
 	ch := make(chan msg.Msg)
-	bus1.RequestControl(pid, ch)
+	bus1.RequestControl(pid, control.Dispatch, ch)
 
 	assert.Equal(t, pid, bus1.config.dynamic.ControlOwner)
 
@@ -0,0 +1,321 @@
+package dnp3comm
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+// application control fields
+const (
+	appFIR = 0x80
+	appFIN = 0x40
+	appCON = 0x20
+	appUNS = 0x10
+)
+
+// function codes
+const (
+	fcConfirm         = 0x00
+	fcRead            = 0x01
+	fcWrite           = 0x02
+	fcSelect          = 0x03
+	fcOperate         = 0x04
+	fcDirectOperate   = 0x05
+	fcDirectOperateNR = 0x06
+	fcResponse        = 0x81
+)
+
+// object groups
+const (
+	groupBinary       = 1
+	groupBinaryEvent  = 2
+	groupCROB         = 12
+	groupAnalog       = 30
+	groupAnalogEvent  = 32
+	groupAnalogOutput = 41
+	groupClass        = 60
+	groupIIN          = 80
+)
+
+// qualifier codes
+const (
+	qualStartStop8  = 0x00
+	qualStartStop16 = 0x01
+	qualAll         = 0x06
+	qualCount8      = 0x07
+	qualCount16     = 0x08
+	qualPrefix8     = 0x17
+	qualPrefix16    = 0x28
+)
+
+// IIN is the internal indications of an outstation response. The first octet, IIN1,
+// is the low byte.
+type IIN uint16
+
+const (
+	IINAllStations      IIN = 0x0001
+	IINClass1Events     IIN = 0x0002
+	IINClass2Events     IIN = 0x0004
+	IINClass3Events     IIN = 0x0008
+	IINNeedTime         IIN = 0x0010
+	IINLocalControl     IIN = 0x0020
+	IINDeviceTrouble    IIN = 0x0040
+	IINDeviceRestart    IIN = 0x0080
+	IINNoFuncSupport    IIN = 0x0100
+	IINObjectUnknown    IIN = 0x0200
+	IINParameterError   IIN = 0x0400
+	IINEventOverflow    IIN = 0x0800
+	IINAlreadyExecuting IIN = 0x1000
+	IINConfigCorrupt    IIN = 0x2000
+)
+
+// classIIN is the IIN bit of each event class
+var classIIN = map[int]IIN{1: IINClass1Events, 2: IINClass2Events, 3: IINClass3Events}
+
+// point flags
+const (
+	FlagOnline   byte = 0x01
+	FlagRestart  byte = 0x02
+	FlagCommLost byte = 0x04
+	FlagState    byte = 0x80
+)
+
+// CommandStatus is the status of a control command
+type CommandStatus byte
+
+const (
+	StatusSuccess       CommandStatus = 0
+	StatusTimeout       CommandStatus = 1
+	StatusNoSelect      CommandStatus = 2
+	StatusFormatError   CommandStatus = 3
+	StatusNotSupported  CommandStatus = 4
+	StatusAlreadyActive CommandStatus = 5
+	StatusHardwareError CommandStatus = 6
+	StatusLocal         CommandStatus = 7
+	StatusTooManyOps    CommandStatus = 8
+	StatusNotAuthorized CommandStatus = 9
+)
+
+// CROB control codes
+const (
+	CodePulseOn  byte = 0x01
+	CodePulseOff byte = 0x02
+	CodeLatchOn  byte = 0x03
+	CodeLatchOff byte = 0x04
+	CodeClose    byte = 0x41
+	CodeTrip     byte = 0x81
+)
+
+// Command is a control command from a master: a control relay output block (CROB),
+// or an analog output
+type Command struct {
+	Index  uint16
+	Analog bool
+	// Value is the analog output value. A CROB commands 1 to latch on or close and 0
+	// to latch off or trip.
+	Value float64
+	// Code is the CROB control code
+	Code byte
+}
+
+// crobValue returns the value commanded by the CROB control code. The queue and clear
+// bits, and codes without an on or off sense, are not supported.
+func crobValue(code byte) (float64, bool) {
+	if code&0x30 != 0 {
+		return 0, false
+	}
+	switch code >> 6 {
+	case 1:
+		return 1, true
+	case 2:
+		return 0, true
+	case 0:
+		switch code & 0x0F {
+		case CodePulseOn, CodeLatchOn:
+			return 1, true
+		case CodePulseOff, CodeLatchOff:
+			return 0, true
+		}
+	}
+	return 0, false
+}
+
+// objectSize returns the size of an object of the command group and variation, or
+// false if the command object is not supported
+func objectSize(group byte, variation byte) (int, bool) {
+	switch {
+	case group == groupCROB && variation == 1:
+		return 11, true
+	case group == groupAnalogOutput && variation == 1:
+		return 5, true
+	case group == groupAnalogOutput && variation == 2:
+		return 3, true
+	case group == groupAnalogOutput && variation == 3:
+		return 5, true
+	case group == groupAnalogOutput && variation == 4:
+		return 9, true
+	}
+	return 0, false
+}
+
+// decodeCommand decodes a command object, less its status
+func decodeCommand(group byte, variation byte, index uint16, obj []byte) Command {
+	c := Command{Index: index}
+	if group == groupCROB {
+		c.Code = obj[0]
+		c.Value, _ = crobValue(c.Code)
+		return c
+	}
+	c.Analog = true
+	switch variation {
+	case 1:
+		c.Value = float64(int32(binary.LittleEndian.Uint32(obj)))
+	case 2:
+		c.Value = float64(int16(binary.LittleEndian.Uint16(obj)))
+	case 3:
+		c.Value = float64(math.Float32frombits(binary.LittleEndian.Uint32(obj)))
+	case 4:
+		c.Value = math.Float64frombits(binary.LittleEndian.Uint64(obj))
+	}
+	return c
+}
+
+// encodeCommand encodes a command as a CROB, latching on or off when no code is
+// given, or as a single precision analog output
+func encodeCommand(c Command) (group byte, variation byte, obj []byte) {
+	if c.Analog {
+		obj = make([]byte, 5)
+		binary.LittleEndian.PutUint32(obj, math.Float32bits(float32(c.Value)))
+		return groupAnalogOutput, 3, obj
+	}
+	code := c.Code
+	if code == 0 {
+		code = CodeLatchOff
+		if c.Value != 0 {
+			code = CodeLatchOn
+		}
+	}
+	obj = make([]byte, 11)
+	obj[0] = code
+	obj[1] = 1
+	return groupCROB, 1, obj
+}
+
+// header is an object header
+type header struct {
+	group     byte
+	variation byte
+	qualifier byte
+	start     uint16
+	stop      uint16
+	count     int
+}
+
+// all reports whether the header selects all objects
+func (h header) all() bool {
+	return h.qualifier == qualAll
+}
+
+// ranged reports whether the header selects the objects by start and stop index
+func (h header) ranged() bool {
+	return h.qualifier == qualStartStop8 || h.qualifier == qualStartStop16
+}
+
+// prefixSize returns the size of the index prefix of each object
+func (h header) prefixSize() int {
+	switch h.qualifier {
+	case qualPrefix8:
+		return 1
+	case qualPrefix16:
+		return 2
+	}
+	return 0
+}
+
+// parseHeader parses an object header, and returns the data following it
+func parseHeader(data []byte) (header, []byte, error) {
+	if len(data) < 3 {
+		return header{}, nil, errors.New("dnp3: short object header")
+	}
+	h := header{group: data[0], variation: data[1], qualifier: data[2]}
+	data = data[3:]
+
+	short := errors.New("dnp3: short object range")
+	switch h.qualifier {
+	case qualStartStop8:
+		if len(data) < 2 {
+			return header{}, nil, short
+		}
+		h.start, h.stop = uint16(data[0]), uint16(data[1])
+		data = data[2:]
+	case qualStartStop16:
+		if len(data) < 4 {
+			return header{}, nil, short
+		}
+		h.start, h.stop = binary.LittleEndian.Uint16(data), binary.LittleEndian.Uint16(data[2:])
+		data = data[4:]
+	case qualAll:
+	case qualCount8, qualPrefix8:
+		if len(data) < 1 {
+			return header{}, nil, short
+		}
+		h.count = int(data[0])
+		data = data[1:]
+	case qualCount16, qualPrefix16:
+		if len(data) < 2 {
+			return header{}, nil, short
+		}
+		h.count = int(binary.LittleEndian.Uint16(data))
+		data = data[2:]
+	default:
+		err := fmt.Sprintf("dnp3: unsupported qualifier 0x%02x", h.qualifier)
+		return header{}, nil, errors.New(err)
+	}
+	if h.ranged() {
+		if h.stop < h.start {
+			return header{}, nil, errors.New("dnp3: bad object range")
+		}
+		h.count = int(h.stop-h.start) + 1
+	}
+	return h, data, nil
+}
+
+// appendRange appends an object header selecting the objects from start to stop
+func appendRange(out []byte, group byte, variation byte, start uint16, stop uint16) []byte {
+	out = append(out, group, variation, qualStartStop16)
+	return appendUint16(out, start, stop)
+}
+
+// appendPrefixed appends an object header for count objects prefixed by a 16 bit index
+func appendPrefixed(out []byte, group byte, variation byte, count int) []byte {
+	out = append(out, group, variation, qualPrefix16)
+	return appendUint16(out, uint16(count))
+}
+
+func appendUint16(out []byte, values ...uint16) []byte {
+	for _, v := range values {
+		out = append(out, byte(v), byte(v>>8))
+	}
+	return out
+}
+
+// appendTime appends the time as a DNP3 timestamp, milliseconds since the epoch
+func appendTime(out []byte, t time.Time) []byte {
+	ms := uint64(t.UnixNano() / int64(time.Millisecond))
+	for i := 0; i < 6; i++ {
+		out = append(out, byte(ms>>(8*i)))
+	}
+	return out
+}
+
+// decodeTime decodes a DNP3 timestamp
+func decodeTime(data []byte) time.Time {
+	var ms uint64
+	for i := 0; i < 6; i++ {
+		ms |= uint64(data[i]) << (8 * i)
+	}
+	return time.Unix(0, int64(ms)*int64(time.Millisecond))
+}
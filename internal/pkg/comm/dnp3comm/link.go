@@ -0,0 +1,184 @@
+package dnp3comm
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// link layer control fields
+const (
+	dirBit = 0x80
+	prmBit = 0x40
+
+	// primary functions
+	linkResetLinkStates     = 0x0
+	linkTestLinkStates      = 0x2
+	linkConfirmedUserData   = 0x3
+	linkUnconfirmedUserData = 0x4
+	linkRequestLinkStatus   = 0x9
+
+	// secondary functions
+	linkAck          = 0x0
+	linkStatus       = 0xB
+	linkNotSupported = 0xF
+
+	maxLinkData = 250
+	blockSize   = 16
+)
+
+// transport header fields
+const (
+	transportFIN = 0x80
+	transportFIR = 0x40
+	maxSegment   = maxLinkData - 1
+)
+
+// frame is a link layer frame
+type frame struct {
+	control byte
+	dest    uint16
+	src     uint16
+	data    []byte
+}
+
+// encode returns the frame on the wire, the header and each block of user data
+// followed by its CRC
+func (f frame) encode() []byte {
+	header := []byte{0x05, 0x64, byte(5 + len(f.data)), f.control, 0, 0, 0, 0}
+	binary.LittleEndian.PutUint16(header[4:], f.dest)
+	binary.LittleEndian.PutUint16(header[6:], f.src)
+
+	out := appendBlock(make([]byte, 0, 10+len(f.data)+2*(len(f.data)/blockSize+1)), header)
+	for i := 0; i < len(f.data); i += blockSize {
+		end := i + blockSize
+		if end > len(f.data) {
+			end = len(f.data)
+		}
+		out = appendBlock(out, f.data[i:end])
+	}
+	return out
+}
+
+// appendBlock appends the block and its CRC
+func appendBlock(out []byte, block []byte) []byte {
+	c := crc(block)
+	out = append(out, block...)
+	return append(out, byte(c), byte(c>>8))
+}
+
+// readFrame reads a frame, checking the CRC of the header and each block
+func readFrame(r io.Reader) (frame, error) {
+	header := make([]byte, 10)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return frame{}, err
+	}
+	if header[0] != 0x05 || header[1] != 0x64 {
+		return frame{}, errors.New("dnp3: bad frame start")
+	}
+	if !checkBlock(header) {
+		return frame{}, errors.New("dnp3: bad header CRC")
+	}
+	if header[2] < 5 {
+		return frame{}, errors.New("dnp3: bad frame length")
+	}
+
+	f := frame{
+		control: header[3],
+		dest:    binary.LittleEndian.Uint16(header[4:]),
+		src:     binary.LittleEndian.Uint16(header[6:]),
+	}
+	for n := int(header[2]) - 5; n > 0; {
+		size := n
+		if size > blockSize {
+			size = blockSize
+		}
+		block := make([]byte, size+2)
+		if _, err := io.ReadFull(r, block); err != nil {
+			return frame{}, err
+		}
+		if !checkBlock(block) {
+			return frame{}, errors.New("dnp3: bad data CRC")
+		}
+		f.data = append(f.data, block[:size]...)
+		n -= size
+	}
+	return f, nil
+}
+
+// checkBlock reports whether the last two bytes of the block are the CRC of the rest
+func checkBlock(block []byte) bool {
+	n := len(block) - 2
+	return crc(block[:n]) == binary.LittleEndian.Uint16(block[n:])
+}
+
+// crc returns the DNP3 CRC-16 of the data
+func crc(data []byte) uint16 {
+	var c uint16
+	for _, b := range data {
+		c ^= uint16(b)
+		for i := 0; i < 8; i++ {
+			if c&1 != 0 {
+				c = (c >> 1) ^ 0xA6BC
+			} else {
+				c >>= 1
+			}
+		}
+	}
+	return ^c
+}
+
+// segments splits an application fragment into transport segments, numbered from
+// the sequence
+func segments(fragment []byte, seq *byte) [][]byte {
+	out := make([][]byte, 0, len(fragment)/maxSegment+1)
+	for i := 0; i == 0 || i < len(fragment); i += maxSegment {
+		end := i + maxSegment
+		if end > len(fragment) {
+			end = len(fragment)
+		}
+		th := *seq & 0x3F
+		if i == 0 {
+			th |= transportFIR
+		}
+		if end == len(fragment) {
+			th |= transportFIN
+		}
+		*seq = (*seq + 1) & 0x3F
+		out = append(out, append([]byte{th}, fragment[i:end]...))
+	}
+	return out
+}
+
+// reassembly joins transport segments into an application fragment
+type reassembly struct {
+	fragment []byte
+	active   bool
+	seq      byte
+}
+
+// add adds a segment, and returns the fragment when the segment is its last
+func (r *reassembly) add(segment []byte) ([]byte, bool) {
+	if len(segment) == 0 {
+		return nil, false
+	}
+	th := segment[0]
+	if th&transportFIR != 0 {
+		r.fragment = r.fragment[:0]
+		r.active = true
+	} else if !r.active || th&0x3F != r.seq {
+		// out of sequence, drop the fragment
+		r.active = false
+		return nil, false
+	}
+	r.seq = (th + 1) & 0x3F
+	r.fragment = append(r.fragment, segment[1:]...)
+
+	if th&transportFIN == 0 {
+		return nil, false
+	}
+	r.active = false
+	fragment := make([]byte, len(r.fragment))
+	copy(fragment, r.fragment)
+	return fragment, true
+}
@@ -0,0 +1,75 @@
+package dnp3comm
+
+import (
+	"bytes"
+	"testing"
+
+	"gotest.tools/assert"
+)
+
+func TestCRC(t *testing.T) {
+	assert.Equal(t, crc([]byte("123456789")), uint16(0xEA82))
+}
+
+// TestReferenceFrames checks the encoding against frames published for DNP3: a master
+// at 1024 resetting the link of the outstation at 1, and the acknowledgement.
+func TestReferenceFrames(t *testing.T) {
+	reset := frame{dirBit | prmBit | linkResetLinkStates, 1, 1024, nil}
+	assert.DeepEqual(t, reset.encode(), []byte{0x05, 0x64, 0x05, 0xC0, 0x01, 0x00, 0x00, 0x04, 0xE9, 0x21})
+
+	ack := frame{linkAck, 1024, 1, nil}
+	assert.DeepEqual(t, ack.encode(), []byte{0x05, 0x64, 0x05, 0x00, 0x00, 0x04, 0x01, 0x00, 0x19, 0xA6})
+}
+
+func TestFrameRoundTrip(t *testing.T) {
+	data := make([]byte, 40)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	f := frame{prmBit | linkUnconfirmedUserData, 10, 1, data}
+
+	encoded := f.encode()
+	// header, then blocks of 16, 16 and 8 bytes, each with a CRC
+	assert.Equal(t, len(encoded), 10+18+18+10)
+
+	decoded, err := readFrame(bytes.NewReader(encoded))
+	assert.NilError(t, err)
+	assert.Equal(t, decoded.control, f.control)
+	assert.Equal(t, decoded.dest, f.dest)
+	assert.Equal(t, decoded.src, f.src)
+	assert.Assert(t, bytes.Equal(decoded.data, f.data))
+
+	encoded[20] ^= 0xFF
+	_, err = readFrame(bytes.NewReader(encoded))
+	assert.Error(t, err, "dnp3: bad data CRC")
+}
+
+func TestSegments(t *testing.T) {
+	fragment := make([]byte, 600)
+	for i := range fragment {
+		fragment[i] = byte(i)
+	}
+
+	var seq byte = 62
+	segs := segments(fragment, &seq)
+	assert.Equal(t, len(segs), 3)
+	assert.Equal(t, segs[0][0], byte(transportFIR|62))
+	assert.Equal(t, segs[1][0], byte(63))
+	assert.Equal(t, segs[2][0], byte(transportFIN|0))
+	assert.Equal(t, seq, byte(1))
+
+	var r reassembly
+	for i, s := range segs {
+		out, ok := r.add(s)
+		assert.Equal(t, ok, i == len(segs)-1)
+		if ok {
+			assert.Assert(t, bytes.Equal(out, fragment))
+		}
+	}
+
+	// a missing segment drops the fragment
+	_, ok := r.add(segs[0])
+	assert.Assert(t, !ok)
+	_, ok = r.add(segs[2])
+	assert.Assert(t, !ok)
+}
@@ -0,0 +1,264 @@
+package dnp3comm
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"sync"
+	"time"
+)
+
+// Master is a minimal DNP3 master polling an outstation over TCP, for testing
+// outstations and for diagnostics. Responses are confirmed when requested.
+type Master struct {
+	mux        *sync.Mutex
+	conn       net.Conn
+	reader     *bufio.Reader
+	address    uint16
+	outstation uint16
+	timeout    time.Duration
+	seq        byte
+	tseq       byte
+	in         reassembly
+}
+
+// Binary is the value of a binary input. The Time of a static value is zero.
+type Binary struct {
+	Index uint16
+	Value bool
+	Flags byte
+	Time  time.Time
+}
+
+// Analog is the value of an analog input. The Time of a static value is zero.
+type Analog struct {
+	Index uint16
+	Value float64
+	Flags byte
+	Time  time.Time
+}
+
+// Response is the objects of an outstation response
+type Response struct {
+	IIN          IIN
+	Binaries     []Binary
+	Analogs      []Analog
+	BinaryEvents []Binary
+	AnalogEvents []Analog
+	Statuses     []CommandStatus
+}
+
+// Dial connects a master at the master address to the outstation at the TCP address,
+// "host:port". The timeout applies to the connection and to each request.
+func Dial(address string, master uint16, outstation uint16, timeout time.Duration) (*Master, error) {
+	conn, err := net.DialTimeout("tcp", address, timeout)
+	if err != nil {
+		return nil, err
+	}
+	return &Master{
+		mux:        &sync.Mutex{},
+		conn:       conn,
+		reader:     bufio.NewReader(conn),
+		address:    master,
+		outstation: outstation,
+		timeout:    timeout,
+	}, nil
+}
+
+// Close closes the connection
+func (m *Master) Close() error {
+	return m.conn.Close()
+}
+
+// Integrity reads the events of every class, then the static data
+func (m *Master) Integrity() (Response, error) {
+	return m.ReadClass(1, 2, 3, 0)
+}
+
+// ReadClass reads the events of classes 1 to 3, and the static data of class 0
+func (m *Master) ReadClass(classes ...int) (Response, error) {
+	objects := make([]byte, 0)
+	for _, c := range classes {
+		if c < 0 || c > 3 {
+			err := fmt.Sprintf("dnp3: bad class %v", c)
+			return Response{}, errors.New(err)
+		}
+		objects = append(objects, groupClass, byte(c+1), qualAll)
+	}
+	return m.request(fcRead, objects)
+}
+
+// ClearRestart clears the device restart indication of the outstation
+func (m *Master) ClearRestart() (Response, error) {
+	return m.request(fcWrite, []byte{groupIIN, 1, qualStartStop8, 7, 7, 0})
+}
+
+// DirectOperate operates the commands and returns the status of each
+func (m *Master) DirectOperate(commands ...Command) ([]CommandStatus, error) {
+	r, err := m.request(fcDirectOperate, commandObjects(commands))
+	return r.Statuses, err
+}
+
+// SelectOperate selects the commands, and operates them if each is selected. Returns
+// the status of each command.
+func (m *Master) SelectOperate(commands ...Command) ([]CommandStatus, error) {
+	objects := commandObjects(commands)
+	r, err := m.request(fcSelect, objects)
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range r.Statuses {
+		if s != StatusSuccess {
+			return r.Statuses, nil
+		}
+	}
+	r, err = m.request(fcOperate, objects)
+	return r.Statuses, err
+}
+
+// commandObjects returns the objects of the commands, each with its own header
+func commandObjects(commands []Command) []byte {
+	out := make([]byte, 0)
+	for _, c := range commands {
+		group, variation, obj := encodeCommand(c)
+		out = appendPrefixed(out, group, variation, 1)
+		out = appendUint16(out, c.Index)
+		out = append(out, obj...)
+	}
+	return out
+}
+
+// request sends a request and returns the response of the same sequence. The objects
+// of a response split over fragments are collected from each fragment in turn.
+func (m *Master) request(fc byte, objects []byte) (Response, error) {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	seq := m.seq
+	m.seq = (m.seq + 1) & 0x0F
+	if err := m.send(append([]byte{appFIR | appFIN | seq, fc}, objects...)); err != nil {
+		return Response{}, err
+	}
+
+	r := Response{}
+	first := true
+	m.conn.SetReadDeadline(time.Now().Add(m.timeout))
+	for {
+		f, err := readFrame(m.reader)
+		if err != nil {
+			return r, err
+		}
+		if f.dest != m.address || f.src != m.outstation || f.control&prmBit == 0 {
+			continue
+		}
+		fragment, ok := m.in.add(f.data)
+		if !ok || len(fragment) < 4 || fragment[1] != fcResponse || fragment[0]&0x0F != seq {
+			continue
+		}
+		if first != (fragment[0]&appFIR != 0) {
+			return r, errors.New("dnp3: response fragment out of order")
+		}
+		first = false
+
+		if fragment[0]&appCON != 0 {
+			if err := m.send([]byte{appFIR | appFIN | seq, fcConfirm}); err != nil {
+				return r, err
+			}
+		}
+		part, err := parseResponse(fragment)
+		r.IIN = part.IIN
+		r.Binaries = append(r.Binaries, part.Binaries...)
+		r.Analogs = append(r.Analogs, part.Analogs...)
+		r.BinaryEvents = append(r.BinaryEvents, part.BinaryEvents...)
+		r.AnalogEvents = append(r.AnalogEvents, part.AnalogEvents...)
+		r.Statuses = append(r.Statuses, part.Statuses...)
+		if err != nil || fragment[0]&appFIN != 0 {
+			return r, err
+		}
+
+		seq = (seq + 1) & 0x0F
+		m.conn.SetReadDeadline(time.Now().Add(m.timeout))
+	}
+}
+
+// send sends an application fragment
+func (m *Master) send(fragment []byte) error {
+	for _, segment := range segments(fragment, &m.tseq) {
+		f := frame{dirBit | prmBit | linkUnconfirmedUserData, m.outstation, m.address, segment}
+		if _, err := m.conn.Write(f.encode()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseResponse parses the objects of a response fragment
+func parseResponse(fragment []byte) (Response, error) {
+	r := Response{IIN: IIN(fragment[2]) | IIN(fragment[3])<<8}
+	data := fragment[4:]
+	for len(data) > 0 {
+		h, rest, err := parseHeader(data)
+		if err != nil {
+			return r, err
+		}
+
+		size := 0
+		switch {
+		case h.group == groupBinary && h.variation == 2 && h.ranged():
+			size = 1
+		case h.group == groupAnalog && h.variation == 2 && h.ranged():
+			size = 3
+		case h.group == groupAnalog && (h.variation == 1 || h.variation == 5) && h.ranged():
+			size = 5
+		case h.group == groupBinaryEvent && h.variation == 2 && h.prefixSize() == 2:
+			size = 9
+		case h.group == groupAnalogEvent && h.variation == 7 && h.prefixSize() == 2:
+			size = 13
+		default:
+			var ok bool
+			if size, ok = objectSize(h.group, h.variation); !ok || h.prefixSize() == 0 {
+				err := fmt.Sprintf("dnp3: unsupported response object g%vv%v", h.group, h.variation)
+				return r, errors.New(err)
+			}
+			size += h.prefixSize()
+		}
+		if len(rest) < h.count*size {
+			return r, errors.New("dnp3: short response object")
+		}
+
+		for i := 0; i < h.count; i++ {
+			obj := rest[i*size : (i+1)*size]
+			index := h.start + uint16(i)
+			switch h.group {
+			case groupBinary:
+				r.Binaries = append(r.Binaries, Binary{index, obj[0]&FlagState != 0, obj[0], time.Time{}})
+			case groupAnalog:
+				r.Analogs = append(r.Analogs, Analog{index, analogValue(h.variation, obj[1:]), obj[0], time.Time{}})
+			case groupBinaryEvent:
+				index = binary.LittleEndian.Uint16(obj)
+				r.BinaryEvents = append(r.BinaryEvents, Binary{index, obj[2]&FlagState != 0, obj[2], decodeTime(obj[3:])})
+			case groupAnalogEvent:
+				index = binary.LittleEndian.Uint16(obj)
+				r.AnalogEvents = append(r.AnalogEvents, Analog{index, analogValue(5, obj[3:]), obj[2], decodeTime(obj[7:])})
+			default:
+				r.Statuses = append(r.Statuses, CommandStatus(obj[size-1]))
+			}
+		}
+		data = rest[h.count*size:]
+	}
+	return r, nil
+}
+
+// analogValue decodes the value of an analog input variation
+func analogValue(variation byte, data []byte) float64 {
+	switch variation {
+	case 1:
+		return float64(int32(binary.LittleEndian.Uint32(data)))
+	case 2:
+		return float64(int16(binary.LittleEndian.Uint16(data)))
+	}
+	return float64(math.Float32frombits(binary.LittleEndian.Uint32(data)))
+}
@@ -0,0 +1,699 @@
+package dnp3comm
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+// defaultFragment is the largest response fragment when none is configured, and
+// minFragment the smallest fragment a master is required to accept
+const (
+	defaultFragment = 2048
+	minFragment     = 249
+)
+
+// OutstationConfig configures an outstation. SelectTimeout is the time in ms within
+// which a selected command must be operated, and EventBuffer is the number of events
+// held before the oldest is dropped. FragmentSize is the largest response fragment in
+// bytes. A read response larger than a fragment is split over fragments, and the
+// master confirms each before the next is sent. The events of a response fill at most
+// one fragment, the rest are left in the buffer for the next read.
+type OutstationConfig struct {
+	Address       uint16 `json:"Address"`
+	SelectTimeout int    `json:"SelectTimeout"`
+	EventBuffer   int    `json:"EventBuffer"`
+	FragmentSize  int    `json:"FragmentSize"`
+}
+
+// PointConfig configures an input point. Class is the event class, 1 to 3, of the
+// point, or 0 for no events. An analog input records an event when its value moves
+// more than the Deadband from the value of its last event.
+type PointConfig struct {
+	Index    uint16  `json:"Index"`
+	Class    int     `json:"Class"`
+	Deadband float64 `json:"Deadband"`
+}
+
+// Commands executes the control commands of a master. Select checks a command and
+// Operate executes it, each returning the status reported to the master.
+type Commands interface {
+	Select(Command) CommandStatus
+	Operate(Command) CommandStatus
+}
+
+// Outstation is a DNP3 outstation serving binary and analog inputs to masters over
+// TCP. It reports events of the input classes, each with the time of its update, and
+// passes CROB and analog output commands to its Commands. Unsolicited responses are
+// not supported, masters poll the event classes.
+type Outstation struct {
+	mux      *sync.Mutex
+	config   OutstationConfig
+	binaries map[uint16]*input
+	analogs  map[uint16]*input
+	events   []event
+	confirm  byte
+	iin      IIN
+	selected *selection
+	commands Commands
+	listener net.Listener
+	conns    map[net.Conn]bool
+}
+
+// input is the state of an input point
+type input struct {
+	config   PointConfig
+	value    float64
+	flags    byte
+	reported float64
+}
+
+// event is a change of an input, in the event group of the input
+type event struct {
+	group byte
+	index uint16
+	class int
+	value float64
+	flags byte
+	time  time.Time
+	sent  bool
+}
+
+// selection is the objects of a select request awaiting an operate request
+type selection struct {
+	seq     byte
+	objects []byte
+	at      time.Time
+}
+
+// NewOutstation returns an Outstation serving the binary and analog inputs. Inputs
+// report the restart flag until first updated.
+func NewOutstation(config OutstationConfig, binaries []PointConfig, analogs []PointConfig, commands Commands) (*Outstation, error) {
+	if config.SelectTimeout == 0 {
+		config.SelectTimeout = 5000
+	}
+	if config.EventBuffer == 0 {
+		config.EventBuffer = 100
+	}
+	if config.FragmentSize == 0 {
+		config.FragmentSize = defaultFragment
+	}
+	if config.FragmentSize < minFragment {
+		err := fmt.Sprintf("dnp3: fragment size %v is below %v", config.FragmentSize, minFragment)
+		return nil, errors.New(err)
+	}
+
+	o := &Outstation{
+		mux:      &sync.Mutex{},
+		config:   config,
+		iin:      IINDeviceRestart,
+		commands: commands,
+		conns:    make(map[net.Conn]bool),
+	}
+	var err error
+	if o.binaries, err = inputs("binary", binaries); err != nil {
+		return nil, err
+	}
+	if o.analogs, err = inputs("analog", analogs); err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+func inputs(kind string, configs []PointConfig) (map[uint16]*input, error) {
+	points := make(map[uint16]*input)
+	for _, c := range configs {
+		if c.Class < 0 || c.Class > 3 {
+			err := fmt.Sprintf("dnp3: %v input %v has bad class %v", kind, c.Index, c.Class)
+			return nil, errors.New(err)
+		}
+		if _, ok := points[c.Index]; ok {
+			err := fmt.Sprintf("dnp3: duplicate %v input %v", kind, c.Index)
+			return nil, errors.New(err)
+		}
+		points[c.Index] = &input{config: c, flags: FlagRestart}
+	}
+	return points, nil
+}
+
+// ListenTCP serves masters on the address, "host:port"
+func (o *Outstation) ListenTCP(address string) error {
+	l, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
+	}
+	o.mux.Lock()
+	o.listener = l
+	o.mux.Unlock()
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			o.mux.Lock()
+			o.conns[conn] = true
+			o.mux.Unlock()
+			go o.serve(conn)
+		}
+	}()
+	return nil
+}
+
+// Close stops listening and closes the connections of masters
+func (o *Outstation) Close() {
+	o.mux.Lock()
+	defer o.mux.Unlock()
+	if o.listener != nil {
+		o.listener.Close()
+	}
+	for conn := range o.conns {
+		conn.Close()
+	}
+}
+
+// UpdateBinary sets a binary input. An event is recorded when the value or quality
+// of the input changes.
+func (o *Outstation) UpdateBinary(index uint16, value bool, good bool, t time.Time) error {
+	v := 0.0
+	if value {
+		v = 1
+	}
+	return o.update(groupBinaryEvent, o.binaries, index, v, good, t)
+}
+
+// UpdateAnalog sets an analog input. An event is recorded when the quality of the
+// input changes or its value moves beyond the deadband.
+func (o *Outstation) UpdateAnalog(index uint16, value float64, good bool, t time.Time) error {
+	return o.update(groupAnalogEvent, o.analogs, index, value, good, t)
+}
+
+func (o *Outstation) update(group byte, inputs map[uint16]*input, index uint16, value float64, good bool, t time.Time) error {
+	o.mux.Lock()
+	defer o.mux.Unlock()
+
+	in, ok := inputs[index]
+	if !ok {
+		err := fmt.Sprintf("dnp3: no input %v", index)
+		return errors.New(err)
+	}
+
+	flags := FlagCommLost
+	if good {
+		flags = FlagOnline
+	}
+	if group == groupBinaryEvent && value != 0 {
+		flags |= FlagState
+	}
+	changed := flags != in.flags || math.Abs(value-in.reported) > in.config.Deadband
+	in.value, in.flags = value, flags
+	if !changed {
+		return nil
+	}
+	in.reported = value
+
+	if in.config.Class == 0 {
+		return nil
+	}
+	if len(o.events) >= o.config.EventBuffer {
+		o.events = o.events[1:]
+		o.iin |= IINEventOverflow
+	}
+	o.events = append(o.events, event{
+		group: group,
+		index: index,
+		class: in.config.Class,
+		value: value,
+		flags: flags,
+		time:  t,
+	})
+	return nil
+}
+
+// serve answers the requests of a master until the connection closes
+func (o *Outstation) serve(conn net.Conn) {
+	defer func() {
+		o.mux.Lock()
+		delete(o.conns, conn)
+		o.mux.Unlock()
+		conn.Close()
+	}()
+
+	r := bufio.NewReader(conn)
+	var in reassembly
+	var seq byte
+	var pending [][]byte
+	var awaiting byte
+	for {
+		f, err := readFrame(r)
+		if err != nil {
+			return
+		}
+		if f.dest != o.config.Address || f.control&prmBit == 0 {
+			continue
+		}
+
+		reply := func(function byte) error {
+			_, err := conn.Write(frame{function, f.src, o.config.Address, nil}.encode())
+			return err
+		}
+		switch f.control & 0x0F {
+		case linkResetLinkStates, linkTestLinkStates:
+			err = reply(linkAck)
+		case linkRequestLinkStatus:
+			err = reply(linkStatus)
+		case linkConfirmedUserData:
+			err = reply(linkAck)
+		case linkUnconfirmedUserData:
+		default:
+			err = reply(linkNotSupported)
+		}
+		if err != nil {
+			return
+		}
+		if fn := f.control & 0x0F; fn != linkConfirmedUserData && fn != linkUnconfirmedUserData {
+			continue
+		}
+
+		request, ok := in.add(f.data)
+		if !ok {
+			continue
+		}
+
+		var responses [][]byte
+		if len(pending) > 0 && len(request) >= 2 && request[1] == fcConfirm {
+			// the fragments of a response are sent as the master confirms each
+			if request[0]&0x0F != awaiting {
+				continue
+			}
+			responses, pending = pending, nil
+		} else {
+			pending = nil
+			responses = o.handle(request)
+		}
+		if len(responses) == 0 {
+			continue
+		}
+		if len(responses) > 1 {
+			pending, awaiting = responses[1:], responses[0][0]&0x0F
+		}
+		for _, segment := range segments(responses[0], &seq) {
+			if _, err := conn.Write(frame{prmBit | linkUnconfirmedUserData, f.src, o.config.Address, segment}.encode()); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// handle returns the fragments of the response to an application request, or nil if
+// there is none. Every fragment but the last of a response asks for confirmation, as
+// does the last when the response carries events.
+func (o *Outstation) handle(request []byte) [][]byte {
+	if len(request) < 2 {
+		return nil
+	}
+	seq := request[0] & 0x0F
+	fc := request[1]
+	objects := request[2:]
+
+	parts := [][]byte{nil}
+	var iin IIN
+	var events bool
+
+	switch fc {
+	case fcConfirm:
+		o.mux.Lock()
+		o.confirmed(seq)
+		o.mux.Unlock()
+		return nil
+	case fcRead:
+		o.mux.Lock()
+		var blocks [][]byte
+		blocks, iin, events = o.read(objects)
+		o.mux.Unlock()
+		parts = pack(blocks, o.config.FragmentSize-4)
+	case fcWrite:
+		o.mux.Lock()
+		iin = o.write(objects)
+		o.mux.Unlock()
+	case fcSelect, fcOperate, fcDirectOperate, fcDirectOperateNR:
+		var out []byte
+		out, iin = o.control(fc, seq, objects)
+		if fc == fcDirectOperateNR {
+			return nil
+		}
+		parts = [][]byte{out}
+	default:
+		iin = IINNoFuncSupport
+	}
+
+	o.mux.Lock()
+	defer o.mux.Unlock()
+	iin |= o.indications()
+	last := len(parts) - 1
+	if events {
+		o.confirm = (seq + byte(last)) & 0x0F
+	}
+	responses := make([][]byte, len(parts))
+	for i, part := range parts {
+		control := (seq + byte(i)) & 0x0F
+		if i == 0 {
+			control |= appFIR
+		}
+		if i == last {
+			control |= appFIN
+		}
+		if i < last || events {
+			control |= appCON
+		}
+		responses[i] = append([]byte{control, fcResponse, byte(iin), byte(iin >> 8)}, part...)
+	}
+	return responses
+}
+
+// pack returns the object blocks packed in order into fragments of at most size bytes
+// of objects. There is always at least one fragment.
+func pack(blocks [][]byte, size int) [][]byte {
+	parts := [][]byte{nil}
+	for _, block := range blocks {
+		last := len(parts) - 1
+		if len(parts[last]) > 0 && len(parts[last])+len(block) > size {
+			parts = append(parts, nil)
+			last++
+		}
+		parts[last] = append(parts[last], block...)
+	}
+	return parts
+}
+
+// indications returns the IIN of the outstation and its buffered events
+func (o *Outstation) indications() IIN {
+	iin := o.iin
+	for _, e := range o.events {
+		iin |= classIIN[e.class]
+	}
+	return iin
+}
+
+// confirmed removes the events sent in the response confirmed by the master. A
+// response split over fragments is confirmed by the confirmation of its last.
+func (o *Outstation) confirmed(seq byte) {
+	if seq != o.confirm {
+		return
+	}
+	events := o.events[:0]
+	for _, e := range o.events {
+		if !e.sent {
+			events = append(events, e)
+		}
+	}
+	o.events = events
+	if len(o.events) == 0 {
+		o.iin &^= IINEventOverflow
+	}
+}
+
+// read returns the objects of a read request in blocks that each fit a fragment, and
+// whether events were sent. Events sent in an unconfirmed response are sent again.
+func (o *Outstation) read(objects []byte) ([][]byte, IIN, bool) {
+	for i := range o.events {
+		o.events[i].sent = false
+	}
+
+	size := o.config.FragmentSize - 4
+	room := size
+	blocks := make([][]byte, 0)
+	var iin IIN
+	var events bool
+	for len(objects) > 0 {
+		h, rest, err := parseHeader(objects)
+		if err != nil || h.prefixSize() != 0 {
+			return blocks, iin | IINParameterError, events
+		}
+		objects = rest
+
+		limit := -1
+		if h.qualifier == qualCount8 || h.qualifier == qualCount16 {
+			limit = h.count
+		}
+		var event []byte
+		switch {
+		case h.group == groupClass && h.variation == 1:
+			blocks = append(blocks, staticBlocks(groupBinary, 2, o.binaries, header{qualifier: qualAll}, size)...)
+			blocks = append(blocks, staticBlocks(groupAnalog, 5, o.analogs, header{qualifier: qualAll}, size)...)
+		case h.group == groupClass && h.variation >= 2 && h.variation <= 4:
+			event, room = o.eventBlock(0, int(h.variation)-1, limit, room)
+		case h.group == groupBinary && (h.variation == 0 || h.variation == 2):
+			blocks = append(blocks, staticBlocks(groupBinary, 2, o.binaries, h, size)...)
+		case h.group == groupAnalog && (h.variation == 0 || h.variation == 1 || h.variation == 2 || h.variation == 5):
+			variation := h.variation
+			if variation == 0 {
+				variation = 5
+			}
+			blocks = append(blocks, staticBlocks(groupAnalog, variation, o.analogs, h, size)...)
+		case h.group == groupBinaryEvent && (h.variation == 0 || h.variation == 2):
+			event, room = o.eventBlock(groupBinaryEvent, 0, limit, room)
+		case h.group == groupAnalogEvent && (h.variation == 0 || h.variation == 7):
+			event, room = o.eventBlock(groupAnalogEvent, 0, limit, room)
+		default:
+			iin |= IINObjectUnknown
+		}
+		if len(event) > 0 {
+			blocks = append(blocks, event)
+			events = true
+		}
+	}
+	return blocks, iin, events
+}
+
+// staticBlocks returns the inputs selected by the header, in runs of contiguous
+// indices. A run is split into blocks of at most size bytes.
+func staticBlocks(group byte, variation byte, inputs map[uint16]*input, h header, size int) [][]byte {
+	indices := make([]int, 0, len(inputs))
+	for index := range inputs {
+		if h.all() || (h.ranged() && index >= h.start && index <= h.stop) {
+			indices = append(indices, int(index))
+		}
+	}
+	sort.Ints(indices)
+
+	header := len(appendRange(nil, group, variation, 0, 0))
+	per := (size - header) / len(appendValue(nil, group, variation, &input{}))
+	blocks := make([][]byte, 0)
+	for i := 0; i < len(indices); {
+		j := i
+		for j+1 < len(indices) && indices[j+1] == indices[j]+1 && j+1-i < per {
+			j++
+		}
+		block := appendRange(nil, group, variation, uint16(indices[i]), uint16(indices[j]))
+		for _, index := range indices[i : j+1] {
+			block = appendValue(block, group, variation, inputs[uint16(index)])
+		}
+		blocks = append(blocks, block)
+		i = j + 1
+	}
+	return blocks
+}
+
+// appendValue appends the static object of an input. An integer analog variation
+// reports the over range flag when the value does not fit.
+func appendValue(out []byte, group byte, variation byte, in *input) []byte {
+	if group == groupBinary {
+		return append(out, in.flags)
+	}
+
+	const overRange = 0x20
+	switch variation {
+	case 1:
+		v := math.Round(in.value)
+		flags := in.flags
+		if v > math.MaxInt32 || v < math.MinInt32 {
+			v, flags = math.Max(math.Min(v, math.MaxInt32), math.MinInt32), flags|overRange
+		}
+		out = append(out, flags, 0, 0, 0, 0)
+		binary.LittleEndian.PutUint32(out[len(out)-4:], uint32(int32(v)))
+	case 2:
+		v := math.Round(in.value)
+		flags := in.flags
+		if v > math.MaxInt16 || v < math.MinInt16 {
+			v, flags = math.Max(math.Min(v, math.MaxInt16), math.MinInt16), flags|overRange
+		}
+		out = append(out, flags)
+		out = appendUint16(out, uint16(int16(v)))
+	default:
+		out = append(out, in.flags, 0, 0, 0, 0)
+		binary.LittleEndian.PutUint32(out[len(out)-4:], math.Float32bits(float32(in.value)))
+	}
+	return out
+}
+
+// eventBlock returns, oldest first, the unsent events of the group and class, where 0
+// selects any, up to the limit and the room left for events in the response. Returns
+// the room left after the block.
+func (o *Outstation) eventBlock(group byte, class int, limit int, room int) ([]byte, int) {
+	groups := []byte{groupBinaryEvent, groupAnalogEvent}
+	variations := []byte{2, 7}
+	sizes := []int{9, 13}
+
+	selected := make([][]int, len(groups))
+	count := 0
+	for i, e := range o.events {
+		if e.sent || (group != 0 && e.group != group) || (class != 0 && e.class != class) {
+			continue
+		}
+		if limit >= 0 && count >= limit {
+			break
+		}
+		k := 0
+		if e.group == groupAnalogEvent {
+			k = 1
+		}
+		n := sizes[k]
+		if len(selected[k]) == 0 {
+			n += 5
+		}
+		if n > room {
+			break
+		}
+		room -= n
+		selected[k] = append(selected[k], i)
+		o.events[i].sent = true
+		count++
+	}
+
+	out := make([]byte, 0)
+	for k, events := range selected {
+		if len(events) == 0 {
+			continue
+		}
+		out = appendPrefixed(out, groups[k], variations[k], len(events))
+		for _, i := range events {
+			e := o.events[i]
+			out = appendUint16(out, e.index)
+			out = append(out, e.flags)
+			if e.group == groupAnalogEvent {
+				out = append(out, 0, 0, 0, 0)
+				binary.LittleEndian.PutUint32(out[len(out)-4:], math.Float32bits(float32(e.value)))
+			}
+			out = appendTime(out, e.time)
+		}
+	}
+	return out, room
+}
+
+// write applies a write request. Writing 0 to IIN1.7 clears the device restart
+// indication.
+func (o *Outstation) write(objects []byte) IIN {
+	h, rest, err := parseHeader(objects)
+	if err != nil {
+		return IINParameterError
+	}
+	if h.group != groupIIN || h.variation != 1 || !h.ranged() {
+		return IINObjectUnknown
+	}
+	if h.start != 7 || h.stop != 7 || len(rest) < 1 || rest[0]&1 != 0 {
+		return IINParameterError
+	}
+	o.iin &^= IINDeviceRestart
+	return 0
+}
+
+// control selects or operates the commands of a request, and returns the objects of
+// the request with the status of each command. An operate request must follow the
+// select request of the same objects, within the select timeout.
+func (o *Outstation) control(fc byte, seq byte, objects []byte) ([]byte, IIN) {
+	out := make([]byte, len(objects))
+	copy(out, objects)
+
+	commands := make([]Command, 0)
+	statuses := make([]CommandStatus, 0)
+	offsets := make([]int, 0)
+	var iin IIN
+
+	pos := 0
+	for pos < len(objects) {
+		h, rest, err := parseHeader(objects[pos:])
+		if err != nil {
+			iin = IINParameterError
+			break
+		}
+		size, ok := objectSize(h.group, h.variation)
+		if !ok {
+			iin = IINObjectUnknown
+			break
+		}
+		prefix := h.prefixSize()
+		if prefix == 0 || len(rest) < h.count*(prefix+size) {
+			iin = IINParameterError
+			break
+		}
+		pos = len(objects) - len(rest)
+
+		for i := 0; i < h.count; i++ {
+			obj := rest[i*(prefix+size):]
+			index := uint16(obj[0])
+			if prefix == 2 {
+				index = binary.LittleEndian.Uint16(obj)
+			}
+			c := decodeCommand(h.group, h.variation, index, obj[prefix:])
+			status := StatusSuccess
+			if _, ok := crobValue(c.Code); !c.Analog && !ok {
+				status = StatusNotSupported
+			}
+			if o.commands == nil {
+				status = StatusNotSupported
+			}
+			commands = append(commands, c)
+			statuses = append(statuses, status)
+			offsets = append(offsets, pos+i*(prefix+size)+prefix+size-1)
+		}
+		pos += h.count * (prefix + size)
+	}
+	if iin != 0 {
+		// echo the objects that were parsed
+		return out[:pos], iin
+	}
+
+	o.mux.Lock()
+	selected := o.selected
+	if fc == fcOperate {
+		o.selected = nil
+	}
+	o.mux.Unlock()
+	if fc == fcOperate {
+		timeout := time.Duration(o.config.SelectTimeout) * time.Millisecond
+		if selected == nil || selected.seq != (seq-1)&0x0F || !bytes.Equal(selected.objects, objects) || time.Since(selected.at) > timeout {
+			for i := range statuses {
+				statuses[i] = StatusNoSelect
+			}
+		}
+	}
+
+	all := true
+	for i, c := range commands {
+		if statuses[i] == StatusSuccess {
+			if fc == fcSelect {
+				statuses[i] = o.commands.Select(c)
+			} else {
+				statuses[i] = o.commands.Operate(c)
+			}
+		}
+		all = all && statuses[i] == StatusSuccess
+		out[offsets[i]] = byte(statuses[i])
+	}
+
+	if fc == fcSelect && all {
+		o.mux.Lock()
+		o.selected = &selection{seq, append([]byte{}, objects...), time.Now()}
+		o.mux.Unlock()
+	}
+	return out, 0
+}
@@ -0,0 +1,411 @@
+package dnp3comm
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"gotest.tools/assert"
+)
+
+// recorder records the commands passed to the outstation Commands
+type recorder struct {
+	mux      *sync.Mutex
+	selected []Command
+	operated []Command
+	status   CommandStatus
+}
+
+func (r *recorder) Select(c Command) CommandStatus {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	r.selected = append(r.selected, c)
+	return r.status
+}
+
+func (r *recorder) Operate(c Command) CommandStatus {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	r.operated = append(r.operated, c)
+	return r.status
+}
+
+func freeAddress(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NilError(t, err)
+	defer l.Close()
+	return l.Addr().String()
+}
+
+func newTestOutstation(t *testing.T, config OutstationConfig) (*Outstation, *recorder, *Master) {
+	config.Address = 10
+	binaries := []PointConfig{{Index: 0, Class: 1}, {Index: 1, Class: 1}}
+	analogs := []PointConfig{{Index: 0, Class: 2, Deadband: 1}, {Index: 1, Class: 2}, {Index: 5, Class: 0}}
+
+	r := &recorder{mux: &sync.Mutex{}}
+	o, err := NewOutstation(config, binaries, analogs, r)
+	assert.NilError(t, err)
+
+	addr := freeAddress(t)
+	assert.NilError(t, o.ListenTCP(addr))
+
+	m, err := Dial(addr, 1, 10, time.Second)
+	assert.NilError(t, err)
+	return o, r, m
+}
+
+func TestNewOutstationBadConfig(t *testing.T) {
+	_, err := NewOutstation(OutstationConfig{}, []PointConfig{{Index: 0, Class: 4}}, nil, nil)
+	assert.Error(t, err, "dnp3: binary input 0 has bad class 4")
+
+	_, err = NewOutstation(OutstationConfig{}, nil, []PointConfig{{Index: 2}, {Index: 2}}, nil)
+	assert.Error(t, err, "dnp3: duplicate analog input 2")
+
+	_, err = NewOutstation(OutstationConfig{FragmentSize: 100}, nil, nil, nil)
+	assert.Error(t, err, "dnp3: fragment size 100 is below 249")
+}
+
+// rawMaster writes requests and reads responses byte for byte, as a master at 1024
+// polling the outstation at 1
+type rawMaster struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+	tseq byte
+	in   reassembly
+}
+
+func dialRaw(t *testing.T, addr string) *rawMaster {
+	conn, err := net.Dial("tcp", addr)
+	assert.NilError(t, err)
+	return &rawMaster{t: t, conn: conn, r: bufio.NewReader(conn)}
+}
+
+func (m *rawMaster) write(b []byte) {
+	_, err := m.conn.Write(b)
+	assert.NilError(m.t, err)
+}
+
+// request sends the application fragment in a single segment
+func (m *rawMaster) request(fragment ...byte) {
+	segment := append([]byte{transportFIR | transportFIN | m.tseq}, fragment...)
+	m.tseq = (m.tseq + 1) & 0x3F
+	m.write(frame{dirBit | prmBit | linkUnconfirmedUserData, 1, 1024, segment}.encode())
+}
+
+// response returns the next application fragment, or false when none arrives within
+// the timeout
+func (m *rawMaster) response(timeout time.Duration) ([]byte, bool) {
+	m.conn.SetReadDeadline(time.Now().Add(timeout))
+	for {
+		f, err := readFrame(m.r)
+		if err != nil {
+			return nil, false
+		}
+		assert.Equal(m.t, f.dest, uint16(1024))
+		assert.Equal(m.t, f.src, uint16(1))
+		if fragment, ok := m.in.add(f.data); ok {
+			return fragment, true
+		}
+	}
+}
+
+func newRawOutstation(t *testing.T, config OutstationConfig, binaries []PointConfig, analogs []PointConfig) (*Outstation, *rawMaster) {
+	config.Address = 1
+	o, err := NewOutstation(config, binaries, analogs, nil)
+	assert.NilError(t, err)
+	addr := freeAddress(t)
+	assert.NilError(t, o.ListenTCP(addr))
+	return o, dialRaw(t, addr)
+}
+
+// TestRawExchange checks the outstation against the bytes of the requests a master
+// sends and the responses it expects, independent of Master
+func TestRawExchange(t *testing.T) {
+	o, m := newRawOutstation(t, OutstationConfig{}, []PointConfig{{Index: 0, Class: 1}}, []PointConfig{{Index: 0}})
+	defer o.Close()
+	defer m.conn.Close()
+
+	m.write([]byte{0x05, 0x64, 0x05, 0xC0, 0x01, 0x00, 0x00, 0x04, 0xE9, 0x21})
+	ack := make([]byte, 10)
+	m.conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, err := io.ReadFull(m.r, ack)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, ack, []byte{0x05, 0x64, 0x05, 0x00, 0x00, 0x04, 0x01, 0x00, 0x19, 0xA6})
+
+	t0 := time.Unix(1600000000, 123000000)
+	assert.NilError(t, o.UpdateBinary(0, true, true, t0))
+	assert.NilError(t, o.UpdateAnalog(0, 12.5, true, t0))
+
+	// integrity poll, class 1 2 3 0
+	m.request(0xC0, 0x01, 0x3C, 0x02, 0x06, 0x3C, 0x03, 0x06, 0x3C, 0x04, 0x06, 0x3C, 0x01, 0x06)
+	r, ok := m.response(time.Second)
+	assert.Assert(t, ok)
+	assert.DeepEqual(t, r, []byte{
+		0xE0, 0x81, 0x82, 0x00,
+		0x02, 0x02, 0x28, 0x01, 0x00, 0x00, 0x00, 0x81, 0x7B, 0x80, 0x6E, 0x87, 0x74, 0x01,
+		0x01, 0x02, 0x01, 0x00, 0x00, 0x00, 0x00, 0x81,
+		0x1E, 0x05, 0x01, 0x00, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x48, 0x41,
+	})
+
+	// the confirmed event is removed
+	m.request(0xC0, 0x00)
+	m.request(0xC1, 0x01, 0x3C, 0x02, 0x06)
+	r, ok = m.response(time.Second)
+	assert.Assert(t, ok)
+	assert.DeepEqual(t, r, []byte{0xC1, 0x81, 0x80, 0x00})
+
+	// clear the restart indication
+	m.request(0xC2, 0x02, 0x50, 0x01, 0x00, 0x07, 0x07, 0x00)
+	r, ok = m.response(time.Second)
+	assert.Assert(t, ok)
+	assert.DeepEqual(t, r, []byte{0xC2, 0x81, 0x00, 0x00})
+}
+
+// TestFragmentedResponse checks that static data larger than a fragment is split over
+// fragments, each sent when the master confirms the last
+func TestFragmentedResponse(t *testing.T) {
+	analogs := make([]PointConfig, 100)
+	for i := range analogs {
+		analogs[i] = PointConfig{Index: uint16(i)}
+	}
+	o, m := newRawOutstation(t, OutstationConfig{FragmentSize: minFragment}, nil, analogs)
+	defer o.Close()
+	defer m.conn.Close()
+
+	m.request(0xC3, 0x01, 0x3C, 0x01, 0x06)
+	objects := 0
+	for i := 0; ; i++ {
+		r, ok := m.response(time.Second)
+		assert.Assert(t, ok)
+		assert.Assert(t, len(r) <= minFragment)
+		seq := (3 + byte(i)) & 0x0F
+		assert.Equal(t, r[0]&0x0F, seq)
+		assert.Equal(t, r[0]&appFIR != 0, i == 0)
+		assert.Equal(t, r[1], byte(fcResponse))
+
+		response, err := parseResponse(r)
+		assert.NilError(t, err)
+		for _, a := range response.Analogs {
+			assert.Equal(t, a.Index, uint16(objects))
+			objects++
+		}
+		if r[0]&appFIN != 0 {
+			assert.Assert(t, r[0]&appCON == 0)
+			break
+		}
+		assert.Assert(t, r[0]&appCON != 0)
+
+		// the next fragment waits for the confirm
+		_, ok = m.response(50 * time.Millisecond)
+		assert.Assert(t, !ok)
+		m.r.Reset(m.conn)
+		m.request(0xC0|seq, 0x00)
+	}
+	assert.Equal(t, objects, 100)
+}
+
+func TestMasterFragmentedResponse(t *testing.T) {
+	analogs := make([]PointConfig, 100)
+	for i := range analogs {
+		analogs[i] = PointConfig{Index: uint16(i)}
+	}
+	o, err := NewOutstation(OutstationConfig{Address: 10, FragmentSize: minFragment}, nil, analogs, nil)
+	assert.NilError(t, err)
+	defer o.Close()
+	addr := freeAddress(t)
+	assert.NilError(t, o.ListenTCP(addr))
+	m, err := Dial(addr, 1, 10, time.Second)
+	assert.NilError(t, err)
+	defer m.Close()
+
+	for i := range analogs {
+		assert.NilError(t, o.UpdateAnalog(uint16(i), float64(i), true, time.Now()))
+	}
+	r, err := m.Integrity()
+	assert.NilError(t, err)
+	assert.Equal(t, len(r.Analogs), 100)
+	assert.Equal(t, r.Analogs[99], Analog{99, 99, FlagOnline, time.Time{}})
+
+	// the master is in step with the outstation after a fragmented response
+	r, err = m.ReadClass(0)
+	assert.NilError(t, err)
+	assert.Equal(t, len(r.Analogs), 100)
+}
+
+func TestIntegrity(t *testing.T) {
+	o, _, m := newTestOutstation(t, OutstationConfig{})
+	defer o.Close()
+	defer m.Close()
+
+	r, err := m.Integrity()
+	assert.NilError(t, err)
+	assert.Assert(t, r.IIN&IINDeviceRestart != 0)
+	assert.DeepEqual(t, r.Binaries, []Binary{{0, false, FlagRestart, time.Time{}}, {1, false, FlagRestart, time.Time{}}})
+	assert.Equal(t, len(r.Analogs), 3)
+	assert.Equal(t, r.Analogs[2].Index, uint16(5))
+	assert.Equal(t, r.Analogs[2].Flags, FlagRestart)
+
+	now := time.Now()
+	assert.NilError(t, o.UpdateBinary(1, true, true, now))
+	assert.NilError(t, o.UpdateAnalog(0, 12.5, true, now))
+	assert.NilError(t, o.UpdateAnalog(5, -3, false, now))
+	assert.Error(t, o.UpdateAnalog(9, 1, true, now), "dnp3: no input 9")
+
+	r, err = m.ReadClass(0)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, r.Binaries[1], Binary{1, true, FlagOnline | FlagState, time.Time{}})
+	assert.DeepEqual(t, r.Analogs[0], Analog{0, 12.5, FlagOnline, time.Time{}})
+	assert.DeepEqual(t, r.Analogs[2], Analog{5, -3, FlagCommLost, time.Time{}})
+
+	r, err = m.ClearRestart()
+	assert.NilError(t, err)
+	assert.Assert(t, r.IIN&IINDeviceRestart == 0)
+}
+
+func TestEvents(t *testing.T) {
+	o, _, m := newTestOutstation(t, OutstationConfig{})
+	defer o.Close()
+	defer m.Close()
+
+	t0 := time.Unix(1600000000, 123000000)
+	t1 := t0.Add(time.Second)
+	assert.NilError(t, o.UpdateBinary(0, true, true, t0))
+	assert.NilError(t, o.UpdateAnalog(0, 10, true, t0))
+	// within the deadband of the last event
+	assert.NilError(t, o.UpdateAnalog(0, 10.5, true, t1))
+	assert.NilError(t, o.UpdateAnalog(0, 12, true, t1))
+	// class 0 inputs record no events
+	assert.NilError(t, o.UpdateAnalog(5, 1, true, t1))
+
+	r, err := m.ReadClass(0)
+	assert.NilError(t, err)
+	assert.Assert(t, r.IIN&IINClass1Events != 0)
+	assert.Assert(t, r.IIN&IINClass2Events != 0)
+	assert.Equal(t, r.Analogs[0].Value, 12.0)
+
+	r, err = m.ReadClass(2)
+	assert.NilError(t, err)
+	assert.Equal(t, len(r.BinaryEvents), 0)
+	assert.DeepEqual(t, r.AnalogEvents, []Analog{{0, 10, FlagOnline, t0}, {0, 12, FlagOnline, t1}})
+	// the confirmed class 2 events are removed
+	assert.Assert(t, r.IIN&IINClass1Events != 0)
+
+	r, err = m.ReadClass(1, 2)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, r.BinaryEvents, []Binary{{0, true, FlagOnline | FlagState, t0}})
+	assert.Equal(t, len(r.AnalogEvents), 0)
+
+	r, err = m.ReadClass(1, 2, 3)
+	assert.NilError(t, err)
+	assert.Equal(t, len(r.BinaryEvents), 0)
+	assert.Assert(t, r.IIN&(IINClass1Events|IINClass2Events) == 0)
+}
+
+func TestEventOverflow(t *testing.T) {
+	o, _, m := newTestOutstation(t, OutstationConfig{EventBuffer: 2})
+	defer o.Close()
+	defer m.Close()
+
+	now := time.Now()
+	for i := 0; i < 3; i++ {
+		assert.NilError(t, o.UpdateAnalog(1, float64(i), true, now))
+	}
+
+	r, err := m.ReadClass(2)
+	assert.NilError(t, err)
+	assert.Assert(t, r.IIN&IINEventOverflow != 0)
+	assert.Equal(t, len(r.AnalogEvents), 2)
+	assert.Equal(t, r.AnalogEvents[0].Value, 1.0)
+
+	r, err = m.ReadClass(2)
+	assert.NilError(t, err)
+	assert.Assert(t, r.IIN&IINEventOverflow == 0)
+}
+
+func TestDirectOperate(t *testing.T) {
+	o, rec, m := newTestOutstation(t, OutstationConfig{})
+	defer o.Close()
+	defer m.Close()
+
+	statuses, err := m.DirectOperate(
+		Command{Index: 2, Value: 1},
+		Command{Index: 3, Code: CodeTrip},
+		Command{Index: 4, Analog: true, Value: -250.5},
+	)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, statuses, []CommandStatus{StatusSuccess, StatusSuccess, StatusSuccess})
+	assert.DeepEqual(t, rec.operated, []Command{
+		{Index: 2, Value: 1, Code: CodeLatchOn},
+		{Index: 3, Value: 0, Code: CodeTrip},
+		{Index: 4, Analog: true, Value: -250.5},
+	})
+	assert.Equal(t, len(rec.selected), 0)
+
+	rec.status = StatusHardwareError
+	statuses, err = m.DirectOperate(Command{Index: 2, Value: 0})
+	assert.NilError(t, err)
+	assert.DeepEqual(t, statuses, []CommandStatus{StatusHardwareError})
+
+	// codes without an on or off sense are not passed to the Commands
+	statuses, err = m.DirectOperate(Command{Index: 2, Code: 0x05})
+	assert.NilError(t, err)
+	assert.DeepEqual(t, statuses, []CommandStatus{StatusNotSupported})
+	assert.Equal(t, len(rec.operated), 4)
+}
+
+func TestSelectOperate(t *testing.T) {
+	o, rec, m := newTestOutstation(t, OutstationConfig{})
+	defer o.Close()
+	defer m.Close()
+
+	c := Command{Index: 7, Analog: true, Value: 60}
+	statuses, err := m.SelectOperate(c)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, statuses, []CommandStatus{StatusSuccess})
+	assert.DeepEqual(t, rec.selected, []Command{c})
+	assert.DeepEqual(t, rec.operated, []Command{c})
+
+	// an operate without a select is refused
+	r, err := m.request(fcOperate, commandObjects([]Command{c}))
+	assert.NilError(t, err)
+	assert.DeepEqual(t, r.Statuses, []CommandStatus{StatusNoSelect})
+	assert.Equal(t, len(rec.operated), 1)
+
+	// a refused select is not operated
+	rec.status = StatusLocal
+	statuses, err = m.SelectOperate(c)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, statuses, []CommandStatus{StatusLocal})
+	assert.Equal(t, len(rec.operated), 1)
+}
+
+func TestSelectTimeout(t *testing.T) {
+	o, rec, m := newTestOutstation(t, OutstationConfig{SelectTimeout: 10})
+	defer o.Close()
+	defer m.Close()
+
+	objects := commandObjects([]Command{{Index: 1, Value: 1}})
+	r, err := m.request(fcSelect, objects)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, r.Statuses, []CommandStatus{StatusSuccess})
+
+	time.Sleep(20 * time.Millisecond)
+	r, err = m.request(fcOperate, objects)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, r.Statuses, []CommandStatus{StatusNoSelect})
+	assert.Equal(t, len(rec.operated), 0)
+}
+
+func TestUnsupportedFunction(t *testing.T) {
+	o, _, m := newTestOutstation(t, OutstationConfig{})
+	defer o.Close()
+	defer m.Close()
+
+	r, err := m.request(0x0D, nil)
+	assert.NilError(t, err)
+	assert.Assert(t, r.IIN&IINNoFuncSupport != 0)
+}
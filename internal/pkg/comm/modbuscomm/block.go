@@ -0,0 +1,169 @@
+package modbuscomm
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/goburrow/modbus"
+)
+
+// table is a Modbus data table, each is read with its own function code
+type table int
+
+const (
+	coilTable table = iota
+	discreteTable
+	inputTable
+	holdingTable
+)
+
+// Protocol limits on the quantity of a single read request
+const (
+	maxBitsPerRead  = 2000
+	maxWordsPerRead = 125
+)
+
+// tableOf returns the table addressed by the register function code
+func tableOf(register Register) (table, error) {
+	switch register.FunctionCode {
+	case modbus.FuncCodeReadCoils, modbus.FuncCodeWriteSingleCoil, modbus.FuncCodeWriteMultipleCoils:
+		return coilTable, nil
+	case modbus.FuncCodeReadDiscreteInputs:
+		return discreteTable, nil
+	case modbus.FuncCodeReadInputRegisters:
+		return inputTable, nil
+	case modbus.FuncCodeReadHoldingRegisters, modbus.FuncCodeWriteSingleRegister, modbus.FuncCodeWriteMultipleRegisters, 0:
+		return holdingTable, nil
+	}
+	err := fmt.Sprintf("register %v: unsupported function code %v", register.Name, register.FunctionCode)
+	return 0, errors.New(err)
+}
+
+func (t table) isBits() bool {
+	return t == coilTable || t == discreteTable
+}
+
+// span returns the number of bits or words the register occupies in the table
+func (t table) span(register Register) int {
+	if t.isBits() {
+		return 1
+	}
+	return int(register.size())
+}
+
+// block is a contiguous range of a table covering one or more registers
+type block struct {
+	table     table
+	address   uint16
+	quantity  uint16
+	registers []Register
+}
+
+// planBlocks coalesces the registers of each table into blocks of at most maxSize
+// bits or words, joining registers separated by at most maxGap unused addresses.
+// Registers with an unsupported function code, or a string length that cannot be read
+// in one request, are returned with their error.
+func planBlocks(registers []Register, maxSize int, maxGap int) ([]block, map[string]error) {
+	type entry struct {
+		table    table
+		register Register
+	}
+
+	failed := make(map[string]error)
+	entries := make([]entry, 0, len(registers))
+	for _, register := range registers {
+		t, err := tableOf(register)
+		if err == nil {
+			err = register.check()
+		}
+		if err != nil {
+			failed[register.Name] = err
+			continue
+		}
+		entries = append(entries, entry{t, register})
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		if entries[i].table != entries[j].table {
+			return entries[i].table < entries[j].table
+		}
+		return entries[i].register.Address < entries[j].register.Address
+	})
+
+	blocks := make([]block, 0)
+	for _, e := range entries {
+		start := int(e.register.Address)
+		end := start + e.table.span(e.register)
+
+		if n := len(blocks); n > 0 {
+			b := &blocks[n-1]
+			blockStart := int(b.address)
+			blockEnd := blockStart + int(b.quantity)
+			if b.table == e.table && start <= blockEnd+maxGap && end-blockStart <= blockLimit(e.table, maxSize) {
+				if end > blockEnd {
+					b.quantity = uint16(end - blockStart)
+				}
+				b.registers = append(b.registers, e.register)
+				continue
+			}
+		}
+		blocks = append(blocks, block{e.table, e.register.Address, uint16(end - start), []Register{e.register}})
+	}
+	return blocks, failed
+}
+
+// blockLimit returns the largest block of the table, bounded by the protocol limit
+func blockLimit(t table, maxSize int) int {
+	limit := maxWordsPerRead
+	if t.isBits() {
+		limit = maxBitsPerRead
+	}
+	if maxSize > 0 && maxSize < limit {
+		return maxSize
+	}
+	return limit
+}
+
+// read requests the block with the read function code of its table
+func (b block) read(client modbus.Client) ([]byte, error) {
+	switch b.table {
+	case coilTable:
+		return client.ReadCoils(b.address, b.quantity)
+	case discreteTable:
+		return client.ReadDiscreteInputs(b.address, b.quantity)
+	case inputTable:
+		return client.ReadInputRegisters(b.address, b.quantity)
+	default:
+		return client.ReadHoldingRegisters(b.address, b.quantity)
+	}
+}
+
+// points decodes the unscaled point of each register from the block read response
+func (b block) points(resp []byte) (map[string]Point, error) {
+	points := make(map[string]Point)
+	for _, register := range b.registers {
+		offset := int(register.Address - b.address)
+		if b.table.isBits() {
+			if offset/8 >= len(resp) {
+				return nil, errors.New("modbus: short block read response")
+			}
+			points[register.Name] = Point{Value: float64(resp[offset/8] >> (offset % 8) & 1)}
+			continue
+		}
+
+		offset *= 2
+		size := 2 * int(register.size())
+		if offset+size > len(resp) {
+			return nil, errors.New("modbus: short block read response")
+		}
+		points[register.Name] = decodePoint(resp[offset:offset+size], register)
+	}
+	return points, nil
+}
+
+// fail records the error against each register of the block
+func (b block) fail(failed map[string]error, err error) {
+	for _, register := range b.registers {
+		failed[register.Name] = err
+	}
+}
@@ -0,0 +1,113 @@
+package modbuscomm
+
+import (
+	"testing"
+
+	"gotest.tools/assert"
+)
+
+func blockNames(b block) []string {
+	names := make([]string, 0)
+	for _, register := range b.registers {
+		names = append(names, register.Name)
+	}
+	return names
+}
+
+func TestPlanBlocksContiguous(t *testing.T) {
+	regs := []Register{
+		{Name: "c", Address: 4, DataType: u32, FunctionCode: 3},
+		{Name: "a", Address: 0, DataType: u16, FunctionCode: 3},
+		{Name: "b", Address: 1, DataType: f64, FunctionCode: 16},
+	}
+	blocks, failed := planBlocks(regs, 0, 0)
+
+	assert.Equal(t, len(failed), 0)
+	assert.Equal(t, len(blocks), 1)
+	assert.Equal(t, blocks[0].table, holdingTable)
+	assert.Equal(t, blocks[0].address, uint16(0))
+	assert.Equal(t, blocks[0].quantity, uint16(6))
+	assert.DeepEqual(t, blockNames(blocks[0]), []string{"a", "b", "c"})
+}
+
+func TestPlanBlocksGap(t *testing.T) {
+	regs := []Register{
+		{Name: "a", Address: 0, DataType: u16, FunctionCode: 3},
+		{Name: "b", Address: 3, DataType: u16, FunctionCode: 3},
+		{Name: "c", Address: 7, DataType: u16, FunctionCode: 3},
+	}
+
+	blocks, _ := planBlocks(regs, 0, 2)
+	assert.Equal(t, len(blocks), 2)
+	assert.DeepEqual(t, blockNames(blocks[0]), []string{"a", "b"})
+	assert.Equal(t, blocks[0].quantity, uint16(4))
+
+	blocks, _ = planBlocks(regs, 0, 3)
+	assert.Equal(t, len(blocks), 1)
+	assert.Equal(t, blocks[0].quantity, uint16(8))
+}
+
+func TestPlanBlocksMaxSize(t *testing.T) {
+	regs := []Register{
+		{Name: "a", Address: 0, DataType: u32, FunctionCode: 3},
+		{Name: "b", Address: 2, DataType: u32, FunctionCode: 3},
+		{Name: "c", Address: 4, DataType: u32, FunctionCode: 3},
+	}
+
+	blocks, _ := planBlocks(regs, 4, 0)
+	assert.Equal(t, len(blocks), 2)
+	assert.DeepEqual(t, blockNames(blocks[0]), []string{"a", "b"})
+	assert.DeepEqual(t, blockNames(blocks[1]), []string{"c"})
+}
+
+func TestPlanBlocksTables(t *testing.T) {
+	regs := []Register{
+		{Name: "holding", Address: 0, DataType: u16, FunctionCode: 3},
+		{Name: "input", Address: 1, DataType: u16, FunctionCode: 4},
+		{Name: "coil", Address: 2, DataType: boolean, FunctionCode: 1},
+		{Name: "coil2", Address: 3, DataType: boolean, FunctionCode: 5},
+		{Name: "bad", Address: 4, DataType: u16, FunctionCode: 99},
+	}
+	blocks, failed := planBlocks(regs, 0, 0)
+
+	assert.Equal(t, len(blocks), 3)
+	assert.Equal(t, blocks[0].table, coilTable)
+	assert.DeepEqual(t, blockNames(blocks[0]), []string{"coil", "coil2"})
+	assert.Equal(t, blocks[1].table, inputTable)
+	assert.Equal(t, blocks[2].table, holdingTable)
+	assert.Error(t, failed["bad"], "register bad: unsupported function code 99")
+}
+
+func TestPlanBlocksStringLength(t *testing.T) {
+	regs := []Register{
+		{Name: "empty", Address: 0, DataType: str, FunctionCode: 3},
+		{Name: "long", Address: 10, DataType: str, Length: maxWordsPerRead + 1, FunctionCode: 3},
+		{Name: "max", Address: 200, DataType: str, Length: maxWordsPerRead, FunctionCode: 3},
+	}
+	blocks, failed := planBlocks(regs, 0, 0)
+
+	assert.Equal(t, len(blocks), 1)
+	assert.DeepEqual(t, blockNames(blocks[0]), []string{"max"})
+	assert.Error(t, failed["empty"], "register empty: string length 0 is not between 1 and 125")
+	assert.Error(t, failed["long"], "register long: string length 126 is not between 1 and 125")
+}
+
+func TestBlockPointsBits(t *testing.T) {
+	b := block{coilTable, 8, 10, []Register{
+		{Name: "first", Address: 8, DataType: boolean, FunctionCode: 1},
+		{Name: "ninth", Address: 16, DataType: boolean, FunctionCode: 1},
+		{Name: "second", Address: 9, DataType: boolean, FunctionCode: 1},
+	}}
+	points, err := b.points([]byte{0x01, 0x01})
+
+	assert.NilError(t, err)
+	assert.DeepEqual(t, points, map[string]Point{"first": {Value: 1}, "ninth": {Value: 1}, "second": {Value: 0}})
+}
+
+func TestBlockPointsShortResponse(t *testing.T) {
+	b := block{holdingTable, 0, 2, []Register{
+		{Name: "a", Address: 0, DataType: u32, FunctionCode: 3},
+	}}
+	_, err := b.points([]byte{0, 1})
+	assert.Error(t, err, "modbus: short block read response")
+}
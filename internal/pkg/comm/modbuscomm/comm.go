@@ -1,9 +1,18 @@
 package modbuscomm
 
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/ohowland/cgc_core/internal/pkg/asset"
+)
+
 // ModbusComm interface
 type ModbusComm interface {
 	Read([]Register) ([]byte, error)
 	Write([]Register, []byte) error
+	Close() error
 }
 
 // DataType defines the type of Modbus register for encoding/decoding
@@ -19,6 +28,11 @@ const (
 	i64 DataType = "i64"
 	f32 DataType = "f32"
 	f64 DataType = "f64"
+	// boolean is a single coil or discrete input, or a status word register tested
+	// against the register Bitmask
+	boolean DataType = "bool"
+	// str is an ASCII string of Length registers, two characters per register
+	str DataType = "string"
 )
 
 // Access devices the register read/write type
@@ -39,14 +53,108 @@ const (
 	bigEndian    Endian = "big"
 )
 
-// Register contains the data required to read and write a Modbus register
+// Register contains the data required to read and write a Modbus register.
+// FunctionCode selects the Modbus table: coils (1, 5, 15), discrete inputs (2),
+// input registers (4) or holding registers (3, 6, 16, the default). A read uses the
+// read function code of the table. A write uses the write function code given, or the
+// multiple write function code of the table when a read function code is given.
+// Bitmask selects the bits of a bool status word register, zero tests the whole word.
+// A value read outside of the optional Min and Max is flagged out-of-range.
+//
+// Endianness orders the bytes of each 16 bit register and WordOrder the registers of
+// a multi-register value, WordOrder follows Endianness when unset. A numeric value is
+// scaled as raw * Scale * 10^sf + Offset, where Scale defaults to 1 and sf is the value
+// of the ScaleFactor register (a SunSpec _SF register), or 0 when unset. The value of
+// an Enum register is labelled by the Enum entry, and each set bit of a Bits register
+// is reported by name. A device reads the optional Unimplemented raw value from a
+// register it does not implement, and a ScaleFactor register it does not implement
+// reads -32768, the SunSpec not implemented value. Either is flagged out-of-range.
 type Register struct {
-	Name         string   `json:"Name"`
-	Address      uint16   `json:"Address"`
-	DataType     DataType `json:"DataType"`
-	FunctionCode int      `json:"FunctionCode"`
-	AccessType   Access   `json:"AccessType"`
-	Endianness   Endian   `json:"Endianness"`
+	Name          string   `json:"Name"`
+	Address       uint16   `json:"Address"`
+	DataType      DataType `json:"DataType"`
+	FunctionCode  int      `json:"FunctionCode"`
+	AccessType    Access   `json:"AccessType"`
+	Endianness    Endian   `json:"Endianness"`
+	Bitmask       uint16   `json:"Bitmask"`
+	Min           *float64 `json:"Min"`
+	Max           *float64 `json:"Max"`
+	WordOrder     Endian   `json:"WordOrder"`
+	Length        uint16   `json:"Length"`
+	Scale         float64  `json:"Scale"`
+	Offset        float64  `json:"Offset"`
+	ScaleFactor   string   `json:"ScaleFactor"`
+	Unimplemented *float64 `json:"Unimplemented"`
+
+	Enum map[int64]string `json:"Enum"`
+	Bits map[uint]string  `json:"Bits"`
+}
+
+// sfUnimplemented is the value of a SunSpec scale factor the device does not implement
+const sfUnimplemented = -32768
+
+// size returns the number of u16 registers the register value occupies
+func (r Register) size() uint16 {
+	if r.DataType == str {
+		return r.Length
+	}
+	return sizeOf(r.DataType)
+}
+
+// check returns an error when the register value cannot be read in one request. A
+// string occupies 1 to 125 registers.
+func (r Register) check() error {
+	if r.DataType == str && (r.Length < 1 || r.Length > maxWordsPerRead) {
+		err := fmt.Sprintf("register %v: string length %v is not between 1 and %v", r.Name, r.Length, maxWordsPerRead)
+		return errors.New(err)
+	}
+	return nil
+}
+
+// scale converts a raw register value into engineering units
+func (r Register) scale(raw float64, sf float64) float64 {
+	val := raw * math.Pow(10, sf)
+	if r.Scale != 0 {
+		val *= r.Scale
+	}
+	return val + r.Offset
+}
+
+// unscale converts a value in engineering units into the raw register value. Scaled
+// integer registers are rounded to the nearest raw value.
+func (r Register) unscale(val float64, sf float64) float64 {
+	if r.Scale == 0 && r.Offset == 0 && sf == 0 {
+		return val
+	}
+	raw := (val - r.Offset) / math.Pow(10, sf)
+	if r.Scale != 0 {
+		raw /= r.Scale
+	}
+	if r.DataType != f32 && r.DataType != f64 {
+		raw = math.Round(raw)
+	}
+	return raw
+}
+
+// inRange returns false when the value is outside of the register limits
+func (r Register) inRange(val float64) bool {
+	if r.Min != nil && val < *r.Min {
+		return false
+	}
+	if r.Max != nil && val > *r.Max {
+		return false
+	}
+	return true
+}
+
+// Point is a register value with the quality of the read. Text holds the value of a
+// string register or the label of an enum value, Flags the names of the set bits of a
+// bitfield.
+type Point struct {
+	Value   float64       `json:"Value"`
+	Quality asset.Quality `json:"Quality"`
+	Text    string        `json:"Text,omitempty"`
+	Flags   []string      `json:"Flags,omitempty"`
 }
 
 // FilterRegisters returns registers from array with matching access type
@@ -4,69 +4,255 @@ import (
 	"encoding/binary"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"log"
 	"math"
 	"os"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/goburrow/modbus"
+	"github.com/ohowland/cgc_core/internal/pkg/asset"
+	"github.com/ohowland/cgc_core/internal/pkg/metrics"
 )
 
-// Poller continiously polls a target
+// Poll metrics, labeled by target address and slave ID
+var (
+	pollSeconds = metrics.NewHistogram("cgc_modbus_poll_seconds",
+		"Time taken to poll the registers of a Modbus target.", metrics.DefaultBuckets, "target", "slave")
+	pollErrors = metrics.NewCounter("cgc_modbus_poll_errors_total",
+		"Polls of a Modbus target that failed to read a register.", "target", "slave")
+)
+
+func init() {
+	metrics.Register(pollSeconds)
+	metrics.Register(pollErrors)
+}
+
+// Poller continiously polls a target. The connection is held open between polls
+// and reopened on the next poll after a transport error.
 type Poller struct {
-	handler  *modbus.TCPClientHandler
-	pollRate int
+	mux          *sync.Mutex
+	handler      clientHandler
+	client       modbus.Client
+	target       string
+	slave        string
+	pollRate     int
+	maxBlockSize int
+	maxGap       int
+	staleTimeout time.Duration
+	last         map[string]sample
+}
+
+// sample is the last good point read from a register
+type sample struct {
+	point Point
+	time  time.Time
+}
+
+// clientHandler is a Modbus transport that can be opened and closed
+type clientHandler interface {
+	modbus.ClientHandler
+	Connect() error
+	Close() error
 }
 
-// PollerConfig is the configuration format for ModbusPoller
+// Transports supported by the Poller
+const (
+	tcp = "tcp"
+	rtu = "rtu"
+)
+
+// PollerConfig is the configuration format for ModbusPoller. Transport is "tcp"
+// (default) or "rtu". IPAddr and Port address a TCP target. Device, BaudRate,
+// DataBits, Parity ("N", "E" or "O") and StopBits configure an RTU serial line,
+// the serial defaults are 19200 baud, 8 data bits, even parity and 1 stop bit.
+// Contiguous registers are read in blocks of up to MaxBlockSize registers (or coils),
+// registers separated by up to MaxGap unused addresses share a block. StaleTimeout is
+// the time in milliseconds a failed register holds its last good value.
 type PollerConfig struct {
+	Transport    string `json:"Transport"`
 	IPAddr       string `json:"IPAddr"`
 	Port         string `json:"Port"`
+	Device       string `json:"Device"`
+	BaudRate     int    `json:"BaudRate"`
+	DataBits     int    `json:"DataBits"`
+	Parity       string `json:"Parity"`
+	StopBits     int    `json:"StopBits"`
 	SlaveID      byte   `json:"SlaveID"`
 	Timeout      int    `json:"Timeout"`
 	PollRate     int    `json:"PollRate"`
+	MaxBlockSize int    `json:"MaxBlockSize"`
+	MaxGap       int    `json:"MaxGap"`
+	StaleTimeout int    `json:"StaleTimeout"`
 	EnableLogger bool
 }
 
 // NewPoller is a factory for the Poller struct
-func NewPoller(cfg PollerConfig) Poller {
-	handler := modbus.NewTCPClientHandler(cfg.IPAddr + ":" + cfg.Port)
-	handler.Timeout = time.Millisecond * time.Duration(cfg.Timeout)
-	handler.SlaveId = cfg.SlaveID
-
+func NewPoller(cfg PollerConfig) (Poller, error) {
+	var logger *log.Logger
 	if cfg.EnableLogger {
-		handler.Logger = log.New(os.Stdout, "modbus: ", log.LstdFlags)
+		logger = log.New(os.Stdout, "modbus: ", log.LstdFlags)
 	}
+	timeout := time.Millisecond * time.Duration(cfg.Timeout)
 
-	return Poller{
-		handler:  handler,
-		pollRate: cfg.PollRate,
+	var handler clientHandler
+	var target string
+	switch cfg.Transport {
+	case tcp, "":
+		target = cfg.IPAddr + ":" + cfg.Port
+		h := modbus.NewTCPClientHandler(target)
+		h.Timeout = timeout
+		h.SlaveId = cfg.SlaveID
+		h.Logger = logger
+		handler = h
+	case rtu:
+		target = cfg.Device
+		h := modbus.NewRTUClientHandler(cfg.Device)
+		h.BaudRate = cfg.BaudRate
+		h.DataBits = cfg.DataBits
+		h.Parity = cfg.Parity
+		h.StopBits = cfg.StopBits
+		h.Timeout = timeout
+		h.SlaveId = cfg.SlaveID
+		h.Logger = logger
+		handler = h
+	default:
+		err := fmt.Sprintf("unsupported modbus transport %v", cfg.Transport)
+		return Poller{}, errors.New(err)
 	}
+
+	return Poller{
+		mux:          &sync.Mutex{},
+		handler:      handler,
+		client:       modbus.NewClient(handler),
+		target:       target,
+		slave:        strconv.Itoa(int(cfg.SlaveID)),
+		pollRate:     cfg.PollRate,
+		maxBlockSize: cfg.MaxBlockSize,
+		maxGap:       cfg.MaxGap,
+		staleTimeout: time.Duration(cfg.StaleTimeout) * time.Millisecond,
+		last:         make(map[string]sample),
+	}, nil
 }
 
+// Read polls the registers and returns a JSON object of Points keyed by register
+// name. Every register is present in the response. A register that could not be read
+// holds its last good value as Stale for up to the StaleTimeout, then reads as
+// CommFail. The error returned is the first read error of the poll. A register scaled
+// by a ScaleFactor register is read only when that register is read in the same poll.
+// The poll time and failed polls are recorded in the poll metrics.
 func (m Poller) Read(registers []Register) ([]byte, error) {
-	err := m.handler.Connect()
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	start := time.Now()
+	response, err := m.read(registers)
+	pollSeconds.Observe(time.Since(start).Seconds(), m.target, m.slave)
 	if err != nil {
-		return nil, err
+		pollErrors.Inc(m.target, m.slave)
 	}
-	defer m.handler.Close()
+	return response, err
+}
 
-	client := modbus.NewClient(m.handler)
-	readValues := make(map[string]float64)
-	for _, register := range registers {
-		resp, readErr := client.ReadHoldingRegisters(register.Address, sizeOf(register.DataType))
+// read polls the registers, see Read. The caller holds the lock.
+func (m Poller) read(registers []Register) ([]byte, error) {
+	blocks, failed := planBlocks(registers, m.maxBlockSize, m.maxGap)
+	raw := make(map[string]Point)
+
+	connErr := m.handler.Connect()
+	for _, b := range blocks {
+		if connErr != nil {
+			// skip the remaining blocks rather than wait out a timeout on each
+			b.fail(failed, connErr)
+			continue
+		}
+
+		resp, readErr := b.read(m.client)
+		var points map[string]Point
+		if readErr == nil {
+			points, readErr = b.points(resp)
+		}
 		if readErr != nil {
-			readValues[register.Name] = 0xBEEF
+			if m.dropOnError(readErr) {
+				connErr = readErr
+			}
+			b.fail(failed, readErr)
+			continue
+		}
+		for name, p := range points {
+			raw[name] = p
+		}
+	}
+
+	now := time.Now()
+	points := make(map[string]Point)
+	var err error
+	for _, register := range registers {
+		p, readErr := m.qualify(register, raw, failed, now)
+		if readErr != nil && err == nil {
 			err = readErr
-		} else {
-			readValues[register.Name] = decode(resp, register)
 		}
+		points[register.Name] = p
 	}
-	response, err := json.Marshal(readValues)
 
+	response, marshalErr := json.Marshal(points)
+	if marshalErr != nil {
+		return nil, marshalErr
+	}
 	return response, err
 }
 
+// qualify scales the raw point of the register and sets its quality. Good points are
+// recorded as the last good value.
+func (m Poller) qualify(register Register, raw map[string]Point, failed map[string]error, now time.Time) (Point, error) {
+	if err, ok := failed[register.Name]; ok {
+		return m.failed(register, now), err
+	}
+
+	p := raw[register.Name]
+	if register.DataType != str {
+		if register.Unimplemented != nil && p.Value == *register.Unimplemented {
+			return Point{Quality: asset.OutOfRange}, nil
+		}
+		var sf float64
+		if register.ScaleFactor != "" {
+			sfPoint, ok := raw[register.ScaleFactor]
+			if !ok {
+				err := fmt.Sprintf("register %v: scale factor %v not read", register.Name, register.ScaleFactor)
+				return m.failed(register, now), errors.New(err)
+			}
+			sf = sfPoint.Value
+			if sf == sfUnimplemented {
+				return Point{Quality: asset.OutOfRange}, nil
+			}
+		}
+		p.Value = register.scale(p.Value, sf)
+
+		if !register.inRange(p.Value) {
+			p.Quality = asset.OutOfRange
+			return p, nil
+		}
+	}
+
+	p.Quality = asset.Good
+	m.last[register.Name] = sample{p, now}
+	return p, nil
+}
+
+// failed returns the point of a register that could not be read
+func (m Poller) failed(register Register, now time.Time) Point {
+	last, ok := m.last[register.Name]
+	if ok && now.Sub(last.time) <= m.staleTimeout {
+		p := last.point
+		p.Quality = asset.Stale
+		return p
+	}
+	return Point{Quality: asset.CommFail}
+}
+
 func (m Poller) Write(registers []Register, jsonWriteValues []byte) error {
 	writeValues := make(map[string]float64)
 
@@ -75,21 +261,22 @@ func (m Poller) Write(registers []Register, jsonWriteValues []byte) error {
 		return err
 	}
 
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
 	err = m.handler.Connect()
 	if err != nil {
 		return err
 	}
-	defer m.handler.Close()
 
-	client := modbus.NewClient(m.handler)
 	for name, val := range writeValues {
 		i, writeErr := findIndexByName(registers, name)
 		if writeErr != nil {
 			err = writeErr
 		} else {
-			valBytes := encode(val, registers[i])
-			_, writeErr = client.WriteMultipleRegisters(registers[i].Address, sizeOf(registers[i].DataType), valBytes)
+			writeErr = m.write(registers[i], registers, val)
 			if writeErr != nil {
+				m.dropOnError(writeErr)
 				err = writeErr
 			}
 		}
@@ -97,6 +284,106 @@ func (m Poller) Write(registers []Register, jsonWriteValues []byte) error {
 	return err
 }
 
+// write converts the value to the raw register value and writes it. The scale factor
+// of the register is the last good value read from its ScaleFactor register, which is
+// read first when it has not been read and is among the registers.
+func (m Poller) write(register Register, registers []Register, val float64) error {
+	if register.DataType == str {
+		err := fmt.Sprintf("register %v: string registers are read-only", register.Name)
+		return errors.New(err)
+	}
+
+	var sf float64
+	if register.ScaleFactor != "" {
+		var err error
+		if sf, err = m.scaleFactor(register, registers); err != nil {
+			return err
+		}
+	}
+
+	return writeRegister(m.client, register, register.unscale(val, sf))
+}
+
+// scaleFactor returns the last good value of the ScaleFactor register of the register,
+// reading it from the registers when it has not been read. The caller holds the lock.
+func (m Poller) scaleFactor(register Register, registers []Register) (float64, error) {
+	last, ok := m.last[register.ScaleFactor]
+	if !ok {
+		if i, err := findIndexByName(registers, register.ScaleFactor); err == nil {
+			if _, err := m.read(registers[i : i+1]); err != nil {
+				return 0, err
+			}
+			last, ok = m.last[register.ScaleFactor]
+		}
+	}
+	if !ok {
+		err := fmt.Sprintf("register %v: scale factor %v not read", register.Name, register.ScaleFactor)
+		return 0, errors.New(err)
+	}
+	if last.point.Value == sfUnimplemented {
+		err := fmt.Sprintf("register %v: scale factor %v not implemented", register.Name, register.ScaleFactor)
+		return 0, errors.New(err)
+	}
+	return last.point.Value, nil
+}
+
+// Close closes the connection to the target
+func (m Poller) Close() error {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	return m.handler.Close()
+}
+
+// dropOnError closes the connection after a transport error, so the next request
+// reconnects. Modbus exception responses leave the connection open. Returns true
+// when the connection was closed.
+func (m Poller) dropOnError(err error) bool {
+	if _, ok := err.(*modbus.ModbusError); ok {
+		return false
+	}
+	m.handler.Close()
+	return true
+}
+
+// writeRegister writes the register with the write function code of its table. A bool
+// status word with a Bitmask is read, modified and written back.
+func writeRegister(client modbus.Client, register Register, val float64) error {
+	var err error
+	switch register.FunctionCode {
+	case modbus.FuncCodeReadCoils, modbus.FuncCodeWriteMultipleCoils:
+		_, err = client.WriteMultipleCoils(register.Address, 1, []byte{encodeBit(val)})
+	case modbus.FuncCodeWriteSingleCoil:
+		var coil uint16
+		if val != 0 {
+			coil = 0xFF00
+		}
+		_, err = client.WriteSingleCoil(register.Address, coil)
+	case modbus.FuncCodeReadHoldingRegisters, modbus.FuncCodeWriteSingleRegister, modbus.FuncCodeWriteMultipleRegisters, 0:
+		if register.DataType == boolean && register.Bitmask != 0 {
+			resp, readErr := client.ReadHoldingRegisters(register.Address, 1)
+			if readErr != nil {
+				return readErr
+			}
+			word := binary.BigEndian.Uint16(order(resp, register))
+			val = float64(setBits(word, register.Bitmask, val != 0))
+		}
+		valBytes := encode(val, register)
+		if register.FunctionCode == modbus.FuncCodeWriteSingleRegister {
+			if register.size() != 1 {
+				err := fmt.Sprintf("register %v: %v does not fit a single register write", register.Name, register.DataType)
+				return errors.New(err)
+			}
+			_, err = client.WriteSingleRegister(register.Address, binary.BigEndian.Uint16(valBytes))
+		} else {
+			_, err = client.WriteMultipleRegisters(register.Address, register.size(), valBytes)
+		}
+	default:
+		err := fmt.Sprintf("register %v: function code %v is not writable", register.Name, register.FunctionCode)
+		return errors.New(err)
+	}
+	return err
+}
+
 // findIndexByName returns the index in the array of the register, if found. Returns -1 and error if not found.
 func findIndexByName(registers []Register, name string) (int, error) {
 	for index, register := range registers {
@@ -110,36 +397,80 @@ func findIndexByName(registers []Register, name string) (int, error) {
 // encode convert a float64 into a byte array
 func encode(val float64, register Register) []byte {
 	var bytes []byte
-	endian := getByteOrder(register.Endianness)
+	endian := binary.BigEndian
 	switch register.DataType {
-	case u16, i16:
+	// signed types are converted through their signed integer, as converting a
+	// negative float to an unsigned integer is implementation specific
+	case u16:
 		bytes = make([]byte, 2*sizeOf(u16))
 		endian.PutUint16(bytes, uint16(val))
-	case u32, i32:
+	case i16:
+		bytes = make([]byte, 2*sizeOf(i16))
+		endian.PutUint16(bytes, uint16(int16(val)))
+	case boolean:
+		// a status word with a Bitmask is written whole, see writeRegister
+		bytes = make([]byte, 2*sizeOf(boolean))
+		word := uint16(val)
+		if register.Bitmask == 0 && val != 0 {
+			word = 1
+		}
+		endian.PutUint16(bytes, word)
+	case u32:
 		bytes = make([]byte, 2*sizeOf(u32))
 		endian.PutUint32(bytes, uint32(val))
+	case i32:
+		bytes = make([]byte, 2*sizeOf(i32))
+		endian.PutUint32(bytes, uint32(int32(val)))
 	case f32:
 		bytes = make([]byte, 2*sizeOf(f32))
 		endian.PutUint32(bytes, math.Float32bits(float32(val)))
-	case u64, i64:
+	case u64:
 		bytes = make([]byte, 2*sizeOf(u64))
 		endian.PutUint64(bytes, uint64(val))
+	case i64:
+		bytes = make([]byte, 2*sizeOf(i64))
+		endian.PutUint64(bytes, uint64(int64(val)))
 	case f64:
 		bytes = make([]byte, 2*sizeOf(f64))
 		endian.PutUint64(bytes, math.Float64bits(val))
 	}
-	return bytes
+	return order(bytes, register)
+}
+
+// encodeBit returns the coil byte of a single bit write
+func encodeBit(val float64) byte {
+	if val != 0 {
+		return 1
+	}
+	return 0
+}
+
+// setBits sets or clears the masked bits of a status word
+func setBits(word uint16, mask uint16, set bool) uint16 {
+	if set {
+		return word | mask
+	}
+	return word &^ mask
 }
 
 // decode coverts byte arrays into float64s
 func decode(bytes []byte, register Register) float64 {
 	var n float64
-	endian := getByteOrder(register.Endianness)
+	bytes = order(bytes, register)
+	endian := binary.BigEndian
 	switch register.DataType {
 	case u16:
 		n = float64(endian.Uint16(bytes))
 	case i16:
 		n = float64(int16(endian.Uint16(bytes)))
+	case boolean:
+		word := endian.Uint16(bytes)
+		if register.Bitmask != 0 {
+			word &= register.Bitmask
+		}
+		if word != 0 {
+			n = 1
+		}
 	case u32:
 		n = float64(endian.Uint32(bytes))
 	case i32:
@@ -158,15 +489,53 @@ func decode(bytes []byte, register Register) float64 {
 	return n
 }
 
-// getByteOrder returns the correct binary.endian object for the register type
-func getByteOrder(e Endian) binary.ByteOrder {
-	switch e {
-	case bigEndian:
-		return binary.BigEndian
-	case littleEndian:
-		return binary.LittleEndian
+// decodePoint decodes the register bytes into an unscaled point, with the text of a
+// string or enum register and the active flags of a bitfield register
+func decodePoint(bytes []byte, register Register) Point {
+	if register.DataType == str {
+		text := string(order(bytes, register))
+		return Point{Text: strings.TrimRight(text, "\x00 ")}
 	}
-	return binary.BigEndian
+
+	val := decode(bytes, register)
+	p := Point{Value: val, Text: register.Enum[int64(val)]}
+	if len(register.Bits) > 0 {
+		bits := uint64(int64(val))
+		if val > 0 {
+			bits = uint64(val)
+		}
+		for i := uint(0); i < 64; i++ {
+			if name, ok := register.Bits[i]; ok && bits&(1<<i) != 0 {
+				p.Flags = append(p.Flags, name)
+			}
+		}
+	}
+	return p
+}
+
+// order rearranges the bytes of a register value between device order and big-endian
+// order. Endianness orders the bytes of each word. WordOrder orders the words of the
+// value, and follows Endianness when unset. The rearrangement is its own inverse.
+func order(bytes []byte, register Register) []byte {
+	wordOrder := register.WordOrder
+	if wordOrder == "" {
+		wordOrder = register.Endianness
+	}
+
+	words := len(bytes) / 2
+	ordered := make([]byte, len(bytes))
+	for i := 0; i < words; i++ {
+		src := i
+		if wordOrder == littleEndian {
+			src = words - 1 - i
+		}
+		hi, lo := bytes[2*src], bytes[2*src+1]
+		if register.Endianness == littleEndian {
+			hi, lo = lo, hi
+		}
+		ordered[2*i], ordered[2*i+1] = hi, lo
+	}
+	return ordered
 }
 
 // sizeOf returns the number of u16 registers for the datatype
@@ -176,6 +545,8 @@ func sizeOf(t DataType) uint16 {
 		return 1
 	case i16:
 		return 1
+	case boolean:
+		return 1
 	case u32:
 		return 2
 	case i32:
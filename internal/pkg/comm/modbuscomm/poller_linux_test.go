@@ -0,0 +1,160 @@
+package modbuscomm
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"syscall"
+	"testing"
+	"unsafe"
+
+	"gotest.tools/assert"
+)
+
+// openPty returns the master side of a new pseudo terminal and the path of its slave
+func openPty() (*os.File, string, error) {
+	master, err := os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var unlock int32
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, master.Fd(), syscall.TIOCSPTLCK, uintptr(unsafe.Pointer(&unlock))); errno != 0 {
+		master.Close()
+		return nil, "", errno
+	}
+	var n uint32
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, master.Fd(), syscall.TIOCGPTN, uintptr(unsafe.Pointer(&n))); errno != 0 {
+		master.Close()
+		return nil, "", errno
+	}
+	return master, fmt.Sprintf("/dev/pts/%d", n), nil
+}
+
+// crc16 is the Modbus RTU frame checksum
+func crc16(b []byte) uint16 {
+	crc := uint16(0xFFFF)
+	for _, v := range b {
+		crc ^= uint16(v)
+		for i := 0; i < 8; i++ {
+			if crc&1 != 0 {
+				crc = crc>>1 ^ 0xA001
+			} else {
+				crc >>= 1
+			}
+		}
+	}
+	return crc
+}
+
+func withCRC(frame []byte) []byte {
+	crc := crc16(frame)
+	return append(frame, byte(crc), byte(crc>>8))
+}
+
+// rtuSlave serves holding register reads and writes on the master side of a pty
+// until the pty is closed.
+func rtuSlave(port io.ReadWriter, id byte, mux *sync.Mutex, registers map[uint16]uint16) {
+	for {
+		header := make([]byte, 6)
+		if _, err := io.ReadFull(port, header); err != nil {
+			return
+		}
+		address := binary.BigEndian.Uint16(header[2:])
+		count := binary.BigEndian.Uint16(header[4:])
+
+		var body []byte
+		switch header[1] {
+		case 0x03:
+			body = make([]byte, 2)
+		case 0x10:
+			body = make([]byte, 1+2*int(count)+2)
+		default:
+			return
+		}
+		if _, err := io.ReadFull(port, body); err != nil {
+			return
+		}
+
+		var response []byte
+		mux.Lock()
+		switch header[1] {
+		case 0x03:
+			response = []byte{id, 0x03, byte(2 * count)}
+			for i := uint16(0); i < count; i++ {
+				response = append(response, 0, 0)
+				binary.BigEndian.PutUint16(response[len(response)-2:], registers[address+i])
+			}
+		case 0x10:
+			for i := uint16(0); i < count; i++ {
+				registers[address+i] = binary.BigEndian.Uint16(body[1+2*i:])
+			}
+			response = append([]byte{}, header...)
+		}
+		mux.Unlock()
+		if header[0] != id {
+			continue
+		}
+		if _, err := port.Write(withCRC(response)); err != nil {
+			return
+		}
+	}
+}
+
+func TestPollerRTU(t *testing.T) {
+	master, device, err := openPty()
+	if err != nil {
+		t.Skip("pseudo terminal unavailable:", err)
+	}
+	defer master.Close()
+
+	// holding the slave open keeps the master from reading a hangup when the poller
+	// closes the port
+	slave, err := os.OpenFile(device, os.O_RDWR|syscall.O_NOCTTY, 0)
+	assert.NilError(t, err)
+	defer slave.Close()
+
+	mux := &sync.Mutex{}
+	registers := map[uint16]uint16{0: 1234, 1: 0xFFFE}
+	go rtuSlave(master, 0x02, mux, registers)
+
+	pollerConfig := PollerConfig{
+		Transport: "rtu",
+		Device:    device,
+		BaudRate:  19200,
+		DataBits:  8,
+		Parity:    "N",
+		StopBits:  2,
+		SlaveID:   0x02,
+		Timeout:   1000,
+	}
+	poller, err := NewPoller(pollerConfig)
+	assert.NilError(t, err)
+
+	reg1 := Register{Name: "test1", Address: 0, DataType: u16, FunctionCode: 3, AccessType: rw, Endianness: bigEndian}
+	reg2 := Register{Name: "test2", Address: 1, DataType: i16, FunctionCode: 3, AccessType: ro, Endianness: bigEndian}
+	reg3 := Register{Name: "test3", Address: 2, DataType: u16, FunctionCode: 3, AccessType: rw, Endianness: bigEndian}
+	regs := []Register{reg1, reg2, reg3}
+
+	resp, err := poller.Read(regs)
+	assert.NilError(t, err)
+	assert.Equal(t, string(resp), `{"test1":{"Value":1234,"Quality":"good"},"test2":{"Value":-2,"Quality":"good"},"test3":{"Value":0,"Quality":"good"}}`)
+
+	err = poller.Write(regs, []byte(`{"test3":42}`))
+	assert.NilError(t, err)
+	mux.Lock()
+	defer mux.Unlock()
+	assert.Equal(t, registers[2], uint16(42))
+}
+
+func TestPollerRTUBadDevice(t *testing.T) {
+	pollerConfig := PollerConfig{Transport: "rtu", Device: "/dev/cgc-missing-tty", Timeout: 100}
+	poller, err := NewPoller(pollerConfig)
+	assert.NilError(t, err)
+
+	reg := Register{Name: "test1", Address: 0, DataType: u16, FunctionCode: 3, AccessType: ro, Endianness: bigEndian}
+	_, err = poller.Read([]Register{reg})
+	assert.Assert(t, err != nil)
+}
@@ -1,18 +1,26 @@
 package modbuscomm
 
 import (
-	"fmt"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
 	"math"
 	"math/rand"
+	"net"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/goburrow/modbus"
+	"github.com/ohowland/cgc_core/internal/pkg/asset"
+	"github.com/ohowland/cgc_core/internal/pkg/metrics"
 	"gotest.tools/assert"
 )
 
 // Encode-Decode U64
 func TestEncodeU64Big(t *testing.T) {
 	rand.Seed(10)
-	testReg := Register{"test", 0, u64, 3, ro, bigEndian}
+	testReg := Register{Name: "test", Address: 0, DataType: u64, FunctionCode: 3, AccessType: ro, Endianness: bigEndian}
 	var testVal float64 = 1234
 	bytes := encode(testVal, testReg)
 	t.Logf("float64: [%v] to U64 to big-endian []bytes: %v", testVal, bytes)
@@ -26,7 +34,7 @@ func TestEncodeU64Big(t *testing.T) {
 }
 
 func TestDecodeU64Big(t *testing.T) {
-	testReg := Register{"test", 0, u64, 3, ro, bigEndian}
+	testReg := Register{Name: "test", Address: 0, DataType: u64, FunctionCode: 3, AccessType: ro, Endianness: bigEndian}
 	assertVal := rand.Float64() * 9223372036854775807
 	testBytes := encode(assertVal, testReg)
 	testVal := decode(testBytes[:], testReg)
@@ -36,7 +44,7 @@ func TestDecodeU64Big(t *testing.T) {
 }
 
 func TestEncodeU64Little(t *testing.T) {
-	testReg := Register{"test", 0, u64, 3, ro, littleEndian}
+	testReg := Register{Name: "test", Address: 0, DataType: u64, FunctionCode: 3, AccessType: ro, Endianness: littleEndian}
 	var testVal float64 = 1234
 	bytes := encode(testVal, testReg)
 	t.Logf("float64: [%v] to U64 to little-endian []bytes: %v", testVal, bytes)
@@ -50,7 +58,7 @@ func TestEncodeU64Little(t *testing.T) {
 }
 
 func TestDecodeU64Little(t *testing.T) {
-	testReg := Register{"test", 0, u64, 3, ro, littleEndian}
+	testReg := Register{Name: "test", Address: 0, DataType: u64, FunctionCode: 3, AccessType: ro, Endianness: littleEndian}
 	assertVal := rand.Float64() * 9223372036854775807
 	testBytes := encode(assertVal, testReg)
 	testVal := decode(testBytes[:], testReg)
@@ -61,7 +69,7 @@ func TestDecodeU64Little(t *testing.T) {
 
 // Encode-Decode U32
 func TestEncodeU32Big(t *testing.T) {
-	testReg := Register{"test", 0, u32, 3, ro, bigEndian}
+	testReg := Register{Name: "test", Address: 0, DataType: u32, FunctionCode: 3, AccessType: ro, Endianness: bigEndian}
 	var testVal float64 = 1234
 	bytes := encode(testVal, testReg)
 	t.Logf("float64: [%v] to U32 to big-endian []bytes: %v", testVal, bytes)
@@ -75,7 +83,7 @@ func TestEncodeU32Big(t *testing.T) {
 }
 
 func TestDecodeU32Big(t *testing.T) {
-	testReg := Register{"test", 0, u32, 3, ro, bigEndian}
+	testReg := Register{Name: "test", Address: 0, DataType: u32, FunctionCode: 3, AccessType: ro, Endianness: bigEndian}
 	assertVal := rand.Float64() * 4294967295
 	testBytes := encode(assertVal, testReg)
 	testVal := decode(testBytes[:], testReg)
@@ -85,7 +93,7 @@ func TestDecodeU32Big(t *testing.T) {
 }
 
 func TestEncodeU32Little(t *testing.T) {
-	testReg := Register{"test", 0, u32, 3, ro, littleEndian}
+	testReg := Register{Name: "test", Address: 0, DataType: u32, FunctionCode: 3, AccessType: ro, Endianness: littleEndian}
 	var testVal float64 = 1234
 	bytes := encode(testVal, testReg)
 	t.Logf("float64: [%v] to U32 to little-endian []bytes: %v", testVal, bytes)
@@ -99,7 +107,7 @@ func TestEncodeU32Little(t *testing.T) {
 }
 
 func TestDecodeU32Little(t *testing.T) {
-	testReg := Register{"test", 0, u32, 3, ro, littleEndian}
+	testReg := Register{Name: "test", Address: 0, DataType: u32, FunctionCode: 3, AccessType: ro, Endianness: littleEndian}
 	assertVal := rand.Float64() * 4294967295
 	testBytes := encode(assertVal, testReg)
 	testVal := decode(testBytes[:], testReg)
@@ -110,7 +118,7 @@ func TestDecodeU32Little(t *testing.T) {
 
 // Encode-Decode U16
 func TestEncodeU16Big(t *testing.T) {
-	testReg := Register{"test", 0, u16, 3, ro, bigEndian}
+	testReg := Register{Name: "test", Address: 0, DataType: u16, FunctionCode: 3, AccessType: ro, Endianness: bigEndian}
 	var testVal float64 = 1234
 	bytes := encode(testVal, testReg)
 	t.Logf("float64: [%v] to U16 to big-endian []bytes: %v", testVal, bytes)
@@ -124,7 +132,7 @@ func TestEncodeU16Big(t *testing.T) {
 }
 
 func TestDecodeU16Big(t *testing.T) {
-	testReg := Register{"test", 0, u16, 3, ro, bigEndian}
+	testReg := Register{Name: "test", Address: 0, DataType: u16, FunctionCode: 3, AccessType: ro, Endianness: bigEndian}
 	assertVal := rand.Float64() * 65535
 	testBytes := encode(assertVal, testReg)
 	testVal := decode(testBytes[:], testReg)
@@ -134,7 +142,7 @@ func TestDecodeU16Big(t *testing.T) {
 }
 
 func TestEncodeU16Little(t *testing.T) {
-	testReg := Register{"test", 0, u16, 3, ro, littleEndian}
+	testReg := Register{Name: "test", Address: 0, DataType: u16, FunctionCode: 3, AccessType: ro, Endianness: littleEndian}
 	var testVal float64 = 1234
 	bytes := encode(testVal, testReg)
 	t.Logf("float64: [%v] to U16 to little-endian []bytes: %v", testVal, bytes)
@@ -148,7 +156,7 @@ func TestEncodeU16Little(t *testing.T) {
 }
 
 func TestDecodeU16Little(t *testing.T) {
-	testReg := Register{"test", 0, u16, 3, ro, littleEndian}
+	testReg := Register{Name: "test", Address: 0, DataType: u16, FunctionCode: 3, AccessType: ro, Endianness: littleEndian}
 	assertVal := rand.Float64() * 65535
 	testBytes := encode(assertVal, testReg)
 	testVal := decode(testBytes[:], testReg)
@@ -159,7 +167,7 @@ func TestDecodeU16Little(t *testing.T) {
 
 // Encode-Decode I64
 func TestEncodeI64Big(t *testing.T) {
-	testReg := Register{"test", 0, i64, 3, ro, bigEndian}
+	testReg := Register{Name: "test", Address: 0, DataType: i64, FunctionCode: 3, AccessType: ro, Endianness: bigEndian}
 	var testVal float64 = 1234
 	bytes := encode(testVal, testReg)
 	t.Logf("float64: [%v] to I64 to big-endian []bytes: %v", testVal, bytes)
@@ -173,7 +181,7 @@ func TestEncodeI64Big(t *testing.T) {
 }
 
 func TestDecodeI64Big(t *testing.T) {
-	testReg := Register{"test", 0, i64, 3, ro, bigEndian}
+	testReg := Register{Name: "test", Address: 0, DataType: i64, FunctionCode: 3, AccessType: ro, Endianness: bigEndian}
 	assertVal := rand.Float64() * -9223372036854775807
 	testBytes := encode(assertVal, testReg)
 	testVal := decode(testBytes[:], testReg)
@@ -183,7 +191,7 @@ func TestDecodeI64Big(t *testing.T) {
 }
 
 func TestEncodeI64Little(t *testing.T) {
-	testReg := Register{"test", 0, i64, 3, ro, littleEndian}
+	testReg := Register{Name: "test", Address: 0, DataType: i64, FunctionCode: 3, AccessType: ro, Endianness: littleEndian}
 	var testVal float64 = 1234
 	bytes := encode(testVal, testReg)
 	t.Logf("float64: [%v] to I64 to little-endian []bytes: %v", testVal, bytes)
@@ -197,7 +205,7 @@ func TestEncodeI64Little(t *testing.T) {
 }
 
 func TestDecodeI64Little(t *testing.T) {
-	testReg := Register{"test", 0, i64, 3, ro, littleEndian}
+	testReg := Register{Name: "test", Address: 0, DataType: i64, FunctionCode: 3, AccessType: ro, Endianness: littleEndian}
 	assertVal := rand.Float64() * -9223372036854775807
 	testBytes := encode(assertVal, testReg)
 	testVal := decode(testBytes[:], testReg)
@@ -208,7 +216,7 @@ func TestDecodeI64Little(t *testing.T) {
 
 // Encode-Decode I32
 func TestEncodeI32Big(t *testing.T) {
-	testReg := Register{"test", 0, i32, 3, ro, bigEndian}
+	testReg := Register{Name: "test", Address: 0, DataType: i32, FunctionCode: 3, AccessType: ro, Endianness: bigEndian}
 	var testVal float64 = -1234
 	bytes := encode(testVal, testReg)
 	t.Logf("float64: [%v] to I32 to big-endian []bytes: %v", testVal, bytes)
@@ -222,7 +230,7 @@ func TestEncodeI32Big(t *testing.T) {
 }
 
 func TestDecodeI32Big(t *testing.T) {
-	testReg := Register{"test", 0, i32, 3, ro, bigEndian}
+	testReg := Register{Name: "test", Address: 0, DataType: i32, FunctionCode: 3, AccessType: ro, Endianness: bigEndian}
 	assertVal := rand.Float64() * -2147483647
 	testBytes := encode(assertVal, testReg)
 	testVal := decode(testBytes[:], testReg)
@@ -232,7 +240,7 @@ func TestDecodeI32Big(t *testing.T) {
 }
 
 func TestEncodeI32Little(t *testing.T) {
-	testReg := Register{"test", 0, i32, 3, ro, littleEndian}
+	testReg := Register{Name: "test", Address: 0, DataType: i32, FunctionCode: 3, AccessType: ro, Endianness: littleEndian}
 	var testVal float64 = -1234
 	bytes := encode(testVal, testReg)
 	t.Logf("float64: [%v] to I32 to little-endian []bytes: %v", testVal, bytes)
@@ -246,7 +254,7 @@ func TestEncodeI32Little(t *testing.T) {
 }
 
 func TestDecodeI32Little(t *testing.T) {
-	testReg := Register{"test", 0, i32, 3, ro, littleEndian}
+	testReg := Register{Name: "test", Address: 0, DataType: i32, FunctionCode: 3, AccessType: ro, Endianness: littleEndian}
 	assertVal := rand.Float64() * -2147483647
 	testBytes := encode(assertVal, testReg)
 	testVal := decode(testBytes[:], testReg)
@@ -257,7 +265,7 @@ func TestDecodeI32Little(t *testing.T) {
 
 // Encode-Decode I16
 func TestEncodeI16Big(t *testing.T) {
-	testReg := Register{"test", 0, i16, 3, ro, bigEndian}
+	testReg := Register{Name: "test", Address: 0, DataType: i16, FunctionCode: 3, AccessType: ro, Endianness: bigEndian}
 	var testVal float64 = -1234
 	bytes := encode(testVal, testReg)
 	t.Logf("float64: [%v] to I16 to big-endian []bytes: %v", testVal, bytes)
@@ -271,7 +279,7 @@ func TestEncodeI16Big(t *testing.T) {
 }
 
 func TestDecodeI16Big(t *testing.T) {
-	testReg := Register{"test", 0, i16, 3, ro, bigEndian}
+	testReg := Register{Name: "test", Address: 0, DataType: i16, FunctionCode: 3, AccessType: ro, Endianness: bigEndian}
 	assertVal := rand.Float64() * -32767
 	testBytes := encode(assertVal, testReg)
 	testVal := decode(testBytes[:], testReg)
@@ -281,7 +289,7 @@ func TestDecodeI16Big(t *testing.T) {
 }
 
 func TestEncodeI16Little(t *testing.T) {
-	testReg := Register{"test", 0, i16, 3, ro, littleEndian}
+	testReg := Register{Name: "test", Address: 0, DataType: i16, FunctionCode: 3, AccessType: ro, Endianness: littleEndian}
 	var testVal float64 = -1234
 	bytes := encode(testVal, testReg)
 	t.Logf("float64: [%v] to I16 to little-endian []bytes: %v", testVal, bytes)
@@ -296,7 +304,7 @@ func TestEncodeI16Little(t *testing.T) {
 
 // encode-decode Float32
 func TestEncodeF32Big(t *testing.T) {
-	testReg := Register{"test", 0, f32, 3, ro, bigEndian}
+	testReg := Register{Name: "test", Address: 0, DataType: f32, FunctionCode: 3, AccessType: ro, Endianness: bigEndian}
 	var testVal float64 = -1234
 	bytes := encode(testVal, testReg)
 	t.Logf("float64: [%v] to F32 to big-endian []bytes: %v", testVal, bytes)
@@ -310,7 +318,7 @@ func TestEncodeF32Big(t *testing.T) {
 }
 
 func TestDecodeF32Big(t *testing.T) {
-	testReg := Register{"test", 0, f32, 3, ro, bigEndian}
+	testReg := Register{Name: "test", Address: 0, DataType: f32, FunctionCode: 3, AccessType: ro, Endianness: bigEndian}
 	assertVal := rand.Float64() * -32767
 	testBytes := encode(assertVal, testReg)
 	testVal := decode(testBytes[:], testReg)
@@ -321,7 +329,7 @@ func TestDecodeF32Big(t *testing.T) {
 
 // encode-decode Float64
 func TestEncodeF64Big(t *testing.T) {
-	testReg := Register{"test", 0, f64, 3, ro, bigEndian}
+	testReg := Register{Name: "test", Address: 0, DataType: f64, FunctionCode: 3, AccessType: ro, Endianness: bigEndian}
 	var testVal float64 = -1234
 	bytes := encode(testVal, testReg)
 	t.Logf("float64: [%v] to F64 to big-endian []bytes: %v", testVal, bytes)
@@ -335,7 +343,7 @@ func TestEncodeF64Big(t *testing.T) {
 }
 
 func TestDecodeF64Big(t *testing.T) {
-	testReg := Register{"test", 0, f64, 3, ro, bigEndian}
+	testReg := Register{Name: "test", Address: 0, DataType: f64, FunctionCode: 3, AccessType: ro, Endianness: bigEndian}
 	assertVal := rand.Float64() * -32767
 	testBytes := encode(assertVal, testReg)
 	testVal := decode(testBytes[:], testReg)
@@ -345,7 +353,7 @@ func TestDecodeF64Big(t *testing.T) {
 }
 
 func TestDecodeI16Little(t *testing.T) {
-	testReg := Register{"test", 0, i16, 3, ro, littleEndian}
+	testReg := Register{Name: "test", Address: 0, DataType: i16, FunctionCode: 3, AccessType: ro, Endianness: littleEndian}
 	assertVal := rand.Float64() * -32767
 	testBytes := encode(assertVal, testReg)
 	testVal := decode(testBytes[:], testReg)
@@ -354,10 +362,32 @@ func TestDecodeI16Little(t *testing.T) {
 	assert.Assert(t, testVal == math.Ceil(assertVal))
 }
 
+// negative values are encoded as two's complement on every architecture
+func TestEncodeSignedNegative(t *testing.T) {
+	tests := []struct {
+		dataType DataType
+		val      float64
+		want     []byte
+	}{
+		{i16, -1, []byte{0xff, 0xff}},
+		{i16, -1.5, []byte{0xff, 0xff}},
+		{i16, -32768, []byte{0x80, 0x00}},
+		{i32, -1, []byte{0xff, 0xff, 0xff, 0xff}},
+		{i32, -2147483648, []byte{0x80, 0x00, 0x00, 0x00}},
+		{i64, -1, []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}},
+		{i64, -1234, []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xfb, 0x2e}},
+	}
+	for _, test := range tests {
+		reg := Register{Name: "test", DataType: test.dataType, FunctionCode: 3, Endianness: bigEndian}
+		assert.DeepEqual(t, encode(test.val, reg), test.want)
+		assert.Equal(t, decode(test.want, reg), math.Trunc(test.val))
+	}
+}
+
 func TestFindRegisterByName(t *testing.T) {
-	testReg1 := Register{"test1", 0, u16, 3, ro, bigEndian}
-	testReg2 := Register{"test2", 1, u32, 3, ro, bigEndian}
-	testReg3 := Register{"test3", 3, u64, 3, ro, bigEndian}
+	testReg1 := Register{Name: "test1", Address: 0, DataType: u16, FunctionCode: 3, AccessType: ro, Endianness: bigEndian}
+	testReg2 := Register{Name: "test2", Address: 1, DataType: u32, FunctionCode: 3, AccessType: ro, Endianness: bigEndian}
+	testReg3 := Register{Name: "test3", Address: 3, DataType: u64, FunctionCode: 3, AccessType: ro, Endianness: bigEndian}
 	testRegs := []Register{testReg1, testReg2, testReg3}
 
 	i, err := findIndexByName(testRegs, "test2")
@@ -372,9 +402,9 @@ func TestFindRegisterByName(t *testing.T) {
 }
 
 func TestFindRegisterByNameFail(t *testing.T) {
-	testReg1 := Register{"test1", 0, u16, 3, wo, bigEndian}
-	testReg2 := Register{"test2", 1, u32, 3, wo, bigEndian}
-	testReg3 := Register{"test3", 3, u64, 3, wo, bigEndian}
+	testReg1 := Register{Name: "test1", Address: 0, DataType: u16, FunctionCode: 3, AccessType: wo, Endianness: bigEndian}
+	testReg2 := Register{Name: "test2", Address: 1, DataType: u32, FunctionCode: 3, AccessType: wo, Endianness: bigEndian}
+	testReg3 := Register{Name: "test3", Address: 3, DataType: u64, FunctionCode: 3, AccessType: wo, Endianness: bigEndian}
 	testRegs := []Register{testReg1, testReg2, testReg3}
 
 	i, err := findIndexByName(testRegs, "test42")
@@ -383,41 +413,533 @@ func TestFindRegisterByNameFail(t *testing.T) {
 }
 
 func TestPoller(t *testing.T) {
-	if testing.Short() {
-		t.Skip("skipping TestModbusPoller in short mode")
-	}
+	client := newFakeClient()
+	client.registers[0] = 1
+	client.registers[1] = 2
+	client.registers[2] = 3
+	poller, _ := newFakePoller(client, 125, 0)
+
+	reg1 := Register{Name: "test1", Address: 0, DataType: u16, FunctionCode: 3, AccessType: rw, Endianness: bigEndian}
+	reg2 := Register{Name: "test2", Address: 1, DataType: u16, FunctionCode: 3, AccessType: rw, Endianness: bigEndian}
+	reg3 := Register{Name: "test3", Address: 2, DataType: u16, FunctionCode: 3, AccessType: rw, Endianness: bigEndian}
+	regs := []Register{reg1, reg2, reg3}
 
-	pollerConfig := PollerConfig{"192.168.0.100", "5020", 0x01, 100, 500, true}
+	points, err := readPoints(t, poller, regs)
+	assert.NilError(t, err)
+	for i, reg := range regs {
+		assert.Equal(t, points[reg.Name].Value, float64(i+1))
+		assert.Equal(t, points[reg.Name].Quality, asset.Good)
+	}
+}
 
-	reg1 := Register{"test1", 0, u16, 3, rw, bigEndian}
-	reg2 := Register{"test2", 1, u16, 3, rw, bigEndian}
-	reg3 := Register{"test3", 2, u16, 3, rw, bigEndian}
+// a device that accepts the connection but never answers times out the read
+func TestPollerFailOnTimeout(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NilError(t, err)
+	defer l.Close()
+	go func() {
+		conns := []net.Conn{}
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				for _, c := range conns {
+					c.Close()
+				}
+				return
+			}
+			conns = append(conns, conn)
+		}
+	}()
+
+	host, port, err := net.SplitHostPort(l.Addr().String())
+	assert.NilError(t, err)
+	pollerConfig := PollerConfig{IPAddr: host, Port: port, SlaveID: 0x01, Timeout: 100, PollRate: 500}
+
+	reg1 := Register{Name: "test1", Address: 0, DataType: u16, FunctionCode: 3, AccessType: ro, Endianness: bigEndian}
+	reg2 := Register{Name: "test2", Address: 1, DataType: u16, FunctionCode: 3, AccessType: ro, Endianness: bigEndian}
+	reg3 := Register{Name: "test3", Address: 2, DataType: u16, FunctionCode: 3, AccessType: ro, Endianness: bigEndian}
 	regs := []Register{reg1, reg2, reg3}
 
-	poller := NewPoller(pollerConfig)
+	poller, err := NewPoller(pollerConfig)
+	assert.NilError(t, err)
 
-	resp, err := poller.Read(regs)
-	t.Logf("\nresponse: %v\n error: %v", resp, err)
-	assert.Assert(t, err == nil)
+	_, err = poller.Read(regs)
+	assert.ErrorContains(t, err, "i/o timeout")
 }
 
-func TestPollerFailOnTimeout(t *testing.T) {
-	if testing.Short() {
-		t.Skip("skipping TestModbusPollerBadAddress in short mode")
+func TestNewPollerUnsupportedTransport(t *testing.T) {
+	_, err := NewPoller(PollerConfig{Transport: "udp"})
+	assert.Error(t, err, "unsupported modbus transport udp")
+}
+
+// fakeClient records the function codes called and serves a single coil table and
+// register table
+type fakeClient struct {
+	modbus.Client
+	calls     []int
+	coils     map[uint16]bool
+	registers map[uint16]uint16
+	failures  map[uint16]error
+}
+
+func newFakeClient() *fakeClient {
+	return &fakeClient{
+		coils:     make(map[uint16]bool),
+		registers: make(map[uint16]uint16),
+		failures:  make(map[uint16]error),
 	}
+}
 
-	testIP := "1.1.1.1"
-	testPort := "123"
+func (c *fakeClient) readBits(address, quantity uint16) []byte {
+	results := make([]byte, (quantity+7)/8)
+	for i := uint16(0); i < quantity; i++ {
+		if c.coils[address+i] {
+			results[i/8] |= 1 << (i % 8)
+		}
+	}
+	return results
+}
 
-	pollerConfig := PollerConfig{testIP, testPort, 0x01, 100, 500, true}
+func (c *fakeClient) readWords(address, quantity uint16) []byte {
+	results := make([]byte, 2*quantity)
+	for i := uint16(0); i < quantity; i++ {
+		binary.BigEndian.PutUint16(results[2*i:], c.registers[address+i])
+	}
+	return results
+}
 
-	reg1 := Register{"test1", 0, u16, 3, ro, bigEndian}
-	reg2 := Register{"test2", 1, u16, 3, ro, bigEndian}
-	reg3 := Register{"test3", 2, u16, 3, ro, bigEndian}
-	regs := []Register{reg1, reg2, reg3}
+func (c *fakeClient) ReadCoils(address, quantity uint16) ([]byte, error) {
+	c.calls = append(c.calls, modbus.FuncCodeReadCoils)
+	return c.readBits(address, quantity), nil
+}
+
+func (c *fakeClient) ReadDiscreteInputs(address, quantity uint16) ([]byte, error) {
+	c.calls = append(c.calls, modbus.FuncCodeReadDiscreteInputs)
+	return c.readBits(address, quantity), nil
+}
+
+func (c *fakeClient) WriteSingleCoil(address, value uint16) ([]byte, error) {
+	c.calls = append(c.calls, modbus.FuncCodeWriteSingleCoil)
+	c.coils[address] = value == 0xFF00
+	return nil, nil
+}
+
+func (c *fakeClient) WriteMultipleCoils(address, quantity uint16, value []byte) ([]byte, error) {
+	c.calls = append(c.calls, modbus.FuncCodeWriteMultipleCoils)
+	for i := uint16(0); i < quantity; i++ {
+		c.coils[address+i] = value[i/8]&(1<<(i%8)) != 0
+	}
+	return nil, nil
+}
+
+func (c *fakeClient) ReadInputRegisters(address, quantity uint16) ([]byte, error) {
+	c.calls = append(c.calls, modbus.FuncCodeReadInputRegisters)
+	return c.readWords(address, quantity), nil
+}
+
+func (c *fakeClient) ReadHoldingRegisters(address, quantity uint16) ([]byte, error) {
+	c.calls = append(c.calls, modbus.FuncCodeReadHoldingRegisters)
+	if err, ok := c.failures[address]; ok {
+		return nil, err
+	}
+	return c.readWords(address, quantity), nil
+}
+
+func (c *fakeClient) WriteSingleRegister(address, value uint16) ([]byte, error) {
+	c.calls = append(c.calls, modbus.FuncCodeWriteSingleRegister)
+	c.registers[address] = value
+	return nil, nil
+}
+
+func (c *fakeClient) WriteMultipleRegisters(address, quantity uint16, value []byte) ([]byte, error) {
+	c.calls = append(c.calls, modbus.FuncCodeWriteMultipleRegisters)
+	for i := uint16(0); i < quantity; i++ {
+		c.registers[address+i] = binary.BigEndian.Uint16(value[2*i:])
+	}
+	return nil, nil
+}
+
+// fakeHandler counts the connections opened and closed by the poller
+type fakeHandler struct {
+	clientHandler
+	connects int
+	closes   int
+}
+
+func (h *fakeHandler) Connect() error {
+	h.connects++
+	return nil
+}
+
+func (h *fakeHandler) Close() error {
+	h.closes++
+	return nil
+}
+
+func newFakePoller(client modbus.Client, maxBlockSize int, maxGap int) (Poller, *fakeHandler) {
+	handler := &fakeHandler{}
+	return Poller{
+		mux:          &sync.Mutex{},
+		handler:      handler,
+		client:       client,
+		maxBlockSize: maxBlockSize,
+		maxGap:       maxGap,
+		last:         make(map[string]sample),
+	}, handler
+}
+
+// readPoints polls the registers and decodes the JSON response
+func readPoints(t *testing.T, poller Poller, registers []Register) (map[string]Point, error) {
+	resp, err := poller.Read(registers)
+	points := make(map[string]Point)
+	assert.NilError(t, json.Unmarshal(resp, &points))
+	return points, err
+}
+
+func TestReadFunctionCode(t *testing.T) {
+	client := newFakeClient()
+	client.coils[3] = true
+	client.registers[7] = 1234
+
+	cases := []struct {
+		functionCode int
+		dataType     DataType
+		address      uint16
+		call         int
+		val          float64
+	}{
+		{1, boolean, 3, modbus.FuncCodeReadCoils, 1},
+		{5, boolean, 3, modbus.FuncCodeReadCoils, 1},
+		{2, boolean, 4, modbus.FuncCodeReadDiscreteInputs, 0},
+		{3, u16, 7, modbus.FuncCodeReadHoldingRegisters, 1234},
+		{16, u16, 7, modbus.FuncCodeReadHoldingRegisters, 1234},
+		{0, u16, 7, modbus.FuncCodeReadHoldingRegisters, 1234},
+		{4, u16, 7, modbus.FuncCodeReadInputRegisters, 1234},
+	}
+
+	poller, _ := newFakePoller(client, 0, 0)
+	for _, c := range cases {
+		client.calls = nil
+		reg := Register{Name: "test", Address: c.address, DataType: c.dataType, FunctionCode: c.functionCode, AccessType: ro}
+		points, err := readPoints(t, poller, []Register{reg})
+		assert.NilError(t, err)
+		assert.Equal(t, points["test"].Value, c.val, "function code %v", c.functionCode)
+		assert.Equal(t, points["test"].Quality, asset.Good)
+		assert.DeepEqual(t, client.calls, []int{c.call})
+	}
+
+	_, failed := planBlocks([]Register{{Name: "test", FunctionCode: 43}}, 0, 0)
+	assert.Error(t, failed["test"], "register test: unsupported function code 43")
+}
+
+func TestWriteRegisterFunctionCode(t *testing.T) {
+	client := newFakeClient()
+
+	cases := []struct {
+		functionCode int
+		dataType     DataType
+		call         int
+	}{
+		{1, boolean, modbus.FuncCodeWriteMultipleCoils},
+		{5, boolean, modbus.FuncCodeWriteSingleCoil},
+		{15, boolean, modbus.FuncCodeWriteMultipleCoils},
+		{3, u32, modbus.FuncCodeWriteMultipleRegisters},
+		{6, u16, modbus.FuncCodeWriteSingleRegister},
+		{16, u16, modbus.FuncCodeWriteMultipleRegisters},
+	}
+
+	for _, c := range cases {
+		client.calls = nil
+		reg := Register{Name: "test", Address: 2, DataType: c.dataType, FunctionCode: c.functionCode, AccessType: wo}
+		err := writeRegister(client, reg, 1)
+		assert.NilError(t, err)
+		assert.DeepEqual(t, client.calls, []int{c.call})
+	}
+	assert.Assert(t, client.coils[2])
+
+	err := writeRegister(client, Register{Name: "test", DataType: u16, FunctionCode: 4}, 1)
+	assert.Error(t, err, "register test: function code 4 is not writable")
+
+	err = writeRegister(client, Register{Name: "test", DataType: u32, FunctionCode: 6}, 1)
+	assert.Error(t, err, "register test: u32 does not fit a single register write")
+}
+
+func TestStatusWordBitmask(t *testing.T) {
+	client := newFakeClient()
+	client.registers[10] = 0x0105
+
+	fault := Register{Name: "fault", Address: 10, DataType: boolean, FunctionCode: 3, AccessType: rw, Bitmask: 0x0004}
+	warn := Register{Name: "warn", Address: 10, DataType: boolean, FunctionCode: 3, AccessType: ro, Bitmask: 0x0002}
+	poller, _ := newFakePoller(client, 0, 0)
+	points, err := readPoints(t, poller, []Register{fault, warn})
+	assert.NilError(t, err)
+	assert.Equal(t, points["fault"].Value, 1.0)
+	assert.Equal(t, points["warn"].Value, 0.0)
+
+	// clearing one bit leaves the rest of the word intact
+	err = writeRegister(client, fault, 0)
+	assert.NilError(t, err)
+	assert.Equal(t, client.registers[10], uint16(0x0101))
+	assert.DeepEqual(t, client.calls, []int{
+		modbus.FuncCodeReadHoldingRegisters,
+		modbus.FuncCodeReadHoldingRegisters,
+		modbus.FuncCodeWriteMultipleRegisters,
+	})
+}
+
+func TestReadBlocks(t *testing.T) {
+	client := newFakeClient()
+	client.registers[0] = 1
+	client.registers[1] = 2
+	client.registers[3] = 4
+	client.registers[100] = 5
+
+	regs := []Register{
+		{Name: "a", Address: 0, DataType: u16, FunctionCode: 3, AccessType: ro},
+		{Name: "b", Address: 1, DataType: u16, FunctionCode: 3, AccessType: ro},
+		{Name: "c", Address: 3, DataType: u16, FunctionCode: 3, AccessType: ro},
+		{Name: "d", Address: 100, DataType: u16, FunctionCode: 3, AccessType: ro},
+	}
+	poller, handler := newFakePoller(client, 125, 1)
+
+	points, err := readPoints(t, poller, regs)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, points, map[string]Point{
+		"a": {Value: 1, Quality: asset.Good},
+		"b": {Value: 2, Quality: asset.Good},
+		"c": {Value: 4, Quality: asset.Good},
+		"d": {Value: 5, Quality: asset.Good},
+	})
+	assert.Equal(t, len(client.calls), 2)
+
+	// the connection is held open between polls
+	_, err = poller.Read(regs)
+	assert.NilError(t, err)
+	assert.Equal(t, handler.closes, 0)
+}
+
+func TestReadTransportErrorReconnects(t *testing.T) {
+	client := newFakeClient()
+	client.failures[0] = errors.New("connection reset")
+
+	regs := []Register{
+		{Name: "a", Address: 0, DataType: u16, FunctionCode: 3, AccessType: ro},
+		{Name: "b", Address: 10, DataType: u16, FunctionCode: 3, AccessType: ro},
+	}
+	poller, handler := newFakePoller(client, 125, 0)
+
+	points, err := readPoints(t, poller, regs)
+	assert.Error(t, err, "connection reset")
+	assert.Equal(t, handler.closes, 1)
+	assert.Equal(t, len(client.calls), 1, "remaining blocks are skipped")
+	assert.DeepEqual(t, points["b"], Point{Value: 0, Quality: asset.CommFail})
+}
+
+func TestReadExceptionKeepsConnection(t *testing.T) {
+	client := newFakeClient()
+	client.failures[0] = &modbus.ModbusError{FunctionCode: 3, ExceptionCode: modbus.ExceptionCodeIllegalDataAddress}
+	client.registers[10] = 7
+
+	regs := []Register{
+		{Name: "a", Address: 0, DataType: u16, FunctionCode: 3, AccessType: ro},
+		{Name: "b", Address: 10, DataType: u16, FunctionCode: 3, AccessType: ro},
+	}
+	poller, handler := newFakePoller(client, 125, 0)
+
+	points, err := readPoints(t, poller, regs)
+	assert.ErrorContains(t, err, "illegal data address")
+	assert.Equal(t, handler.closes, 0)
+	assert.DeepEqual(t, points["a"], Point{Value: 0, Quality: asset.CommFail})
+	assert.DeepEqual(t, points["b"], Point{Value: 7, Quality: asset.Good})
+}
+
+func TestReadMetrics(t *testing.T) {
+	client := newFakeClient()
+	reg := Register{Name: "kw", Address: 0, DataType: u16, FunctionCode: 3, AccessType: ro}
+	poller, _ := newFakePoller(client, 0, 0)
+	poller.target, poller.slave = "metrics-test", "1"
+
+	_, err := readPoints(t, poller, []Register{reg})
+	assert.NilError(t, err)
+	client.failures[0] = errors.New("connection reset")
+	_, err = readPoints(t, poller, []Register{reg})
+	assert.Error(t, err, "connection reset")
+
+	assert.Equal(t, sampleValue(pollSeconds.Collect(), "cgc_modbus_poll_seconds_count", "metrics-test"), 2.0)
+	assert.Equal(t, sampleValue(pollErrors.Collect(), "cgc_modbus_poll_errors_total", "metrics-test"), 1.0)
+}
+
+// sampleValue returns the value of the named sample of the target
+func sampleValue(families []metrics.Family, name string, target string) float64 {
+	for _, f := range families {
+		for _, s := range f.Samples {
+			if s.Name == name && s.Labels[0].Value == target {
+				return s.Value
+			}
+		}
+	}
+	return math.NaN()
+}
+
+func TestReadOutOfRange(t *testing.T) {
+	client := newFakeClient()
+	client.registers[0] = 1200
+
+	min, max := 0.0, 1000.0
+	reg := Register{Name: "kw", Address: 0, DataType: u16, FunctionCode: 3, AccessType: ro, Min: &min, Max: &max}
+	poller, _ := newFakePoller(client, 0, 0)
+
+	points, err := readPoints(t, poller, []Register{reg})
+	assert.NilError(t, err)
+	assert.DeepEqual(t, points["kw"], Point{Value: 1200, Quality: asset.OutOfRange})
+
+	client.registers[0] = 800
+	points, err = readPoints(t, poller, []Register{reg})
+	assert.NilError(t, err)
+	assert.DeepEqual(t, points["kw"], Point{Value: 800, Quality: asset.Good})
+}
+
+func TestReadStale(t *testing.T) {
+	client := newFakeClient()
+	client.registers[0] = 42
 
-	poller := NewPoller(pollerConfig)
+	reg := Register{Name: "kw", Address: 0, DataType: u16, FunctionCode: 3, AccessType: ro}
+	poller, _ := newFakePoller(client, 0, 0)
+	poller.staleTimeout = 50 * time.Millisecond
 
-	_, err := poller.Read(regs)
-	assert.Assert(t, err.Error() == fmt.Sprintf("dial tcp %v:%v: i/o timeout", testIP, testPort))
+	points, err := readPoints(t, poller, []Register{reg})
+	assert.NilError(t, err)
+	assert.DeepEqual(t, points["kw"], Point{Value: 42, Quality: asset.Good})
+
+	// the last good value is held while the device is unreachable
+	client.failures[0] = errors.New("connection reset")
+	points, err = readPoints(t, poller, []Register{reg})
+	assert.Error(t, err, "connection reset")
+	assert.DeepEqual(t, points["kw"], Point{Value: 42, Quality: asset.Stale})
+
+	time.Sleep(60 * time.Millisecond)
+	points, _ = readPoints(t, poller, []Register{reg})
+	assert.DeepEqual(t, points["kw"], Point{Value: 0, Quality: asset.CommFail})
+}
+
+func TestWordOrder(t *testing.T) {
+	// words low first, bytes high first
+	reg := Register{Name: "test", DataType: u32, Endianness: bigEndian, WordOrder: littleEndian}
+	device := []byte{0, 2, 0, 1}
+	assert.Equal(t, decode(device, reg), 65538.0)
+	assert.DeepEqual(t, encode(65538, reg), device)
+
+	// words high first, bytes low first
+	reg = Register{Name: "test", DataType: u32, Endianness: littleEndian, WordOrder: bigEndian}
+	device = []byte{1, 0, 2, 0}
+	assert.Equal(t, decode(device, reg), 65538.0)
+	assert.DeepEqual(t, encode(65538, reg), device)
+}
+
+func TestDecodeString(t *testing.T) {
+	reg := Register{Name: "serial", DataType: str, Length: 4}
+	p := decodePoint([]byte("SN-42\x00\x00\x00"), reg)
+	assert.Equal(t, p.Text, "SN-42")
+	assert.Equal(t, reg.size(), uint16(4))
+}
+
+func TestDecodeEnumAndBits(t *testing.T) {
+	var reg Register
+	err := json.Unmarshal([]byte(`{
+		"Name": "state",
+		"DataType": "u16",
+		"Enum": {"1": "off", "4": "mppt"},
+		"Bits": {"0": "ground fault", "2": "over temp"}
+	}`), &reg)
+	assert.NilError(t, err)
+
+	p := decodePoint([]byte{0, 4}, reg)
+	assert.Equal(t, p.Text, "mppt")
+	assert.DeepEqual(t, p.Flags, []string{"over temp"})
+
+	p = decodePoint([]byte{0, 5}, reg)
+	assert.Equal(t, p.Text, "")
+	assert.DeepEqual(t, p.Flags, []string{"ground fault", "over temp"})
+}
+
+func TestReadScaled(t *testing.T) {
+	client := newFakeClient()
+	client.registers[0] = 1234
+	client.registers[1] = 0xFFFF // -1
+	client.registers[2] = 650
+
+	regs := []Register{
+		{Name: "W", Address: 0, DataType: i16, FunctionCode: 3, ScaleFactor: "W_SF"},
+		{Name: "W_SF", Address: 1, DataType: i16, FunctionCode: 3},
+		{Name: "Temp", Address: 2, DataType: u16, FunctionCode: 3, Scale: 0.1, Offset: -40},
+	}
+	poller, _ := newFakePoller(client, 0, 0)
+
+	points, err := readPoints(t, poller, regs)
+	assert.NilError(t, err)
+	assert.Assert(t, math.Abs(points["W"].Value-123.4) < 1e-9)
+	assert.Equal(t, points["W_SF"].Value, -1.0)
+	assert.Equal(t, points["Temp"].Value, 25.0)
+
+	// without its scale factor the value cannot be trusted
+	points, err = readPoints(t, poller, regs[:1])
+	assert.Error(t, err, "register W: scale factor W_SF not read")
+	assert.Equal(t, points["W"].Quality, asset.CommFail)
+}
+
+func TestReadUnimplemented(t *testing.T) {
+	client := newFakeClient()
+	client.registers[0] = 0x8000
+	client.registers[1] = 0xFFFF // -1
+	client.registers[2] = 0x8000
+	client.registers[3] = 0x8000 // not implemented
+
+	unimplemented := float64(-32768)
+	regs := []Register{
+		{Name: "W", Address: 0, DataType: i16, FunctionCode: 3, ScaleFactor: "W_SF", Unimplemented: &unimplemented},
+		{Name: "W_SF", Address: 1, DataType: i16, FunctionCode: 3},
+		{Name: "VAr", Address: 2, DataType: i16, FunctionCode: 3, ScaleFactor: "VAr_SF"},
+		{Name: "VAr_SF", Address: 3, DataType: i16, FunctionCode: 3},
+	}
+	poller, _ := newFakePoller(client, 0, 0)
+
+	points, err := readPoints(t, poller, regs)
+	assert.NilError(t, err)
+	assert.Equal(t, points["W"].Quality, asset.OutOfRange)
+	assert.Equal(t, points["W_SF"].Quality, asset.Good)
+	assert.Equal(t, points["VAr"].Quality, asset.OutOfRange, "the scale factor is not implemented")
+}
+
+func TestWriteScaled(t *testing.T) {
+	client := newFakeClient()
+	client.registers[1] = 0xFFFE // -2
+
+	regs := []Register{
+		{Name: "WMax", Address: 0, DataType: u16, FunctionCode: 3, AccessType: rw, ScaleFactor: "WMax_SF"},
+		{Name: "WMax_SF", Address: 1, DataType: i16, FunctionCode: 3, AccessType: ro},
+		{Name: "Serial", Address: 2, DataType: str, Length: 8, FunctionCode: 3, AccessType: rw},
+	}
+	poller, _ := newFakePoller(client, 0, 0)
+
+	// the scale factor is unknown until read
+	err := poller.Write(regs[:1], []byte(`{"WMax": 12.34}`))
+	assert.Error(t, err, "register WMax: scale factor WMax_SF not read")
+
+	// and is read with the write when it is among the registers
+	err = poller.Write(regs, []byte(`{"WMax": 12.34}`))
+	assert.NilError(t, err)
+	assert.Equal(t, client.registers[0], uint16(1234))
+
+	client.registers[1] = 0xFFFF // -1, used once read
+	err = poller.Write(regs, []byte(`{"WMax": 12.34}`))
+	assert.NilError(t, err)
+	assert.Equal(t, client.registers[0], uint16(1234))
+	_, err = poller.Read(regs[1:2])
+	assert.NilError(t, err)
+	err = poller.Write(regs[:1], []byte(`{"WMax": 12.34}`))
+	assert.NilError(t, err)
+	assert.Equal(t, client.registers[0], uint16(123))
+
+	err = poller.Write(regs, []byte(`{"Serial": 1}`))
+	assert.Error(t, err, "register Serial: string registers are read-only")
 }
@@ -0,0 +1,243 @@
+package modbuscomm
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/goburrow/modbus"
+	"github.com/tbrandon/mbserver"
+)
+
+// Server is a Modbus TCP slave serving an image of registers. Values are set in
+// engineering units and encoded with the data type, byte order and scaling of the
+// register, a ScaleFactor register is not supported. A client write to read-write or
+// write-only registers is decoded and passed to the onWrite function. A write that
+// touches an address outside of the writable registers is refused with an illegal
+// data address exception.
+type Server struct {
+	mux       *sync.Mutex
+	server    *mbserver.Server
+	registers map[string]served
+	onWrite   func(Register, float64)
+}
+
+// served is a register of the server image and the table it is served from
+type served struct {
+	register Register
+	table    table
+}
+
+type functionHandler func(*mbserver.Server, mbserver.Framer) ([]byte, *mbserver.Exception)
+
+// NewServer returns a Server for the registers. onWrite is called, in order, with the
+// value of each register written by a client.
+func NewServer(registers []Register, onWrite func(Register, float64)) (*Server, error) {
+	s := &Server{
+		mux:       &sync.Mutex{},
+		server:    mbserver.NewServer(),
+		registers: make(map[string]served),
+		onWrite:   onWrite,
+	}
+
+	for _, register := range registers {
+		t, err := tableOf(register)
+		if err != nil {
+			return nil, err
+		}
+		if register.DataType == str || register.ScaleFactor != "" {
+			err := fmt.Sprintf("register %v: string and scale factor registers are not served", register.Name)
+			return nil, errors.New(err)
+		}
+		if _, ok := s.registers[register.Name]; ok {
+			err := fmt.Sprintf("register %v: duplicate register name", register.Name)
+			return nil, errors.New(err)
+		}
+		s.registers[register.Name] = served{register, t}
+	}
+
+	s.server.RegisterFunctionHandler(modbus.FuncCodeReadCoils, s.read(mbserver.ReadCoils))
+	s.server.RegisterFunctionHandler(modbus.FuncCodeReadDiscreteInputs, s.read(mbserver.ReadDiscreteInputs))
+	s.server.RegisterFunctionHandler(modbus.FuncCodeReadHoldingRegisters, s.read(mbserver.ReadHoldingRegisters))
+	s.server.RegisterFunctionHandler(modbus.FuncCodeReadInputRegisters, s.read(mbserver.ReadInputRegisters))
+	s.server.RegisterFunctionHandler(modbus.FuncCodeWriteSingleCoil, s.write(coilTable, mbserver.WriteSingleCoil))
+	s.server.RegisterFunctionHandler(modbus.FuncCodeWriteMultipleCoils, s.write(coilTable, mbserver.WriteMultipleCoils))
+	s.server.RegisterFunctionHandler(modbus.FuncCodeWriteSingleRegister, s.write(holdingTable, mbserver.WriteHoldingRegister))
+	s.server.RegisterFunctionHandler(modbus.FuncCodeWriteMultipleRegisters, s.write(holdingTable, mbserver.WriteHoldingRegisters))
+	return s, nil
+}
+
+// ListenTCP serves clients on the address, "host:port"
+func (s *Server) ListenTCP(address string) error {
+	return s.server.ListenTCP(address)
+}
+
+// Close stops listening for clients
+func (s *Server) Close() {
+	s.server.Close()
+}
+
+// Set writes the value of the named register into the server image. A bool register
+// with a Bitmask sets or clears the masked bits of its word.
+func (s *Server) Set(name string, val float64) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	r, ok := s.registers[name]
+	if !ok {
+		err := fmt.Sprintf("register %v: not served", name)
+		return errors.New(err)
+	}
+
+	address := int(r.register.Address)
+	switch r.table {
+	case coilTable:
+		s.server.Coils[address] = encodeBit(val)
+	case discreteTable:
+		s.server.DiscreteInputs[address] = encodeBit(val)
+	default:
+		words := s.words(r)
+		if r.register.DataType == boolean && r.register.Bitmask != 0 {
+			words[0] = setBits(words[0], r.register.Bitmask, val != 0)
+			return nil
+		}
+		bytes := encode(r.register.unscale(val, 0), r.register)
+		for i := range words {
+			words[i] = binary.BigEndian.Uint16(bytes[2*i:])
+		}
+	}
+	return nil
+}
+
+// Get returns the value of the named register in the server image
+func (s *Server) Get(name string) (float64, error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	r, ok := s.registers[name]
+	if !ok {
+		err := fmt.Sprintf("register %v: not served", name)
+		return 0, errors.New(err)
+	}
+	return s.get(r), nil
+}
+
+// get decodes the value of the register from the server image
+func (s *Server) get(r served) float64 {
+	address := int(r.register.Address)
+	switch r.table {
+	case coilTable:
+		return float64(s.server.Coils[address] & 1)
+	case discreteTable:
+		return float64(s.server.DiscreteInputs[address] & 1)
+	}
+
+	words := s.words(r)
+	bytes := make([]byte, 2*len(words))
+	for i, word := range words {
+		binary.BigEndian.PutUint16(bytes[2*i:], word)
+	}
+	return r.register.scale(decode(bytes, r.register), 0)
+}
+
+// words returns the slice of the word table holding the register
+func (s *Server) words(r served) []uint16 {
+	memory := s.server.HoldingRegisters
+	if r.table == inputTable {
+		memory = s.server.InputRegisters
+	}
+	start := int(r.register.Address)
+	end := start + int(r.register.size())
+	if end > len(memory) {
+		end = len(memory)
+	}
+	return memory[start:end]
+}
+
+// read serves a read function under the image lock
+func (s *Server) read(f functionHandler) functionHandler {
+	return func(server *mbserver.Server, frame mbserver.Framer) ([]byte, *mbserver.Exception) {
+		s.mux.Lock()
+		defer s.mux.Unlock()
+		return f(server, frame)
+	}
+}
+
+// write serves a write function to the table. The write is applied to the image when
+// every address written belongs to a writable register, and the value of each
+// register written is then passed to onWrite.
+func (s *Server) write(t table, f functionHandler) functionHandler {
+	return func(server *mbserver.Server, frame mbserver.Framer) ([]byte, *mbserver.Exception) {
+		data := frame.GetData()
+		if len(data) < 4 {
+			return []byte{}, &mbserver.IllegalDataValue
+		}
+		address := int(binary.BigEndian.Uint16(data))
+		quantity := 1
+		switch frame.GetFunction() {
+		case modbus.FuncCodeWriteMultipleCoils, modbus.FuncCodeWriteMultipleRegisters:
+			quantity = int(binary.BigEndian.Uint16(data[2:]))
+		}
+
+		s.mux.Lock()
+		written, ok := s.writable(t, address, quantity)
+		if !ok {
+			s.mux.Unlock()
+			return []byte{}, &mbserver.IllegalDataAddress
+		}
+		resp, exception := f(server, frame)
+		if exception != &mbserver.Success {
+			s.mux.Unlock()
+			return resp, exception
+		}
+		values := make([]float64, len(written))
+		for i, r := range written {
+			values[i] = s.get(r)
+		}
+		s.mux.Unlock()
+
+		if s.onWrite != nil {
+			for i, r := range written {
+				s.onWrite(r.register, values[i])
+			}
+		}
+		return resp, exception
+	}
+}
+
+// writable returns the writable registers of the table covering the addresses, in
+// address order. Returns false when an address is not covered by a writable register.
+func (s *Server) writable(t table, address int, quantity int) ([]served, bool) {
+	written := make([]served, 0)
+	for a := address; a < address+quantity; a++ {
+		covered := false
+		for _, r := range s.registers {
+			start := int(r.register.Address)
+			if r.table != t || a < start || a >= start+t.span(r.register) {
+				continue
+			}
+			if r.register.AccessType != rw && r.register.AccessType != wo {
+				return nil, false
+			}
+			covered = true
+			if a == start || a == address {
+				written = appendServed(written, r)
+			}
+		}
+		if !covered {
+			return nil, false
+		}
+	}
+	return written, true
+}
+
+// appendServed appends the register unless it is already in the list
+func appendServed(list []served, r served) []served {
+	for _, l := range list {
+		if l.register.Name == r.register.Name {
+			return list
+		}
+	}
+	return append(list, r)
+}
@@ -0,0 +1,137 @@
+package modbuscomm
+
+import (
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/goburrow/modbus"
+	"gotest.tools/assert"
+)
+
+// freeAddress returns a local TCP address that is free to listen on
+func freeAddress(t *testing.T) *net.TCPAddr {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NilError(t, err)
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr)
+}
+
+// written records the values passed to the server onWrite function
+type written struct {
+	mux    *sync.Mutex
+	values map[string]float64
+}
+
+func (w *written) onWrite(register Register, val float64) {
+	w.mux.Lock()
+	defer w.mux.Unlock()
+	w.values[register.Name] = val
+}
+
+func serverRegisters() []Register {
+	return []Register{
+		{Name: "KW", Address: 0, DataType: i32, FunctionCode: 4, AccessType: ro, Scale: 0.1},
+		{Name: "SOC", Address: 2, DataType: u16, FunctionCode: 4, AccessType: ro},
+		{Name: "Online", Address: 0, DataType: boolean, FunctionCode: 2, AccessType: ro},
+		{Name: "KWSet", Address: 10, DataType: i32, FunctionCode: 16, AccessType: rw, Scale: 0.1},
+		{Name: "Run", Address: 0, DataType: boolean, FunctionCode: 5, AccessType: rw},
+		{Name: "Status", Address: 20, DataType: u16, FunctionCode: 3, AccessType: ro},
+	}
+}
+
+func newTestServer(t *testing.T) (*Server, *written, Poller) {
+	w := &written{&sync.Mutex{}, make(map[string]float64)}
+	server, err := NewServer(serverRegisters(), w.onWrite)
+	assert.NilError(t, err)
+
+	addr := freeAddress(t)
+	assert.NilError(t, server.ListenTCP(addr.String()))
+
+	poller, err := NewPoller(PollerConfig{IPAddr: "127.0.0.1", Port: strconv.Itoa(addr.Port), SlaveID: 1, Timeout: 1000})
+	assert.NilError(t, err)
+	return server, w, poller
+}
+
+func TestServerRead(t *testing.T) {
+	server, _, poller := newTestServer(t)
+	defer server.Close()
+	defer poller.Close()
+
+	assert.NilError(t, server.Set("KW", -12.5))
+	assert.NilError(t, server.Set("SOC", 65))
+	assert.NilError(t, server.Set("Online", 1))
+
+	resp, err := poller.Read(serverRegisters()[:3])
+	assert.NilError(t, err)
+	assert.Equal(t, string(resp), `{"KW":{"Value":-12.5,"Quality":"good"},"Online":{"Value":1,"Quality":"good"},"SOC":{"Value":65,"Quality":"good"}}`)
+
+	val, err := server.Get("KW")
+	assert.NilError(t, err)
+	assert.Equal(t, val, -12.5)
+}
+
+func TestServerWrite(t *testing.T) {
+	server, w, poller := newTestServer(t)
+	defer server.Close()
+	defer poller.Close()
+
+	err := poller.Write(serverRegisters(), []byte(`{"KWSet": -20, "Run": 1}`))
+	assert.NilError(t, err)
+
+	w.mux.Lock()
+	assert.DeepEqual(t, w.values, map[string]float64{"KWSet": -20, "Run": 1})
+	w.mux.Unlock()
+
+	val, err := server.Get("KWSet")
+	assert.NilError(t, err)
+	assert.Equal(t, val, -20.0)
+}
+
+func TestServerWriteReadOnly(t *testing.T) {
+	server, w, poller := newTestServer(t)
+	defer server.Close()
+	defer poller.Close()
+
+	err := poller.Write(serverRegisters(), []byte(`{"Status": 3}`))
+	assert.ErrorContains(t, err, "exception '2'")
+
+	// an address outside of the served registers
+	err = writeRegister(poller.client, Register{Name: "Unmapped", Address: 40, DataType: u16}, 1)
+	assert.ErrorContains(t, err, "exception '2'")
+
+	w.mux.Lock()
+	assert.Equal(t, len(w.values), 0)
+	w.mux.Unlock()
+}
+
+func TestServerBitmask(t *testing.T) {
+	registers := []Register{
+		{Name: "Alarm", Address: 0, DataType: boolean, FunctionCode: 3, AccessType: ro, Bitmask: 0x0004},
+		{Name: "Word", Address: 0, DataType: u16, FunctionCode: 3, AccessType: ro},
+	}
+	server, err := NewServer(registers, nil)
+	assert.NilError(t, err)
+
+	assert.NilError(t, server.Set("Word", 0x0101))
+	assert.NilError(t, server.Set("Alarm", 1))
+	val, err := server.Get("Word")
+	assert.NilError(t, err)
+	assert.Equal(t, val, float64(0x0105))
+}
+
+func TestNewServerBadRegister(t *testing.T) {
+	_, err := NewServer([]Register{{Name: "Name", DataType: str, Length: 8}}, nil)
+	assert.Error(t, err, "register Name: string and scale factor registers are not served")
+
+	_, err = NewServer([]Register{{Name: "KW", DataType: u16}, {Name: "KW", Address: 1, DataType: u16}}, nil)
+	assert.Error(t, err, "register KW: duplicate register name")
+
+	_, err = NewServer([]Register{{Name: "KW", DataType: u16, FunctionCode: modbus.FuncCodeReadFIFOQueue}}, nil)
+	assert.Error(t, err, "register KW: unsupported function code 24")
+
+	server, err := NewServer(nil, nil)
+	assert.NilError(t, err)
+	assert.Error(t, server.Set("KW", 1), "register KW: not served")
+}
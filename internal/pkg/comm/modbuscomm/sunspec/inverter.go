@@ -0,0 +1,143 @@
+package sunspec
+
+import (
+	"errors"
+	"math"
+
+	"github.com/ohowland/cgc_core/internal/pkg/asset"
+	"github.com/ohowland/cgc_core/internal/pkg/comm/modbuscomm"
+)
+
+// Inverter operating states (St) of the inverter models that export power
+const (
+	stateMPPT      = 4
+	stateThrottled = 5
+)
+
+// Inverter is the state of the inverter model of a device. KWMax is the maximum
+// power setting of the basic settings model, or the nameplate rating.
+type Inverter struct {
+	KW      float64
+	KVAR    float64
+	Hz      float64
+	Volts   float64
+	KWMax   float64
+	Online  bool
+	Quality asset.Quality
+}
+
+// inverterModel returns the first of the single, split or three phase inverter models
+// of the device
+func (d *Device) inverterModel() (uint16, error) {
+	for _, id := range []uint16{InverterThree, InverterSplit, InverterSingle} {
+		if d.Has(id) {
+			return id, nil
+		}
+	}
+	return 0, errors.New("sunspec: device has no inverter model")
+}
+
+// ReadInverter reads the inverter model of the device, together with the named
+// points, which are returned by name. The quality of the inverter is the worst quality
+// of its mandatory points. The reactive power is optional, and reads 0 when it is not
+// good. The immediate control percentages are read with the inverter so their
+// scale factors are known to WriteInverter.
+func (d *Device) ReadInverter(names ...string) (Inverter, map[string]modbuscomm.Point, error) {
+	id, err := d.inverterModel()
+	if err != nil {
+		return Inverter{}, nil, err
+	}
+
+	measured := []string{Name(id, "W"), Name(id, "Hz"), Name(id, "PhVphA"), Name(id, "St")}
+	optional := []string{Name(id, "VAr")}
+	rated := []string{Name(BasicSettings, "WMax"), Name(Nameplate, "WRtg")}
+	controls := []string{Name(Controls, "WMaxLimPct"), Name(Controls, "VArWMaxPct")}
+
+	reads := append(append(append(append(append([]string{}, measured...), optional...), rated...), controls...), names...)
+	points, err := d.Read(reads...)
+	if err != nil {
+		return Inverter{}, nil, err
+	}
+
+	quality := asset.Good
+	for _, name := range measured {
+		p, ok := points[name]
+		if !ok {
+			p.Quality = asset.CommFail
+		}
+		quality = asset.Worst(quality, p.Quality)
+	}
+
+	state := points[Name(id, "St")].Value
+	inverter := Inverter{
+		KW:      points[Name(id, "W")].Value / 1000,
+		KVAR:    good(points[Name(id, "VAr")]) / 1000,
+		Hz:      points[Name(id, "Hz")].Value,
+		Volts:   points[Name(id, "PhVphA")].Value,
+		Online:  state == stateMPPT || state == stateThrottled,
+		Quality: quality,
+	}
+	for _, name := range rated {
+		if p, ok := points[name]; ok && p.Quality == asset.Good {
+			inverter.KWMax = p.Value / 1000
+			break
+		}
+	}
+
+	d.mux.Lock()
+	d.wMax = inverter.KWMax * 1000
+	d.mux.Unlock()
+	return inverter, points, nil
+}
+
+// InverterControls returns the immediate control point values that connect or
+// disconnect the inverter, limit its real power to kwLimit and set its reactive power
+// to kvar. The limits are a percentage of the maximum power last read by
+// ReadInverter, and are left out until it is known. No points are returned when the
+// device has no immediate controls model.
+func (d *Device) InverterControls(run bool, kwLimit float64, kvar float64) map[string]float64 {
+	values := make(map[string]float64)
+	if !d.Has(Controls) {
+		return values
+	}
+
+	values[Name(Controls, "Conn")] = 0
+	if run {
+		values[Name(Controls, "Conn")] = 1
+	}
+
+	d.mux.Lock()
+	wMax := d.wMax
+	d.mux.Unlock()
+	if wMax <= 0 {
+		return values
+	}
+
+	values[Name(Controls, "WMaxLimPct")] = clamp(kwLimit*1000/wMax*100, 0, 100)
+	values[Name(Controls, "WMaxLim_Ena")] = 1
+	values[Name(Controls, "VArWMaxPct")] = clamp(kvar*1000/wMax*100, -100, 100)
+	values[Name(Controls, "VArPct_Mod")] = 1
+	values[Name(Controls, "VArPct_Ena")] = 1
+	return values
+}
+
+// WriteInverter writes the immediate control point values of InverterControls
+func (d *Device) WriteInverter(run bool, kwLimit float64, kvar float64) error {
+	values := d.InverterControls(run, kwLimit, kvar)
+	if len(values) == 0 {
+		return nil
+	}
+	return d.Write(values)
+}
+
+// good returns the value of the point, or 0 when it is not good
+func good(p modbuscomm.Point) float64 {
+	if p.Quality != asset.Good {
+		return 0
+	}
+	return p.Value
+}
+
+func clamp(val float64, min float64, max float64) float64 {
+	return math.Max(min, math.Min(max, val))
+}
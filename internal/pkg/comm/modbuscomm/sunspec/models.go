@@ -0,0 +1,446 @@
+package sunspec
+
+import (
+	"fmt"
+
+	"github.com/ohowland/cgc_core/internal/pkg/comm/modbuscomm"
+)
+
+// SunSpec model IDs
+const (
+	Common          uint16 = 1
+	InverterSingle  uint16 = 101
+	InverterSplit   uint16 = 102
+	InverterThree   uint16 = 103
+	Nameplate       uint16 = 120
+	BasicSettings   uint16 = 121
+	MeasurementsExt uint16 = 122
+	Controls        uint16 = 123
+	Storage         uint16 = 124
+	MPPT            uint16 = 160
+	Battery         uint16 = 802
+)
+
+// point is a SunSpec point at an offset from the first data register of its model.
+// sf names the scale factor point of the same model.
+type point struct {
+	name     string
+	offset   uint16
+	dataType modbuscomm.DataType
+	length   uint16
+	sf       string
+	access   modbuscomm.Access
+}
+
+// SunSpec point types in modbuscomm data types. Enumerations, bitfields and
+// accumulators read as unsigned integers of the same width.
+const (
+	uint16T    modbuscomm.DataType = "u16"
+	int16T     modbuscomm.DataType = "i16"
+	uint32T    modbuscomm.DataType = "u32"
+	int32T     modbuscomm.DataType = "i32"
+	acc64T     modbuscomm.DataType = "u64"
+	stringT    modbuscomm.DataType = "string"
+	enum16T                        = uint16T
+	bitfield16                     = uint16T
+	bitfield32                     = uint32T
+	acc32T                         = uint32T
+	sunssf                         = int16T
+)
+
+const (
+	ro modbuscomm.Access = "read-only"
+	rw modbuscomm.Access = "read-write"
+)
+
+func value(name string, offset uint16, dataType modbuscomm.DataType, sf string) point {
+	return point{name: name, offset: offset, dataType: dataType, sf: sf, access: ro}
+}
+
+func setting(name string, offset uint16, dataType modbuscomm.DataType, sf string) point {
+	return point{name: name, offset: offset, dataType: dataType, sf: sf, access: rw}
+}
+
+func text(name string, offset uint16, length uint16) point {
+	return point{name: name, offset: offset, dataType: stringT, length: length, access: ro}
+}
+
+var commonPoints = []point{
+	text("Mn", 0, 16),
+	text("Md", 16, 16),
+	text("Opt", 32, 8),
+	text("Vr", 40, 8),
+	text("SN", 48, 16),
+	value("DA", 64, uint16T, ""),
+}
+
+// inverterPoints are common to the single, split and three phase inverter models
+var inverterPoints = []point{
+	value("A", 0, uint16T, "A_SF"),
+	value("AphA", 1, uint16T, "A_SF"),
+	value("AphB", 2, uint16T, "A_SF"),
+	value("AphC", 3, uint16T, "A_SF"),
+	value("A_SF", 4, sunssf, ""),
+	value("PPVphAB", 5, uint16T, "V_SF"),
+	value("PPVphBC", 6, uint16T, "V_SF"),
+	value("PPVphCA", 7, uint16T, "V_SF"),
+	value("PhVphA", 8, uint16T, "V_SF"),
+	value("PhVphB", 9, uint16T, "V_SF"),
+	value("PhVphC", 10, uint16T, "V_SF"),
+	value("V_SF", 11, sunssf, ""),
+	value("W", 12, int16T, "W_SF"),
+	value("W_SF", 13, sunssf, ""),
+	value("Hz", 14, uint16T, "Hz_SF"),
+	value("Hz_SF", 15, sunssf, ""),
+	value("VA", 16, int16T, "VA_SF"),
+	value("VA_SF", 17, sunssf, ""),
+	value("VAr", 18, int16T, "VAr_SF"),
+	value("VAr_SF", 19, sunssf, ""),
+	value("PF", 20, int16T, "PF_SF"),
+	value("PF_SF", 21, sunssf, ""),
+	value("WH", 22, acc32T, "WH_SF"),
+	value("WH_SF", 24, sunssf, ""),
+	value("DCA", 25, uint16T, "DCA_SF"),
+	value("DCA_SF", 26, sunssf, ""),
+	value("DCV", 27, uint16T, "DCV_SF"),
+	value("DCV_SF", 28, sunssf, ""),
+	value("DCW", 29, int16T, "DCW_SF"),
+	value("DCW_SF", 30, sunssf, ""),
+	value("TmpCab", 31, int16T, "Tmp_SF"),
+	value("TmpSnk", 32, int16T, "Tmp_SF"),
+	value("TmpTrns", 33, int16T, "Tmp_SF"),
+	value("TmpOt", 34, int16T, "Tmp_SF"),
+	value("Tmp_SF", 35, sunssf, ""),
+	value("St", 36, enum16T, ""),
+	value("StVnd", 37, enum16T, ""),
+	value("Evt1", 38, bitfield32, ""),
+	value("Evt2", 40, bitfield32, ""),
+	value("EvtVnd1", 42, bitfield32, ""),
+	value("EvtVnd2", 44, bitfield32, ""),
+	value("EvtVnd3", 46, bitfield32, ""),
+	value("EvtVnd4", 48, bitfield32, ""),
+}
+
+var nameplatePoints = []point{
+	value("DERTyp", 0, enum16T, ""),
+	value("WRtg", 1, uint16T, "WRtg_SF"),
+	value("WRtg_SF", 2, sunssf, ""),
+	value("VARtg", 3, uint16T, "VARtg_SF"),
+	value("VARtg_SF", 4, sunssf, ""),
+	value("VArRtgQ1", 5, int16T, "VArRtg_SF"),
+	value("VArRtgQ2", 6, int16T, "VArRtg_SF"),
+	value("VArRtgQ3", 7, int16T, "VArRtg_SF"),
+	value("VArRtgQ4", 8, int16T, "VArRtg_SF"),
+	value("VArRtg_SF", 9, sunssf, ""),
+	value("ARtg", 10, uint16T, "ARtg_SF"),
+	value("ARtg_SF", 11, sunssf, ""),
+	value("PFRtgQ1", 12, int16T, "PFRtg_SF"),
+	value("PFRtgQ2", 13, int16T, "PFRtg_SF"),
+	value("PFRtgQ3", 14, int16T, "PFRtg_SF"),
+	value("PFRtgQ4", 15, int16T, "PFRtg_SF"),
+	value("PFRtg_SF", 16, sunssf, ""),
+	value("WHRtg", 17, uint16T, "WHRtg_SF"),
+	value("WHRtg_SF", 18, sunssf, ""),
+	value("AhrRtg", 19, uint16T, "AhrRtg_SF"),
+	value("AhrRtg_SF", 20, sunssf, ""),
+	value("MaxChaRte", 21, uint16T, "MaxChaRte_SF"),
+	value("MaxChaRte_SF", 22, sunssf, ""),
+	value("MaxDisChaRte", 23, uint16T, "MaxDisChaRte_SF"),
+	value("MaxDisChaRte_SF", 24, sunssf, ""),
+}
+
+var settingsPoints = []point{
+	setting("WMax", 0, uint16T, "WMax_SF"),
+	setting("VRef", 1, uint16T, "VRef_SF"),
+	setting("VRefOfs", 2, int16T, "VRefOfs_SF"),
+	setting("VMax", 3, uint16T, "VMinMax_SF"),
+	setting("VMin", 4, uint16T, "VMinMax_SF"),
+	setting("VAMax", 5, uint16T, "VAMax_SF"),
+	setting("VArMaxQ1", 6, int16T, "VArMax_SF"),
+	setting("VArMaxQ2", 7, int16T, "VArMax_SF"),
+	setting("VArMaxQ3", 8, int16T, "VArMax_SF"),
+	setting("VArMaxQ4", 9, int16T, "VArMax_SF"),
+	setting("WGra", 10, uint16T, "WGra_SF"),
+	setting("PFMinQ1", 11, int16T, "PFMin_SF"),
+	setting("PFMinQ2", 12, int16T, "PFMin_SF"),
+	setting("PFMinQ3", 13, int16T, "PFMin_SF"),
+	setting("PFMinQ4", 14, int16T, "PFMin_SF"),
+	setting("VArAct", 15, enum16T, ""),
+	setting("ClcTotVA", 16, enum16T, ""),
+	setting("MaxRmpRte", 17, uint16T, "MaxRmpRte_SF"),
+	setting("ECPNomHz", 18, uint16T, "ECPNomHz_SF"),
+	setting("ConnPh", 19, enum16T, ""),
+	value("WMax_SF", 20, sunssf, ""),
+	value("VRef_SF", 21, sunssf, ""),
+	value("VRefOfs_SF", 22, sunssf, ""),
+	value("VMinMax_SF", 23, sunssf, ""),
+	value("VAMax_SF", 24, sunssf, ""),
+	value("VArMax_SF", 25, sunssf, ""),
+	value("WGra_SF", 26, sunssf, ""),
+	value("PFMin_SF", 27, sunssf, ""),
+	value("MaxRmpRte_SF", 28, sunssf, ""),
+	value("ECPNomHz_SF", 29, sunssf, ""),
+}
+
+var measurementsPoints = []point{
+	value("PVConn", 0, bitfield16, ""),
+	value("StorConn", 1, bitfield16, ""),
+	value("ECPConn", 2, bitfield16, ""),
+	value("ActWh", 3, acc64T, ""),
+	value("ActVAh", 7, acc64T, ""),
+	value("ActVArhQ1", 11, acc64T, ""),
+	value("ActVArhQ2", 15, acc64T, ""),
+	value("ActVArhQ3", 19, acc64T, ""),
+	value("ActVArhQ4", 23, acc64T, ""),
+	value("VArAval", 27, int16T, "VArAval_SF"),
+	value("VArAval_SF", 28, sunssf, ""),
+	value("WAval", 29, uint16T, "WAval_SF"),
+	value("WAval_SF", 30, sunssf, ""),
+	value("StSetLimMsk", 31, bitfield32, ""),
+	value("StActCtl", 33, bitfield32, ""),
+	text("TmSrc", 35, 4),
+	value("Tms", 39, uint32T, ""),
+	value("RtSt", 41, bitfield16, ""),
+	value("Ris", 42, uint16T, "Ris_SF"),
+	value("Ris_SF", 43, sunssf, ""),
+}
+
+var controlsPoints = []point{
+	setting("Conn_WinTms", 0, uint16T, ""),
+	setting("Conn_RvrtTms", 1, uint16T, ""),
+	setting("Conn", 2, enum16T, ""),
+	setting("WMaxLimPct", 3, uint16T, "WMaxLimPct_SF"),
+	setting("WMaxLimPct_WinTms", 4, uint16T, ""),
+	setting("WMaxLimPct_RvrtTms", 5, uint16T, ""),
+	setting("WMaxLimPct_RmpTms", 6, uint16T, ""),
+	setting("WMaxLim_Ena", 7, enum16T, ""),
+	setting("OutPFSet", 8, int16T, "OutPFSet_SF"),
+	setting("OutPFSet_WinTms", 9, uint16T, ""),
+	setting("OutPFSet_RvrtTms", 10, uint16T, ""),
+	setting("OutPFSet_RmpTms", 11, uint16T, ""),
+	setting("OutPFSet_Ena", 12, enum16T, ""),
+	setting("VArWMaxPct", 13, int16T, "VArPct_SF"),
+	setting("VArMaxPct", 14, int16T, "VArPct_SF"),
+	setting("VArAvalPct", 15, int16T, "VArPct_SF"),
+	setting("VArPct_WinTms", 16, uint16T, ""),
+	setting("VArPct_RvrtTms", 17, uint16T, ""),
+	setting("VArPct_RmpTms", 18, uint16T, ""),
+	setting("VArPct_Mod", 19, enum16T, ""),
+	setting("VArPct_Ena", 20, enum16T, ""),
+	value("WMaxLimPct_SF", 21, sunssf, ""),
+	value("OutPFSet_SF", 22, sunssf, ""),
+	value("VArPct_SF", 23, sunssf, ""),
+}
+
+var storagePoints = []point{
+	setting("WChaMax", 0, uint16T, "WChaMax_SF"),
+	setting("WChaGra", 1, uint16T, "WChaDisChaGra_SF"),
+	setting("WDisChaGra", 2, uint16T, "WChaDisChaGra_SF"),
+	setting("StorCtl_Mod", 3, bitfield16, ""),
+	setting("VAChaMax", 4, uint16T, "VAChaMax_SF"),
+	setting("MinRsvPct", 5, uint16T, "MinRsvPct_SF"),
+	value("ChaState", 6, uint16T, "ChaState_SF"),
+	value("StorAval", 7, uint16T, "StorAval_SF"),
+	value("InBatV", 8, uint16T, "InBatV_SF"),
+	value("ChaSt", 9, enum16T, ""),
+	setting("OutWRte", 10, int16T, "InOutWRte_SF"),
+	setting("InWRte", 11, int16T, "InOutWRte_SF"),
+	setting("InOutWRte_WinTms", 12, uint16T, ""),
+	setting("InOutWRte_RvrtTms", 13, uint16T, ""),
+	setting("InOutWRte_RmpTms", 14, uint16T, ""),
+	setting("ChaGriSet", 15, enum16T, ""),
+	value("WChaMax_SF", 16, sunssf, ""),
+	value("WChaDisChaGra_SF", 17, sunssf, ""),
+	value("VAChaMax_SF", 18, sunssf, ""),
+	value("MinRsvPct_SF", 19, sunssf, ""),
+	value("ChaState_SF", 20, sunssf, ""),
+	value("StorAval_SF", 21, sunssf, ""),
+	value("InBatV_SF", 22, sunssf, ""),
+	value("InOutWRte_SF", 23, sunssf, ""),
+}
+
+// mpptPoints is the fixed block of the multiple MPPT model, it is followed by a
+// repeating mpptModulePoints block for each tracker
+var mpptPoints = []point{
+	value("DCA_SF", 0, sunssf, ""),
+	value("DCV_SF", 1, sunssf, ""),
+	value("DCW_SF", 2, sunssf, ""),
+	value("DCWH_SF", 3, sunssf, ""),
+	value("Evt", 4, bitfield32, ""),
+	value("N", 6, uint16T, ""),
+	value("TmsPer", 7, uint16T, ""),
+}
+
+const (
+	mpptFixedLength  = 8
+	mpptModuleLength = 20
+)
+
+var mpptModulePoints = []point{
+	value("ID", 0, uint16T, ""),
+	text("IDStr", 1, 8),
+	value("DCA", 9, uint16T, "DCA_SF"),
+	value("DCV", 10, uint16T, "DCV_SF"),
+	value("DCW", 11, uint16T, "DCW_SF"),
+	value("DCWH", 12, acc32T, "DCWH_SF"),
+	value("Tms", 14, uint32T, ""),
+	value("Tmp", 16, int16T, ""),
+	value("DCSt", 17, enum16T, ""),
+	value("DCEvt", 18, bitfield32, ""),
+}
+
+var batteryPoints = []point{
+	value("AHRtg", 0, uint16T, "AHRtg_SF"),
+	value("WHRtg", 1, uint16T, "WHRtg_SF"),
+	value("WChaRteMax", 2, uint16T, "WChaDisChaMax_SF"),
+	value("WDisChaRteMax", 3, uint16T, "WChaDisChaMax_SF"),
+	value("DisChaRte", 4, uint16T, "DisChaRte_SF"),
+	value("SoCNpMaxPct", 5, uint16T, "SoC_SF"),
+	value("SoCNpMinPct", 6, uint16T, "SoC_SF"),
+	setting("MaxRsvPct", 7, uint16T, "SoC_SF"),
+	setting("MinRsvPct", 8, uint16T, "SoC_SF"),
+	value("SoC", 9, uint16T, "SoC_SF"),
+	value("DoD", 10, uint16T, "DoD_SF"),
+	value("SoH", 11, uint16T, "SoH_SF"),
+	value("NCyc", 12, acc32T, ""),
+	value("ChaSt", 14, enum16T, ""),
+	value("LocRemCtl", 15, enum16T, ""),
+	value("Hb", 16, uint16T, ""),
+	setting("CtrlHb", 17, uint16T, ""),
+	setting("AlmRst", 18, uint16T, ""),
+	value("Typ", 19, enum16T, ""),
+	value("State", 20, enum16T, ""),
+	value("StateVnd", 21, enum16T, ""),
+	value("WarrDt", 22, uint32T, ""),
+	value("Evt1", 24, bitfield32, ""),
+	value("Evt2", 26, bitfield32, ""),
+	value("EvtVnd1", 28, bitfield32, ""),
+	value("EvtVnd2", 30, bitfield32, ""),
+	value("V", 32, uint16T, "V_SF"),
+	value("VMax", 33, uint16T, "V_SF"),
+	value("VMin", 34, uint16T, "V_SF"),
+	value("CellVMax", 35, uint16T, "CellV_SF"),
+	value("CellVMaxStr", 36, uint16T, ""),
+	value("CellVMaxMod", 37, uint16T, ""),
+	value("CellVMin", 38, uint16T, "CellV_SF"),
+	value("CellVMinStr", 39, uint16T, ""),
+	value("CellVMinMod", 40, uint16T, ""),
+	value("CellVAvg", 41, uint16T, "CellV_SF"),
+	value("A", 42, int16T, "A_SF"),
+	value("AChaMax", 43, uint16T, "AMax_SF"),
+	value("ADisChaMax", 44, uint16T, "AMax_SF"),
+	value("W", 45, int16T, "W_SF"),
+	value("ReqInvState", 46, enum16T, ""),
+	value("ReqW", 47, int16T, "W_SF"),
+	setting("SetOp", 48, enum16T, ""),
+	setting("SetInvState", 49, enum16T, ""),
+	value("AHRtg_SF", 50, sunssf, ""),
+	value("WHRtg_SF", 51, sunssf, ""),
+	value("WChaDisChaMax_SF", 52, sunssf, ""),
+	value("DisChaRte_SF", 53, sunssf, ""),
+	value("SoC_SF", 54, sunssf, ""),
+	value("DoD_SF", 55, sunssf, ""),
+	value("SoH_SF", 56, sunssf, ""),
+	value("V_SF", 57, sunssf, ""),
+	value("CellV_SF", 58, sunssf, ""),
+	value("A_SF", 59, sunssf, ""),
+	value("AMax_SF", 60, sunssf, ""),
+	value("W_SF", 61, sunssf, ""),
+}
+
+// definitions are the fixed points of each supported model
+var definitions = map[uint16][]point{
+	Common:          commonPoints,
+	InverterSingle:  inverterPoints,
+	InverterSplit:   inverterPoints,
+	InverterThree:   inverterPoints,
+	Nameplate:       nameplatePoints,
+	BasicSettings:   settingsPoints,
+	MeasurementsExt: measurementsPoints,
+	Controls:        controlsPoints,
+	Storage:         storagePoints,
+	MPPT:            mpptPoints,
+	Battery:         batteryPoints,
+}
+
+// Name returns the register name of a point of a model, the model ID and point name
+// separated by a dot. Points of the repeating MPPT module block are named by model,
+// module number (from 1) and point, as in "160.2.DCW".
+func Name(model uint16, point string) string {
+	return fmt.Sprintf("%d.%s", model, point)
+}
+
+// Registers returns the registers of the points of the model, addressed from the
+// first data register of the model. Unsupported models have no registers.
+func (m Model) Registers() []modbuscomm.Register {
+	points, ok := definitions[m.ID]
+	if !ok {
+		return nil
+	}
+
+	registers := make([]modbuscomm.Register, 0)
+	add := func(prefix string, base uint16, points []point) {
+		for _, p := range points {
+			if p.offset+sizeOf(p) > m.Length {
+				continue
+			}
+			r := modbuscomm.Register{
+				Name:         prefix + p.name,
+				Address:      base + p.offset,
+				DataType:     p.dataType,
+				FunctionCode: 3,
+				AccessType:   p.access,
+				Length:       p.length,
+			}
+			if p.sf != "" {
+				r.ScaleFactor = Name(m.ID, p.sf)
+			}
+			r.Unimplemented = unimplemented(p.dataType)
+			registers = append(registers, r)
+		}
+	}
+
+	add(Name(m.ID, ""), m.Address, points)
+	if m.ID == MPPT && m.Length > mpptFixedLength {
+		modules := (m.Length - mpptFixedLength) / mpptModuleLength
+		for i := uint16(0); i < modules; i++ {
+			base := m.Address + mpptFixedLength + i*mpptModuleLength
+			add(fmt.Sprintf("%d.%d.", m.ID, i+1), base, mpptModulePoints)
+		}
+	}
+	return registers
+}
+
+// unimplemented returns the raw value a device reads from a point of the data type that
+// it does not implement. Enumerations, bitfields and acc32 read the value of their
+// integer type. An acc64 reads 0, which is also a count, so it has none, nor has a
+// string.
+func unimplemented(dataType modbuscomm.DataType) *float64 {
+	var val float64
+	switch dataType {
+	case uint16T:
+		val = 0xFFFF
+	case int16T:
+		val = -0x8000
+	case uint32T:
+		val = 0xFFFFFFFF
+	case int32T:
+		val = -0x80000000
+	default:
+		return nil
+	}
+	return &val
+}
+
+// sizeOf returns the number of registers of the point
+func sizeOf(p point) uint16 {
+	switch p.dataType {
+	case stringT:
+		return p.length
+	case uint32T, int32T:
+		return 2
+	case acc64T:
+		return 4
+	}
+	return 1
+}
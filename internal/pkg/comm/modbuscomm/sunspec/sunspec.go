@@ -0,0 +1,285 @@
+// Package sunspec discovers and reads SunSpec devices over modbuscomm. A SunSpec
+// device maps a chain of models into its holding registers, starting after the "SunS"
+// marker at one of the well known base addresses.
+package sunspec
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/ohowland/cgc_core/internal/pkg/asset"
+	"github.com/ohowland/cgc_core/internal/pkg/comm/modbuscomm"
+)
+
+// BaseAddresses are the addresses scanned for the SunS marker, in order
+var BaseAddresses = []uint16{40000, 50000, 0}
+
+// endModel is the model ID that terminates the model chain
+const endModel uint16 = 0xFFFF
+
+// Model is a SunSpec model found in the model chain of a device. Address is the first
+// data register of the model, following the ID and length header.
+type Model struct {
+	ID      uint16
+	Address uint16
+	Length  uint16
+}
+
+// Discover scans the base addresses for the SunS marker and returns the model chain
+// that follows it. BaseAddresses are scanned when no base address is given.
+func Discover(comm modbuscomm.ModbusComm, bases ...uint16) ([]Model, error) {
+	if len(bases) == 0 {
+		bases = BaseAddresses
+	}
+
+	var err error
+	for _, base := range bases {
+		marker := modbuscomm.Register{Name: "SunS", Address: base, DataType: stringT, FunctionCode: 3, Length: 2}
+		points, readErr := read(comm, []modbuscomm.Register{marker})
+		if readErr != nil {
+			err = readErr
+			continue
+		}
+		if p := points["SunS"]; p.Quality != asset.Good || p.Text != "SunS" {
+			continue
+		}
+		return walk(comm, base+2)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+	msg := fmt.Sprintf("sunspec: no SunS marker found at %v", bases)
+	return nil, errors.New(msg)
+}
+
+// walk reads the model headers from the address until the end of the model chain
+func walk(comm modbuscomm.ModbusComm, address uint16) ([]Model, error) {
+	models := make([]Model, 0)
+	for {
+		header := []modbuscomm.Register{
+			{Name: "ID", Address: address, DataType: uint16T, FunctionCode: 3},
+			{Name: "L", Address: address + 1, DataType: uint16T, FunctionCode: 3},
+		}
+		points, err := read(comm, header)
+		if err != nil {
+			return nil, err
+		}
+
+		id := uint16(points["ID"].Value)
+		if id == endModel {
+			return models, nil
+		}
+		length := uint16(points["L"].Value)
+		if int(address)+2+int(length) > 0xFFFF {
+			err := fmt.Sprintf("sunspec: model %v at %v overruns the register space", id, address)
+			return nil, errors.New(err)
+		}
+		models = append(models, Model{ID: id, Address: address + 2, Length: length})
+		address += 2 + length
+	}
+}
+
+// read polls the registers and returns the points by register name. A register that
+// could not be read is an error.
+func read(comm modbuscomm.ModbusComm, registers []modbuscomm.Register) (map[string]modbuscomm.Point, error) {
+	resp, err := comm.Read(registers)
+	if err != nil {
+		return nil, err
+	}
+	points := make(map[string]modbuscomm.Point)
+	if err := json.Unmarshal(resp, &points); err != nil {
+		return nil, err
+	}
+	for _, register := range registers {
+		if points[register.Name].Quality != asset.Good {
+			err := fmt.Sprintf("sunspec: register %v at %v not read", register.Name, register.Address)
+			return nil, errors.New(err)
+		}
+	}
+	return points, nil
+}
+
+// Device is a SunSpec device. The model chain is discovered on the first read or
+// write, and again after a failed discovery.
+type Device struct {
+	mux       *sync.Mutex
+	comm      modbuscomm.ModbusComm
+	bases     []uint16
+	models    []Model
+	registers map[string]modbuscomm.Register
+	wMax      float64
+}
+
+// Config is the SunSpec section of an asset configuration. BaseAddress overrides the
+// addresses scanned for the SunS marker.
+type Config struct {
+	Poller      modbuscomm.PollerConfig `json:"Poller"`
+	BaseAddress []uint16                `json:"BaseAddress"`
+}
+
+// New returns a Device configured from the SunSpec section of the asset json
+// configuration.
+func New(jsonConfig []byte) (*Device, error) {
+	wrapper := struct {
+		SunSpec *Config `json:"SunSpec"`
+	}{}
+	if err := json.Unmarshal(jsonConfig, &wrapper); err != nil {
+		return nil, err
+	}
+	if wrapper.SunSpec == nil {
+		return nil, errors.New("sunspec: asset configuration has no SunSpec section")
+	}
+
+	poller, err := modbuscomm.NewPoller(wrapper.SunSpec.Poller)
+	if err != nil {
+		return nil, err
+	}
+	return NewDevice(poller, wrapper.SunSpec.BaseAddress...), nil
+}
+
+// NewDevice returns a Device communicating over comm. The base addresses are scanned
+// for the SunS marker, or BaseAddresses when none are given.
+func NewDevice(comm modbuscomm.ModbusComm, bases ...uint16) *Device {
+	return &Device{
+		mux:   &sync.Mutex{},
+		comm:  comm,
+		bases: bases,
+	}
+}
+
+// discover walks the model chain when it has not been discovered
+func (d *Device) discover() error {
+	if d.registers != nil {
+		return nil
+	}
+
+	models, err := Discover(d.comm, d.bases...)
+	if err != nil {
+		return err
+	}
+
+	registers := make(map[string]modbuscomm.Register)
+	found := make(map[uint16]bool)
+	for _, model := range models {
+		// the first instance of a repeated model is used
+		if found[model.ID] {
+			continue
+		}
+		found[model.ID] = true
+		for _, register := range model.Registers() {
+			registers[register.Name] = register
+		}
+	}
+	d.models = models
+	d.registers = registers
+	return nil
+}
+
+// Models returns the model chain of the device
+func (d *Device) Models() ([]Model, error) {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	if err := d.discover(); err != nil {
+		return nil, err
+	}
+	return d.models, nil
+}
+
+// Has returns true when the model is in the model chain of the device. The chain is
+// discovered if needed, a failed discovery has no models.
+func (d *Device) Has(id uint16) bool {
+	models, err := d.Models()
+	if err != nil {
+		return false
+	}
+	for _, model := range models {
+		if model.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// Read polls the named points, and the scale factors they depend on, and returns the
+// points by name. Points of models the device does not have are left out of the
+// response. An error is returned when the model chain cannot be discovered, read
+// failures are reported through the quality of each point. A point the device does
+// not implement, or scaled by a scale factor it does not implement, is out-of-range.
+func (d *Device) Read(names ...string) (map[string]modbuscomm.Point, error) {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+
+	if err := d.discover(); err != nil {
+		return nil, err
+	}
+
+	registers := make([]modbuscomm.Register, 0, len(names))
+	added := make(map[string]bool)
+	add := func(name string) {
+		register, ok := d.registers[name]
+		if !ok || added[name] {
+			return
+		}
+		registers = append(registers, register)
+		added[name] = true
+	}
+	for _, name := range names {
+		add(name)
+		if sf := d.registers[name].ScaleFactor; sf != "" {
+			add(sf)
+		}
+	}
+
+	points := make(map[string]modbuscomm.Point)
+	if len(registers) == 0 {
+		return points, nil
+	}
+	resp, _ := d.comm.Read(registers)
+	if err := json.Unmarshal(resp, &points); err != nil {
+		return nil, err
+	}
+	return points, nil
+}
+
+// Write writes the values of the named points. A scaled point uses the scale factor
+// of the last read of the point, and its scale factor is read when it has not been.
+func (d *Device) Write(values map[string]float64) error {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+
+	if err := d.discover(); err != nil {
+		return err
+	}
+
+	registers := make([]modbuscomm.Register, 0, len(values))
+	for name := range values {
+		register, ok := d.registers[name]
+		if !ok {
+			err := fmt.Sprintf("sunspec: device has no point %v", name)
+			return errors.New(err)
+		}
+		registers = append(registers, register)
+	}
+	// the scale factors are read by the poller if they have not been
+	for name := range values {
+		if sf, ok := d.registers[d.registers[name].ScaleFactor]; ok {
+			registers = append(registers, sf)
+		}
+	}
+
+	jsonValues, err := json.Marshal(values)
+	if err != nil {
+		return err
+	}
+	return d.comm.Write(registers, jsonValues)
+}
+
+// Close closes the connection to the device
+func (d *Device) Close() error {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	return d.comm.Close()
+}
@@ -0,0 +1,214 @@
+package sunspec
+
+import (
+	"testing"
+
+	"github.com/ohowland/cgc_core/internal/pkg/asset"
+	"github.com/ohowland/cgc_core/internal/pkg/comm/modbuscomm"
+	"github.com/ohowland/cgc_core/internal/pkg/comm/modbuscomm/sunspec/sunspectest"
+	"gotest.tools/assert"
+)
+
+// newTestDevice returns a Device served the register image, and a function that
+// closes the device and server
+func newTestDevice(t *testing.T, imagePath string) (*Device, func()) {
+	server, err := sunspectest.NewServer(imagePath)
+	assert.NilError(t, err)
+	poller, err := modbuscomm.NewPoller(server.Config)
+	assert.NilError(t, err)
+	d := NewDevice(poller)
+	return d, func() {
+		d.Close()
+		server.Close()
+	}
+}
+
+func modelIDs(models []Model) []uint16 {
+	ids := make([]uint16, 0, len(models))
+	for _, model := range models {
+		ids = append(ids, model.ID)
+	}
+	return ids
+}
+
+func TestDiscover(t *testing.T) {
+	server, err := sunspectest.NewServer(sunspectest.Image("pv"))
+	assert.NilError(t, err)
+	defer server.Close()
+	poller, err := modbuscomm.NewPoller(server.Config)
+	assert.NilError(t, err)
+	defer poller.Close()
+
+	models, err := Discover(poller)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, modelIDs(models), []uint16{1, 103, 120, 121, 123, 160})
+	assert.Equal(t, models[0], Model{ID: Common, Address: 40004, Length: 66})
+	assert.Equal(t, models[1], Model{ID: InverterThree, Address: 40072, Length: 50})
+}
+
+func TestDiscoverSecondBase(t *testing.T) {
+	server, err := sunspectest.NewServer(sunspectest.Image("ess"))
+	assert.NilError(t, err)
+	defer server.Close()
+	poller, err := modbuscomm.NewPoller(server.Config)
+	assert.NilError(t, err)
+	defer poller.Close()
+
+	models, err := Discover(poller)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, modelIDs(models), []uint16{1, 103, 120, 121, 123, 124, 802})
+	assert.Equal(t, models[0].Address, uint16(50004))
+}
+
+func TestDiscoverNoMarker(t *testing.T) {
+	server, err := sunspectest.NewServer("")
+	assert.NilError(t, err)
+	defer server.Close()
+	poller, err := modbuscomm.NewPoller(server.Config)
+	assert.NilError(t, err)
+	defer poller.Close()
+
+	_, err = Discover(poller)
+	assert.Error(t, err, "sunspec: no SunS marker found at [40000 50000 0]")
+}
+
+func TestModelRegisters(t *testing.T) {
+	registers := Model{ID: MPPT, Address: 100, Length: 48}.Registers()
+	byName := make(map[string]modbuscomm.Register)
+	for _, register := range registers {
+		byName[register.Name] = register
+	}
+	assert.Equal(t, len(registers), len(mpptPoints)+2*len(mpptModulePoints))
+	assert.Equal(t, byName["160.N"].Address, uint16(106))
+	assert.Equal(t, byName["160.2.DCW"].Address, uint16(139))
+	assert.Equal(t, byName["160.2.DCW"].ScaleFactor, "160.DCW_SF")
+
+	assert.Assert(t, Model{ID: 64001, Address: 100, Length: 10}.Registers() == nil)
+}
+
+func TestRead(t *testing.T) {
+	d, closeDevice := newTestDevice(t, sunspectest.Image("pv"))
+	defer closeDevice()
+
+	points, err := d.Read("1.Mn", "1.SN", "103.W", "103.Hz", "160.2.DCW", "124.ChaState")
+	assert.NilError(t, err)
+	assert.Equal(t, points["1.Mn"].Text, "CGC Solar")
+	assert.Equal(t, points["1.SN"].Text, "PV0001")
+	assert.Equal(t, points["103.W"].Value, 12500.0)
+	assert.Equal(t, points["103.Hz"].Value, 60.01)
+	assert.Equal(t, points["160.2.DCW"].Value, 6500.0)
+	_, ok := points["124.ChaState"]
+	assert.Assert(t, !ok, "the device has no storage model")
+	assert.Assert(t, d.Has(MPPT))
+	assert.Assert(t, !d.Has(Storage))
+}
+
+func TestReadInverter(t *testing.T) {
+	d, closeDevice := newTestDevice(t, sunspectest.Image("pv"))
+	defer closeDevice()
+
+	inverter, _, err := d.ReadInverter()
+	assert.NilError(t, err)
+	assert.Equal(t, inverter, Inverter{
+		KW:      12.5,
+		KVAR:    -0.15,
+		Hz:      60.01,
+		Volts:   277,
+		KWMax:   20,
+		Online:  true,
+		Quality: asset.Good,
+	})
+}
+
+func TestReadUnimplemented(t *testing.T) {
+	server, err := sunspectest.NewServer(sunspectest.Image("pv"))
+	assert.NilError(t, err)
+	defer server.Close()
+	poller, err := modbuscomm.NewPoller(server.Config)
+	assert.NilError(t, err)
+	d := NewDevice(poller)
+	defer d.Close()
+
+	// the data of the inverter model starts at 40072
+	server.Set(40072+5, 0xFFFF)          // uint16 PPVphAB
+	server.Set(40072+12, 0x8000)         // int16 W
+	server.Set(40072+15, 0x8000)         // sunssf Hz_SF
+	server.Set(40072+22, 0xFFFF, 0xFFFF) // acc32 WH
+	server.Set(40072+36, 0xFFFF)         // enum16 St
+
+	points, err := d.Read("103.PPVphAB", "103.W", "103.Hz", "103.WH", "103.St", "103.PhVphA")
+	assert.NilError(t, err)
+	for _, name := range []string{"103.PPVphAB", "103.W", "103.Hz", "103.Hz_SF", "103.WH", "103.St"} {
+		assert.Equal(t, points[name].Quality, asset.OutOfRange, name)
+	}
+	assert.Equal(t, points["103.PhVphA"].Quality, asset.Good)
+	assert.Equal(t, points["103.PhVphA"].Value, 277.0)
+
+	inverter, _, err := d.ReadInverter()
+	assert.NilError(t, err)
+	assert.Equal(t, inverter.Quality, asset.OutOfRange)
+}
+
+func TestReadInverterWithoutVAr(t *testing.T) {
+	server, err := sunspectest.NewServer(sunspectest.Image("pv"))
+	assert.NilError(t, err)
+	defer server.Close()
+	poller, err := modbuscomm.NewPoller(server.Config)
+	assert.NilError(t, err)
+	d := NewDevice(poller)
+	defer d.Close()
+
+	server.Set(40072+18, 0x8000) // int16 VAr
+
+	inverter, _, err := d.ReadInverter()
+	assert.NilError(t, err)
+	assert.Equal(t, inverter.KVAR, 0.0)
+	assert.Equal(t, inverter.Quality, asset.Good, "reactive power is optional")
+}
+
+func TestWriteInverter(t *testing.T) {
+	d, closeDevice := newTestDevice(t, sunspectest.Image("pv"))
+	defer closeDevice()
+
+	assert.DeepEqual(t, d.InverterControls(true, 10, 2), map[string]float64{"123.Conn": 1})
+
+	_, _, err := d.ReadInverter()
+	assert.NilError(t, err)
+	err = d.WriteInverter(false, 10, -2)
+	assert.NilError(t, err)
+
+	points, err := d.Read("123.Conn", "123.WMaxLimPct", "123.WMaxLim_Ena", "123.VArWMaxPct", "123.VArPct_Ena")
+	assert.NilError(t, err)
+	assert.Equal(t, points["123.Conn"].Value, 0.0)
+	assert.Equal(t, points["123.WMaxLimPct"].Value, 50.0)
+	assert.Equal(t, points["123.WMaxLim_Ena"].Value, 1.0)
+	assert.Equal(t, points["123.VArWMaxPct"].Value, -10.0)
+	assert.Equal(t, points["123.VArPct_Ena"].Value, 1.0)
+}
+
+func TestWriteScaledUnread(t *testing.T) {
+	d, closeDevice := newTestDevice(t, sunspectest.Image("pv"))
+	defer closeDevice()
+
+	assert.NilError(t, d.Write(map[string]float64{"121.WMax": 15000}))
+	points, err := d.Read("121.WMax")
+	assert.NilError(t, err)
+	assert.Equal(t, points["121.WMax"].Value, 15000.0)
+}
+
+func TestWriteUnknownPoint(t *testing.T) {
+	d, closeDevice := newTestDevice(t, sunspectest.Image("pv"))
+	defer closeDevice()
+
+	err := d.Write(map[string]float64{"124.InWRte": 10})
+	assert.Error(t, err, "sunspec: device has no point 124.InWRte")
+}
+
+func TestNew(t *testing.T) {
+	_, err := New([]byte(`{"Name": "test"}`))
+	assert.Error(t, err, "sunspec: asset configuration has no SunSpec section")
+
+	d, err := New([]byte(`{"SunSpec": {"Poller": {"IPAddr": "127.0.0.1", "Port": "502"}, "BaseAddress": [0]}}`))
+	assert.NilError(t, err)
+	assert.DeepEqual(t, d.bases, []uint16{0})
+}
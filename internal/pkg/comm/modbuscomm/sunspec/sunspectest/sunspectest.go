@@ -0,0 +1,85 @@
+// Package sunspectest serves recorded SunSpec register images over Modbus TCP for
+// tests of SunSpec devices.
+package sunspectest
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"runtime"
+	"strconv"
+
+	"github.com/ohowland/cgc_core/internal/pkg/comm/modbuscomm"
+	"github.com/tbrandon/mbserver"
+)
+
+// Server is an in-process Modbus TCP server holding a register image in its holding
+// registers. Config is the poller configuration that reaches the server.
+type Server struct {
+	server *mbserver.Server
+	Config modbuscomm.PollerConfig
+}
+
+// Image returns the path of a recorded register image of this package, "pv" for a
+// three phase PV inverter at base address 40000 or "ess" for a storage inverter with a
+// battery model at base address 50000.
+func Image(name string) string {
+	_, file, _, _ := runtime.Caller(0)
+	return filepath.Join(filepath.Dir(file), name+".json")
+}
+
+// NewServer starts a server on a free local port loaded with the register image at
+// imagePath, a JSON object of register words keyed by start address. An empty path
+// serves zeroed registers.
+func NewServer(imagePath string) (*Server, error) {
+	server := mbserver.NewServer()
+	if imagePath != "" {
+		jsonImage, err := ioutil.ReadFile(imagePath)
+		if err != nil {
+			return nil, err
+		}
+		image := make(map[string][]uint16)
+		if err := json.Unmarshal(jsonImage, &image); err != nil {
+			return nil, err
+		}
+		for start, words := range image {
+			address, err := strconv.ParseUint(start, 10, 16)
+			if err != nil {
+				return nil, err
+			}
+			copy(server.HoldingRegisters[address:], words)
+		}
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	addr := l.Addr().(*net.TCPAddr)
+	l.Close()
+
+	if err := server.ListenTCP(addr.String()); err != nil {
+		return nil, err
+	}
+
+	return &Server{
+		server: server,
+		Config: modbuscomm.PollerConfig{
+			IPAddr:  addr.IP.String(),
+			Port:    strconv.Itoa(addr.Port),
+			SlaveID: 1,
+			Timeout: 1000,
+		},
+	}, nil
+}
+
+// Set writes the words to the holding registers from the address
+func (s *Server) Set(address uint16, words ...uint16) {
+	copy(s.server.HoldingRegisters[address:], words)
+}
+
+// Close stops the server
+func (s *Server) Close() {
+	s.server.Close()
+}
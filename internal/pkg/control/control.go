@@ -0,0 +1,235 @@
+/*
+control.go Arbitration of control ownership. Assets and buses accept control channels
+from several sources (the parent bus, the dispatcher and operators), and an Arbiter
+decides which one of them is allowed to write to the device at any instant.
+*/
+
+package control
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/google/uuid"
+	"github.com/ohowland/cgc_core/internal/pkg/msg"
+)
+
+// Priority ranks the sources of control. A request of higher priority preempts
+// the current owner, a request of lower priority is denied.
+type Priority int
+
+const (
+	// Bus is the priority of a parent bus controlling its members
+	Bus Priority = iota + 1
+	// Dispatch is the priority of the system dispatcher
+	Dispatch
+	// Operator is the priority of a manual operator override
+	Operator
+)
+
+func (p Priority) String() string {
+	switch p {
+	case Bus:
+		return "Bus"
+	case Dispatch:
+		return "Dispatch"
+	case Operator:
+		return "Operator"
+	default:
+		return fmt.Sprintf("Priority(%d)", int(p))
+	}
+}
+
+// ErrDenied is returned when control is held at an equal or higher priority than
+// requested
+var ErrDenied = errors.New("control denied: held at an equal or higher priority")
+
+// Handler acts on a control message received from the owner
+type Handler func(msg.Msg)
+
+// Arbiter tracks the outstanding control requests for a single controllable node.
+// A request controls either the whole node or a single target, such as one member of
+// a bus. The owner of a target is the request of the highest priority that controls
+// the node or the target. Only messages from the owner of their target reach the
+// Handler; messages from displaced requests are discarded until the owner releases
+// control and the displaced request becomes the owner again.
+type Arbiter struct {
+	mux      sync.Mutex
+	writeMux sync.Mutex
+	handler  Handler
+	requests []*request
+}
+
+type request struct {
+	pid      uuid.UUID
+	priority Priority
+	target   uuid.UUID
+	stop     chan struct{}
+}
+
+// controls reports whether the request controls messages to the target
+func (r *request) controls(target uuid.UUID) bool {
+	return r.target == (uuid.UUID{}) || r.target == target
+}
+
+// overlaps reports whether the request controls any target the other controls
+func (r *request) overlaps(target uuid.UUID) bool {
+	return r.controls(target) || target == (uuid.UUID{})
+}
+
+// NewArbiter returns an Arbiter that passes the owner's messages to handler
+func NewArbiter(handler Handler) *Arbiter {
+	return &Arbiter{handler: handler, requests: make([]*request, 0)}
+}
+
+// Request asks for control of the node at the given priority. Messages received on ch
+// are handled while pid is the owner of their target. Control is denied while another
+// request of equal or higher priority controls any part of the node. A second request
+// from the same pid replaces the first.
+func (a *Arbiter) Request(pid uuid.UUID, priority Priority, ch <-chan msg.Msg) error {
+	return a.RequestTarget(pid, priority, uuid.UUID{}, ch)
+}
+
+// RequestTarget asks for control of the messages addressed to the target. Requests
+// for different targets do not compete, so an operator overriding one target leaves
+// the others to the dispatcher.
+func (a *Arbiter) RequestTarget(pid uuid.UUID, priority Priority, target uuid.UUID, ch <-chan msg.Msg) error {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+
+	for _, req := range a.requests {
+		if req.pid != pid && req.overlaps(target) && req.priority >= priority {
+			return ErrDenied
+		}
+	}
+
+	a.remove(pid, target)
+	r := &request{pid, priority, target, make(chan struct{})}
+	a.requests = append(a.requests, r)
+	go a.serve(r, ch)
+
+	return nil
+}
+
+// Release gives up the control requests held by pid. Control passes to the next
+// outstanding request. The caller must stop sending on its control channels.
+func (a *Arbiter) Release(pid uuid.UUID) error {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+
+	released := false
+	for _, req := range a.held(pid) {
+		released = a.remove(pid, req.target) || released
+	}
+	if !released {
+		err := fmt.Sprintf("%v does not hold a control request", pid)
+		return errors.New(err)
+	}
+	return nil
+}
+
+// Owner returns the pid and priority of the owner of the node as a whole. The zero
+// values are returned when there is no owner.
+func (a *Arbiter) Owner() (uuid.UUID, Priority) {
+	return a.OwnerOf(uuid.UUID{})
+}
+
+// OwnerOf returns the pid and priority of the owner of the target
+func (a *Arbiter) OwnerOf(target uuid.UUID) (uuid.UUID, Priority) {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+
+	owner := a.owner(target)
+	if owner == nil {
+		return uuid.UUID{}, 0
+	}
+	return owner.pid, owner.priority
+}
+
+// Exclusive calls f while no control message is being handled, for a write made to
+// the node outside arbitration, such as a fail-safe control
+func (a *Arbiter) Exclusive(f func()) {
+	a.writeMux.Lock()
+	defer a.writeMux.Unlock()
+	f()
+}
+
+func (a *Arbiter) serve(r *request, ch <-chan msg.Msg) {
+	for {
+		select {
+		case m, ok := <-ch:
+			if !ok {
+				a.drop(r)
+				return
+			}
+			a.handle(r, m)
+		case <-r.stop:
+			return
+		}
+	}
+}
+
+// handle serializes writes so that an in-flight message from a displaced owner
+// completes before the new owner's first message is handled.
+func (a *Arbiter) handle(r *request, m msg.Msg) {
+	a.writeMux.Lock()
+	defer a.writeMux.Unlock()
+
+	a.mux.Lock()
+	isOwner := a.owner(m.Target()) == r
+	a.mux.Unlock()
+
+	if isOwner {
+		a.handler(m)
+	}
+}
+
+// drop removes a request whose control channel has closed
+func (a *Arbiter) drop(r *request) {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+
+	for i, req := range a.requests {
+		if req == r {
+			a.requests = append(a.requests[:i], a.requests[i+1:]...)
+			return
+		}
+	}
+}
+
+// held returns the requests held by pid
+func (a *Arbiter) held(pid uuid.UUID) []*request {
+	held := make([]*request, 0)
+	for _, req := range a.requests {
+		if req.pid == pid {
+			held = append(held, req)
+		}
+	}
+	return held
+}
+
+// remove stops and deletes the request held by pid for the target, and reports
+// whether one existed
+func (a *Arbiter) remove(pid uuid.UUID, target uuid.UUID) bool {
+	for i, req := range a.requests {
+		if req.pid == pid && req.target == target {
+			close(req.stop)
+			a.requests = append(a.requests[:i], a.requests[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// owner returns the request of the highest priority that controls the target. The
+// zero target is controlled only by requests for the whole node.
+func (a *Arbiter) owner(target uuid.UUID) *request {
+	var owner *request
+	for _, req := range a.requests {
+		if req.controls(target) && (owner == nil || req.priority >= owner.priority) {
+			owner = req
+		}
+	}
+	return owner
+}
@@ -0,0 +1,220 @@
+package control
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/ohowland/cgc_core/internal/pkg/msg"
+	"gotest.tools/assert"
+)
+
+const timeout = 50 * time.Millisecond
+
+type recorder struct {
+	mux      sync.Mutex
+	received []msg.Msg
+}
+
+func (r *recorder) handle(m msg.Msg) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	r.received = append(r.received, m)
+}
+
+func (r *recorder) last() msg.Msg {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	if len(r.received) == 0 {
+		return msg.Msg{}
+	}
+	return r.received[len(r.received)-1]
+}
+
+func (r *recorder) count() int {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	return len(r.received)
+}
+
+func TestRequestControl(t *testing.T) {
+	r := &recorder{}
+	a := NewArbiter(r.handle)
+
+	pid, _ := uuid.NewUUID()
+	ch := make(chan msg.Msg)
+	err := a.Request(pid, Dispatch, ch)
+	assert.NilError(t, err)
+
+	owner, priority := a.Owner()
+	assert.Equal(t, owner, pid)
+	assert.Equal(t, priority, Dispatch)
+
+	m := msg.New(pid, msg.Control, 1)
+	ch <- m
+	time.Sleep(timeout)
+	assert.Equal(t, r.last(), m)
+}
+
+func TestDenyLowerPriority(t *testing.T) {
+	a := NewArbiter(func(msg.Msg) {})
+
+	operator, _ := uuid.NewUUID()
+	err := a.Request(operator, Operator, make(chan msg.Msg))
+	assert.NilError(t, err)
+
+	dispatch, _ := uuid.NewUUID()
+	err = a.Request(dispatch, Dispatch, make(chan msg.Msg))
+	assert.Equal(t, err, ErrDenied)
+
+	owner, _ := a.Owner()
+	assert.Equal(t, owner, operator)
+}
+
+func TestPreemptAndRelease(t *testing.T) {
+	r := &recorder{}
+	a := NewArbiter(r.handle)
+
+	dispatch, _ := uuid.NewUUID()
+	dispatchCh := make(chan msg.Msg)
+	a.Request(dispatch, Dispatch, dispatchCh)
+
+	operator, _ := uuid.NewUUID()
+	operatorCh := make(chan msg.Msg)
+	err := a.Request(operator, Operator, operatorCh)
+	assert.NilError(t, err)
+
+	// the displaced owner is not blocked, but its messages do not reach the handler
+	dispatchCh <- msg.New(dispatch, msg.Control, 1)
+	time.Sleep(timeout)
+	assert.Equal(t, r.count(), 0)
+
+	m := msg.New(operator, msg.Control, 2)
+	operatorCh <- m
+	time.Sleep(timeout)
+	assert.Equal(t, r.last(), m)
+
+	err = a.Release(operator)
+	assert.NilError(t, err)
+
+	owner, _ := a.Owner()
+	assert.Equal(t, owner, dispatch)
+
+	m = msg.New(dispatch, msg.Control, 3)
+	dispatchCh <- m
+	time.Sleep(timeout)
+	assert.Equal(t, r.last(), m)
+}
+
+func TestDenyEqualPriority(t *testing.T) {
+	a := NewArbiter(func(msg.Msg) {})
+
+	first, _ := uuid.NewUUID()
+	err := a.Request(first, Operator, make(chan msg.Msg))
+	assert.NilError(t, err)
+
+	second, _ := uuid.NewUUID()
+	err = a.Request(second, Operator, make(chan msg.Msg))
+	assert.Equal(t, err, ErrDenied)
+
+	owner, _ := a.Owner()
+	assert.Equal(t, owner, first)
+}
+
+func TestRequestTarget(t *testing.T) {
+	r := &recorder{}
+	a := NewArbiter(r.handle)
+
+	x, _ := uuid.NewUUID()
+	y, _ := uuid.NewUUID()
+
+	dispatch, _ := uuid.NewUUID()
+	dispatchCh := make(chan msg.Msg)
+	a.Request(dispatch, Dispatch, dispatchCh)
+
+	operator, _ := uuid.NewUUID()
+	operatorCh := make(chan msg.Msg)
+	err := a.RequestTarget(operator, Operator, x, operatorCh)
+	assert.NilError(t, err)
+
+	owner, _ := a.OwnerOf(x)
+	assert.Equal(t, owner, operator)
+	owner, _ = a.OwnerOf(y)
+	assert.Equal(t, owner, dispatch)
+	owner, _ = a.Owner()
+	assert.Equal(t, owner, dispatch)
+
+	// the dispatcher keeps control of the targets that are not overridden
+	dispatchCh <- msg.New(dispatch, msg.Control, 1).WithTarget(x)
+	m := msg.New(dispatch, msg.Control, 2).WithTarget(y)
+	dispatchCh <- m
+	time.Sleep(timeout)
+	assert.Equal(t, r.count(), 1)
+	assert.Equal(t, r.last(), m)
+
+	// messages outside the target of the request are discarded
+	operatorCh <- msg.New(operator, msg.Control, 3).WithTarget(y)
+	m = msg.New(operator, msg.Control, 4).WithTarget(x)
+	operatorCh <- m
+	time.Sleep(timeout)
+	assert.Equal(t, r.count(), 2)
+	assert.Equal(t, r.last(), m)
+
+	// a competing operator is denied the same target, but not another
+	other, _ := uuid.NewUUID()
+	err = a.RequestTarget(other, Operator, x, make(chan msg.Msg))
+	assert.Equal(t, err, ErrDenied)
+	err = a.RequestTarget(other, Operator, y, make(chan msg.Msg))
+	assert.NilError(t, err)
+
+	assert.NilError(t, a.Release(operator))
+	owner, _ = a.OwnerOf(x)
+	assert.Equal(t, owner, dispatch)
+}
+
+func TestRerequestReplacesChannel(t *testing.T) {
+	r := &recorder{}
+	a := NewArbiter(r.handle)
+
+	pid, _ := uuid.NewUUID()
+	oldCh := make(chan msg.Msg, 1)
+	a.Request(pid, Bus, oldCh)
+
+	newCh := make(chan msg.Msg)
+	a.Request(pid, Dispatch, newCh)
+
+	oldCh <- msg.New(pid, msg.Control, 1)
+	time.Sleep(timeout)
+	assert.Equal(t, r.count(), 0)
+
+	m := msg.New(pid, msg.Control, 2)
+	newCh <- m
+	time.Sleep(timeout)
+	assert.Equal(t, r.last(), m)
+}
+
+func TestClosedChannelDropsRequest(t *testing.T) {
+	a := NewArbiter(func(msg.Msg) {})
+
+	bus, _ := uuid.NewUUID()
+	a.Request(bus, Bus, make(chan msg.Msg))
+
+	operator, _ := uuid.NewUUID()
+	ch := make(chan msg.Msg)
+	a.Request(operator, Operator, ch)
+
+	close(ch)
+	time.Sleep(timeout)
+
+	owner, _ := a.Owner()
+	assert.Equal(t, owner, bus)
+}
+
+func TestReleaseUnknown(t *testing.T) {
+	a := NewArbiter(func(msg.Msg) {})
+
+	pid, _ := uuid.NewUUID()
+	err := a.Release(pid)
+	assert.Assert(t, err != nil)
+}
@@ -0,0 +1,109 @@
+package control
+
+import (
+	"sync"
+	"time"
+)
+
+// Watchdog detects the loss of supervisory control. Each control message kicks the
+// watchdog; if no kick arrives within the timeout, expire is called once. The next
+// kick after an expiry calls recover. The watchdog is armed by the first kick, and
+// a timeout of zero disables it.
+type Watchdog struct {
+	mux       sync.Mutex
+	timeout   time.Duration
+	timer     *time.Timer
+	kicks     uint64
+	expired   bool
+	stopped   bool
+	onExpire  func()
+	onRecover func()
+	exclusive func(func())
+}
+
+// NewWatchdog returns a disarmed Watchdog
+func NewWatchdog(timeout time.Duration, onExpire func(), onRecover func()) *Watchdog {
+	return &Watchdog{
+		timeout:   timeout,
+		onExpire:  onExpire,
+		onRecover: onRecover,
+	}
+}
+
+// Kick restarts the watchdog timeout
+func (w *Watchdog) Kick() {
+	w.mux.Lock()
+	if w.timeout <= 0 || w.stopped {
+		w.mux.Unlock()
+		return
+	}
+
+	if w.timer != nil {
+		w.timer.Stop()
+	}
+	w.kicks++
+	kick := w.kicks
+	w.timer = time.AfterFunc(w.timeout, func() { w.expire(kick) })
+
+	recovered := w.expired
+	w.expired = false
+	w.mux.Unlock()
+
+	if recovered && w.onRecover != nil {
+		w.onRecover()
+	}
+}
+
+// SerializeWith runs each expiry inside exclusive, such as Arbiter.Exclusive, so that
+// the expiry does not interleave with a control write. A kick made by a control write
+// that completes while the expiry waits cancels the expiry.
+func (w *Watchdog) SerializeWith(exclusive func(func())) {
+	w.mux.Lock()
+	defer w.mux.Unlock()
+	w.exclusive = exclusive
+}
+
+// Expired reports whether the watchdog has timed out without a subsequent kick
+func (w *Watchdog) Expired() bool {
+	w.mux.Lock()
+	defer w.mux.Unlock()
+	return w.expired
+}
+
+// Stop disarms the watchdog permanently
+func (w *Watchdog) Stop() {
+	w.mux.Lock()
+	defer w.mux.Unlock()
+	w.stopped = true
+	if w.timer != nil {
+		w.timer.Stop()
+	}
+}
+
+// expire is called by the timer armed by the kick-th kick. A timer that fires
+// after it has been superseded by a later kick is ignored.
+func (w *Watchdog) expire(kick uint64) {
+	w.mux.Lock()
+	exclusive := w.exclusive
+	w.mux.Unlock()
+
+	run := func() {
+		w.mux.Lock()
+		if w.stopped || kick != w.kicks {
+			w.mux.Unlock()
+			return
+		}
+		w.expired = true
+		w.mux.Unlock()
+
+		if w.onExpire != nil {
+			w.onExpire()
+		}
+	}
+
+	if exclusive != nil {
+		exclusive(run)
+	} else {
+		run()
+	}
+}
@@ -0,0 +1,86 @@
+package control
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ohowland/cgc_core/internal/pkg/msg"
+	"gotest.tools/assert"
+)
+
+func TestWatchdogExpire(t *testing.T) {
+	var expired, recovered int32
+	w := NewWatchdog(20*time.Millisecond,
+		func() { atomic.AddInt32(&expired, 1) },
+		func() { atomic.AddInt32(&recovered, 1) })
+
+	// not armed until the first kick
+	time.Sleep(timeout)
+	assert.Equal(t, atomic.LoadInt32(&expired), int32(0))
+
+	w.Kick()
+	time.Sleep(timeout)
+	assert.Equal(t, atomic.LoadInt32(&expired), int32(1))
+	assert.Assert(t, w.Expired())
+
+	// expires once per loss of control
+	time.Sleep(timeout)
+	assert.Equal(t, atomic.LoadInt32(&expired), int32(1))
+
+	w.Kick()
+	assert.Equal(t, atomic.LoadInt32(&recovered), int32(1))
+	assert.Assert(t, !w.Expired())
+	w.Stop()
+}
+
+func TestWatchdogKickHolds(t *testing.T) {
+	var expired int32
+	w := NewWatchdog(30*time.Millisecond, func() { atomic.AddInt32(&expired, 1) }, nil)
+
+	for i := 0; i < 10; i++ {
+		w.Kick()
+		time.Sleep(5 * time.Millisecond)
+	}
+	assert.Equal(t, atomic.LoadInt32(&expired), int32(0))
+	w.Stop()
+}
+
+func TestWatchdogDisabled(t *testing.T) {
+	var expired int32
+	w := NewWatchdog(0, func() { atomic.AddInt32(&expired, 1) }, nil)
+
+	w.Kick()
+	time.Sleep(timeout)
+	assert.Equal(t, atomic.LoadInt32(&expired), int32(0))
+}
+
+func TestWatchdogStop(t *testing.T) {
+	var expired int32
+	w := NewWatchdog(20*time.Millisecond, func() { atomic.AddInt32(&expired, 1) }, nil)
+
+	w.Kick()
+	w.Stop()
+	time.Sleep(timeout)
+	assert.Equal(t, atomic.LoadInt32(&expired), int32(0))
+}
+
+func TestWatchdogSerialized(t *testing.T) {
+	var expired int32
+	a := NewArbiter(func(msg.Msg) {})
+	w := NewWatchdog(20*time.Millisecond, func() { atomic.AddInt32(&expired, 1) }, nil)
+	w.SerializeWith(a.Exclusive)
+
+	// a control write in flight when the timeout passes cancels the expiry
+	w.Kick()
+	a.Exclusive(func() {
+		time.Sleep(timeout)
+		w.Kick()
+	})
+	time.Sleep(5 * time.Millisecond)
+	assert.Equal(t, atomic.LoadInt32(&expired), int32(0))
+
+	time.Sleep(timeout)
+	assert.Equal(t, atomic.LoadInt32(&expired), int32(1))
+	w.Stop()
+}
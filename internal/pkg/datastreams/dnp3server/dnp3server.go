@@ -0,0 +1,357 @@
+package dnp3server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"strings"
+	"sync"
+
+	"github.com/google/uuid"
+	"github.com/ohowland/cgc_core/internal/pkg/comm/dnp3comm"
+	"github.com/ohowland/cgc_core/internal/pkg/datastreams/points"
+	"github.com/ohowland/cgc_core/internal/pkg/msg"
+	"github.com/ohowland/cgc_core/internal/pkg/operator"
+)
+
+// Handler serves the system status to utility SCADA masters as a DNP3 outstation, and
+// turns their control commands into operator control requests.
+//
+// Each Binary and Analog serves a status field of an asset, or an aggregate of the
+// field over the assets of an archetype, as an input point reporting events of its
+// class, timestamped with the status message. Each Control maps a CROB or analog output
+// index to a field of the machine control of an asset. An operate sends the asset a
+// control request that sets the field, and keeps the other fields of the last control
+// commanded to the asset. Until the asset has been commanded, a command is not
+// supported until every other index mapped to the asset control has been operated. A
+// CROB latching on or closing the Release index returns control to dispatch.
+type Handler struct {
+	inbox      <-chan msg.Msg
+	configs    <-chan msg.Msg
+	pid        uuid.UUID
+	config     config
+	outstation *dnp3comm.Outstation
+	table      *points.Table
+	commands   *commands
+	stop       chan bool
+}
+
+type config struct {
+	Listen     string                    `json:"Listen"`
+	Outstation dnp3comm.OutstationConfig `json:"Outstation"`
+	Binaries   []Input                   `json:"Binaries"`
+	Analogs    []Input                   `json:"Analogs"`
+	Controls   []Control                 `json:"Controls"`
+	Release    *uint16                   `json:"Release"`
+}
+
+// Input serves the status field of its Source at the point index
+type Input struct {
+	points.Source
+	dnp3comm.PointConfig
+}
+
+// Control maps the CROB index, or the analog output index if Analog, to the asset
+// control field of its Target
+type Control struct {
+	points.Target
+	Index  uint16 `json:"Index"`
+	Analog bool   `json:"Analog"`
+}
+
+// commands executes the commands of masters
+type commands struct {
+	mux       *sync.Mutex
+	controls  []Control
+	release   *uint16
+	table     *points.Table
+	operator  *operator.Operator
+	commanded map[int]float64
+}
+
+// configSubscription delivers every config message. Config is published once, when a
+// node joins the system, so a dropped config is never replaced.
+var configSubscription = msg.Subscription{
+	Topics: []msg.Topic{msg.Config},
+	Policy: msg.Policy{Buffer: 16, Delivery: msg.Guaranteed},
+}
+
+func (h Handler) PID() uuid.UUID {
+	return h.pid
+}
+
+func New(configPath string, system operator.System) (Handler, error) {
+	jsonConfig, err := ioutil.ReadFile(configPath)
+	if err != nil {
+		return Handler{}, err
+	}
+	cfg := config{Listen: ":20000"}
+	if err := json.Unmarshal(jsonConfig, &cfg); err != nil {
+		return Handler{}, err
+	}
+	if err := cfg.check(); err != nil {
+		return Handler{}, err
+	}
+
+	op, err := operator.New(system)
+	if err != nil {
+		return Handler{}, err
+	}
+
+	inputs := append(append([]Input{}, cfg.Binaries...), cfg.Analogs...)
+	sources := make([]points.Source, len(inputs))
+	for i, in := range inputs {
+		sources[i] = in.Source
+	}
+
+	h := Handler{
+		config: cfg,
+		table:  points.NewTable(sources),
+		stop:   make(chan bool),
+	}
+	h.commands = &commands{
+		mux:       &sync.Mutex{},
+		controls:  cfg.Controls,
+		release:   cfg.Release,
+		table:     h.table,
+		operator:  op,
+		commanded: make(map[int]float64),
+	}
+
+	h.outstation, err = dnp3comm.NewOutstation(cfg.Outstation, pointConfigs(cfg.Binaries), pointConfigs(cfg.Analogs), h.commands)
+	if err != nil {
+		return Handler{}, err
+	}
+
+	h.pid, _ = uuid.NewUUID()
+	h.inbox, err = system.SubscribeFiltered(h.pid, msg.Subscription{
+		Topics: []msg.Topic{msg.Status},
+		Policy: msg.Policy{Buffer: 50, Delivery: msg.LatestValue},
+	})
+	if err != nil {
+		return Handler{}, err
+	}
+	h.configs, err = system.SubscribeFiltered(h.pid, configSubscription)
+	if err != nil {
+		system.Unsubscribe(h.pid)
+		return Handler{}, err
+	}
+
+	if err := h.outstation.ListenTCP(cfg.Listen); err != nil {
+		system.Unsubscribe(h.pid)
+		return Handler{}, err
+	}
+	return h, nil
+}
+
+// check returns an error if an input or control is misconfigured
+func (c config) check() error {
+	for _, in := range append(append([]Input{}, c.Binaries...), c.Analogs...) {
+		if err := in.Check(); err != nil {
+			err := fmt.Sprintf("dnp3 server: input %v: %v", in.Index, err)
+			return errors.New(err)
+		}
+	}
+	targets := make([]points.Target, len(c.Controls))
+	for i, ctrl := range c.Controls {
+		if err := ctrl.Check(); err != nil {
+			err := fmt.Sprintf("dnp3 server: control %v: %v", ctrl.Index, err)
+			return errors.New(err)
+		}
+		targets[i] = ctrl.Target
+	}
+	if err := points.CheckTargets(targets); err != nil {
+		err := fmt.Sprintf("dnp3 server: %v", err)
+		return errors.New(err)
+	}
+	return nil
+}
+
+func pointConfigs(inputs []Input) []dnp3comm.PointConfig {
+	configs := make([]dnp3comm.PointConfig, len(inputs))
+	for i, in := range inputs {
+		configs[i] = in.PointConfig
+	}
+	return configs
+}
+
+func (h *Handler) StopProcess() {
+	h.stop <- true
+}
+
+// Process serves system messages to DNP3 masters until stopped
+func (h Handler) Process() {
+loop:
+	for {
+		// a pending config is served first, so it precedes the status of its node
+		select {
+		case m, ok := <-h.configs:
+			if !ok {
+				break loop
+			}
+			h.update(m)
+			continue
+		default:
+		}
+
+		select {
+		case m, ok := <-h.inbox:
+			if !ok {
+				break loop
+			}
+			h.update(m)
+		case m, ok := <-h.configs:
+			if !ok {
+				break loop
+			}
+			h.update(m)
+		case <-h.stop:
+			break loop
+		}
+	}
+
+	h.outstation.Close()
+	if err := h.commands.operator.Release(); err != nil {
+		log.Println("[DNP3]", err)
+	}
+	log.Println("[DNP3] Process Shutdown")
+}
+
+// update serves the inputs updated by a message
+func (h Handler) update(m msg.Msg) {
+	updated, err := h.table.Update(m)
+	if err != nil {
+		log.Println("[DNP3]", err)
+		return
+	}
+	for i, v := range updated {
+		if i < len(h.config.Binaries) {
+			err = h.outstation.UpdateBinary(h.config.Binaries[i].Index, v.Value != 0, v.Good, m.Timestamp())
+		} else {
+			err = h.outstation.UpdateAnalog(h.config.Analogs[i-len(h.config.Binaries)].Index, v.Value, v.Good, m.Timestamp())
+		}
+		if err != nil {
+			log.Println("[DNP3]", err)
+		}
+	}
+}
+
+// Select accepts a command to a mapped control that can be operated, or to the Release
+// index
+func (c *commands) Select(cmd dnp3comm.Command) dnp3comm.CommandStatus {
+	if c.isRelease(cmd) {
+		return dnp3comm.StatusSuccess
+	}
+	i, ok := c.control(cmd)
+	if !ok {
+		return dnp3comm.StatusNotSupported
+	}
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if len(c.unoperated(i)) > 0 {
+		return dnp3comm.StatusNotSupported
+	}
+	return dnp3comm.StatusSuccess
+}
+
+// Operate sends the control request of a command to a mapped control, or releases
+// control to dispatch
+func (c *commands) Operate(cmd dnp3comm.Command) dnp3comm.CommandStatus {
+	if c.isRelease(cmd) {
+		if cmd.Value != 0 {
+			if err := c.operator.Release(); err != nil {
+				log.Println("[DNP3] release request:", err)
+				return dnp3comm.StatusHardwareError
+			}
+		}
+		return dnp3comm.StatusSuccess
+	}
+
+	i, ok := c.control(cmd)
+	if !ok {
+		return dnp3comm.StatusNotSupported
+	}
+	if err := c.request(i, cmd.Value); err != nil {
+		log.Println("[DNP3] control request:", err)
+		if errors.Is(err, errUnoperated) {
+			return dnp3comm.StatusNotSupported
+		}
+		return dnp3comm.StatusHardwareError
+	}
+	return dnp3comm.StatusSuccess
+}
+
+func (c *commands) isRelease(cmd dnp3comm.Command) bool {
+	return c.release != nil && !cmd.Analog && cmd.Index == *c.release
+}
+
+// control returns the index of the control mapped to the command
+func (c *commands) control(cmd dnp3comm.Command) (int, bool) {
+	for i, ctrl := range c.controls {
+		if ctrl.Index == cmd.Index && ctrl.Analog == cmd.Analog {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// errUnoperated rejects a command to an asset that has not been commanded, while a
+// field of its control has not been operated
+var errUnoperated = errors.New("fields not operated")
+
+// request records the value commanded to a control, and sends its asset a control
+// request that sets the field of the control. The operator merges it onto the last
+// control commanded to the asset. An asset that has not been commanded is sent the last
+// value commanded to each control mapped to the asset control.
+func (c *commands) request(i int, val float64) error {
+	c.mux.Lock()
+	c.commanded[i] = val
+	target := c.controls[i].Target
+	values := map[string]float64{target.Field: val}
+	if unoperated := c.unoperated(i); len(unoperated) > 0 {
+		c.mux.Unlock()
+		return fmt.Errorf("%v of %v: %w %v", target.Type, target.Asset, errUnoperated, strings.Join(unoperated, ", "))
+	}
+	if c.uncommanded(target) {
+		for j, ctrl := range c.controls {
+			if ctrl.Asset == target.Asset && ctrl.Type == target.Type {
+				values[ctrl.Field] = c.commanded[j]
+			}
+		}
+	}
+	c.mux.Unlock()
+
+	request, err := c.table.Request(target.Asset, target.Type, values)
+	if err != nil {
+		return err
+	}
+	return c.operator.Control(request)
+}
+
+// uncommanded reports whether the operator has not commanded the asset of the target
+func (c *commands) uncommanded(target points.Target) bool {
+	pid, ok := c.table.Resolve(target.Asset)
+	return !ok || c.operator.Commanded(pid) == nil
+}
+
+// unoperated returns the fields of the other controls mapped to the asset control of
+// control i that have not been operated, when the asset has not been commanded. The
+// caller holds the lock.
+func (c *commands) unoperated(i int) []string {
+	target := c.controls[i].Target
+	if !c.uncommanded(target) {
+		return nil
+	}
+	fields := []string{}
+	for j, ctrl := range c.controls {
+		if j == i || ctrl.Asset != target.Asset || ctrl.Type != target.Type {
+			continue
+		}
+		if _, ok := c.commanded[j]; !ok {
+			fields = append(fields, ctrl.Field)
+		}
+	}
+	return fields
+}
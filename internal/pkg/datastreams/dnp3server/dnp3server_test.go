@@ -0,0 +1,265 @@
+package dnp3server
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/ohowland/cgc_core/internal/pkg/asset"
+	"github.com/ohowland/cgc_core/internal/pkg/asset/ess"
+	"github.com/ohowland/cgc_core/internal/pkg/asset/feeder"
+	"github.com/ohowland/cgc_core/internal/pkg/asset/grid"
+	"github.com/ohowland/cgc_core/internal/pkg/comm/dnp3comm"
+	"github.com/ohowland/cgc_core/internal/pkg/control"
+	"github.com/ohowland/cgc_core/internal/pkg/mocksystem"
+	"github.com/ohowland/cgc_core/internal/pkg/msg"
+	"gotest.tools/assert"
+)
+
+const testPoints = `
+	"Outstation": {"Address": 10},
+	"Binaries": [
+		{"Asset": "ess", "Field": "Online", "Index": 0, "Class": 1},
+		{"Asset": "grid", "Field": "Online", "Index": 1, "Class": 1}
+	],
+	"Analogs": [
+		{"Asset": "ess", "Field": "KW", "Index": 0, "Class": 2, "Deadband": 1},
+		{"Asset": "ess", "Field": "SOC", "Index": 1, "Class": 3},
+		{"Archetype": "feeder", "Field": "KW", "Index": 2}
+	],
+	"Controls": [
+		{"Asset": "ess", "Type": "ess.MachineControl", "Field": "Run", "Index": 0},
+		{"Asset": "ess", "Type": "ess.MachineControl", "Field": "KW", "Index": 0, "Analog": true},
+		{"Asset": "ess", "Type": "ess.MachineControl", "Field": "KVAR", "Index": 1, "Analog": true},
+		{"Asset": "ess", "Type": "ess.MachineControl", "Field": "Gridform", "Index": 3},
+		{"Asset": "grid", "Type": "grid.MachineControl", "Field": "CloseIntertie", "Index": 1},
+		{"Asset": "feeder", "Type": "feeder.MachineControl", "Field": "CloseFeeder", "Index": 2}
+	],
+	"Release": 10`
+
+// configFile writes the handler config to a temporary file and returns its path. The
+// caller removes the file.
+func configFile(t *testing.T, config string) string {
+	t.Helper()
+	f, err := ioutil.TempFile("", "handler_config")
+	assert.NilError(t, err)
+	defer f.Close()
+	_, err = f.WriteString(config)
+	assert.NilError(t, err)
+	return f.Name()
+}
+
+// poll calls check until it returns nil, and fails the test with the last error of
+// check if it has not within a second. The handler processes messages on its own
+// goroutine, so a test waits for the effect of the messages it publishes.
+func poll(t *testing.T, check func() error) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for {
+		err := check()
+		if err == nil {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal(err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// newHandler returns a Handler listening on a free local port, and a master polling it
+func newHandler(t *testing.T, system *mocksystem.System) (Handler, *dnp3comm.Master) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NilError(t, err)
+	addr := l.Addr().String()
+	l.Close()
+
+	path := configFile(t, fmt.Sprintf(`{"Listen": "%v", %v}`, addr, testPoints))
+	defer os.Remove(path)
+	h, err := New(path, system)
+	assert.NilError(t, err)
+
+	m, err := dnp3comm.Dial(addr, 1, 10, time.Second)
+	assert.NilError(t, err)
+	return h, m
+}
+
+// waitFor polls the static analogs until the input at the index holds the value
+func waitFor(t *testing.T, m *dnp3comm.Master, index int, want dnp3comm.Analog) dnp3comm.Response {
+	var r dnp3comm.Response
+	poll(t, func() error {
+		var err error
+		r, err = m.ReadClass(0)
+		assert.NilError(t, err)
+		if r.Analogs[index] != want {
+			return fmt.Errorf("analog %v: got %+v, want %+v", index, r.Analogs[index], want)
+		}
+		return nil
+	})
+	return r
+}
+
+func TestServeStatus(t *testing.T) {
+	system := mocksystem.New()
+	h, m := newHandler(t, system)
+	defer m.Close()
+	go h.Process()
+	defer h.StopProcess()
+
+	essPID := uuid.New()
+	system.Forward(msg.New(essPID, msg.Config, ess.Config{Static: ess.StaticConfig{Name: "ess"}}))
+	status := msg.New(essPID, msg.Status, ess.Status{Machine: ess.MachineStatus{KW: -12.5, SOC: 65, Online: true}})
+	system.Forward(status)
+	r := waitFor(t, m, 1, dnp3comm.Analog{Index: 1, Value: 65, Flags: dnp3comm.FlagOnline})
+	assert.Equal(t, r.Analogs[0].Value, -12.5)
+	assert.Equal(t, r.Binaries[0].Value, true)
+	assert.Equal(t, r.Binaries[1].Flags, dnp3comm.FlagRestart)
+
+	r, err := m.ReadClass(1, 2, 3)
+	assert.NilError(t, err)
+	stamp := status.Timestamp().Truncate(time.Millisecond)
+	assert.Equal(t, len(r.BinaryEvents), 1)
+	assert.Assert(t, r.BinaryEvents[0].Time.Equal(stamp))
+	assert.Equal(t, len(r.AnalogEvents), 2)
+	assert.Assert(t, r.AnalogEvents[0].Time.Equal(stamp))
+
+	system.Forward(msg.New(uuid.New(), msg.Status, feeder.Status{Machine: feeder.MachineStatus{KW: 10}}))
+	system.Forward(msg.New(uuid.New(), msg.Status, feeder.Status{Machine: feeder.MachineStatus{KW: 5}}))
+	system.Forward(msg.New(uuid.New(), msg.Status, feeder.Status{Machine: feeder.MachineStatus{KW: 20, Quality: asset.CommFail}}))
+	waitFor(t, m, 2, dnp3comm.Analog{Index: 2, Value: 15, Flags: dnp3comm.FlagOnline})
+}
+
+func TestOperate(t *testing.T) {
+	system := mocksystem.New()
+	h, m := newHandler(t, system)
+	defer m.Close()
+	go h.Process()
+	defer h.StopProcess()
+
+	dispatch := uuid.New()
+	assert.NilError(t, system.RequestControl(dispatch, control.Dispatch, make(chan msg.Msg)))
+
+	essPID := uuid.New()
+	gridPID := uuid.New()
+	system.Forward(msg.New(essPID, msg.Config, ess.Config{Static: ess.StaticConfig{Name: "ess"}}))
+	system.Forward(msg.New(gridPID, msg.Config, grid.Config{Static: grid.StaticConfig{Name: "grid"}}))
+	system.Forward(msg.New(essPID, msg.Status, ess.Status{Machine: ess.MachineStatus{SOC: 1}}))
+	waitFor(t, m, 1, dnp3comm.Analog{Index: 1, Value: 1, Flags: dnp3comm.FlagOnline})
+
+	// the ess is not commanded until each index of its control is operated
+	notSupported := []dnp3comm.CommandStatus{dnp3comm.StatusNotSupported}
+	statuses, err := m.SelectOperate(dnp3comm.Command{Index: 0, Value: 1})
+	assert.NilError(t, err)
+	assert.DeepEqual(t, statuses, notSupported)
+	statuses, err = m.DirectOperate(dnp3comm.Command{Index: 0, Value: 1})
+	assert.NilError(t, err)
+	assert.DeepEqual(t, statuses, notSupported)
+	statuses, err = m.DirectOperate(dnp3comm.Command{Index: 1, Analog: true, Value: 5})
+	assert.NilError(t, err)
+	assert.DeepEqual(t, statuses, notSupported)
+	statuses, err = m.DirectOperate(dnp3comm.Command{Index: 0, Analog: true, Value: -30})
+	assert.NilError(t, err)
+	assert.DeepEqual(t, statuses, notSupported)
+	statuses, err = m.DirectOperate(dnp3comm.Command{Index: 3, Value: 0})
+	assert.NilError(t, err)
+	assert.DeepEqual(t, statuses, []dnp3comm.CommandStatus{dnp3comm.StatusSuccess})
+
+	statuses, err = m.SelectOperate(dnp3comm.Command{Index: 0, Analog: true, Value: -20})
+	assert.NilError(t, err)
+	assert.DeepEqual(t, statuses, []dnp3comm.CommandStatus{dnp3comm.StatusSuccess})
+	statuses, err = m.DirectOperate(dnp3comm.Command{Index: 1, Code: dnp3comm.CodeClose})
+	assert.NilError(t, err)
+	assert.DeepEqual(t, statuses, []dnp3comm.CommandStatus{dnp3comm.StatusSuccess})
+
+	wants := []struct {
+		target  uuid.UUID
+		payload interface{}
+	}{
+		{essPID, ess.MachineControl{Run: true, KW: -30, KVAR: 5}},
+		{essPID, ess.MachineControl{Run: true, KW: -20, KVAR: 5}},
+		{gridPID, grid.MachineControl{CloseIntertie: true}},
+	}
+	for _, want := range wants {
+		select {
+		case w := <-system.Written:
+			assert.Equal(t, w.Target(), want.target)
+			assert.Equal(t, w.Payload(), want.payload)
+		case <-time.After(time.Second):
+			t.Fatal("control was not written")
+		}
+	}
+	owner, priority := system.OwnerOf(essPID)
+	assert.Equal(t, priority, control.Operator)
+	assert.Assert(t, owner != dispatch)
+
+	statuses, err = m.DirectOperate(dnp3comm.Command{Index: 10, Value: 1})
+	assert.NilError(t, err)
+	assert.DeepEqual(t, statuses, []dnp3comm.CommandStatus{dnp3comm.StatusSuccess})
+	owner, _ = system.OwnerOf(essPID)
+	assert.Equal(t, owner, dispatch)
+}
+
+func TestOperateMerges(t *testing.T) {
+	system := mocksystem.New()
+	h, m := newHandler(t, system)
+	defer m.Close()
+	go h.Process()
+	defer h.StopProcess()
+
+	essPID := uuid.New()
+	system.Forward(msg.New(essPID, msg.Config, ess.Config{Static: ess.StaticConfig{Name: "ess"}}))
+	system.Forward(msg.New(essPID, msg.Status, ess.Status{Machine: ess.MachineStatus{SOC: 1}}))
+	waitFor(t, m, 1, dnp3comm.Analog{Index: 1, Value: 1, Flags: dnp3comm.FlagOnline})
+
+	commanded := ess.MachineControl{Run: true, KW: 10, Gridform: true}
+	assert.NilError(t, h.commands.operator.Control(msg.New(uuid.New(), msg.Control, commanded).WithTarget(essPID)))
+	<-system.Written
+
+	statuses, err := m.DirectOperate(dnp3comm.Command{Index: 0, Analog: true, Value: 25})
+	assert.NilError(t, err)
+	assert.DeepEqual(t, statuses, []dnp3comm.CommandStatus{dnp3comm.StatusSuccess})
+	select {
+	case w := <-system.Written:
+		assert.Equal(t, w.Payload(), ess.MachineControl{Run: true, KW: 25, Gridform: true})
+	case <-time.After(time.Second):
+		t.Fatal("control was not written")
+	}
+}
+
+func TestOperateFails(t *testing.T) {
+	system := mocksystem.New()
+	h, m := newHandler(t, system)
+	defer m.Close()
+	go h.Process()
+	defer h.StopProcess()
+
+	// an unmapped index
+	statuses, err := m.SelectOperate(dnp3comm.Command{Index: 5, Analog: true, Value: 1})
+	assert.NilError(t, err)
+	assert.DeepEqual(t, statuses, []dnp3comm.CommandStatus{dnp3comm.StatusNotSupported})
+
+	// the feeder has not reported its name
+	statuses, err = m.DirectOperate(dnp3comm.Command{Index: 2, Value: 1})
+	assert.NilError(t, err)
+	assert.DeepEqual(t, statuses, []dnp3comm.CommandStatus{dnp3comm.StatusHardwareError})
+}
+
+func TestBadConfig(t *testing.T) {
+	tests := map[string]string{
+		`{"Analogs": [{"Field": "KW", "Index": 3}]}`:                                                   "dnp3 server: input 3: no asset or archetype",
+		`{"Binaries": [{"Archetype": "grid", "Aggregate": "median", "Field": "Online", "Index": 1}]}`:  "dnp3 server: input 1: unsupported aggregate median",
+		`{"Binaries": [{"Asset": "grid", "Field": "Online", "Index": 1, "Class": 5}]}`:                 "dnp3: binary input 1 has bad class 5",
+		`{"Controls": [{"Asset": "ess", "Type": "ess.MachineControl", "Field": "Volts", "Index": 2}]}`: "dnp3 server: control 2: ess.MachineControl has no field Volts",
+		`{"Controls": [{"Asset": "grid", "Type": "ess.MachineControl", "Field": "KW", "Index": 2}]}`:   "dnp3 server: grid ess.MachineControl does not map Run, KVAR, Gridform",
+	}
+	for jsonConfig, want := range tests {
+		path := configFile(t, jsonConfig)
+		_, err := New(path, mocksystem.New())
+		os.Remove(path)
+		assert.Error(t, err, want)
+	}
+}
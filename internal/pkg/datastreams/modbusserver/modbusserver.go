@@ -0,0 +1,420 @@
+package modbusserver
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"strings"
+	"sync"
+
+	"github.com/google/uuid"
+	"github.com/ohowland/cgc_core/internal/pkg/asset/grid"
+	"github.com/ohowland/cgc_core/internal/pkg/asset/pv"
+	"github.com/ohowland/cgc_core/internal/pkg/comm/modbuscomm"
+	"github.com/ohowland/cgc_core/internal/pkg/datastreams/points"
+	"github.com/ohowland/cgc_core/internal/pkg/msg"
+	"github.com/ohowland/cgc_core/internal/pkg/operator"
+)
+
+// Handler serves the system status to Modbus TCP clients, such as utility SCADA and
+// site PLCs, and turns their register writes into operator control requests.
+//
+// Each Point serves a status field of an asset, or an aggregate of the field over the
+// assets of an archetype, in a register. A point holds its last good value while its
+// source is not good, and its optional Quality register reads 1 while the value is
+// good and 0 otherwise. Each Control maps a writable register to a field of the
+// machine control of an asset. A write sends the asset a control request that sets
+// the written field, and keeps the other fields of the last control commanded to the
+// asset. Until the asset has been commanded, writes are held back until every
+// register mapped to the asset control has been written. The Site registers take
+// commands for the whole site. Writing a non-zero value to the Release register
+// returns control to dispatch.
+type Handler struct {
+	mux      *sync.Mutex
+	inbox    <-chan msg.Msg
+	configs  <-chan msg.Msg
+	pid      uuid.UUID
+	config   config
+	server   *modbuscomm.Server
+	table    *points.Table
+	operator *operator.Operator
+	written  map[string]bool
+	stop     chan bool
+}
+
+type config struct {
+	Listen   string               `json:"Listen"`
+	Points   []Point              `json:"Points"`
+	Controls []Control            `json:"Controls"`
+	Release  *modbuscomm.Register `json:"Release"`
+	Site     *Site                `json:"Site"`
+}
+
+// Point serves the status field of its Source in a register, and whether the value is
+// good in the Quality register. Registers default to read-only.
+type Point struct {
+	points.Source
+	Register modbuscomm.Register  `json:"Register"`
+	Quality  *modbuscomm.Register `json:"Quality"`
+}
+
+// Site maps the site commands to registers. A non-zero write to Island opens the
+// intertie of each grid asset, and zero closes it. KWLimit limits the total real power
+// of the pv assets, shared equally between them. Registers default to read-write.
+type Site struct {
+	KWLimit *modbuscomm.Register `json:"KWLimit"`
+	Island  *modbuscomm.Register `json:"Island"`
+}
+
+// Control maps a register to the asset control field of its Target. Registers default
+// to read-write.
+type Control struct {
+	points.Target
+	Register modbuscomm.Register `json:"Register"`
+}
+
+// configSubscription delivers every config message. Config is published once, when a
+// node joins the system, so a dropped config is never replaced.
+var configSubscription = msg.Subscription{
+	Topics: []msg.Topic{msg.Config},
+	Policy: msg.Policy{Buffer: 16, Delivery: msg.Guaranteed},
+}
+
+func (h Handler) PID() uuid.UUID {
+	return h.pid
+}
+
+func New(configPath string, system operator.System) (Handler, error) {
+	jsonConfig, err := ioutil.ReadFile(configPath)
+	if err != nil {
+		return Handler{}, err
+	}
+	cfg := config{Listen: ":502"}
+	if err := json.Unmarshal(jsonConfig, &cfg); err != nil {
+		return Handler{}, err
+	}
+
+	registers, err := cfg.registers()
+	if err != nil {
+		return Handler{}, err
+	}
+
+	op, err := operator.New(system)
+	if err != nil {
+		return Handler{}, err
+	}
+
+	sources := make([]points.Source, len(cfg.Points))
+	for i, p := range cfg.Points {
+		sources[i] = p.Source
+	}
+
+	h := Handler{
+		mux:      &sync.Mutex{},
+		config:   cfg,
+		table:    points.NewTable(sources),
+		operator: op,
+		written:  make(map[string]bool),
+		stop:     make(chan bool),
+	}
+
+	// the closure sees the server, which a method value taken here would not
+	h.server, err = modbuscomm.NewServer(registers, func(r modbuscomm.Register, val float64) {
+		h.onWrite(r, val)
+	})
+	if err != nil {
+		return Handler{}, err
+	}
+
+	h.pid, _ = uuid.NewUUID()
+	h.inbox, err = system.SubscribeFiltered(h.pid, msg.Subscription{
+		Topics: []msg.Topic{msg.Status},
+		Policy: msg.Policy{Buffer: 50, Delivery: msg.LatestValue},
+	})
+	if err != nil {
+		return Handler{}, err
+	}
+	h.configs, err = system.SubscribeFiltered(h.pid, configSubscription)
+	if err != nil {
+		system.Unsubscribe(h.pid)
+		return Handler{}, err
+	}
+
+	if err := h.server.ListenTCP(cfg.Listen); err != nil {
+		system.Unsubscribe(h.pid)
+		return Handler{}, err
+	}
+	return h, nil
+}
+
+// registers returns the served registers, with the default access of each
+func (c *config) registers() ([]modbuscomm.Register, error) {
+	registers := make([]modbuscomm.Register, 0)
+	for i, p := range c.Points {
+		if err := p.Check(); err != nil {
+			err := fmt.Sprintf("modbus server: point %v: %v", p.Register.Name, err)
+			return nil, errors.New(err)
+		}
+		if p.Register.AccessType == "" {
+			c.Points[i].Register.AccessType = "read-only"
+		}
+		registers = append(registers, c.Points[i].Register)
+		if q := p.Quality; q != nil {
+			if q.AccessType == "" {
+				q.AccessType = "read-only"
+			}
+			registers = append(registers, *q)
+		}
+	}
+
+	for i, ctrl := range c.Controls {
+		if err := ctrl.Check(); err != nil {
+			err := fmt.Sprintf("modbus server: control %v: %v", ctrl.Register.Name, err)
+			return nil, errors.New(err)
+		}
+		if ctrl.Register.AccessType == "" {
+			c.Controls[i].Register.AccessType = "read-write"
+		}
+		registers = append(registers, c.Controls[i].Register)
+	}
+	targets := make([]points.Target, len(c.Controls))
+	for i, ctrl := range c.Controls {
+		targets[i] = ctrl.Target
+	}
+	if err := points.CheckTargets(targets); err != nil {
+		err := fmt.Sprintf("modbus server: %v", err)
+		return nil, errors.New(err)
+	}
+
+	if c.Release != nil {
+		if c.Release.AccessType == "" {
+			c.Release.AccessType = "read-write"
+		}
+		registers = append(registers, *c.Release)
+	}
+
+	if c.Site != nil {
+		for _, r := range []*modbuscomm.Register{c.Site.KWLimit, c.Site.Island} {
+			if r == nil {
+				continue
+			}
+			if r.AccessType == "" {
+				r.AccessType = "read-write"
+			}
+			registers = append(registers, *r)
+		}
+	}
+	return registers, nil
+}
+
+func (h *Handler) StopProcess() {
+	h.stop <- true
+}
+
+// Process serves system messages to Modbus clients until stopped
+func (h Handler) Process() {
+loop:
+	for {
+		// a pending config is served first, so it precedes the status of its node
+		select {
+		case m, ok := <-h.configs:
+			if !ok {
+				break loop
+			}
+			h.update(m)
+			continue
+		default:
+		}
+
+		select {
+		case m, ok := <-h.inbox:
+			if !ok {
+				break loop
+			}
+			h.update(m)
+		case m, ok := <-h.configs:
+			if !ok {
+				break loop
+			}
+			h.update(m)
+		case <-h.stop:
+			break loop
+		}
+	}
+
+	h.server.Close()
+	if err := h.operator.Release(); err != nil {
+		log.Println("[Modbus]", err)
+	}
+	log.Println("[Modbus] Process Shutdown")
+}
+
+// update serves the sources updated by a message. A value that is not good is not
+// served, the register holds the last good value.
+func (h Handler) update(m msg.Msg) {
+	updated, err := h.table.Update(m)
+	if err != nil {
+		log.Println("[Modbus]", err)
+		return
+	}
+	for i, v := range updated {
+		p := h.config.Points[i]
+		if v.Good {
+			if err := h.server.Set(p.Register.Name, v.Value); err != nil {
+				log.Println("[Modbus]", err)
+			}
+		}
+		if p.Quality == nil {
+			continue
+		}
+		quality := 0.0
+		if v.Good {
+			quality = 1
+		}
+		if err := h.server.Set(p.Quality.Name, quality); err != nil {
+			log.Println("[Modbus]", err)
+		}
+	}
+}
+
+// onWrite sends the control request of a control register written by a client
+func (h Handler) onWrite(register modbuscomm.Register, val float64) {
+	if h.config.Release != nil && register.Name == h.config.Release.Name {
+		if val != 0 {
+			if err := h.operator.Release(); err != nil {
+				log.Println("[Modbus] release request:", err)
+			}
+		}
+		return
+	}
+
+	if site := h.config.Site; site != nil {
+		if site.Island != nil && register.Name == site.Island.Name {
+			if err := h.island(val != 0); err != nil {
+				log.Println("[Modbus] island request:", err)
+			}
+			return
+		}
+		if site.KWLimit != nil && register.Name == site.KWLimit.Name {
+			if err := h.limitKW(val); err != nil {
+				log.Println("[Modbus] kW limit request:", err)
+			}
+			return
+		}
+	}
+
+	for _, ctrl := range h.config.Controls {
+		if ctrl.Register.Name == register.Name {
+			if err := h.control(ctrl, val); err != nil {
+				log.Println("[Modbus] control request:", err)
+			}
+			return
+		}
+	}
+}
+
+// control sends the asset of the control a request that sets the written field. The
+// operator merges it onto the last control commanded to the asset. An asset that has
+// not been commanded is sent the value of each register mapped to the asset control,
+// once every one of them has been written.
+func (h Handler) control(written Control, val float64) error {
+	h.mux.Lock()
+	h.written[written.Register.Name] = true
+	h.mux.Unlock()
+
+	pid, ok := h.table.Resolve(written.Asset)
+	if !ok {
+		err := fmt.Sprintf("unknown asset %v", written.Asset)
+		return errors.New(err)
+	}
+	values := map[string]float64{written.Field: val}
+	if h.operator.Commanded(pid) == nil {
+		var err error
+		if values, err = h.registerValues(written.Asset, written.Type); err != nil {
+			return err
+		}
+	}
+
+	request, err := h.table.Request(written.Asset, written.Type, values)
+	if err != nil {
+		return err
+	}
+	return h.operator.Control(request)
+}
+
+// registerValues returns the value of each register mapped to the asset control, or
+// an error naming the registers that have not been written
+func (h Handler) registerValues(assetName string, controlType string) (map[string]float64, error) {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+
+	values := make(map[string]float64)
+	unwritten := []string{}
+	for _, ctrl := range h.config.Controls {
+		if ctrl.Asset != assetName || ctrl.Type != controlType {
+			continue
+		}
+		if !h.written[ctrl.Register.Name] {
+			unwritten = append(unwritten, ctrl.Register.Name)
+			continue
+		}
+		val, err := h.server.Get(ctrl.Register.Name)
+		if err != nil {
+			return nil, err
+		}
+		values[ctrl.Field] = val
+	}
+	if len(unwritten) > 0 {
+		err := fmt.Sprintf("%v of %v waits for writes to %v", controlType, assetName, strings.Join(unwritten, ", "))
+		return nil, errors.New(err)
+	}
+	return values, nil
+}
+
+// island opens the intertie of each grid asset to island the site, or closes it
+func (h Handler) island(island bool) error {
+	grids := h.table.Members("grid")
+	if len(grids) == 0 {
+		return errors.New("no grid asset")
+	}
+	for _, pid := range grids {
+		request := msg.New(h.pid, msg.Control, grid.MachineControl{CloseIntertie: !island}).WithTarget(pid)
+		if err := h.operator.Control(request); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// limitKW shares the site kW limit equally between the pv assets. The other fields of
+// the control of a pv keep the value last commanded, so the limit is rejected while a
+// pv has not been commanded.
+func (h Handler) limitKW(kw float64) error {
+	if kw < 0 {
+		err := fmt.Sprintf("kW limit %v is negative", kw)
+		return errors.New(err)
+	}
+	pvs := h.table.Members("pv")
+	if len(pvs) == 0 {
+		return errors.New("no pv asset")
+	}
+	controls := make([]pv.MachineControl, len(pvs))
+	for i, pid := range pvs {
+		c, ok := h.operator.Commanded(pid).(pv.MachineControl)
+		if !ok {
+			err := fmt.Sprintf("pv %v has not been commanded", pid)
+			return errors.New(err)
+		}
+		controls[i] = c
+	}
+	for i, pid := range pvs {
+		c := controls[i]
+		c.KWLimit = kw / float64(len(pvs))
+		if err := h.operator.Control(msg.New(h.pid, msg.Control, c).WithTarget(pid)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,284 @@
+package modbusserver
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/goburrow/modbus"
+	"github.com/google/uuid"
+	"github.com/ohowland/cgc_core/internal/pkg/asset"
+	"github.com/ohowland/cgc_core/internal/pkg/asset/ess"
+	"github.com/ohowland/cgc_core/internal/pkg/asset/grid"
+	"github.com/ohowland/cgc_core/internal/pkg/asset/pv"
+	"github.com/ohowland/cgc_core/internal/pkg/control"
+	"github.com/ohowland/cgc_core/internal/pkg/mocksystem"
+	"github.com/ohowland/cgc_core/internal/pkg/msg"
+	"gotest.tools/assert"
+)
+
+const testPoints = `
+	"Points": [
+		{"Asset": "ess", "Field": "KW", "Register": {"Name": "ess.KW", "Address": 0, "DataType": "i32", "FunctionCode": 4, "Scale": 0.1},
+			"Quality": {"Name": "ess.KW.Quality", "Address": 4, "DataType": "u16", "FunctionCode": 4}},
+		{"Asset": "ess", "Field": "SOC", "Register": {"Name": "ess.SOC", "Address": 2, "DataType": "u16", "FunctionCode": 4}},
+		{"Asset": "ess", "Field": "Online", "Register": {"Name": "ess.Online", "Address": 0, "DataType": "bool", "FunctionCode": 2}},
+		{"Asset": "ess", "Field": "Quality", "Register": {"Name": "ess.Quality", "Address": 3, "DataType": "u16", "FunctionCode": 4}},
+		{"Archetype": "pv", "Field": "KW", "Register": {"Name": "pv.KW", "Address": 10, "DataType": "u16", "FunctionCode": 4}},
+		{"Archetype": "pv", "Aggregate": "max", "Field": "MachineStatus.KW", "Register": {"Name": "pv.MaxKW", "Address": 11, "DataType": "u16", "FunctionCode": 4}}
+	],
+	"Controls": [
+		{"Asset": "ess", "Type": "ess.MachineControl", "Field": "Run", "Register": {"Name": "ess.Run", "Address": 0, "DataType": "bool", "FunctionCode": 5}},
+		{"Asset": "ess", "Type": "ess.MachineControl", "Field": "KW", "Register": {"Name": "ess.KWSet", "Address": 100, "DataType": "i16", "FunctionCode": 6}},
+		{"Asset": "ess", "Type": "ess.MachineControl", "Field": "KVAR", "Register": {"Name": "ess.KVARSet", "Address": 102, "DataType": "i16", "FunctionCode": 6}},
+		{"Asset": "ess", "Type": "ess.MachineControl", "Field": "Gridform", "Register": {"Name": "ess.Gridform", "Address": 1, "DataType": "bool", "FunctionCode": 5}},
+		{"Asset": "grid", "Type": "grid.MachineControl", "Field": "CloseIntertie", "Register": {"Name": "grid.Close", "Address": 101, "DataType": "u16", "FunctionCode": 6}}
+	],
+	"Release": {"Name": "Release", "Address": 10, "DataType": "bool", "FunctionCode": 5},
+	"Site": {
+		"KWLimit": {"Name": "site.KWLimit", "Address": 200, "DataType": "u16", "FunctionCode": 6},
+		"Island": {"Name": "site.Island", "Address": 11, "DataType": "bool", "FunctionCode": 5}
+	}`
+
+// configFile writes the handler config to a temporary file and returns its path. The
+// caller removes the file.
+func configFile(t *testing.T, config string) string {
+	t.Helper()
+	f, err := ioutil.TempFile("", "handler_config")
+	assert.NilError(t, err)
+	defer f.Close()
+	_, err = f.WriteString(config)
+	assert.NilError(t, err)
+	return f.Name()
+}
+
+// poll calls check until it returns nil, and fails the test with the last error of
+// check if it has not within a second. The handler processes messages on its own
+// goroutine, so a test waits for the effect of the messages it publishes.
+func poll(t *testing.T, check func() error) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for {
+		err := check()
+		if err == nil {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal(err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// newHandler returns a Handler listening on a free local port, and a client of it
+func newHandler(t *testing.T, system *mocksystem.System) (Handler, modbus.Client, func()) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NilError(t, err)
+	addr := l.Addr().String()
+	l.Close()
+
+	path := configFile(t, fmt.Sprintf(`{"Listen": "%v", %v}`, addr, testPoints))
+	defer os.Remove(path)
+	h, err := New(path, system)
+	assert.NilError(t, err)
+
+	handler := modbus.NewTCPClientHandler(addr)
+	handler.Timeout = time.Second
+	return h, modbus.NewClient(handler), func() { handler.Close() }
+}
+
+// waitFor reads the input registers until they hold the value
+func waitFor(t *testing.T, client modbus.Client, address uint16, quantity uint16, want []byte) {
+	poll(t, func() error {
+		got, err := client.ReadInputRegisters(address, quantity)
+		assert.NilError(t, err)
+		if string(got) != string(want) {
+			return fmt.Errorf("input registers %v: got % x, want % x", address, got, want)
+		}
+		return nil
+	})
+}
+
+func TestServeStatus(t *testing.T) {
+	system := mocksystem.New()
+	h, client, closeClient := newHandler(t, system)
+	defer closeClient()
+	go h.Process()
+	defer h.StopProcess()
+
+	essPID := uuid.New()
+	system.Forward(msg.New(essPID, msg.Config, ess.Config{Static: ess.StaticConfig{Name: "ess"}}))
+	system.Forward(msg.New(essPID, msg.Status, ess.Status{Machine: ess.MachineStatus{KW: -12.5, SOC: 65, Online: true}}))
+	waitFor(t, client, 0, 5, []byte{0xFF, 0xFF, 0xFF, 0x83, 0, 65, 0, 0, 0, 1})
+
+	online, err := client.ReadDiscreteInputs(0, 1)
+	assert.NilError(t, err)
+	assert.DeepEqual(t, online, []byte{1})
+
+	// a stale status is not served, the registers hold the last good value
+	system.Forward(msg.New(essPID, msg.Status, ess.Status{Machine: ess.MachineStatus{KW: 40, SOC: 70, Online: true, Quality: asset.Stale}}))
+	waitFor(t, client, 0, 5, []byte{0xFF, 0xFF, 0xFF, 0x83, 0, 65, 0, 1, 0, 0})
+
+	system.Forward(msg.New(uuid.New(), msg.Status, pv.Status{Machine: pv.MachineStatus{KW: 10}}))
+	system.Forward(msg.New(uuid.New(), msg.Status, pv.Status{Machine: pv.MachineStatus{KW: 5}}))
+	system.Forward(msg.New(uuid.New(), msg.Status, pv.Status{Machine: pv.MachineStatus{KW: 20, Quality: asset.CommFail}}))
+	waitFor(t, client, 10, 2, []byte{0, 15, 0, 10})
+}
+
+func TestWriteControl(t *testing.T) {
+	system := mocksystem.New()
+	h, client, closeClient := newHandler(t, system)
+	defer closeClient()
+	go h.Process()
+	defer h.StopProcess()
+
+	dispatch := uuid.New()
+	assert.NilError(t, system.RequestControl(dispatch, control.Dispatch, make(chan msg.Msg)))
+
+	essPID := uuid.New()
+	system.Forward(msg.New(essPID, msg.Config, ess.Config{Static: ess.StaticConfig{Name: "ess"}}))
+	system.Forward(msg.New(essPID, msg.Status, ess.Status{Machine: ess.MachineStatus{SOC: 1}}))
+	waitFor(t, client, 2, 1, []byte{0, 1})
+
+	// the ess is not commanded until each register of its control is written
+	_, err := client.WriteSingleCoil(0, 0xFF00)
+	assert.NilError(t, err)
+	_, err = client.WriteSingleRegister(102, 5)
+	assert.NilError(t, err)
+	_, err = client.WriteSingleCoil(1, 0x0000)
+	assert.NilError(t, err)
+	select {
+	case m := <-system.Written:
+		t.Fatalf("partial control was written: %v", m.Payload())
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	_, err = client.WriteSingleRegister(100, uint16(0x10000-30))
+	assert.NilError(t, err)
+	select {
+	case m := <-system.Written:
+		assert.Equal(t, m.Target(), essPID)
+		assert.Equal(t, m.Payload(), ess.MachineControl{Run: true, KW: -30, KVAR: 5})
+	case <-time.After(time.Second):
+		t.Fatal("control was not written")
+	}
+	owner, priority := system.OwnerOf(essPID)
+	assert.Equal(t, priority, control.Operator)
+	assert.Assert(t, owner != dispatch)
+
+	_, err = client.WriteSingleCoil(10, 0xFF00)
+	assert.NilError(t, err)
+	owner, _ = system.OwnerOf(essPID)
+	assert.Equal(t, owner, dispatch)
+}
+
+func TestWriteControlMerges(t *testing.T) {
+	system := mocksystem.New()
+	h, client, closeClient := newHandler(t, system)
+	defer closeClient()
+	go h.Process()
+	defer h.StopProcess()
+
+	essPID := uuid.New()
+	system.Forward(msg.New(essPID, msg.Config, ess.Config{Static: ess.StaticConfig{Name: "ess"}}))
+	system.Forward(msg.New(essPID, msg.Status, ess.Status{Machine: ess.MachineStatus{SOC: 1}}))
+	waitFor(t, client, 2, 1, []byte{0, 1})
+
+	commanded := ess.MachineControl{Run: true, KW: 10, Gridform: true}
+	assert.NilError(t, h.operator.Control(msg.New(uuid.New(), msg.Control, commanded).WithTarget(essPID)))
+	<-system.Written
+
+	_, err := client.WriteSingleRegister(100, 25)
+	assert.NilError(t, err)
+	select {
+	case m := <-system.Written:
+		assert.Equal(t, m.Payload(), ess.MachineControl{Run: true, KW: 25, Gridform: true})
+	case <-time.After(time.Second):
+		t.Fatal("control was not written")
+	}
+}
+
+func TestSiteCommands(t *testing.T) {
+	system := mocksystem.New()
+	h, client, closeClient := newHandler(t, system)
+	defer closeClient()
+	go h.Process()
+	defer h.StopProcess()
+
+	gridPID := uuid.New()
+	pv1, pv2 := uuid.New(), uuid.New()
+	system.Forward(msg.New(gridPID, msg.Config, grid.Config{Static: grid.StaticConfig{Name: "grid"}}))
+	system.Forward(msg.New(pv1, msg.Status, pv.Status{Machine: pv.MachineStatus{KW: 1}}))
+	system.Forward(msg.New(pv2, msg.Status, pv.Status{Machine: pv.MachineStatus{KW: 2}}))
+	waitFor(t, client, 10, 1, []byte{0, 3})
+
+	_, err := client.WriteSingleCoil(11, 0xFF00)
+	assert.NilError(t, err)
+	written := <-system.Written
+	assert.Equal(t, written.Target(), gridPID)
+	assert.Equal(t, written.Payload(), grid.MachineControl{CloseIntertie: false})
+
+	// the limit is rejected until each pv has been commanded
+	_, err = client.WriteSingleRegister(200, 30)
+	assert.NilError(t, err)
+	select {
+	case m := <-system.Written:
+		t.Fatalf("kW limit of an uncommanded pv was written: %v", m.Payload())
+	case <-time.After(50 * time.Millisecond):
+	}
+	for _, pid := range []uuid.UUID{pv1, pv2} {
+		assert.NilError(t, h.operator.Control(msg.New(uuid.New(), msg.Control, pv.MachineControl{Run: true, KVAR: 2}).WithTarget(pid)))
+		<-system.Written
+	}
+
+	_, err = client.WriteSingleRegister(200, 30)
+	assert.NilError(t, err)
+	limits := make(map[uuid.UUID]interface{})
+	for len(limits) < 2 {
+		select {
+		case m := <-system.Written:
+			limits[m.Target()] = m.Payload()
+		case <-time.After(time.Second):
+			t.Fatal("kW limit was not written")
+		}
+	}
+	assert.DeepEqual(t, limits, map[uuid.UUID]interface{}{
+		pv1: pv.MachineControl{Run: true, KWLimit: 15, KVAR: 2},
+		pv2: pv.MachineControl{Run: true, KWLimit: 15, KVAR: 2},
+	})
+
+	_, err = client.WriteSingleCoil(11, 0x0000)
+	assert.NilError(t, err)
+	written = <-system.Written
+	assert.Equal(t, written.Payload(), grid.MachineControl{CloseIntertie: true})
+}
+
+func TestWriteReadOnly(t *testing.T) {
+	system := mocksystem.New()
+	h, client, closeClient := newHandler(t, system)
+	defer closeClient()
+	go h.Process()
+	defer h.StopProcess()
+
+	_, err := client.WriteSingleRegister(3, 1)
+	assert.ErrorContains(t, err, "exception '2'")
+}
+
+func TestBadConfig(t *testing.T) {
+	tests := map[string]string{
+		`{"Points": [{"Field": "KW", "Register": {"Name": "KW"}}]}`:                                                   "modbus server: point KW: no asset or archetype",
+		`{"Points": [{"Archetype": "pv", "Aggregate": "median", "Field": "KW", "Register": {"Name": "KW"}}]}`:         "modbus server: point KW: unsupported aggregate median",
+		`{"Controls": [{"Asset": "ess", "Type": "ess.MachineControl", "Field": "Volts", "Register": {"Name": "V"}}]}`: "modbus server: control V: ess.MachineControl has no field Volts",
+		`{"Controls": [{"Asset": "ess", "Type": "ess.Status", "Field": "KW", "Register": {"Name": "KW"}}]}`:           "modbus server: control KW: unsupported control type ess.Status",
+		`{"Controls": [{"Asset": "ess", "Type": "ess.MachineControl", "Field": "KW", "Register": {"Name": "KW"}}]}`:   "modbus server: ess ess.MachineControl does not map Run, KVAR, Gridform",
+	}
+	for jsonConfig, want := range tests {
+		path := configFile(t, jsonConfig)
+		_, err := New(path, mocksystem.New())
+		os.Remove(path)
+		assert.Error(t, err, want)
+	}
+}
@@ -6,55 +6,76 @@ import (
 	"io/ioutil"
 	"log"
 	"sync"
+	"time"
 
 	"github.com/google/uuid"
+	"github.com/ohowland/cgc_core/internal/pkg/asset"
 	"github.com/ohowland/cgc_core/internal/pkg/msg"
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
+// Handler writes the latest status and config of every asset to the assetStatus and
+// assetConfig collections, and the state of each asset alarm to the assetAlarm
+// collection. With History enabled every status sample is also appended to the history
+// collection. Writes are batched, and buffered while the database is unreachable; the
+// handler reconnects with exponential backoff. The database is written from its own
+// goroutine, so a slow or unreachable database never holds up the system publisher.
 type Handler struct {
 	mux    *sync.Mutex
 	inbox  <-chan msg.Msg
 	pid    uuid.UUID
 	config config
+	store  store
 	stop   chan bool
 }
 
+// config durations are in milliseconds. BufferSize bounds the number of history
+// samples held while disconnected, the oldest samples are dropped first.
 type config struct {
-	URI      string `json:"URI"`
-	Database string `json:"Database"`
-	Port     string `json:"Port"`
+	URI               string `json:"URI"`
+	Database          string `json:"Database"`
+	Port              string `json:"Port"`
+	History           bool   `json:"History"`
+	HistoryCollection string `json:"HistoryCollection"`
+	BatchSize         int    `json:"BatchSize"`
+	FlushInterval     int    `json:"FlushInterval"`
+	BufferSize        int    `json:"BufferSize"`
+	MaxBackoff        int    `json:"MaxBackoff"`
 }
 
-func redirectMsg(chIn <-chan msg.Msg, chOut chan<- msg.Msg) {
-	for m := range chIn {
-		chOut <- m
-	}
-}
+const (
+	statusCollection = "assetStatus"
+	configCollection = "assetConfig"
+	alarmCollection  = "assetAlarm"
+	minBackoff       = 500 * time.Millisecond
+	writeTimeout     = 10 * time.Second
+)
 
 func New(configPath string, system msg.Publisher) (Handler, error) {
 	jsonConfig, err := ioutil.ReadFile(configPath)
 	if err != nil {
 		return Handler{}, err
 	}
-	cfg := config{}
+	cfg := config{
+		HistoryCollection: "assetHistory",
+		BatchSize:         100,
+		FlushInterval:     1000,
+		BufferSize:        10000,
+		MaxBackoff:        30000,
+	}
 	if err := json.Unmarshal(jsonConfig, &cfg); err != nil {
 		return Handler{}, err
 	}
 
 	pid, _ := uuid.NewUUID()
 
-	inbox := make(chan msg.Msg, 50)
-
-	chStatus, err := system.Subscribe(pid, msg.Status)
-	go redirectMsg(chStatus, inbox)
-
-	chConfig, err := system.Subscribe(pid, msg.Config)
-	go redirectMsg(chConfig, inbox)
-
-	if err := json.Unmarshal(jsonConfig, &cfg); err != nil {
+	inbox, err := system.SubscribeFiltered(pid, msg.Subscription{
+		Topics: []msg.Topic{msg.Status, msg.Config},
+		Policy: msg.Policy{Buffer: 50, Delivery: msg.LatestValue},
+	})
+	if err != nil {
 		return Handler{}, err
 	}
 
@@ -65,77 +86,308 @@ func New(configPath string, system msg.Publisher) (Handler, error) {
 		inbox:  inbox,
 		pid:    pid,
 		config: cfg,
+		store:  &mongoStore{uri: cfg.URI + ":" + cfg.Port, database: cfg.Database},
 		stop:   stop,
 	}, nil
 }
 
-func msgToBSON(m msg.Msg) bson.D {
-	//TODO: PID should be written as a binary of subtype 0x04 (UUID standard).
-	// currently written as a string.
-	return bson.D{
-		{"$set", bson.M{
-			"pid":  m.PID().String(),
-			"data": m.Payload(),
-		}},
+// pidToBSON encodes the PID as a binary UUID (subtype 0x04)
+func pidToBSON(pid uuid.UUID) primitive.Binary {
+	return primitive.Binary{Subtype: 0x04, Data: pid[:]}
+}
+
+func msgToDocument(m msg.Msg) bson.M {
+	return bson.M{
+		"pid":       pidToBSON(m.PID()),
+		"type":      m.Type(),
+		"timestamp": m.Timestamp(),
+		"seq":       int64(m.Sequence()),
+		"data":      m.Payload(),
 	}
 }
 
+func msgToBSON(m msg.Msg) bson.D {
+	return bson.D{{Key: "$set", Value: msgToDocument(m)}}
+}
+
+func alarmToBSON(m msg.Msg, alarm asset.Alarm) bson.D {
+	return bson.D{{Key: "$set", Value: bson.M{
+		"pid":       pidToBSON(m.PID()),
+		"name":      alarm.Name,
+		"active":    alarm.Active,
+		"timestamp": m.Timestamp(),
+		"seq":       int64(m.Sequence()),
+	}}}
+}
+
 func (h *Handler) StopProcess() {
 	h.stop <- true
 }
 
-func (h Handler) Process() {
-	//TODO: Handle reconnection to the MongoDB resource
-	client, err := mongo.NewClient(options.Client().ApplyURI(h.config.URI + ":" + h.config.Port))
-	if err != nil {
-		log.Println(err)
+// alarmKey identifies an alarm of an asset
+type alarmKey struct {
+	pid  uuid.UUID
+	name string
+}
+
+// buffer holds the writes waiting for the next flush. Latest values are coalesced
+// per asset, alarms per asset and alarm name, history samples are kept in order.
+type buffer struct {
+	status  map[uuid.UUID]msg.Msg
+	config  map[uuid.UUID]msg.Msg
+	alarm   map[alarmKey]msg.Msg
+	history []msg.Msg
+	size    int
+	dropped int
+}
+
+func newBuffer(size int) *buffer {
+	return &buffer{
+		status: make(map[uuid.UUID]msg.Msg),
+		config: make(map[uuid.UUID]msg.Msg),
+		alarm:  make(map[alarmKey]msg.Msg),
+		size:   size,
 	}
+}
 
-	//ctx, _ := context.WithTimeout(context.TODO(), 20*time.Second)
-	ctx := context.TODO()
-	err = client.Connect(ctx)
-	if err != nil {
-		log.Println(err)
+func (b *buffer) add(m msg.Msg, history bool) {
+	switch m.Topic() {
+	case msg.Status:
+		// alarms share the status topic, but are not the status of the asset
+		if alarm, ok := m.Payload().(asset.Alarm); ok {
+			b.alarm[alarmKey{m.PID(), alarm.Name}] = m
+		} else {
+			b.status[m.PID()] = m
+		}
+		if history {
+			b.record(m)
+		}
+	case msg.Config:
+		b.config[m.PID()] = m
+	}
+}
+
+// record appends the history sample, dropping the oldest sample when full
+func (b *buffer) record(m msg.Msg) {
+	if len(b.history) >= b.size && b.size > 0 {
+		b.history = b.history[1:]
+		b.dropped++
+	}
+	b.history = append(b.history, m)
+}
+
+func (b *buffer) len() int {
+	return len(b.status) + len(b.config) + len(b.alarm) + len(b.history)
+}
+
+// take returns the buffered writes and empties the buffer
+func (b *buffer) take() *buffer {
+	taken := *b
+	b.status = make(map[uuid.UUID]msg.Msg)
+	b.config = make(map[uuid.UUID]msg.Msg)
+	b.alarm = make(map[alarmKey]msg.Msg)
+	b.history = nil
+	b.dropped = 0
+	return &taken
+}
+
+// restore returns writes that failed to the buffer. Values received since the writes
+// were taken are newer, and are kept.
+func (b *buffer) restore(failed *buffer) {
+	for pid, m := range failed.status {
+		if _, ok := b.status[pid]; !ok {
+			b.status[pid] = m
+		}
+	}
+	for pid, m := range failed.config {
+		if _, ok := b.config[pid]; !ok {
+			b.config[pid] = m
+		}
+	}
+	for key, m := range failed.alarm {
+		if _, ok := b.alarm[key]; !ok {
+			b.alarm[key] = m
+		}
+	}
+	newer := b.history
+	b.history = nil
+	b.dropped += failed.dropped
+	for _, m := range append(failed.history, newer...) {
+		b.record(m)
+	}
+}
+
+// clear empties the writes of the collection, once they are written
+func (b *buffer) clear(collection string, historyCollection string) {
+	switch collection {
+	case statusCollection:
+		b.status = make(map[uuid.UUID]msg.Msg)
+	case configCollection:
+		b.config = make(map[uuid.UUID]msg.Msg)
+	case alarmCollection:
+		b.alarm = make(map[alarmKey]msg.Msg)
+	case historyCollection:
+		b.history = nil
+	}
+}
+
+// models returns the bulk writes for each collection
+func (b *buffer) models(historyCollection string) map[string][]mongo.WriteModel {
+	models := make(map[string][]mongo.WriteModel)
+	for pid, m := range b.status {
+		models[statusCollection] = append(models[statusCollection], upsert(pid, m))
+	}
+	for pid, m := range b.config {
+		models[configCollection] = append(models[configCollection], upsert(pid, m))
 	}
-	defer client.Disconnect(ctx)
+	for key, m := range b.alarm {
+		model := mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"pid": pidToBSON(key.pid), "name": key.name}).
+			SetUpdate(alarmToBSON(m, m.Payload().(asset.Alarm))).
+			SetUpsert(true)
+		models[alarmCollection] = append(models[alarmCollection], model)
+	}
+	for _, m := range b.history {
+		models[historyCollection] = append(models[historyCollection], mongo.NewInsertOneModel().SetDocument(msgToDocument(m)))
+	}
+	return models
+}
+
+func upsert(pid uuid.UUID, m msg.Msg) mongo.WriteModel {
+	return mongo.NewUpdateOneModel().
+		SetFilter(bson.M{"pid": pidToBSON(pid)}).
+		SetUpdate(msgToBSON(m)).
+		SetUpsert(true)
+}
+
+// Process buffers the received messages until they are written by the writer
+// goroutine. Receiving does not wait on the database.
+func (h Handler) Process() {
+	buf := newBuffer(h.config.BufferSize)
+	mux := &sync.Mutex{}
+	kick := make(chan struct{}, 1)
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		h.write(buf, mux, kick, done)
+		close(stopped)
+	}()
 
-	client.Database(h.config.Database).Collection("assetStatus").Drop(ctx)
-	client.Database(h.config.Database).Collection("assetConfig").Drop(ctx)
 loop:
 	for {
 		select {
-		case m := <-h.inbox:
-			switch m.Topic() {
-			case msg.Status:
-				opts := options.Update().SetUpsert(true)
-				_, err = client.Database(h.config.Database).Collection("assetStatus").UpdateOne(
-					ctx,
-					bson.M{"pid": m.PID()},
-					msgToBSON(m),
-					opts,
-				)
-
-				if err != nil {
-					log.Fatal(err)
-				}
-
-			case msg.Config:
-				log.Println("[Mongo] Config:", m)
-				opts := options.Update().SetUpsert(true)
-				_, err = client.Database(h.config.Database).Collection("assetConfig").UpdateOne(
-					ctx,
-					bson.M{"pid": m.PID()},
-					msgToBSON(m),
-					opts,
-				)
-
-				if err != nil {
-					log.Fatal(err)
+		case m, ok := <-h.inbox:
+			if !ok {
+				break loop
+			}
+			mux.Lock()
+			buf.add(m, h.config.History)
+			full := buf.len() >= h.config.BatchSize
+			mux.Unlock()
+			if full {
+				select {
+				case kick <- struct{}{}:
+				default:
 				}
 			}
 		case <-h.stop:
 			break loop
 		}
 	}
+	close(done)
+	<-stopped
 	log.Println("[Mongo] Process Shutdown")
 }
+
+// write flushes the buffer each FlushInterval, or when kicked by a full batch, until
+// done is closed. Writes that fail while disconnected are returned to the buffer.
+func (h Handler) write(buf *buffer, mux *sync.Mutex, kick <-chan struct{}, done <-chan struct{}) {
+	ticker := time.NewTicker(time.Duration(h.config.FlushInterval) * time.Millisecond)
+	defer ticker.Stop()
+
+	connected := false
+	backoff := minBackoff
+	retry := time.Now()
+
+	connect := func() {
+		if connected || time.Now().Before(retry) {
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), writeTimeout)
+		defer cancel()
+		if err := h.store.connect(ctx, h.historyCollection()); err != nil {
+			log.Printf("[Mongo] connect failed, retry in %v: %v", backoff, err)
+			retry = time.Now().Add(backoff)
+			backoff *= 2
+			if max := time.Duration(h.config.MaxBackoff) * time.Millisecond; backoff > max {
+				backoff = max
+			}
+			return
+		}
+		log.Println("[Mongo] connected")
+		connected = true
+		backoff = minBackoff
+	}
+
+	flush := func() {
+		connect()
+		if !connected {
+			return
+		}
+		mux.Lock()
+		batch := buf.take()
+		mux.Unlock()
+		if batch.len() == 0 {
+			return
+		}
+		if batch.dropped > 0 {
+			log.Printf("[Mongo] dropped %v history samples while disconnected", batch.dropped)
+			batch.dropped = 0
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), writeTimeout)
+		defer cancel()
+		for collection, models := range batch.models(h.config.HistoryCollection) {
+			if err := h.store.bulkWrite(ctx, collection, models); err != nil {
+				if h.store.ping(ctx) != nil {
+					// keep the rest of the batch and write it once reconnected
+					log.Println("[Mongo] disconnected:", err)
+					h.store.disconnect(ctx)
+					connected = false
+					mux.Lock()
+					buf.restore(batch)
+					mux.Unlock()
+					return
+				}
+				log.Printf("[Mongo] %v write failed: %v", collection, err)
+				continue
+			}
+			batch.clear(collection, h.config.HistoryCollection)
+		}
+	}
+
+	connect()
+loop:
+	for {
+		select {
+		case <-kick:
+			flush()
+		case <-ticker.C:
+			flush()
+		case <-done:
+			break loop
+		}
+	}
+	flush()
+
+	ctx, cancel := context.WithTimeout(context.Background(), writeTimeout)
+	defer cancel()
+	h.store.disconnect(ctx)
+}
+
+func (h Handler) historyCollection() string {
+	if !h.config.History {
+		return ""
+	}
+	return h.config.HistoryCollection
+}
@@ -1 +1,252 @@
 package mongodb
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/ohowland/cgc_core/internal/pkg/asset"
+	"github.com/ohowland/cgc_core/internal/pkg/asset/ess"
+	"github.com/ohowland/cgc_core/internal/pkg/msg"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"gotest.tools/assert"
+)
+
+// fakeStore records bulk writes. While down, connects and writes fail. While hung,
+// connects wait until the context expires. A store with writes left goes down after
+// that many more writes.
+type fakeStore struct {
+	mux        sync.Mutex
+	down       bool
+	hung       bool
+	connects   int
+	writesLeft int
+	writes     map[string][]mongo.WriteModel
+}
+
+func newFakeStore(down bool) *fakeStore {
+	return &fakeStore{down: down, writes: make(map[string][]mongo.WriteModel)}
+}
+
+var errDown = errors.New("server selection timeout")
+
+func (s *fakeStore) connect(ctx context.Context, history string) error {
+	s.mux.Lock()
+	hung := s.hung
+	s.mux.Unlock()
+	if hung {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.connects++
+	if s.down {
+		return errDown
+	}
+	return nil
+}
+
+func (s *fakeStore) bulkWrite(ctx context.Context, collection string, models []mongo.WriteModel) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if s.down {
+		return errDown
+	}
+	s.writes[collection] = append(s.writes[collection], models...)
+	if s.writesLeft > 0 {
+		s.writesLeft--
+		s.down = s.writesLeft == 0
+	}
+	return nil
+}
+
+func (s *fakeStore) ping(ctx context.Context) error {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	if s.down {
+		return errDown
+	}
+	return nil
+}
+
+func (s *fakeStore) disconnect(ctx context.Context) {}
+
+func (s *fakeStore) setDown(down bool) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.down = down
+}
+
+func (s *fakeStore) count(collection string) int {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return len(s.writes[collection])
+}
+
+func newHandler(t *testing.T, pub msg.Publisher, s store) Handler {
+	h, err := New("./web_handler_config.json", pub)
+	assert.NilError(t, err)
+	h.store = s
+	h.config.History = true
+	h.config.FlushInterval = 20
+	h.config.MaxBackoff = 20
+	return h
+}
+
+// eventually waits up to a second for the condition to hold
+func eventually(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestMsgToBSON(t *testing.T) {
+	pid := uuid.New()
+	doc := msgToBSON(msg.New(pid, msg.Status, ess.Status{}))
+
+	set := doc[0].Value.(bson.M)
+	bin := set["pid"].(primitive.Binary)
+	assert.Equal(t, bin.Subtype, byte(0x04))
+	assert.DeepEqual(t, bin.Data, pid[:])
+	assert.Equal(t, set["type"], "ess.Status")
+}
+
+func TestBufferCoalescesLatestValues(t *testing.T) {
+	b := newBuffer(2)
+	pid := uuid.New()
+	for i := 0; i < 3; i++ {
+		b.add(msg.New(pid, msg.Status, ess.Status{}), true)
+	}
+	b.add(msg.New(pid, msg.Config, ess.Config{}), true)
+
+	models := b.models("history")
+	assert.Equal(t, len(models[statusCollection]), 1)
+	assert.Equal(t, len(models[configCollection]), 1)
+	assert.Equal(t, len(models["history"]), 2)
+	assert.Equal(t, b.dropped, 1)
+}
+
+func TestBufferKeepsAlarmsOutOfStatus(t *testing.T) {
+	b := newBuffer(10)
+	pid := uuid.New()
+	b.add(msg.New(pid, msg.Status, ess.Status{}), false)
+	b.add(msg.New(pid, msg.Status, asset.Alarm{Name: asset.ControlTimeout, Active: true}), false)
+	b.add(msg.New(pid, msg.Status, asset.Alarm{Name: "Other", Active: true}), false)
+
+	assert.Equal(t, b.status[pid].Type(), "ess.Status")
+	models := b.models("history")
+	assert.Equal(t, len(models[statusCollection]), 1)
+	assert.Equal(t, len(models[alarmCollection]), 2)
+}
+
+func TestBufferRestoreKeepsNewer(t *testing.T) {
+	b := newBuffer(3)
+	pid := uuid.New()
+	for i := 0; i < 2; i++ {
+		b.add(msg.New(pid, msg.Status, i), true)
+	}
+	failed := b.take()
+	assert.Equal(t, b.len(), 0)
+
+	for i := 2; i < 4; i++ {
+		b.add(msg.New(pid, msg.Status, i), true)
+	}
+	b.restore(failed)
+
+	assert.Equal(t, b.status[pid].Payload(), 3)
+	assert.Equal(t, len(b.history), 3)
+	assert.Equal(t, b.history[0].Payload(), 1)
+	assert.Equal(t, b.dropped, 1)
+}
+
+func TestProcessWrites(t *testing.T) {
+	pub := msg.NewPublisher(uuid.New())
+	s := newFakeStore(false)
+	h := newHandler(t, pub, s)
+	go h.Process()
+	defer h.StopProcess()
+
+	pid := uuid.New()
+	pub.Forward(msg.New(pid, msg.Config, ess.Config{}))
+	pub.Forward(msg.New(pid, msg.Status, ess.Status{}))
+	pub.Forward(msg.New(pid, msg.Status, ess.Status{}))
+
+	eventually(t, func() bool { return s.count("assetHistory") == 2 })
+	assert.Assert(t, s.count(statusCollection) >= 1)
+	assert.Equal(t, s.count(configCollection), 1)
+}
+
+func TestProcessBuffersWhileDisconnected(t *testing.T) {
+	pub := msg.NewPublisher(uuid.New())
+	s := newFakeStore(true)
+	h := newHandler(t, pub, s)
+	go h.Process()
+	defer h.StopProcess()
+
+	pid := uuid.New()
+	pub.Forward(msg.New(pid, msg.Status, ess.Status{}))
+	pub.Forward(msg.New(pid, msg.Status, ess.Status{}))
+
+	// reconnect attempts continue with backoff
+	eventually(t, func() bool {
+		s.mux.Lock()
+		defer s.mux.Unlock()
+		return s.connects >= 3
+	})
+	assert.Equal(t, s.count("assetHistory"), 0)
+
+	s.setDown(false)
+	eventually(t, func() bool { return s.count("assetHistory") == 2 })
+	assert.Equal(t, s.count(statusCollection), 1)
+}
+
+func TestProcessRestoresUnwrittenCollections(t *testing.T) {
+	pub := msg.NewPublisher(uuid.New())
+	s := newFakeStore(false)
+	s.writesLeft = 1
+	h := newHandler(t, pub, s)
+	go h.Process()
+	defer h.StopProcess()
+
+	// the status and history writes are flushed together, and the store goes down
+	// after the first of them
+	pub.Forward(msg.New(uuid.New(), msg.Status, ess.Status{}))
+	eventually(t, func() bool {
+		s.mux.Lock()
+		defer s.mux.Unlock()
+		return s.down && s.connects >= 2
+	})
+
+	s.setDown(false)
+	eventually(t, func() bool { return s.count("assetHistory") == 1 && s.count(statusCollection) == 1 })
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, s.count("assetHistory"), 1, "history was written twice")
+	assert.Equal(t, s.count(statusCollection), 1)
+}
+
+func TestProcessDoesNotStallPublisher(t *testing.T) {
+	pub := msg.NewPublisher(uuid.New())
+	s := newFakeStore(false)
+	s.hung = true
+	h := newHandler(t, pub, s)
+	go h.Process()
+
+	start := time.Now()
+	pid := uuid.New()
+	for i := 0; i < 200; i++ {
+		pub.Forward(msg.New(pid, msg.Status, ess.Status{}))
+	}
+	assert.Assert(t, time.Since(start) < time.Second)
+}
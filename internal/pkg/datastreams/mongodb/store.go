@@ -0,0 +1,67 @@
+package mongodb
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// store is the database written by the handler
+type store interface {
+	// connect opens the connection and prepares the history collection, if named
+	connect(ctx context.Context, history string) error
+	bulkWrite(ctx context.Context, collection string, models []mongo.WriteModel) error
+	ping(ctx context.Context) error
+	disconnect(ctx context.Context)
+}
+
+type mongoStore struct {
+	uri      string
+	database string
+	client   *mongo.Client
+}
+
+func (s *mongoStore) connect(ctx context.Context, history string) error {
+	client, err := mongo.NewClient(options.Client().ApplyURI(s.uri))
+	if err != nil {
+		return err
+	}
+	if err := client.Connect(ctx); err != nil {
+		return err
+	}
+	if err := client.Ping(ctx, nil); err != nil {
+		client.Disconnect(ctx)
+		return err
+	}
+
+	if history != "" {
+		index := mongo.IndexModel{Keys: bson.D{{Key: "pid", Value: 1}, {Key: "timestamp", Value: 1}}}
+		_, err := client.Database(s.database).Collection(history).Indexes().CreateOne(ctx, index)
+		if err != nil {
+			client.Disconnect(ctx)
+			return err
+		}
+	}
+
+	s.client = client
+	return nil
+}
+
+func (s *mongoStore) bulkWrite(ctx context.Context, collection string, models []mongo.WriteModel) error {
+	opts := options.BulkWrite().SetOrdered(false)
+	_, err := s.client.Database(s.database).Collection(collection).BulkWrite(ctx, models, opts)
+	return err
+}
+
+func (s *mongoStore) ping(ctx context.Context) error {
+	return s.client.Ping(ctx, nil)
+}
+
+func (s *mongoStore) disconnect(ctx context.Context) {
+	if s.client != nil {
+		s.client.Disconnect(ctx)
+		s.client = nil
+	}
+}
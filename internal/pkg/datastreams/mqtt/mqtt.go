@@ -1,11 +1,273 @@
 package mqtt
 
 import (
-	"fmt"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"log"
+	"sync"
+	"text/template"
+	"time"
 
-	mqtt "github.com/eclipse/paho.mqtt.golang"
+	paho "github.com/eclipse/paho.mqtt.golang"
+	"github.com/google/uuid"
+	"github.com/ohowland/cgc_core/internal/pkg/asset"
+	"github.com/ohowland/cgc_core/internal/pkg/msg"
+	"github.com/ohowland/cgc_core/internal/pkg/operator"
 )
 
-var msgPubHandler mqtt.MessageHandler = func(client mqtt.Client, msg mqtt.Message) {
-	fmt.Printf("recieved: %s from topic %s\n", msg.Payload, msg.Topic())
+// Handler publishes the system status and config to an MQTT broker and accepts
+// operator setpoint commands. Topics are text/template strings over the fields of
+// topicData, e.g. "cgc/{{.Site}}/{{.PID}}/status". Alarms are published on their own
+// topic and are never retained, so the retained status of an asset is its status.
+//
+// A command is a msg JSON envelope with the target asset PID, the control Type
+// (e.g. "ess.MachineControl") and the Payload. Any message on the release topic
+// returns control to dispatch.
+type Handler struct {
+	mux       *sync.Mutex
+	inbox     <-chan msg.Msg
+	pid       uuid.UUID
+	config    config
+	topics    topics
+	operator  *operator.Operator
+	newClient func(*paho.ClientOptions) paho.Client
+	stop      chan bool
+}
+
+type config struct {
+	Server       string `json:"Server"`
+	ClientID     string `json:"ClientID"`
+	Username     string `json:"Username"`
+	Password     string `json:"Password"`
+	Site         string `json:"Site"`
+	StatusTopic  string `json:"StatusTopic"`
+	ConfigTopic  string `json:"ConfigTopic"`
+	AlarmTopic   string `json:"AlarmTopic"`
+	CommandTopic string `json:"CommandTopic"`
+	ReleaseTopic string `json:"ReleaseTopic"`
+	QoS          byte   `json:"QoS"`
+	Retain       bool   `json:"Retain"`
+}
+
+type topics struct {
+	status  *template.Template
+	config  *template.Template
+	alarm   *template.Template
+	command string
+	release string
+}
+
+// topicData is the input to the topic templates
+type topicData struct {
+	Site string
+	PID  string
+	Type string
+}
+
+const timeout = 5 * time.Second
+
+func (h Handler) PID() uuid.UUID {
+	return h.pid
+}
+
+func New(configPath string, system operator.System) (Handler, error) {
+	jsonConfig, err := ioutil.ReadFile(configPath)
+	if err != nil {
+		return Handler{}, err
+	}
+	cfg := config{
+		Server:       "tcp://localhost:1883",
+		ClientID:     "cgc",
+		Site:         "site",
+		StatusTopic:  "cgc/{{.Site}}/{{.PID}}/status",
+		ConfigTopic:  "cgc/{{.Site}}/{{.PID}}/config",
+		AlarmTopic:   "cgc/{{.Site}}/{{.PID}}/alarm",
+		CommandTopic: "cgc/{{.Site}}/control",
+		ReleaseTopic: "cgc/{{.Site}}/control/release",
+	}
+	if err := json.Unmarshal(jsonConfig, &cfg); err != nil {
+		return Handler{}, err
+	}
+	if cfg.QoS > 2 {
+		return Handler{}, errors.New("mqtt QoS must be 0, 1 or 2")
+	}
+
+	t, err := parseTopics(cfg)
+	if err != nil {
+		return Handler{}, err
+	}
+
+	pid, _ := uuid.NewUUID()
+
+	inbox, err := system.SubscribeFiltered(pid, msg.Subscription{
+		Topics: []msg.Topic{msg.Status, msg.Config},
+		Policy: msg.Policy{Buffer: 50, Delivery: msg.LatestValue},
+	})
+	if err != nil {
+		return Handler{}, err
+	}
+
+	op, err := operator.New(system)
+	if err != nil {
+		return Handler{}, err
+	}
+
+	return Handler{
+		mux:       &sync.Mutex{},
+		inbox:     inbox,
+		pid:       pid,
+		config:    cfg,
+		topics:    t,
+		operator:  op,
+		newClient: paho.NewClient,
+		stop:      make(chan bool),
+	}, nil
+}
+
+func parseTopics(cfg config) (topics, error) {
+	statusTopic, err := template.New("status").Parse(cfg.StatusTopic)
+	if err != nil {
+		return topics{}, err
+	}
+	configTopic, err := template.New("config").Parse(cfg.ConfigTopic)
+	if err != nil {
+		return topics{}, err
+	}
+	alarmTopic, err := template.New("alarm").Parse(cfg.AlarmTopic)
+	if err != nil {
+		return topics{}, err
+	}
+
+	// inbound topics are fixed for the site
+	site := topicData{Site: cfg.Site}
+	commandTopic, err := render("command", cfg.CommandTopic, site)
+	if err != nil {
+		return topics{}, err
+	}
+	releaseTopic, err := render("release", cfg.ReleaseTopic, site)
+	if err != nil {
+		return topics{}, err
+	}
+	return topics{statusTopic, configTopic, alarmTopic, commandTopic, releaseTopic}, nil
+}
+
+func render(name string, text string, data topicData) (string, error) {
+	t, err := template.New(name).Parse(text)
+	if err != nil {
+		return "", err
+	}
+	return execute(t, data)
+}
+
+func execute(t *template.Template, data topicData) (string, error) {
+	buf := bytes.Buffer{}
+	if err := t.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// topic returns the topic the message is published on, and whether it is retained.
+// Alarms share the status topic of the system, but are events rather than state.
+func (h Handler) topic(m msg.Msg) (string, bool, error) {
+	data := topicData{Site: h.config.Site, PID: m.PID().String(), Type: m.Type()}
+	if _, ok := m.Payload().(asset.Alarm); ok {
+		topic, err := execute(h.topics.alarm, data)
+		return topic, false, err
+	}
+	switch m.Topic() {
+	case msg.Status:
+		topic, err := execute(h.topics.status, data)
+		return topic, h.config.Retain, err
+	case msg.Config:
+		topic, err := execute(h.topics.config, data)
+		return topic, h.config.Retain, err
+	default:
+		return "", false, errors.New("mqtt handler does not publish " + m.Topic().String())
+	}
+}
+
+func (h *Handler) StopProcess() {
+	h.stop <- true
+}
+
+// Process connects to the broker and publishes system messages until stopped
+func (h Handler) Process() {
+	opts := paho.NewClientOptions().
+		AddBroker(h.config.Server).
+		SetClientID(h.config.ClientID).
+		SetUsername(h.config.Username).
+		SetPassword(h.config.Password).
+		SetAutoReconnect(true).
+		SetOnConnectHandler(h.subscribe)
+
+	client := h.newClient(opts)
+	if t := client.Connect(); t.Wait() && t.Error() != nil {
+		log.Println("[MQTT]", t.Error())
+		return
+	}
+	defer client.Disconnect(250)
+
+loop:
+	for {
+		select {
+		case m, ok := <-h.inbox:
+			if !ok {
+				break loop
+			}
+			topic, retain, err := h.topic(m)
+			if err != nil {
+				log.Println("[MQTT]", err)
+				continue
+			}
+			data, err := json.Marshal(m)
+			if err != nil {
+				log.Println("[MQTT]", err)
+				continue
+			}
+			t := client.Publish(topic, h.config.QoS, retain, data)
+			if t.WaitTimeout(timeout) && t.Error() != nil {
+				log.Println("[MQTT]", t.Error())
+			}
+		case <-h.stop:
+			break loop
+		}
+	}
+
+	if err := h.operator.Release(); err != nil {
+		log.Println("[MQTT]", err)
+	}
+	log.Println("[MQTT] Process Shutdown")
+}
+
+// subscribe is called on every connection to the broker, since a clean session
+// does not keep subscriptions across a reconnect.
+func (h Handler) subscribe(client paho.Client) {
+	t := client.SubscribeMultiple(map[string]byte{
+		h.topics.command: h.config.QoS,
+		h.topics.release: h.config.QoS,
+	}, h.command)
+	if t.WaitTimeout(timeout) && t.Error() != nil {
+		log.Println("[MQTT]", t.Error())
+	}
+}
+
+func (h Handler) command(client paho.Client, m paho.Message) {
+	switch m.Topic() {
+	case h.topics.release:
+		if err := h.operator.Release(); err != nil {
+			log.Println("[MQTT] release command:", err)
+		}
+	case h.topics.command:
+		request := msg.Msg{}
+		err := json.Unmarshal(m.Payload(), &request)
+		if err == nil {
+			err = h.operator.Control(request)
+		}
+		if err != nil {
+			log.Println("[MQTT] control command:", err)
+		}
+	}
 }
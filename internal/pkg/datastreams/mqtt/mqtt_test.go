@@ -0,0 +1,190 @@
+package mqtt
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+	"github.com/google/uuid"
+	"github.com/ohowland/cgc_core/internal/pkg/asset"
+	"github.com/ohowland/cgc_core/internal/pkg/asset/ess"
+	"github.com/ohowland/cgc_core/internal/pkg/control"
+	"github.com/ohowland/cgc_core/internal/pkg/mocksystem"
+	"github.com/ohowland/cgc_core/internal/pkg/msg"
+	"gotest.tools/assert"
+)
+
+type token struct{}
+
+// configFile writes the handler config to a temporary file and returns its path. The
+// caller removes the file.
+func configFile(t *testing.T, config string) string {
+	t.Helper()
+	f, err := ioutil.TempFile("", "handler_config")
+	assert.NilError(t, err)
+	defer f.Close()
+	_, err = f.WriteString(config)
+	assert.NilError(t, err)
+	return f.Name()
+}
+
+func (t token) Wait() bool                       { return true }
+func (t token) WaitTimeout(d time.Duration) bool { return true }
+func (t token) Done() <-chan struct{}            { ch := make(chan struct{}); close(ch); return ch }
+func (t token) Error() error                     { return nil }
+
+type message struct {
+	topic   string
+	payload []byte
+	qos     byte
+	retain  bool
+}
+
+func (m message) Duplicate() bool   { return false }
+func (m message) Qos() byte         { return m.qos }
+func (m message) Retained() bool    { return m.retain }
+func (m message) Topic() string     { return m.topic }
+func (m message) MessageID() uint16 { return 0 }
+func (m message) Payload() []byte   { return m.payload }
+func (m message) Ack()              {}
+
+// fakeClient is an in-process stand in for the broker connection
+type fakeClient struct {
+	paho.Client
+	mux       sync.Mutex
+	opts      *paho.ClientOptions
+	filters   map[string]byte
+	handler   paho.MessageHandler
+	published chan message
+}
+
+func (c *fakeClient) Connect() paho.Token {
+	c.opts.OnConnect(c)
+	return token{}
+}
+
+func (c *fakeClient) Disconnect(quiesce uint) {}
+
+func (c *fakeClient) Publish(topic string, qos byte, retained bool, payload interface{}) paho.Token {
+	c.published <- message{topic, payload.([]byte), qos, retained}
+	return token{}
+}
+
+func (c *fakeClient) SubscribeMultiple(filters map[string]byte, callback paho.MessageHandler) paho.Token {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.filters = filters
+	c.handler = callback
+	return token{}
+}
+
+func (c *fakeClient) deliver(topic string, payload []byte) {
+	c.mux.Lock()
+	handler := c.handler
+	c.mux.Unlock()
+	handler(c, message{topic: topic, payload: payload})
+}
+
+func newHandler(t *testing.T, system *mocksystem.System) (Handler, *fakeClient) {
+	path := configFile(t, `{"Site": "test", "StatusTopic": "site/{{.Site}}/{{.Type}}/{{.PID}}", "QoS": 1, "Retain": true}`)
+	defer os.Remove(path)
+	h, err := New(path, system)
+	assert.NilError(t, err)
+
+	client := &fakeClient{published: make(chan message, 10)}
+	h.newClient = func(opts *paho.ClientOptions) paho.Client {
+		client.opts = opts
+		return client
+	}
+	return h, client
+}
+
+func TestPublishStatus(t *testing.T) {
+	system := mocksystem.New()
+	h, client := newHandler(t, system)
+	go h.Process()
+	defer h.StopProcess()
+
+	pid := uuid.New()
+	system.Forward(msg.New(pid, msg.Status, ess.Status{Machine: ess.MachineStatus{KW: 12}}))
+
+	select {
+	case m := <-client.published:
+		assert.Equal(t, m.topic, fmt.Sprintf("site/test/ess.Status/%v", pid))
+		assert.Equal(t, m.qos, byte(1))
+		assert.Assert(t, m.retain)
+
+		received := msg.Msg{}
+		assert.NilError(t, json.Unmarshal(m.payload, &received))
+		assert.Equal(t, received.PID(), pid)
+	case <-time.After(time.Second):
+		t.Fatal("status was not published")
+	}
+}
+
+func TestPublishAlarm(t *testing.T) {
+	system := mocksystem.New()
+	h, client := newHandler(t, system)
+	go h.Process()
+	defer h.StopProcess()
+
+	pid := uuid.New()
+	system.Forward(msg.New(pid, msg.Status, asset.Alarm{Name: asset.ControlTimeout, Active: true}))
+
+	select {
+	case m := <-client.published:
+		assert.Equal(t, m.topic, fmt.Sprintf("cgc/test/%v/alarm", pid))
+		assert.Assert(t, !m.retain, "an alarm replaced the retained status")
+	case <-time.After(time.Second):
+		t.Fatal("alarm was not published")
+	}
+}
+
+func TestCommand(t *testing.T) {
+	system := mocksystem.New()
+	h, client := newHandler(t, system)
+	go h.Process()
+	defer h.StopProcess()
+
+	system.Forward(msg.New(uuid.New(), msg.Status, ess.Status{}))
+	<-client.published // connected and subscribed
+
+	assert.Equal(t, client.filters["cgc/test/control"], byte(1))
+	assert.Equal(t, client.filters["cgc/test/control/release"], byte(1))
+
+	target := uuid.New()
+	ctrl := ess.MachineControl{Run: true, KW: 40}
+	data, err := json.Marshal(msg.New(uuid.New(), msg.Control, ctrl).WithTarget(target))
+	assert.NilError(t, err)
+	client.deliver("cgc/test/control", data)
+
+	select {
+	case m := <-system.Written:
+		assert.Equal(t, m.Target(), target)
+		assert.Equal(t, m.Payload(), ctrl)
+	case <-time.After(time.Second):
+		t.Fatal("setpoint command was not written")
+	}
+	_, priority := system.OwnerOf(target)
+	assert.Equal(t, priority, control.Operator)
+
+	client.deliver("cgc/test/control/release", nil)
+	owner, _ := system.OwnerOf(target)
+	assert.Equal(t, owner, uuid.UUID{})
+}
+
+func TestBadTopicTemplate(t *testing.T) {
+	f, err := ioutil.TempFile("", "mqtt_config")
+	assert.NilError(t, err)
+	defer os.Remove(f.Name())
+	f.WriteString(`{"StatusTopic": "cgc/{{.Site"}`)
+	f.Close()
+
+	_, err = New(f.Name(), mocksystem.New())
+	assert.Assert(t, err != nil)
+}
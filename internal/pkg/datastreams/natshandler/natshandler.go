@@ -0,0 +1,214 @@
+package natshandler
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"strings"
+	"sync"
+
+	"github.com/google/uuid"
+	"github.com/nats-io/nats.go"
+	"github.com/ohowland/cgc_core/internal/pkg/msg"
+	"github.com/ohowland/cgc_core/internal/pkg/operator"
+)
+
+// Handler publishes the system status and config on NATS subjects
+//
+//	cgc.<site>.<pid>.status
+//	cgc.<site>.<pid>.config
+//
+// and accepts operator control requests on cgc.<site>.control. A request is a msg
+// JSON envelope with the target asset PID, the control Type (e.g. "ess.MachineControl")
+// and the Payload. A request on cgc.<site>.control.release returns control to dispatch.
+type Handler struct {
+	mux      *sync.Mutex
+	inbox    <-chan msg.Msg
+	configs  <-chan msg.Msg
+	pid      uuid.UUID
+	config   config
+	operator *operator.Operator
+	stop     chan bool
+}
+
+type config struct {
+	Server string `json:"Server"`
+	Site   string `json:"Site"`
+}
+
+// reply is returned to operator requests that set a reply subject
+type reply struct {
+	Error string `json:"Error,omitempty"`
+}
+
+// configSubscription delivers every config message. Config is published once, when a
+// node joins the system, so a dropped config is never replaced.
+var configSubscription = msg.Subscription{
+	Topics: []msg.Topic{msg.Config},
+	Policy: msg.Policy{Buffer: 16, Delivery: msg.Guaranteed},
+}
+
+func (h Handler) PID() uuid.UUID {
+	return h.pid
+}
+
+func New(configPath string, system operator.System) (Handler, error) {
+	jsonConfig, err := ioutil.ReadFile(configPath)
+	if err != nil {
+		return Handler{}, err
+	}
+	cfg := config{Server: nats.DefaultURL, Site: "site"}
+	if err := json.Unmarshal(jsonConfig, &cfg); err != nil {
+		return Handler{}, err
+	}
+
+	pid, _ := uuid.NewUUID()
+
+	inbox, err := system.SubscribeFiltered(pid, msg.Subscription{
+		Topics: []msg.Topic{msg.Status},
+		Policy: msg.Policy{Buffer: 50, Delivery: msg.LatestValue},
+	})
+	if err != nil {
+		return Handler{}, err
+	}
+	configs, err := system.SubscribeFiltered(pid, configSubscription)
+	if err != nil {
+		system.Unsubscribe(pid)
+		return Handler{}, err
+	}
+
+	op, err := operator.New(system)
+	if err != nil {
+		return Handler{}, err
+	}
+
+	return Handler{
+		mux:      &sync.Mutex{},
+		inbox:    inbox,
+		configs:  configs,
+		pid:      pid,
+		config:   cfg,
+		operator: op,
+		stop:     make(chan bool),
+	}, nil
+}
+
+func (h Handler) subject(tokens ...string) string {
+	return strings.Join(append([]string{"cgc", h.config.Site}, tokens...), ".")
+}
+
+func (h *Handler) StopProcess() {
+	h.stop <- true
+}
+
+// Process connects to the NATS server and publishes system messages until stopped.
+// The connection is retried in the background while the server is unreachable.
+func (h Handler) Process() {
+	nc, err := h.connect()
+	if err != nil {
+		log.Println("[NATS]", err)
+	} else {
+		defer nc.Close()
+	}
+
+loop:
+	for {
+		select {
+		case m, ok := <-h.inbox:
+			if !ok {
+				break loop
+			}
+			h.publish(nc, m)
+		case m, ok := <-h.configs:
+			if !ok {
+				break loop
+			}
+			h.publish(nc, m)
+		case <-h.stop:
+			break loop
+		}
+	}
+
+	if err := h.operator.Release(); err != nil {
+		log.Println("[NATS]", err)
+	}
+	log.Println("[NATS] Process Shutdown")
+}
+
+// publish publishes the message on the subject of its node and topic. The message is
+// dropped when there is no connection.
+func (h Handler) publish(nc *nats.Conn, m msg.Msg) {
+	if nc == nil {
+		return
+	}
+	data, err := json.Marshal(m)
+	if err != nil {
+		log.Println("[NATS]", err)
+		return
+	}
+	topic := strings.ToLower(m.Topic().String())
+	if err := nc.Publish(h.subject(m.PID().String(), topic), data); err != nil {
+		log.Println("[NATS]", err)
+	}
+}
+
+// connect returns a connection to the NATS server subscribed to the control subjects
+func (h Handler) connect() (*nats.Conn, error) {
+	nc, err := nats.Connect(h.config.Server,
+		nats.Name(fmt.Sprintf("cgc %v", h.config.Site)),
+		nats.MaxReconnects(-1),
+		nats.RetryOnFailedConnect(true),
+	)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := nc.Subscribe(h.subject("control"), h.controlRequest); err != nil {
+		nc.Close()
+		return nil, err
+	}
+	if _, err := nc.Subscribe(h.subject("control", "release"), h.releaseRequest); err != nil {
+		nc.Close()
+		return nil, err
+	}
+	if !nc.IsConnected() {
+		log.Println("[NATS] server unreachable, retrying", h.config.Server)
+		return nc, nil
+	}
+	if err := nc.Flush(); err != nil {
+		log.Println("[NATS]", err)
+	}
+	return nc, nil
+}
+
+func (h Handler) controlRequest(m *nats.Msg) {
+	request := msg.Msg{}
+	err := json.Unmarshal(m.Data, &request)
+	if err == nil {
+		err = h.operator.Control(request)
+	}
+	if err != nil {
+		log.Println("[NATS] control request:", err)
+	}
+	respond(m, err)
+}
+
+func (h Handler) releaseRequest(m *nats.Msg) {
+	err := h.operator.Release()
+	if err != nil {
+		log.Println("[NATS] release request:", err)
+	}
+	respond(m, err)
+}
+
+func respond(m *nats.Msg, err error) {
+	if m.Reply == "" {
+		return
+	}
+	r := reply{}
+	if err != nil {
+		r.Error = err.Error()
+	}
+	data, _ := json.Marshal(r)
+	m.Respond(data)
+}
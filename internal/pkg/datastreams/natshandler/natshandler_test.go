@@ -0,0 +1,171 @@
+package natshandler
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+	"github.com/ohowland/cgc_core/internal/pkg/asset/ess"
+	"github.com/ohowland/cgc_core/internal/pkg/control"
+	"github.com/ohowland/cgc_core/internal/pkg/mocksystem"
+	"github.com/ohowland/cgc_core/internal/pkg/msg"
+	"gotest.tools/assert"
+)
+
+// configFile writes the handler config to a temporary file and returns its path. The
+// caller removes the file.
+func configFile(t *testing.T, config string) string {
+	t.Helper()
+	f, err := ioutil.TempFile("", "handler_config")
+	assert.NilError(t, err)
+	defer f.Close()
+	_, err = f.WriteString(config)
+	assert.NilError(t, err)
+	return f.Name()
+}
+
+// runServer starts an embedded NATS server on a random port
+func runServer(t *testing.T) *server.Server {
+	s, err := server.NewServer(&server.Options{Host: "127.0.0.1", Port: -1, NoLog: true, NoSigs: true})
+	assert.NilError(t, err)
+	go s.Start()
+	if !s.ReadyForConnections(5 * time.Second) {
+		t.Fatal("nats server did not start")
+	}
+	return s
+}
+
+func newHandler(t *testing.T, url string, system *mocksystem.System) Handler {
+	path := configFile(t, fmt.Sprintf(`{"Server": %q, "Site": "test"}`, url))
+	defer os.Remove(path)
+	h, err := New(path, system)
+	assert.NilError(t, err)
+	return h
+}
+
+// connect returns a client connection once the handler has subscribed to the
+// control subject
+func connect(t *testing.T, url string) *nats.Conn {
+	nc, err := nats.Connect(url)
+	assert.NilError(t, err)
+	for i := 0; i < 50; i++ {
+		if _, err := nc.Request("cgc.test.control.release", nil, 100*time.Millisecond); err == nil {
+			return nc
+		}
+	}
+	t.Fatal("handler did not subscribe to the control subject")
+	return nil
+}
+
+func TestPublishStatus(t *testing.T) {
+	s := runServer(t)
+	defer s.Shutdown()
+
+	system := mocksystem.New()
+	h := newHandler(t, s.ClientURL(), system)
+	go h.Process()
+	defer h.StopProcess()
+
+	nc := connect(t, s.ClientURL())
+	defer nc.Close()
+
+	pid := uuid.New()
+	sub, err := nc.SubscribeSync(fmt.Sprintf("cgc.test.%v.status", pid))
+	assert.NilError(t, err)
+	assert.NilError(t, nc.Flush())
+
+	status := ess.Status{Machine: ess.MachineStatus{KW: 12}}
+	system.Forward(msg.New(pid, msg.Status, status))
+
+	m, err := sub.NextMsg(2 * time.Second)
+	assert.NilError(t, err)
+
+	received := msg.Msg{}
+	assert.NilError(t, json.Unmarshal(m.Data, &received))
+	assert.Equal(t, received.PID(), pid)
+	assert.Equal(t, received.Type(), "ess.Status")
+}
+
+func TestControlRequest(t *testing.T) {
+	s := runServer(t)
+	defer s.Shutdown()
+
+	system := mocksystem.New()
+	h := newHandler(t, s.ClientURL(), system)
+	go h.Process()
+	defer h.StopProcess()
+
+	nc := connect(t, s.ClientURL())
+	defer nc.Close()
+
+	target := uuid.New()
+	ctrl := ess.MachineControl{Run: true, KW: 40}
+	data, err := json.Marshal(msg.New(uuid.New(), msg.Control, ctrl).WithTarget(target))
+	assert.NilError(t, err)
+
+	resp, err := nc.Request("cgc.test.control", data, 2*time.Second)
+	assert.NilError(t, err)
+	assert.Equal(t, string(resp.Data), "{}")
+
+	select {
+	case m := <-system.Written:
+		assert.Equal(t, m.Target(), target)
+		assert.Equal(t, m.Payload(), ctrl)
+	case <-time.After(2 * time.Second):
+		t.Fatal("operator control was not written")
+	}
+
+	_, priority := system.OwnerOf(target)
+	assert.Equal(t, priority, control.Operator)
+
+	_, err = nc.Request("cgc.test.control.release", nil, 2*time.Second)
+	assert.NilError(t, err)
+	owner, _ := system.OwnerOf(target)
+	assert.Equal(t, owner, uuid.UUID{})
+}
+
+func TestControlRequestRejected(t *testing.T) {
+	s := runServer(t)
+	defer s.Shutdown()
+
+	h := newHandler(t, s.ClientURL(), mocksystem.New())
+	go h.Process()
+	defer h.StopProcess()
+
+	nc := connect(t, s.ClientURL())
+	defer nc.Close()
+
+	resp, err := nc.Request("cgc.test.control", []byte(`{"Type": "ess.MachineControl"}`), 2*time.Second)
+	assert.NilError(t, err)
+
+	r := reply{}
+	assert.NilError(t, json.Unmarshal(resp.Data, &r))
+	assert.Assert(t, strings.Contains(r.Error, "no target"), r.Error)
+}
+
+func TestStopUnreachableServer(t *testing.T) {
+	s := runServer(t)
+	url := s.ClientURL()
+	s.Shutdown()
+
+	h := newHandler(t, url, mocksystem.New())
+	go h.Process()
+
+	stopped := make(chan bool)
+	go func() {
+		h.StopProcess()
+		stopped <- true
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("process did not stop")
+	}
+}
@@ -0,0 +1,353 @@
+/*
+points.go Status and control points shared by the datastream servers, such as the
+Modbus and DNP3 servers, that serve the system to a SCADA master. A Table tracks the
+value of each configured Source as status messages arrive, and builds operator
+control requests for the asset control fields mapped to Targets.
+*/
+
+package points
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/google/uuid"
+	"github.com/ohowland/cgc_core/internal/pkg/asset"
+	"github.com/ohowland/cgc_core/internal/pkg/msg"
+	"github.com/ohowland/cgc_core/internal/pkg/operator"
+)
+
+// Source selects a status field. Asset names the asset by PID or name. Otherwise the
+// source is the Aggregate, "sum" (default), "min", "max" or "mean", of the field over
+// the assets of the Archetype with good status. Field is a status field name, such as
+// "KW", or a dotted path, such as "MachineStatus.KW", and bool fields read as 1 or 0.
+// The Quality field of a status is good whatever its value.
+type Source struct {
+	Asset     string `json:"Asset"`
+	Archetype string `json:"Archetype"`
+	Aggregate string `json:"Aggregate"`
+	Field     string `json:"Field"`
+}
+
+// Check returns an error if the source selects no asset or has an unknown aggregate
+func (s Source) Check() error {
+	if s.Asset == "" && s.Archetype == "" {
+		return errors.New("no asset or archetype")
+	}
+	if _, ok := aggregates[s.Aggregate]; !ok {
+		err := fmt.Sprintf("unsupported aggregate %v", s.Aggregate)
+		return errors.New(err)
+	}
+	return nil
+}
+
+// Target selects a Field of the control Type, such as "ess.MachineControl", of the
+// Asset named by PID or name
+type Target struct {
+	Asset string `json:"Asset"`
+	Type  string `json:"Type"`
+	Field string `json:"Field"`
+}
+
+// Check returns an error if the target field is not a float64 or bool field of the
+// control type
+func (t Target) Check() error {
+	kind, err := operator.FieldKind(t.Type, t.Field)
+	if err != nil {
+		return err
+	}
+	if kind != reflect.Float64 && kind != reflect.Bool {
+		err := fmt.Sprintf("%v field %v is not a number or bool", t.Type, t.Field)
+		return errors.New(err)
+	}
+	return nil
+}
+
+// CheckTargets returns an error if the targets of an asset control do not map every
+// field of the control type. A request is built from the mapped fields alone, and
+// the operator rejects a first control that omits a field.
+func CheckTargets(targets []Target) error {
+	type control struct{ asset, kind string }
+	order := []control{}
+	mapped := make(map[control]map[string]bool)
+	for _, t := range targets {
+		c := control{t.Asset, t.Type}
+		if _, ok := mapped[c]; !ok {
+			order = append(order, c)
+			mapped[c] = make(map[string]bool)
+		}
+		mapped[c][t.Field] = true
+	}
+
+	for _, c := range order {
+		fields, err := operator.Fields(c.kind)
+		if err != nil {
+			return err
+		}
+		missing := []string{}
+		for _, f := range fields {
+			if !mapped[c][f] {
+				missing = append(missing, f)
+			}
+		}
+		if len(missing) > 0 {
+			err := fmt.Sprintf("%v %v does not map %v", c.asset, c.kind, strings.Join(missing, ", "))
+			return errors.New(err)
+		}
+	}
+	return nil
+}
+
+// Value is the value of a source, and whether it is good
+type Value struct {
+	Value float64
+	Good  bool
+}
+
+// Table tracks the values of the sources, and the archetype of each asset
+type Table struct {
+	mux        *sync.Mutex
+	sources    []Source
+	names      map[string]uuid.UUID
+	archetypes map[uuid.UUID]string
+	members    map[int]map[uuid.UUID]float64
+}
+
+// NewTable returns a Table for the sources
+func NewTable(sources []Source) *Table {
+	return &Table{
+		mux:        &sync.Mutex{},
+		sources:    sources,
+		names:      make(map[string]uuid.UUID),
+		archetypes: make(map[uuid.UUID]string),
+		members:    make(map[int]map[uuid.UUID]float64),
+	}
+}
+
+// Update records the names of configured assets and returns, by source index, the
+// value of each source updated by a status message
+func (t *Table) Update(m msg.Msg) (map[int]Value, error) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+
+	updated := make(map[int]Value)
+	if m.Topic() == msg.Config || m.Topic() == msg.Status {
+		t.archetypes[m.PID()] = strings.SplitN(m.Type(), ".", 2)[0]
+	}
+	switch m.Topic() {
+	case msg.Config:
+		if named, ok := m.Payload().(interface{ Name() string }); ok {
+			t.names[named.Name()] = m.PID()
+		}
+	case msg.Status:
+		fields, err := Fields(m.Payload())
+		if err != nil {
+			return updated, err
+		}
+		good := asset.IsGood(m.Payload())
+		for i, s := range t.sources {
+			val, ok := fields[s.Field]
+			if !ok {
+				continue
+			}
+			// the quality of a status is good whatever its value
+			g := good || s.Field == "Quality" || strings.HasSuffix(s.Field, ".Quality")
+			if s.Asset != "" {
+				if pid, ok := t.resolve(s.Asset); ok && pid == m.PID() {
+					updated[i] = Value{val, g}
+				}
+				continue
+			}
+			if strings.HasPrefix(m.Type(), s.Archetype+".") {
+				updated[i] = t.aggregate(i, m.PID(), val, g)
+			}
+		}
+	}
+	return updated, nil
+}
+
+// aggregate records the value of the archetype member and returns the aggregate of
+// the members with good status, which is good if there is a member
+func (t *Table) aggregate(i int, pid uuid.UUID, val float64, good bool) Value {
+	members, ok := t.members[i]
+	if !ok {
+		members = make(map[uuid.UUID]float64)
+		t.members[i] = members
+	}
+	if good {
+		members[pid] = val
+	} else {
+		delete(members, pid)
+	}
+
+	values := make([]float64, 0, len(members))
+	for _, v := range members {
+		values = append(values, v)
+	}
+	return Value{aggregates[t.sources[i].Aggregate](values), len(values) > 0}
+}
+
+// Members returns the PIDs, in order, of the assets of the archetype that have sent
+// a status or config
+func (t *Table) Members(archetype string) []uuid.UUID {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	members := make([]uuid.UUID, 0)
+	for pid, a := range t.archetypes {
+		if a == archetype {
+			members = append(members, pid)
+		}
+	}
+	sort.Slice(members, func(i, j int) bool {
+		return members[i].String() < members[j].String()
+	})
+	return members
+}
+
+// Resolve returns the PID of an asset named by PID or name
+func (t *Table) Resolve(name string) (uuid.UUID, bool) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+	return t.resolve(name)
+}
+
+func (t *Table) resolve(name string) (uuid.UUID, bool) {
+	if pid, err := uuid.Parse(name); err == nil {
+		return pid, true
+	}
+	pid, ok := t.names[name]
+	return pid, ok
+}
+
+// Request returns an operator control request of the type to the named asset. The
+// fields are set from the values, and a bool field is true when its value is non-zero.
+func (t *Table) Request(assetName string, controlType string, values map[string]float64) (msg.Msg, error) {
+	target, ok := t.Resolve(assetName)
+	if !ok {
+		err := fmt.Sprintf("unknown asset %v", assetName)
+		return msg.Msg{}, errors.New(err)
+	}
+
+	fields := make(map[string]interface{})
+	for field, val := range values {
+		kind, err := operator.FieldKind(controlType, field)
+		if err != nil {
+			return msg.Msg{}, err
+		}
+		if kind == reflect.Bool {
+			fields[field] = val != 0
+		} else {
+			fields[field] = val
+		}
+	}
+	return operator.NewRequest(target, controlType, fields)
+}
+
+// Fields returns the numeric fields of a status payload by dotted path, and by field
+// name where the name is not also a field of the top level. Bool fields are 1 or 0
+// and a Quality field is its numeric value.
+func Fields(payload interface{}) (map[string]float64, error) {
+	paths, err := Paths(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	top := make(map[string]bool)
+	fields := make(map[string]float64)
+	for path, val := range paths {
+		top[strings.SplitN(path, ".", 2)[0]] = true
+		fields[path] = val
+	}
+	for path, val := range paths {
+		name := path[strings.LastIndex(path, ".")+1:]
+		if !top[name] {
+			fields[name] = val
+		}
+	}
+	return fields, nil
+}
+
+// Paths returns the numeric fields of a status payload by dotted path, such as
+// "Machine.KW". Bool fields are 1 or 0 and a Quality field is its numeric value.
+func Paths(payload interface{}) (map[string]float64, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	obj := make(map[string]interface{})
+	if err := json.Unmarshal(data, &obj); err != nil {
+		// the payload is not a struct
+		return map[string]float64{}, nil
+	}
+
+	paths := make(map[string]float64)
+	var walk func(prefix string, obj map[string]interface{})
+	walk = func(prefix string, obj map[string]interface{}) {
+		for name, v := range obj {
+			switch v := v.(type) {
+			case float64:
+				paths[prefix+name] = v
+			case bool:
+				paths[prefix+name] = 0
+				if v {
+					paths[prefix+name] = 1
+				}
+			case string:
+				var q asset.Quality
+				if err := q.UnmarshalText([]byte(v)); err == nil {
+					paths[prefix+name] = float64(q)
+				}
+			case map[string]interface{}:
+				walk(prefix+name+".", v)
+			}
+		}
+	}
+	walk("", obj)
+	return paths, nil
+}
+
+// aggregates reduce the values of the members of an archetype
+var aggregates = map[string]func([]float64) float64{
+	"":    sum,
+	"sum": sum,
+	"min": func(values []float64) float64 {
+		if len(values) == 0 {
+			return 0
+		}
+		min := math.Inf(1)
+		for _, v := range values {
+			min = math.Min(min, v)
+		}
+		return min
+	},
+	"max": func(values []float64) float64 {
+		if len(values) == 0 {
+			return 0
+		}
+		max := math.Inf(-1)
+		for _, v := range values {
+			max = math.Max(max, v)
+		}
+		return max
+	},
+	"mean": func(values []float64) float64 {
+		if len(values) == 0 {
+			return 0
+		}
+		return sum(values) / float64(len(values))
+	},
+}
+
+func sum(values []float64) float64 {
+	total := 0.0
+	for _, v := range values {
+		total += v
+	}
+	return total
+}
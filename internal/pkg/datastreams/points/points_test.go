@@ -0,0 +1,123 @@
+package points
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/ohowland/cgc_core/internal/pkg/asset"
+	"github.com/ohowland/cgc_core/internal/pkg/asset/ess"
+	"github.com/ohowland/cgc_core/internal/pkg/asset/grid"
+	"github.com/ohowland/cgc_core/internal/pkg/asset/pv"
+	"github.com/ohowland/cgc_core/internal/pkg/msg"
+	"github.com/ohowland/cgc_core/internal/pkg/operator"
+	"gotest.tools/assert"
+)
+
+func TestUpdateAsset(t *testing.T) {
+	table := NewTable([]Source{
+		{Asset: "ess", Field: "KW"},
+		{Asset: "ess", Field: "Online"},
+		{Asset: "ess", Field: "Quality"},
+	})
+
+	pid := uuid.New()
+	status := ess.Status{Machine: ess.MachineStatus{KW: 12, Online: true}}
+	updated, err := table.Update(msg.New(pid, msg.Status, status))
+	assert.NilError(t, err)
+	assert.Equal(t, len(updated), 0)
+
+	_, err = table.Update(msg.New(pid, msg.Config, ess.Config{Static: ess.StaticConfig{Name: "ess"}}))
+	assert.NilError(t, err)
+	updated, err = table.Update(msg.New(pid, msg.Status, status))
+	assert.NilError(t, err)
+	assert.DeepEqual(t, updated, map[int]Value{0: {12, true}, 1: {1, true}, 2: {0, true}})
+
+	status.Machine.Quality = asset.Stale
+	updated, err = table.Update(msg.New(pid, msg.Status, status))
+	assert.NilError(t, err)
+	assert.DeepEqual(t, updated[0], Value{12, false})
+	assert.DeepEqual(t, updated[2], Value{float64(asset.Stale), true})
+}
+
+func TestUpdateAggregate(t *testing.T) {
+	table := NewTable([]Source{
+		{Archetype: "pv", Field: "KW"},
+		{Archetype: "pv", Aggregate: "mean", Field: "MachineStatus.KW"},
+	})
+
+	table.Update(msg.New(uuid.New(), msg.Status, pv.Status{Machine: pv.MachineStatus{KW: 10}}))
+	updated, err := table.Update(msg.New(uuid.New(), msg.Status, pv.Status{Machine: pv.MachineStatus{KW: 20}}))
+	assert.NilError(t, err)
+	assert.DeepEqual(t, updated, map[int]Value{0: {30, true}, 1: {15, true}})
+
+	pid := uuid.New()
+	updated, err = table.Update(msg.New(pid, msg.Status, pv.Status{Machine: pv.MachineStatus{KW: 50, Quality: asset.CommFail}}))
+	assert.NilError(t, err)
+	assert.DeepEqual(t, updated[0], Value{30, true})
+
+	updated, err = table.Update(msg.New(uuid.New(), msg.Status, grid.Status{Machine: grid.MachineStatus{KW: 5}}))
+	assert.NilError(t, err)
+	assert.Equal(t, len(updated), 0)
+
+	assert.Equal(t, len(table.Members("pv")), 3)
+	assert.Equal(t, len(table.Members("grid")), 1)
+	assert.Equal(t, len(table.Members("ess")), 0)
+}
+
+func TestRequest(t *testing.T) {
+	table := NewTable(nil)
+	pid := uuid.New()
+	table.Update(msg.New(pid, msg.Config, grid.Config{Static: grid.StaticConfig{Name: "grid"}}))
+
+	request, err := table.Request("grid", "grid.MachineControl", map[string]float64{"CloseIntertie": 1})
+	assert.NilError(t, err)
+	assert.Equal(t, request.Target(), pid)
+	ctrl, err := operator.DecodeControl(request)
+	assert.NilError(t, err)
+	assert.Equal(t, ctrl, grid.MachineControl{CloseIntertie: true})
+
+	_, err = table.Request("feeder", "feeder.MachineControl", map[string]float64{})
+	assert.Error(t, err, "unknown asset feeder")
+}
+
+func TestCheckTargets(t *testing.T) {
+	targets := []Target{
+		{Asset: "grid", Type: "grid.MachineControl", Field: "CloseIntertie"},
+		{Asset: "ess", Type: "ess.MachineControl", Field: "Run"},
+		{Asset: "ess", Type: "ess.MachineControl", Field: "KW"},
+	}
+	assert.Error(t, CheckTargets(targets), "ess ess.MachineControl does not map KVAR, Gridform")
+
+	targets = append(targets,
+		Target{Asset: "ess", Type: "ess.MachineControl", Field: "KVAR"},
+		Target{Asset: "ess", Type: "ess.MachineControl", Field: "Gridform"})
+	assert.NilError(t, CheckTargets(targets))
+}
+
+func TestCheck(t *testing.T) {
+	assert.Error(t, Source{Field: "KW"}.Check(), "no asset or archetype")
+	assert.Error(t, Source{Archetype: "pv", Aggregate: "median"}.Check(), "unsupported aggregate median")
+	assert.NilError(t, Source{Archetype: "pv", Aggregate: "max"}.Check())
+
+	assert.NilError(t, Target{Type: "ess.MachineControl", Field: "KW"}.Check())
+	assert.Error(t, Target{Type: "ess.MachineControl", Field: "Volts"}.Check(), "ess.MachineControl has no field Volts")
+}
+
+func TestFields(t *testing.T) {
+	fields, err := Fields(ess.Status{Machine: ess.MachineStatus{KW: 4, Online: true, Quality: asset.OutOfRange}})
+	assert.NilError(t, err)
+	assert.Equal(t, fields["KW"], 4.0)
+	assert.Equal(t, fields["MachineStatus.KW"], 4.0)
+	assert.Equal(t, fields["Online"], 1.0)
+	assert.Equal(t, fields["Quality"], float64(asset.OutOfRange))
+
+	paths, err := Paths(ess.Status{Machine: ess.MachineStatus{KW: 4}})
+	assert.NilError(t, err)
+	assert.Equal(t, paths["MachineStatus.KW"], 4.0)
+	_, ok := paths["KW"]
+	assert.Assert(t, !ok)
+
+	fields, err = Fields(42)
+	assert.NilError(t, err)
+	assert.Equal(t, len(fields), 0)
+}
@@ -0,0 +1,299 @@
+package promexporter
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/google/uuid"
+	"github.com/ohowland/cgc_core/internal/pkg/asset"
+	"github.com/ohowland/cgc_core/internal/pkg/bus"
+	"github.com/ohowland/cgc_core/internal/pkg/datastreams/points"
+	"github.com/ohowland/cgc_core/internal/pkg/metrics"
+	"github.com/ohowland/cgc_core/internal/pkg/msg"
+)
+
+// System is the control system exported
+type System interface {
+	msg.Publisher
+	Topology() bus.Topology
+	Stats() []msg.SubscriberStats
+}
+
+// Handler serves the system metrics to Prometheus at the configured Path. Each numeric
+// field of the latest status of a bus or asset is a gauge named for its dotted path in
+// snake case, such as cgc_status_machine_status_kw, labeled by:
+//
+//	asset      the name of the bus or asset
+//	bus        the bus the asset is a member of, or the name of a bus itself
+//	archetype  the archetype of the status, e.g. ess
+//
+// The delivery state of the system subscribers, and the metrics registered by other
+// packages, such as Modbus poll latency and dispatch cycle time, are also exported.
+type Handler struct {
+	inbox  <-chan msg.Msg
+	pid    uuid.UUID
+	config config
+	system System
+	cache  *cache
+	server *http.Server
+	stop   chan bool
+}
+
+type config struct {
+	Listen string `json:"Listen"`
+	Path   string `json:"Path"`
+}
+
+// cache holds the latest status message of each node
+type cache struct {
+	mux    *sync.Mutex
+	status map[uuid.UUID]msg.Msg
+}
+
+// member is the name of a node and of the bus it is a member of
+type member struct {
+	name string
+	bus  string
+}
+
+func (h Handler) PID() uuid.UUID {
+	return h.pid
+}
+
+func New(configPath string, system System) (Handler, error) {
+	jsonConfig, err := ioutil.ReadFile(configPath)
+	if err != nil {
+		return Handler{}, err
+	}
+	cfg := config{Listen: ":9464", Path: "/metrics"}
+	if err := json.Unmarshal(jsonConfig, &cfg); err != nil {
+		return Handler{}, err
+	}
+
+	h := Handler{
+		config: cfg,
+		system: system,
+		cache: &cache{
+			mux:    &sync.Mutex{},
+			status: make(map[uuid.UUID]msg.Msg),
+		},
+		stop: make(chan bool),
+	}
+	mux := http.NewServeMux()
+	mux.Handle(cfg.Path, h)
+	h.server = &http.Server{Handler: mux}
+
+	h.pid, _ = uuid.NewUUID()
+	h.inbox, err = system.SubscribeFiltered(h.pid, msg.Subscription{
+		Topics: []msg.Topic{msg.Status},
+		Policy: msg.Policy{Buffer: 50, Delivery: msg.LatestValue},
+	})
+	if err != nil {
+		return Handler{}, err
+	}
+
+	l, err := net.Listen("tcp", cfg.Listen)
+	if err != nil {
+		system.Unsubscribe(h.pid)
+		return Handler{}, err
+	}
+	go func() {
+		if err := h.server.Serve(l); err != http.ErrServerClosed {
+			log.Println("[Prometheus]", err)
+		}
+	}()
+	return h, nil
+}
+
+func (h *Handler) StopProcess() {
+	h.stop <- true
+}
+
+// Process caches the system status for scrapes until stopped
+func (h Handler) Process() {
+loop:
+	for {
+		select {
+		case m, ok := <-h.inbox:
+			if !ok {
+				break loop
+			}
+			h.cache.update(m)
+		case <-h.stop:
+			break loop
+		}
+	}
+
+	h.server.Close()
+	log.Println("[Prometheus] Process Shutdown")
+}
+
+func (c *cache) update(m msg.Msg) {
+	if _, ok := m.Payload().(asset.Alarm); ok {
+		return
+	}
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.status[m.PID()] = m
+}
+
+func (c *cache) latest() []msg.Msg {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	latest := make([]msg.Msg, 0, len(c.status))
+	for _, m := range c.status {
+		latest = append(latest, m)
+	}
+	return latest
+}
+
+// ServeHTTP writes the metrics in the Prometheus text exposition format
+func (h Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, fmt.Sprintf("method %v not allowed", r.Method), http.StatusMethodNotAllowed)
+		return
+	}
+
+	families := append(h.statusFamilies(), h.subscriberFamilies()...)
+	families = append(families, metrics.Gather()...)
+	buf := &bytes.Buffer{}
+	if err := metrics.Write(buf, families); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Write(buf.Bytes())
+}
+
+// statusFamilies returns a gauge of each status field, and the time of the latest
+// status of each node
+func (h Handler) statusFamilies() []metrics.Family {
+	members := members(h.system.Topology())
+	timestamps := metrics.Family{
+		Name: "cgc_status_timestamp_seconds",
+		Help: "Time of the latest status of the bus or asset, in seconds since the epoch.",
+		Type: metrics.GaugeType,
+	}
+	gauges := make(map[string]*metrics.Family)
+
+	latest := h.cache.latest()
+	sort.Slice(latest, func(i, j int) bool {
+		return label(members, latest[i]) < label(members, latest[j])
+	})
+	for _, m := range latest {
+		paths, err := points.Paths(m.Payload())
+		if err != nil {
+			log.Println("[Prometheus]", err)
+			continue
+		}
+		labels := labels(members, m)
+		ts := float64(m.Timestamp().UnixNano()) / 1e9
+		timestamps.Samples = append(timestamps.Samples, metrics.Sample{Name: timestamps.Name, Labels: labels, Value: ts})
+
+		for path, val := range paths {
+			name := "cgc_status_" + metrics.Name(path)
+			f, ok := gauges[name]
+			if !ok {
+				f = &metrics.Family{
+					Name: name,
+					Help: fmt.Sprintf("Status field %v of the bus or asset.", path),
+					Type: metrics.GaugeType,
+				}
+				gauges[name] = f
+			}
+			f.Samples = append(f.Samples, metrics.Sample{Name: name, Labels: labels, Value: val})
+		}
+	}
+
+	families := make([]metrics.Family, 0, len(gauges)+1)
+	for _, f := range gauges {
+		families = append(families, *f)
+	}
+	sort.Slice(families, func(i, j int) bool {
+		return families[i].Name < families[j].Name
+	})
+	return append(families, timestamps)
+}
+
+// subscriberFamilies returns the subscriber count of each topic, and the delivery
+// state of each subscriber to the system
+func (h Handler) subscriberFamilies() []metrics.Family {
+	subscribers := metrics.Family{
+		Name: "cgc_pubsub_subscribers",
+		Help: "Subscribers to the system topic.",
+		Type: metrics.GaugeType,
+	}
+	queued := metrics.Family{
+		Name: "cgc_pubsub_queued_messages",
+		Help: "Messages waiting in the buffer of the subscriber.",
+		Type: metrics.GaugeType,
+	}
+	dropped := metrics.Family{
+		Name: "cgc_pubsub_dropped_messages_total",
+		Help: "Messages discarded because the buffer of the subscriber was full.",
+		Type: metrics.CounterType,
+	}
+
+	counts := make(map[msg.Topic]int)
+	for _, s := range h.system.Stats() {
+		for _, topic := range s.Topics {
+			counts[topic]++
+		}
+		labels := []metrics.Label{{Name: "subscriber", Value: s.PID.String()}}
+		queued.Samples = append(queued.Samples, metrics.Sample{Name: queued.Name, Labels: labels, Value: float64(s.Queued)})
+		dropped.Samples = append(dropped.Samples, metrics.Sample{Name: dropped.Name, Labels: labels, Value: float64(s.Dropped)})
+	}
+	for _, topic := range []msg.Topic{msg.Status, msg.Control, msg.Config} {
+		labels := []metrics.Label{{Name: "topic", Value: topic.String()}}
+		subscribers.Samples = append(subscribers.Samples, metrics.Sample{Name: subscribers.Name, Labels: labels, Value: float64(counts[topic])})
+	}
+	return []metrics.Family{subscribers, queued, dropped}
+}
+
+// members returns the name and bus of each node of the topology. The bus of a bus is
+// itself.
+func members(t bus.Topology) map[uuid.UUID]member {
+	out := make(map[uuid.UUID]member)
+	var walk func(t bus.Topology, parent string)
+	walk = func(t bus.Topology, parent string) {
+		if t.Bus {
+			parent = t.Name
+		}
+		if t.PID != uuid.Nil {
+			out[t.PID] = member{t.Name, parent}
+		}
+		for _, m := range t.Members {
+			walk(m, parent)
+		}
+	}
+	walk(t, "")
+	return out
+}
+
+// labels returns the asset, bus and archetype labels of a status message. A node not
+// in the topology is named by its PID.
+func labels(members map[uuid.UUID]member, m msg.Msg) []metrics.Label {
+	n, ok := members[m.PID()]
+	if !ok {
+		n = member{name: m.PID().String()}
+	}
+	return []metrics.Label{
+		{Name: "asset", Value: n.name},
+		{Name: "bus", Value: n.bus},
+		{Name: "archetype", Value: strings.SplitN(m.Type(), ".", 2)[0]},
+	}
+}
+
+// label returns the name of the node of a status message, which orders the samples
+func label(members map[uuid.UUID]member, m msg.Msg) string {
+	return labels(members, m)[0].Value
+}
@@ -0,0 +1,209 @@
+package promexporter
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/ohowland/cgc_core/internal/pkg/asset"
+	"github.com/ohowland/cgc_core/internal/pkg/asset/ess"
+	"github.com/ohowland/cgc_core/internal/pkg/asset/pv"
+	"github.com/ohowland/cgc_core/internal/pkg/bus"
+	"github.com/ohowland/cgc_core/internal/pkg/metrics"
+	"github.com/ohowland/cgc_core/internal/pkg/mocksystem"
+	"github.com/ohowland/cgc_core/internal/pkg/msg"
+	"gotest.tools/assert"
+)
+
+var (
+	bus1PID = uuid.New()
+	bus2PID = uuid.New()
+	essPID  = uuid.New()
+	pvPID   = uuid.New()
+)
+
+// topology is the tree of buses and assets of the mock system
+var topology = bus.Topology{PID: bus1PID, Name: "Bus-1", Bus: true, Members: []bus.Topology{
+	{PID: bus2PID, Name: "Bus-2", Bus: true, Members: []bus.Topology{
+		{PID: pvPID, Name: "pv", Members: []bus.Topology{}},
+	}},
+	{PID: essPID, Name: "ess", Members: []bus.Topology{}},
+}}
+
+// configFile writes the handler config to a temporary file and returns its path. The
+// caller removes the file.
+func configFile(t *testing.T, config string) string {
+	t.Helper()
+	f, err := ioutil.TempFile("", "handler_config")
+	assert.NilError(t, err)
+	defer f.Close()
+	_, err = f.WriteString(config)
+	assert.NilError(t, err)
+	return f.Name()
+}
+
+// poll calls check until it returns nil, and fails the test with the last error of
+// check if it has not within a second. The handler processes messages on its own
+// goroutine, so a test waits for the effect of the messages it publishes.
+func poll(t *testing.T, check func() error) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for {
+		err := check()
+		if err == nil {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal(err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// newHandler returns a running Handler and a test server of it
+func newHandler(t *testing.T, system *mocksystem.System) (Handler, *httptest.Server) {
+	path := configFile(t, `{"Listen": "127.0.0.1:0"}`)
+	defer os.Remove(path)
+	h, err := New(path, system)
+	assert.NilError(t, err)
+	go h.Process()
+	return h, httptest.NewServer(h)
+}
+
+func scrape(t *testing.T, url string) string {
+	resp, err := http.Get(url)
+	assert.NilError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, resp.StatusCode, http.StatusOK)
+	assert.Equal(t, resp.Header.Get("Content-Type"), "text/plain; version=0.0.4; charset=utf-8")
+	body, err := ioutil.ReadAll(resp.Body)
+	assert.NilError(t, err)
+	return string(body)
+}
+
+// waitForLine scrapes the server until the line is exported, and returns the scrape
+func waitForLine(t *testing.T, url string, line string) string {
+	var body string
+	poll(t, func() error {
+		body = scrape(t, url)
+		if !strings.Contains(body, line+"\n") {
+			return fmt.Errorf("%v not exported in:\n%v", line, body)
+		}
+		return nil
+	})
+	return body
+}
+
+func TestStatus(t *testing.T) {
+	system := mocksystem.New()
+	system.SetTopology(topology)
+	h, server := newHandler(t, system)
+	defer server.Close()
+	defer h.StopProcess()
+
+	system.Forward(msg.New(essPID, msg.Status, ess.Status{Machine: ess.MachineStatus{KW: 12.5, SOC: 0.8, Online: true}}))
+	system.Forward(msg.New(essPID, msg.Status, asset.Alarm{Name: asset.ControlTimeout, Active: true}))
+	system.Forward(msg.New(pvPID, msg.Status, pv.Status{Machine: pv.MachineStatus{KW: 3}}))
+	body := waitForLine(t, server.URL, `cgc_status_machine_status_kw{asset="pv",bus="Bus-2",archetype="pv"} 3`)
+
+	for _, line := range []string{
+		"# TYPE cgc_status_machine_status_kw gauge",
+		`cgc_status_machine_status_kw{asset="ess",bus="Bus-1",archetype="ess"} 12.5`,
+		`cgc_status_machine_status_soc{asset="ess",bus="Bus-1",archetype="ess"} 0.8`,
+		`cgc_status_machine_status_online{asset="ess",bus="Bus-1",archetype="ess"} 1`,
+	} {
+		assert.Assert(t, strings.Contains(body, line+"\n"), line)
+	}
+	assert.Assert(t, strings.Index(body, `{asset="ess"`) < strings.Index(body, `{asset="pv"`))
+	assert.Assert(t, strings.Contains(body, `cgc_status_timestamp_seconds{asset="ess",bus="Bus-1",archetype="ess"} `))
+	assert.Assert(t, !strings.Contains(body, "alarm"))
+}
+
+// TestExposition checks that each family of a scrape is declared by its HELP and TYPE
+// lines before its samples, and that label values are escaped
+func TestExposition(t *testing.T) {
+	system := mocksystem.New()
+	odd := uuid.New()
+	system.SetTopology(bus.Topology{PID: bus1PID, Name: `Bus "1"`, Bus: true, Members: []bus.Topology{
+		{PID: odd, Name: `ess\north "A"`, Members: []bus.Topology{}},
+	}})
+	h, server := newHandler(t, system)
+	defer server.Close()
+	defer h.StopProcess()
+
+	system.Forward(msg.New(odd, msg.Status, ess.Status{Machine: ess.MachineStatus{KW: 1}}))
+	body := waitForLine(t, server.URL, `cgc_status_machine_status_kw{asset="ess\\north \"A\"",bus="Bus \"1\"",archetype="ess"} 1`)
+
+	declared := make(map[string]string)
+	help := ""
+	for _, line := range strings.Split(strings.TrimSuffix(body, "\n"), "\n") {
+		fields := strings.SplitN(line, " ", 4)
+		switch {
+		case strings.HasPrefix(line, "# HELP "):
+			help = fields[2]
+		case strings.HasPrefix(line, "# TYPE "):
+			assert.Equal(t, fields[2], help, "TYPE without HELP: %v", line)
+			_, ok := declared[fields[2]]
+			assert.Assert(t, !ok, "family declared twice: %v", line)
+			declared[fields[2]] = fields[3]
+			help = ""
+		default:
+			name := strings.FieldsFunc(line, func(r rune) bool { return r == '{' || r == ' ' })[0]
+			_, ok := declared[name]
+			for _, suffix := range []string{"_bucket", "_sum", "_count"} {
+				if kind := declared[strings.TrimSuffix(name, suffix)]; strings.HasSuffix(name, suffix) && kind == metrics.HistogramType {
+					ok = true
+				}
+			}
+			assert.Assert(t, ok, "sample of an undeclared family: %v", line)
+		}
+	}
+}
+
+func TestSubscribers(t *testing.T) {
+	system := mocksystem.New()
+	h, server := newHandler(t, system)
+	defer server.Close()
+	defer h.StopProcess()
+
+	other := uuid.New()
+	_, err := system.Subscribe(other, msg.Control)
+	assert.NilError(t, err)
+
+	body := scrape(t, server.URL)
+	for _, line := range []string{
+		`cgc_pubsub_subscribers{topic="Status"} 1`,
+		`cgc_pubsub_subscribers{topic="Control"} 1`,
+		`cgc_pubsub_subscribers{topic="Config"} 0`,
+		fmt.Sprintf(`cgc_pubsub_dropped_messages_total{subscriber="%v"} 0`, h.PID()),
+		fmt.Sprintf(`cgc_pubsub_queued_messages{subscriber="%v"} 0`, other),
+	} {
+		assert.Assert(t, strings.Contains(body, line+"\n"), line)
+	}
+}
+
+func TestRegistered(t *testing.T) {
+	system := mocksystem.New()
+	h, server := newHandler(t, system)
+	defer server.Close()
+	defer h.StopProcess()
+
+	c := metrics.NewCounter("cgc_test_events_total", "Test events.", "source")
+	metrics.Register(c)
+	c.Inc("promexporter")
+
+	body := scrape(t, server.URL)
+	assert.Assert(t, strings.Contains(body, "# HELP cgc_test_events_total Test events.\n"))
+	assert.Assert(t, strings.Contains(body, `cgc_test_events_total{source="promexporter"} 1`+"\n"))
+
+	resp, err := http.Post(server.URL, "text/plain", nil)
+	assert.NilError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, resp.StatusCode, http.StatusMethodNotAllowed)
+}
@@ -0,0 +1,390 @@
+package restapi
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/google/uuid"
+	"github.com/ohowland/cgc_core/internal/pkg/asset"
+	"github.com/ohowland/cgc_core/internal/pkg/bus"
+	"github.com/ohowland/cgc_core/internal/pkg/control"
+	"github.com/ohowland/cgc_core/internal/pkg/msg"
+	"github.com/ohowland/cgc_core/internal/pkg/operator"
+)
+
+// maxBody is the largest request body accepted
+const maxBody = 1 << 20
+
+// System is the control system served by the API
+type System interface {
+	operator.System
+	Topology() bus.Topology
+}
+
+// Handler serves the system over HTTP, for the cgc_web front end. Responses are JSON,
+// and a node, a bus or an asset, is named in a path by its PID or name.
+//
+//	GET  /api/topology             the tree of buses and assets
+//	GET  /api/nodes                the latest status and config of every node
+//	GET  /api/nodes/{node}         the latest status and config of a node
+//	GET  /api/nodes/{node}/status  the latest status message of a node
+//	GET  /api/nodes/{node}/config  the latest config message of a node
+//	POST /api/nodes/{node}/control the machine control of an asset, e.g. {"KW": 10}
+//	POST /api/release              returns control to dispatch
+//
+// A control is decoded into the MachineControl type of the asset archetype, so unknown
+// fields are rejected, and is sent with operator control of the system. Fields omitted
+// from a control keep the value of the last control sent to the asset, and the first
+// control sent to an asset must set every field. A control denied by a higher priority
+// owner is a 409 Conflict.
+//
+// The API is not authenticated, so it listens on the loopback interface unless the
+// config names another address.
+type Handler struct {
+	inbox    <-chan msg.Msg
+	configs  <-chan msg.Msg
+	pid      uuid.UUID
+	config   config
+	system   System
+	cache    *cache
+	operator *operator.Operator
+	server   *http.Server
+	stop     chan bool
+}
+
+type config struct {
+	Listen string `json:"Listen"`
+}
+
+// Node is the latest status and config of a bus or asset. Alarms are not kept.
+type Node struct {
+	PID    uuid.UUID `json:"PID"`
+	Name   string    `json:"Name"`
+	Bus    bool      `json:"Bus"`
+	Status *msg.Msg  `json:"Status"`
+	Config *msg.Msg  `json:"Config"`
+}
+
+// cache holds the latest status and config message of each node
+type cache struct {
+	mux    *sync.Mutex
+	status map[uuid.UUID]msg.Msg
+	config map[uuid.UUID]msg.Msg
+}
+
+type errorResponse struct {
+	Error string `json:"Error"`
+}
+
+// configSubscription delivers every config message. Config is published once, when a
+// node joins the system, so a dropped config is never replaced.
+var configSubscription = msg.Subscription{
+	Topics: []msg.Topic{msg.Config},
+	Policy: msg.Policy{Buffer: 16, Delivery: msg.Guaranteed},
+}
+
+func (h Handler) PID() uuid.UUID {
+	return h.pid
+}
+
+func New(configPath string, system System) (Handler, error) {
+	jsonConfig, err := ioutil.ReadFile(configPath)
+	if err != nil {
+		return Handler{}, err
+	}
+	cfg := config{Listen: "127.0.0.1:8080"}
+	if err := json.Unmarshal(jsonConfig, &cfg); err != nil {
+		return Handler{}, err
+	}
+
+	op, err := operator.New(system)
+	if err != nil {
+		return Handler{}, err
+	}
+
+	h := Handler{
+		config: cfg,
+		system: system,
+		cache: &cache{
+			mux:    &sync.Mutex{},
+			status: make(map[uuid.UUID]msg.Msg),
+			config: make(map[uuid.UUID]msg.Msg),
+		},
+		operator: op,
+		stop:     make(chan bool),
+	}
+	h.server = &http.Server{Handler: h}
+
+	h.pid, _ = uuid.NewUUID()
+	h.inbox, err = system.SubscribeFiltered(h.pid, msg.Subscription{
+		Topics: []msg.Topic{msg.Status},
+		Policy: msg.Policy{Buffer: 50, Delivery: msg.LatestValue},
+	})
+	if err != nil {
+		return Handler{}, err
+	}
+	h.configs, err = system.SubscribeFiltered(h.pid, configSubscription)
+	if err != nil {
+		system.Unsubscribe(h.pid)
+		return Handler{}, err
+	}
+
+	l, err := net.Listen("tcp", cfg.Listen)
+	if err != nil {
+		system.Unsubscribe(h.pid)
+		return Handler{}, err
+	}
+	go func() {
+		if err := h.server.Serve(l); err != http.ErrServerClosed {
+			log.Println("[REST]", err)
+		}
+	}()
+	return h, nil
+}
+
+func (h *Handler) StopProcess() {
+	h.stop <- true
+}
+
+// Process caches system messages for the API until stopped
+func (h Handler) Process() {
+loop:
+	for {
+		// a pending config is served first, so it precedes the status of its node
+		select {
+		case m, ok := <-h.configs:
+			if !ok {
+				break loop
+			}
+			h.cache.update(m)
+			continue
+		default:
+		}
+
+		select {
+		case m, ok := <-h.inbox:
+			if !ok {
+				break loop
+			}
+			h.cache.update(m)
+		case m, ok := <-h.configs:
+			if !ok {
+				break loop
+			}
+			h.cache.update(m)
+		case <-h.stop:
+			break loop
+		}
+	}
+
+	h.server.Close()
+	if err := h.operator.Release(); err != nil {
+		log.Println("[REST]", err)
+	}
+	log.Println("[REST] Process Shutdown")
+}
+
+func (c *cache) update(m msg.Msg) {
+	if _, ok := m.Payload().(asset.Alarm); ok {
+		return
+	}
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	switch m.Topic() {
+	case msg.Status:
+		c.status[m.PID()] = m
+	case msg.Config:
+		c.config[m.PID()] = m
+	}
+}
+
+// node returns the cached messages of a node
+func (c *cache) node(pid uuid.UUID, name string, isBus bool) Node {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	n := Node{PID: pid, Name: name, Bus: isBus}
+	if m, ok := c.status[pid]; ok {
+		n.Status = &m
+	}
+	if m, ok := c.config[pid]; ok {
+		n.Config = &m
+	}
+	return n
+}
+
+// ServeHTTP routes an API request
+func (h Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.URL.Path, "/api/") {
+		writeError(w, http.StatusNotFound, fmt.Sprintf("unknown path %v", r.URL.Path))
+		return
+	}
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/"), "/")
+	parts := strings.Split(path, "/")
+
+	switch {
+	case path == "topology":
+		if allow(w, r, http.MethodGet) {
+			writeJSON(w, http.StatusOK, h.system.Topology())
+		}
+	case path == "release":
+		if allow(w, r, http.MethodPost) {
+			h.release(w)
+		}
+	case path == "nodes":
+		if allow(w, r, http.MethodGet) {
+			writeJSON(w, http.StatusOK, h.nodes())
+		}
+	case parts[0] == "nodes" && len(parts) <= 3:
+		n, ok := h.find(parts[1])
+		if !ok {
+			writeError(w, http.StatusNotFound, fmt.Sprintf("unknown node %v", parts[1]))
+			return
+		}
+		h.serveNode(w, r, n, parts[2:])
+	default:
+		writeError(w, http.StatusNotFound, fmt.Sprintf("unknown path %v", r.URL.Path))
+	}
+}
+
+func (h Handler) serveNode(w http.ResponseWriter, r *http.Request, n Node, rest []string) {
+	resource := ""
+	if len(rest) > 0 {
+		resource = rest[0]
+	}
+
+	switch resource {
+	case "":
+		if allow(w, r, http.MethodGet) {
+			writeJSON(w, http.StatusOK, n)
+		}
+	case "status", "config":
+		if !allow(w, r, http.MethodGet) {
+			return
+		}
+		m := n.Status
+		if resource == "config" {
+			m = n.Config
+		}
+		if m == nil {
+			writeError(w, http.StatusNotFound, fmt.Sprintf("no %v for node %v", resource, n.Name))
+			return
+		}
+		writeJSON(w, http.StatusOK, m)
+	case "control":
+		if allow(w, r, http.MethodPost) {
+			h.control(w, r, n)
+		}
+	default:
+		writeError(w, http.StatusNotFound, fmt.Sprintf("unknown path %v", r.URL.Path))
+	}
+}
+
+// nodes returns every node of the system topology, ordered by name
+func (h Handler) nodes() []Node {
+	nodes := make([]Node, 0)
+	h.system.Topology().Walk(func(t bus.Topology) {
+		nodes = append(nodes, h.cache.node(t.PID, t.Name, t.Bus))
+	})
+	sort.Slice(nodes, func(i, j int) bool {
+		return nodes[i].Name < nodes[j].Name
+	})
+	return nodes
+}
+
+// find returns the node named by PID or name
+func (h Handler) find(name string) (Node, bool) {
+	pid, err := uuid.Parse(name)
+	var found *bus.Topology
+	h.system.Topology().Walk(func(t bus.Topology) {
+		if found == nil && ((err == nil && t.PID == pid) || t.Name == name) {
+			found = &t
+		}
+	})
+	if found == nil {
+		return Node{}, false
+	}
+	return h.cache.node(found.PID, found.Name, found.Bus), true
+}
+
+// control sends the machine control in the request body to an asset. The control
+// type is the MachineControl of the archetype of the asset's messages.
+func (h Handler) control(w http.ResponseWriter, r *http.Request, n Node) {
+	if n.Bus {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("bus %v has no machine control", n.Name))
+		return
+	}
+	m := n.Status
+	if m == nil {
+		m = n.Config
+	}
+	if m == nil {
+		writeError(w, http.StatusServiceUnavailable, fmt.Sprintf("asset %v has not reported", n.Name))
+		return
+	}
+	controlType := strings.SplitN(m.Type(), ".", 2)[0] + ".MachineControl"
+
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxBody))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	payload, err := operator.MergeControl(h.operator.Commanded(n.PID), controlType, body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	request := msg.New(h.pid, msg.Control, payload).WithTarget(n.PID)
+	if err := h.operator.Control(request); err != nil {
+		code := http.StatusInternalServerError
+		if errors.Is(err, control.ErrDenied) {
+			code = http.StatusConflict
+		}
+		writeError(w, code, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusAccepted, request)
+}
+
+// release returns control of the system to dispatch
+func (h Handler) release(w http.ResponseWriter) {
+	if err := h.operator.Release(); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// allow writes a 405 Method Not Allowed, and returns false, unless the request is of
+// the method
+func allow(w http.ResponseWriter, r *http.Request, method string) bool {
+	if r.Method == method {
+		return true
+	}
+	w.Header().Set("Allow", method)
+	writeError(w, http.StatusMethodNotAllowed, fmt.Sprintf("method %v not allowed", r.Method))
+	return false
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		code = http.StatusInternalServerError
+		data, _ = json.Marshal(errorResponse{err.Error()})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	w.Write(data)
+}
+
+func writeError(w http.ResponseWriter, code int, err string) {
+	writeJSON(w, code, errorResponse{err})
+}
@@ -0,0 +1,229 @@
+package restapi
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/ohowland/cgc_core/internal/pkg/asset"
+	"github.com/ohowland/cgc_core/internal/pkg/asset/ess"
+	"github.com/ohowland/cgc_core/internal/pkg/bus"
+	"github.com/ohowland/cgc_core/internal/pkg/control"
+	"github.com/ohowland/cgc_core/internal/pkg/mocksystem"
+	"github.com/ohowland/cgc_core/internal/pkg/msg"
+	"gotest.tools/assert"
+)
+
+var (
+	busPID = uuid.New()
+	essPID = uuid.New()
+)
+
+// topology is the tree of buses and assets of the mock system
+var topology = bus.Topology{PID: busPID, Name: "Bus-1", Bus: true, Members: []bus.Topology{
+	{PID: essPID, Name: "ess", Members: []bus.Topology{}},
+}}
+
+// configFile writes the handler config to a temporary file and returns its path. The
+// caller removes the file.
+func configFile(t *testing.T, config string) string {
+	t.Helper()
+	f, err := ioutil.TempFile("", "handler_config")
+	assert.NilError(t, err)
+	defer f.Close()
+	_, err = f.WriteString(config)
+	assert.NilError(t, err)
+	return f.Name()
+}
+
+// poll calls check until it returns nil, and fails the test with the last error of
+// check if it has not within a second. The handler processes messages on its own
+// goroutine, so a test waits for the effect of the messages it publishes.
+func poll(t *testing.T, check func() error) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for {
+		err := check()
+		if err == nil {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal(err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// newHandler returns a running Handler and a test server of its API
+func newHandler(t *testing.T, system *mocksystem.System) (Handler, *httptest.Server) {
+	path := configFile(t, `{"Listen": "127.0.0.1:0"}`)
+	defer os.Remove(path)
+	h, err := New(path, system)
+	assert.NilError(t, err)
+	go h.Process()
+	return h, httptest.NewServer(h)
+}
+
+// do sends a request and decodes the JSON response into v, if not nil
+func do(t *testing.T, method string, url string, body string, v interface{}) int {
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	assert.NilError(t, err)
+	resp, err := http.DefaultClient.Do(req)
+	assert.NilError(t, err)
+	defer resp.Body.Close()
+	if v != nil {
+		assert.NilError(t, json.NewDecoder(resp.Body).Decode(v))
+	}
+	return resp.StatusCode
+}
+
+// waitForStatus polls the node until it has reported its status
+func waitForStatus(t *testing.T, url string) {
+	poll(t, func() error {
+		n := Node{}
+		assert.Equal(t, do(t, http.MethodGet, url, "", &n), http.StatusOK)
+		if n.Status == nil {
+			return fmt.Errorf("%v has no status", url)
+		}
+		return nil
+	})
+}
+
+func TestTopology(t *testing.T) {
+	system := mocksystem.New()
+	system.SetTopology(topology)
+	h, server := newHandler(t, system)
+	defer server.Close()
+	defer h.StopProcess()
+
+	top := bus.Topology{}
+	assert.Equal(t, do(t, http.MethodGet, server.URL+"/api/topology", "", &top), http.StatusOK)
+	assert.DeepEqual(t, top, system.Topology())
+
+	e := errorResponse{}
+	assert.Equal(t, do(t, http.MethodPost, server.URL+"/api/topology", "", &e), http.StatusMethodNotAllowed)
+	assert.Equal(t, e.Error, "method POST not allowed")
+	assert.Equal(t, do(t, http.MethodGet, server.URL+"/topology", "", nil), http.StatusNotFound)
+}
+
+func TestNodes(t *testing.T) {
+	system := mocksystem.New()
+	system.SetTopology(topology)
+	h, server := newHandler(t, system)
+	defer server.Close()
+	defer h.StopProcess()
+
+	system.Forward(msg.New(essPID, msg.Config, ess.Config{Static: ess.StaticConfig{Name: "ess"}}))
+	system.Forward(msg.New(essPID, msg.Status, ess.Status{Machine: ess.MachineStatus{KW: 12.5}}))
+	system.Forward(msg.New(essPID, msg.Status, asset.Alarm{Name: asset.ControlTimeout, Active: true}))
+	waitForStatus(t, server.URL+"/api/nodes/ess")
+
+	nodes := []Node{}
+	assert.Equal(t, do(t, http.MethodGet, server.URL+"/api/nodes", "", &nodes), http.StatusOK)
+	assert.Equal(t, len(nodes), 2)
+	assert.Equal(t, nodes[0].Name, "Bus-1")
+	assert.Assert(t, nodes[0].Bus)
+	assert.Assert(t, nodes[0].Status == nil)
+	assert.Equal(t, nodes[1].PID, essPID)
+	assert.Equal(t, nodes[1].Status.Type(), "ess.Status")
+	assert.Equal(t, nodes[1].Config.Type(), "ess.Config")
+
+	status := struct {
+		Type    string
+		Payload ess.Status
+	}{}
+	assert.Equal(t, do(t, http.MethodGet, server.URL+"/api/nodes/"+essPID.String()+"/status", "", &status), http.StatusOK)
+	assert.Equal(t, status.Type, "ess.Status")
+	assert.Equal(t, status.Payload.Machine.KW, 12.5)
+
+	e := errorResponse{}
+	assert.Equal(t, do(t, http.MethodGet, server.URL+"/api/nodes/Bus-1/status", "", &e), http.StatusNotFound)
+	assert.Equal(t, e.Error, "no status for node Bus-1")
+	assert.Equal(t, do(t, http.MethodGet, server.URL+"/api/nodes/pcs", "", &e), http.StatusNotFound)
+	assert.Equal(t, e.Error, "unknown node pcs")
+	assert.Equal(t, do(t, http.MethodGet, server.URL+"/api/nodes/ess/alarms", "", nil), http.StatusNotFound)
+}
+
+func TestControl(t *testing.T) {
+	system := mocksystem.New()
+	system.SetTopology(topology)
+	h, server := newHandler(t, system)
+	defer server.Close()
+	defer h.StopProcess()
+
+	dispatch := uuid.New()
+	assert.NilError(t, system.RequestControl(dispatch, control.Dispatch, make(chan msg.Msg)))
+
+	url := server.URL + "/api/nodes/ess/control"
+	e := errorResponse{}
+	assert.Equal(t, do(t, http.MethodPost, url, `{"KW": 10}`, &e), http.StatusServiceUnavailable)
+	assert.Equal(t, e.Error, "asset ess has not reported")
+
+	system.Forward(msg.New(essPID, msg.Status, ess.Status{}))
+	waitForStatus(t, server.URL+"/api/nodes/ess")
+
+	assert.Equal(t, do(t, http.MethodPost, url, `{"KW": 10}`, &e), http.StatusBadRequest)
+	assert.Equal(t, e.Error, "ess.MachineControl omits Run, KVAR, Gridform")
+
+	assert.Equal(t, do(t, http.MethodPost, url, `{"Run": true, "KW": 10, "KVAR": 0, "Gridform": true}`, nil), http.StatusAccepted)
+	select {
+	case m := <-system.Written:
+		assert.Equal(t, m.Target(), essPID)
+		assert.Equal(t, m.Payload(), ess.MachineControl{Run: true, KW: 10, Gridform: true})
+	case <-time.After(time.Second):
+		t.Fatal("control was not written")
+	}
+	owner, priority := system.OwnerOf(essPID)
+	assert.Equal(t, priority, control.Operator)
+	assert.Assert(t, owner != dispatch)
+
+	// omitted fields keep the last control
+	assert.Equal(t, do(t, http.MethodPost, url, `{"KW": 20}`, nil), http.StatusAccepted)
+	select {
+	case m := <-system.Written:
+		assert.Equal(t, m.Payload(), ess.MachineControl{Run: true, KW: 20, Gridform: true})
+	case <-time.After(time.Second):
+		t.Fatal("control was not written")
+	}
+
+	assert.Equal(t, do(t, http.MethodPost, url, `{"Volts": 480}`, &e), http.StatusBadRequest)
+	assert.Equal(t, e.Error, `json: unknown field "Volts"`)
+	assert.Equal(t, do(t, http.MethodPost, server.URL+"/api/nodes/Bus-1/control", `{}`, &e), http.StatusBadRequest)
+	assert.Equal(t, e.Error, "bus Bus-1 has no machine control")
+	assert.Equal(t, do(t, http.MethodGet, url, "", nil), http.StatusMethodNotAllowed)
+
+	assert.Equal(t, do(t, http.MethodPost, server.URL+"/api/release", "", nil), http.StatusNoContent)
+	owner, _ = system.OwnerOf(essPID)
+	assert.Equal(t, owner, dispatch)
+}
+
+func TestControlDenied(t *testing.T) {
+	system := mocksystem.New()
+	system.SetTopology(topology)
+	h, server := newHandler(t, system)
+	defer server.Close()
+	defer h.StopProcess()
+
+	assert.NilError(t, system.RequestControl(uuid.New(), control.Operator+1, make(chan msg.Msg)))
+	system.Forward(msg.New(essPID, msg.Config, ess.Config{}))
+	poll(t, func() error {
+		n := Node{}
+		do(t, http.MethodGet, server.URL+"/api/nodes/ess", "", &n)
+		if n.Config == nil {
+			return errors.New("ess has no config")
+		}
+		return nil
+	})
+
+	e := errorResponse{}
+	assert.Equal(t, do(t, http.MethodPost, server.URL+"/api/nodes/ess/control", `{"Run": true, "KW": 0, "KVAR": 0, "Gridform": false}`, &e), http.StatusConflict)
+	assert.Equal(t, e.Error, control.ErrDenied.Error())
+}
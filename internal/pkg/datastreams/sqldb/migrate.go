@@ -0,0 +1,79 @@
+package sqldb
+
+import (
+	"database/sql"
+)
+
+// migrations are applied in order, each exactly once. The version of the last applied
+// migration is recorded in the schema_migrations table. Append new migrations to the
+// end of the list, never edit one that has been released. Column types are chosen to
+// be valid for both MySQL and SQLite. MySQL commits DDL implicitly, so a migration
+// that fails part way must be cleaned up by hand before it is retried.
+var migrations = [][]string{
+	{
+		`CREATE TABLE status (
+			timestamp_ms BIGINT NOT NULL,
+			pid VARCHAR(36) NOT NULL,
+			name VARCHAR(255),
+			type VARCHAR(64) NOT NULL,
+			seq BIGINT NOT NULL,
+			kw DOUBLE,
+			kvar DOUBLE,
+			data TEXT NOT NULL
+		)`,
+		`CREATE INDEX status_pid_time ON status (pid, timestamp_ms)`,
+		`CREATE TABLE config (
+			timestamp_ms BIGINT NOT NULL,
+			pid VARCHAR(36) NOT NULL,
+			name VARCHAR(255),
+			type VARCHAR(64) NOT NULL,
+			seq BIGINT NOT NULL,
+			data TEXT NOT NULL
+		)`,
+		`CREATE INDEX config_pid_time ON config (pid, timestamp_ms)`,
+	},
+}
+
+// migrate brings the database schema up to date
+func migrate(db *sql.DB) error {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (version INTEGER NOT NULL PRIMARY KEY)`)
+	if err != nil {
+		return err
+	}
+
+	version, err := schemaVersion(db)
+	if err != nil {
+		return err
+	}
+
+	for i := version; i < len(migrations); i++ {
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		for _, statement := range migrations[i] {
+			if _, err := tx.Exec(statement); err != nil {
+				tx.Rollback()
+				return err
+			}
+		}
+		if _, err := tx.Exec(`INSERT INTO schema_migrations (version) VALUES (?)`, i+1); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// schemaVersion returns the number of migrations applied to the database
+func schemaVersion(db *sql.DB) (int, error) {
+	var version sql.NullInt64
+	err := db.QueryRow(`SELECT MAX(version) FROM schema_migrations`).Scan(&version)
+	if err != nil {
+		return 0, err
+	}
+	return int(version.Int64), nil
+}
@@ -1,9 +1,9 @@
 package sqldb
 
 import (
-	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"log"
@@ -11,35 +11,57 @@ import (
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/ohowland/cgc_core/internal/pkg/asset"
 	"github.com/ohowland/cgc_core/internal/pkg/msg"
 
 	_ "github.com/go-sql-driver/mysql"
+	_ "github.com/mattn/go-sqlite3"
 )
 
+// Handler is a time-series historian. Every status sample is written as a row of the
+// status table, and every config message as a row of the config table. Rows are
+// buffered and inserted in batches from their own goroutine, so a slow database never
+// holds up the system publisher.
 type Handler struct {
 	mux    *sync.Mutex
 	inbox  <-chan msg.Msg
 	pid    uuid.UUID
 	config config
+	names  map[uuid.UUID]string
 	stop   chan bool
 }
 
+// config selects the database. Driver is "mysql" or "sqlite3". For sqlite3 the
+// Database is the path of the database file, and the server fields are unused.
+// FlushInterval is in milliseconds. BufferSize bounds the number of rows waiting to be
+// inserted, the oldest rows are dropped first.
 type config struct {
-	Server   string `json:"Server"`
-	Port     int    `json:"Port"`
-	Username string `json:"Username"`
-	Password string `json:"Password"`
-	Database string `json:"Database"`
+	Driver        string `json:"Driver"`
+	Server        string `json:"Server"`
+	Port          int    `json:"Port"`
+	Username      string `json:"Username"`
+	Password      string `json:"Password"`
+	Database      string `json:"Database"`
+	BatchSize     int    `json:"BatchSize"`
+	FlushInterval int    `json:"FlushInterval"`
+	BufferSize    int    `json:"BufferSize"`
 }
 
-func (h Handler) PID() uuid.UUID {
-	return h.pid
+// row is a single sample waiting to be inserted
+type row struct {
+	table     string
+	timestamp int64
+	pid       string
+	name      sql.NullString
+	kind      string
+	seq       int64
+	kw        sql.NullFloat64
+	kvar      sql.NullFloat64
+	data      string
 }
 
-func redirectMsg(chIn <-chan msg.Msg, chOut chan<- msg.Msg) {
-	for m := range chIn {
-		chOut <- m
-	}
+func (h Handler) PID() uuid.UUID {
+	return h.pid
 }
 
 func New(configPath string, system msg.Publisher) (Handler, error) {
@@ -47,28 +69,31 @@ func New(configPath string, system msg.Publisher) (Handler, error) {
 	if err != nil {
 		return Handler{}, err
 	}
-	cfg := config{}
+	cfg := config{Driver: "mysql", BatchSize: 100, FlushInterval: 1000, BufferSize: 10000}
 	if err := json.Unmarshal(jsonConfig, &cfg); err != nil {
 		return Handler{}, err
 	}
+	if cfg.Driver != "mysql" && cfg.Driver != "sqlite3" {
+		err := fmt.Sprintf("unsupported sql driver %v", cfg.Driver)
+		return Handler{}, errors.New(err)
+	}
+	if cfg.BatchSize < 1 {
+		cfg.BatchSize = 1
+	}
+	if cfg.FlushInterval < 1 {
+		cfg.FlushInterval = 1000
+	}
+	if cfg.BufferSize < cfg.BatchSize {
+		cfg.BufferSize = cfg.BatchSize
+	}
 
 	pid, _ := uuid.NewUUID()
 
-	inbox := make(chan msg.Msg, 50)
-
-	chStatus, err := system.Subscribe(pid, msg.Status)
-	if err != nil {
-		panic(err)
-	}
-	go redirectMsg(chStatus, inbox)
-
-	chConfig, err := system.Subscribe(pid, msg.Config)
+	inbox, err := system.SubscribeFiltered(pid, msg.Subscription{
+		Topics: []msg.Topic{msg.Status, msg.Config},
+		Policy: msg.Policy{Buffer: 2 * cfg.BatchSize, Delivery: msg.LatestValue},
+	})
 	if err != nil {
-		panic(err)
-	}
-	go redirectMsg(chConfig, inbox)
-
-	if err := json.Unmarshal(jsonConfig, &cfg); err != nil {
 		return Handler{}, err
 	}
 
@@ -79,6 +104,7 @@ func New(configPath string, system msg.Publisher) (Handler, error) {
 		inbox:  inbox,
 		pid:    pid,
 		config: cfg,
+		names:  make(map[uuid.UUID]string),
 		stop:   stop,
 	}, nil
 }
@@ -88,58 +114,190 @@ func (h *Handler) Stop() {
 }
 
 func (h Handler) DB() (*sql.DB, error) {
-	uri := fmt.Sprintf("%v:%v@tcp(%v:%v)/%v", h.config.Username, h.config.Password, h.config.Server, h.config.Port, h.config.Database)
-	db, err := sql.Open("mysql", uri)
-	if err != nil {
-		return nil, err
+	switch h.config.Driver {
+	case "sqlite3":
+		db, err := sql.Open("sqlite3", h.config.Database)
+		if err != nil {
+			return nil, err
+		}
+		// sqlite serializes writers, a single connection avoids busy errors
+		db.SetMaxOpenConns(1)
+		return db, nil
+	default:
+		uri := fmt.Sprintf("%v:%v@tcp(%v:%v)/%v", h.config.Username, h.config.Password, h.config.Server, h.config.Port, h.config.Database)
+		return sql.Open("mysql", uri)
 	}
-	return db, nil
 }
 
 func (h Handler) Process() {
 	db, err := h.DB()
-	defer db.Close()
 	if err != nil {
-		panic(err) // #TODO Handle failed connection
+		log.Println("[SQL]", err)
+		return
 	}
+	defer db.Close()
 
-	err = initDBTables(db)
-	if err != nil {
-		panic(err) // #TODO Handle failed query
+	if err := migrate(db); err != nil {
+		log.Println("[SQL] schema migration:", err)
+		return
 	}
 
+	var pending []row
+	dropped := 0
+	mux := &sync.Mutex{}
+	kick := make(chan struct{}, 1)
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+
+	// rows are inserted from the writer goroutine, so receiving never waits on the
+	// database
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(time.Duration(h.config.FlushInterval) * time.Millisecond)
+		defer ticker.Stop()
+
+		flush := func() {
+			mux.Lock()
+			batch := pending
+			pending = nil
+			if dropped > 0 {
+				log.Printf("[SQL] dropped %v rows while the database was busy", dropped)
+				dropped = 0
+			}
+			mux.Unlock()
+			if err := insert(db, batch); err != nil {
+				log.Printf("[SQL] dropped %v rows: %v", len(batch), err)
+			}
+		}
+
+		for {
+			select {
+			case <-kick:
+				flush()
+			case <-ticker.C:
+				flush()
+			case <-done:
+				flush()
+				return
+			}
+		}
+	}()
+
 loop:
 	for {
 		select {
-		case m := <-h.inbox:
-			switch m.Topic() {
-			case msg.Status:
-				sqlStatement := `INSERT INTO realtime VALUES (uuid $1, status $2)`
-
-				ctx, _ := context.WithTimeout(context.Background(), 1*time.Second)
-				_, err := db.ExecContext(ctx, sqlStatement, m.PID().String, m.Payload())
-				if err != nil {
-					log.Printf("error %s update db", err)
+		case m, ok := <-h.inbox:
+			if !ok {
+				break loop
+			}
+			r, err := h.toRow(m)
+			if err != nil {
+				log.Println("[SQL]", err)
+				continue
+			}
+			mux.Lock()
+			if len(pending) >= h.config.BufferSize {
+				pending = pending[1:]
+				dropped++
+			}
+			pending = append(pending, r)
+			full := len(pending) >= h.config.BatchSize
+			mux.Unlock()
+			if full {
+				select {
+				case kick <- struct{}{}:
+				default:
 				}
-
-			case msg.Config:
 			}
-
 		case <-h.stop:
 			break loop
 		}
 	}
-	log.Println("[Mongo] Process Shutdown")
+	close(done)
+	<-stopped
+	log.Println("[SQL] Process Shutdown")
 }
 
-func initDBTables(db *sql.DB) error {
-	sqlStatement := `CREATE TABLE IF NOT EXISTS realtime(uuid VARCHAR(32) primary key, status BLOB, config BLOB)`
-	_, err := db.Exec(sqlStatement)
-	return err
+// toRow flattens a status or config message into a row. The asset name is taken from
+// the most recent config message of the asset.
+func (h Handler) toRow(m msg.Msg) (row, error) {
+	data, err := json.Marshal(m.Payload())
+	if err != nil {
+		return row{}, err
+	}
+
+	r := row{
+		timestamp: m.Timestamp().UnixNano() / int64(time.Millisecond),
+		pid:       m.PID().String(),
+		kind:      m.Type(),
+		seq:       int64(m.Sequence()),
+		data:      string(data),
+	}
+
+	h.mux.Lock()
+	defer h.mux.Unlock()
+
+	switch m.Topic() {
+	case msg.Status:
+		r.table = "status"
+		if p, ok := m.Payload().(asset.RealPower); ok {
+			r.kw = sql.NullFloat64{Float64: p.KW(), Valid: true}
+		}
+		if p, ok := m.Payload().(asset.ReactivePower); ok {
+			r.kvar = sql.NullFloat64{Float64: p.KVAR(), Valid: true}
+		}
+	case msg.Config:
+		r.table = "config"
+		if c, ok := m.Payload().(interface{ Name() string }); ok {
+			h.names[m.PID()] = c.Name()
+		}
+	default:
+		err := fmt.Sprintf("historian does not record %v messages", m.Topic())
+		return row{}, errors.New(err)
+	}
+
+	if name, ok := h.names[m.PID()]; ok {
+		r.name = sql.NullString{String: name, Valid: true}
+	}
+	return r, nil
 }
 
-func updateRow(db *sql.DB, m msg.Msg) error {
-	sqlStatement := `INSERT INTO realtime (uuid, status) VALUES ($1, $2) ON DUPLICATE KEY UPDATE`
-	_, err := db.Exec(sqlStatement, m.PID().String())
-	return err
+// insert writes the batch in a single transaction
+func insert(db *sql.DB, batch []row) error {
+	if len(batch) == 0 {
+		return nil
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	status, err := tx.Prepare(`INSERT INTO status (timestamp_ms, pid, name, type, seq, kw, kvar, data) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	defer status.Close()
+
+	config, err := tx.Prepare(`INSERT INTO config (timestamp_ms, pid, name, type, seq, data) VALUES (?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	defer config.Close()
+
+	for _, r := range batch {
+		switch r.table {
+		case "status":
+			_, err = status.Exec(r.timestamp, r.pid, r.name, r.kind, r.seq, r.kw, r.kvar, r.data)
+		case "config":
+			_, err = config.Exec(r.timestamp, r.pid, r.name, r.kind, r.seq, r.data)
+		}
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
 }
@@ -1,10 +1,15 @@
 package sqldb
 
 import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/google/uuid"
-	"github.com/ohowland/cgc_core/internal/pkg/asset/mockasset"
+	"github.com/ohowland/cgc_core/internal/pkg/asset/ess"
 	"github.com/ohowland/cgc_core/internal/pkg/msg"
 	"gotest.tools/v3/assert"
 )
@@ -16,42 +21,117 @@ func newHandler() (Handler, error) {
 
 }
 
+// newSQLiteHandler returns a handler writing to a sqlite database in a temporary
+// directory, which is removed by the returned cleanup function.
+func newSQLiteHandler(t *testing.T, pub *msg.PubSub, batchSize int) (Handler, func()) {
+	dir, err := ioutil.TempDir("", "sqldb")
+	assert.NilError(t, err)
+
+	path := filepath.Join(dir, "config.json")
+	config := fmt.Sprintf(`{"Driver": "sqlite3", "Database": %q, "BatchSize": %d, "FlushInterval": 50}`,
+		filepath.Join(dir, "cgc.db"), batchSize)
+	assert.NilError(t, ioutil.WriteFile(path, []byte(config), 0644))
+
+	h, err := New(path, pub)
+	assert.NilError(t, err)
+	return h, func() { os.RemoveAll(dir) }
+}
+
 func TestGetConfig(t *testing.T) {
 	h, err := newHandler()
 	assert.NilError(t, err)
 
+	assert.Equal(t, h.config.Driver, "mysql")
 	assert.Equal(t, h.config.Port, 3306)
 	assert.Equal(t, h.config.Server, "localhost")
 }
 
-func TestDatabaseConnection(t *testing.T) {
-	h, _ := newHandler()
+func TestMigrate(t *testing.T) {
+	h, cleanup := newSQLiteHandler(t, msg.NewPublisher(uuid.New()), 1)
+	defer cleanup()
+
 	db, err := h.DB()
+	assert.NilError(t, err)
 	defer db.Close()
 
+	assert.NilError(t, migrate(db))
+	version, err := schemaVersion(db)
 	assert.NilError(t, err)
+	assert.Equal(t, version, len(migrations))
+
+	// migrations are applied once
+	assert.NilError(t, migrate(db))
+	var count int
+	assert.NilError(t, db.QueryRow(`SELECT COUNT(*) FROM schema_migrations`).Scan(&count))
+	assert.Equal(t, count, len(migrations))
 }
 
-func TestInitDatabase(t *testing.T) {
-	h, _ := newHandler()
-	db, _ := h.DB()
+func TestHistorian(t *testing.T) {
+	pub := msg.NewPublisher(uuid.New())
+	h, cleanup := newSQLiteHandler(t, pub, 3)
+	defer cleanup()
+
+	go h.Process()
+
+	pid := uuid.New()
+	cfg := ess.Config{Static: ess.StaticConfig{Name: "ESS-1", BusName: "Bus-1"}}
+	pub.Forward(msg.New(pid, msg.Config, cfg))
+	for i := 0; i < 5; i++ {
+		status := ess.Status{Machine: ess.MachineStatus{KW: float64(i), KVAR: 1}}
+		pub.Forward(msg.New(pid, msg.Status, status))
+	}
+	time.Sleep(200 * time.Millisecond) // the final partial batch flushes on the interval
+	h.Stop()
+
+	db, err := h.DB()
+	assert.NilError(t, err)
 	defer db.Close()
 
-	err := initDBTables(db)
+	var count int
+	var sum float64
+	err = db.QueryRow(`SELECT COUNT(*), SUM(kw) FROM status WHERE pid = ? AND name = ?`, pid.String(), "ESS-1").Scan(&count, &sum)
+	assert.NilError(t, err)
+	assert.Equal(t, count, 5)
+	assert.Equal(t, sum, 10.0)
+
+	var kind, data string
+	err = db.QueryRow(`SELECT type, data FROM config WHERE pid = ?`, pid.String()).Scan(&kind, &data)
 	assert.NilError(t, err)
+	assert.Equal(t, kind, "ess.Config")
+	assert.Assert(t, len(data) > 0)
 }
 
-func TestAddRowDatabase(t *testing.T) {
-	h, _ := newHandler()
-	db, _ := h.DB()
-	defer db.Close()
+func TestHistorianDoesNotStallPublisher(t *testing.T) {
+	pub := msg.NewPublisher(uuid.New())
+	h, cleanup := newSQLiteHandler(t, pub, 3)
+	defer cleanup()
 
-	mock := mockasset.New()
-	ch, err := mock.Subscribe(h.PID(), msg.Status)
+	// another writer holds the database, so the inserts wait on the busy timeout
+	db, err := h.DB()
 	assert.NilError(t, err)
-	mock.UpdateStatus()
+	defer db.Close()
+	assert.NilError(t, migrate(db))
+	_, err = db.Exec(`BEGIN IMMEDIATE`)
+	assert.NilError(t, err)
+	defer db.Exec(`ROLLBACK`)
+
+	go h.Process()
 
-	msg := <-ch
-	err = updateRow(db, msg)
+	start := time.Now()
+	pid := uuid.New()
+	for i := 0; i < 100; i++ {
+		pub.Forward(msg.New(pid, msg.Status, ess.Status{}))
+	}
+	assert.Assert(t, time.Since(start) < time.Second)
+}
+
+func TestUnsupportedDriver(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sqldb")
 	assert.NilError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "config.json")
+	assert.NilError(t, ioutil.WriteFile(path, []byte(`{"Driver": "oracle"}`), 0644))
+	_, err = New(path, msg.NewPublisher(uuid.New()))
+	assert.ErrorContains(t, err, "unsupported sql driver")
 }
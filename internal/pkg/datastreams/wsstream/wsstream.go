@@ -0,0 +1,429 @@
+package wsstream
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+	"github.com/ohowland/cgc_core/internal/pkg/asset"
+	"github.com/ohowland/cgc_core/internal/pkg/bus"
+	"github.com/ohowland/cgc_core/internal/pkg/msg"
+)
+
+// System is the control system streamed to clients
+type System interface {
+	msg.Publisher
+	Topology() bus.Topology
+}
+
+// Handler streams system status and config messages to WebSocket clients, such as the
+// cgc_web one-line diagram. Each message is a text frame holding the JSON msg
+// envelope. A client connects to the configured Path, and may filter the stream with
+// query parameters, each taking a comma separated list:
+//
+//	pid=<PID>           messages sent by the nodes
+//	archetype=<name>    messages of the archetypes, e.g. ess,pv
+//	bus=<name>          messages sent by the buses and the nodes below them
+//	topic=<topic>       status or config
+//	interval=<ms>       the time between writes, at least the configured Interval
+//
+// On connect a client is sent the latest status and config of each node that passes
+// its filter. After that, writes are rate limited to one batch per interval, and a
+// batch holds only the latest message of each node and topic. Alarms are always sent.
+type Handler struct {
+	inbox    <-chan msg.Msg
+	pid      uuid.UUID
+	config   config
+	system   System
+	hub      *hub
+	upgrader *websocket.Upgrader
+	server   *http.Server
+	stop     chan bool
+}
+
+type config struct {
+	Listen string `json:"Listen"`
+	Path   string `json:"Path"`
+	// Interval is the least time in ms between writes to a client
+	Interval int `json:"Interval"`
+	// WriteTimeout is the time in ms allowed for a write before a client is dropped
+	WriteTimeout int `json:"WriteTimeout"`
+	// AllowedOrigins are the origins of browser clients, or "*" for any origin. Only
+	// same origin requests are allowed when empty.
+	AllowedOrigins []string `json:"AllowedOrigins"`
+}
+
+// key identifies the messages coalesced in a batch
+type key struct {
+	pid   uuid.UUID
+	topic msg.Topic
+}
+
+// hub holds the latest message of each node and topic, and the connected clients
+type hub struct {
+	mux      *sync.Mutex
+	snapshot map[key]msg.Msg
+	clients  map[*client]bool
+}
+
+// client is a connected WebSocket client
+type client struct {
+	conn     *websocket.Conn
+	filter   msg.Filter
+	interval time.Duration
+	timeout  time.Duration
+	mux      *sync.Mutex
+	pending  []msg.Msg
+	index    map[key]int
+	wake     chan bool
+	done     chan bool
+	once     *sync.Once
+}
+
+func (h Handler) PID() uuid.UUID {
+	return h.pid
+}
+
+func New(configPath string, system System) (Handler, error) {
+	jsonConfig, err := ioutil.ReadFile(configPath)
+	if err != nil {
+		return Handler{}, err
+	}
+	cfg := config{Listen: ":8081", Path: "/ws", Interval: 100, WriteTimeout: 1000}
+	if err := json.Unmarshal(jsonConfig, &cfg); err != nil {
+		return Handler{}, err
+	}
+	if cfg.Interval <= 0 || cfg.WriteTimeout <= 0 {
+		return Handler{}, errors.New("websocket stream: Interval and WriteTimeout must be positive")
+	}
+
+	h := Handler{
+		config: cfg,
+		system: system,
+		hub: &hub{
+			mux:      &sync.Mutex{},
+			snapshot: make(map[key]msg.Msg),
+			clients:  make(map[*client]bool),
+		},
+		upgrader: &websocket.Upgrader{CheckOrigin: checkOrigin(cfg.AllowedOrigins)},
+		stop:     make(chan bool),
+	}
+	mux := http.NewServeMux()
+	mux.Handle(cfg.Path, h)
+	h.server = &http.Server{Handler: mux}
+
+	h.pid, _ = uuid.NewUUID()
+	h.inbox, err = system.SubscribeFiltered(h.pid, msg.Subscription{
+		Topics: []msg.Topic{msg.Status, msg.Config},
+		Policy: msg.Policy{Buffer: 50, Delivery: msg.LatestValue},
+	})
+	if err != nil {
+		return Handler{}, err
+	}
+
+	l, err := net.Listen("tcp", cfg.Listen)
+	if err != nil {
+		system.Unsubscribe(h.pid)
+		return Handler{}, err
+	}
+	go func() {
+		if err := h.server.Serve(l); err != http.ErrServerClosed {
+			log.Println("[WebSocket]", err)
+		}
+	}()
+	return h, nil
+}
+
+// checkOrigin returns the origin check of the allowed origins. The default check of
+// the upgrader, same origin only, is used when there are none.
+func checkOrigin(allowed []string) func(*http.Request) bool {
+	if len(allowed) == 0 {
+		return nil
+	}
+	return func(r *http.Request) bool {
+		origin := r.Header.Get("Origin")
+		for _, a := range allowed {
+			if a == "*" || a == origin {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+func (h *Handler) StopProcess() {
+	h.stop <- true
+}
+
+// Process streams system messages to clients until stopped
+func (h Handler) Process() {
+loop:
+	for {
+		select {
+		case m, ok := <-h.inbox:
+			if !ok {
+				break loop
+			}
+			h.hub.publish(m)
+		case <-h.stop:
+			break loop
+		}
+	}
+
+	h.server.Close()
+	h.hub.closeAll()
+	log.Println("[WebSocket] Process Shutdown")
+}
+
+// publish records the message in the snapshot and queues it to the clients it passes
+func (hb *hub) publish(m msg.Msg) {
+	hb.mux.Lock()
+	defer hb.mux.Unlock()
+	if _, ok := m.Payload().(asset.Alarm); !ok {
+		hb.snapshot[key{m.PID(), m.Topic()}] = m
+	}
+	for c := range hb.clients {
+		if c.filter(m) {
+			c.queue(m)
+		}
+	}
+}
+
+// register adds the client and queues the snapshot messages it passes, oldest first
+func (hb *hub) register(c *client) {
+	hb.mux.Lock()
+	defer hb.mux.Unlock()
+	snapshot := make([]msg.Msg, 0, len(hb.snapshot))
+	for _, m := range hb.snapshot {
+		if c.filter(m) {
+			snapshot = append(snapshot, m)
+		}
+	}
+	sort.Slice(snapshot, func(i, j int) bool {
+		return snapshot[i].Timestamp().Before(snapshot[j].Timestamp())
+	})
+	for _, m := range snapshot {
+		c.queue(m)
+	}
+	hb.clients[c] = true
+}
+
+func (hb *hub) unregister(c *client) {
+	hb.mux.Lock()
+	defer hb.mux.Unlock()
+	delete(hb.clients, c)
+}
+
+func (hb *hub) closeAll() {
+	hb.mux.Lock()
+	defer hb.mux.Unlock()
+	for c := range hb.clients {
+		c.close()
+	}
+}
+
+// ServeHTTP upgrades a client request to a WebSocket stream
+func (h Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	filter, err := h.filter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	interval := time.Duration(h.config.Interval) * time.Millisecond
+	if v := r.URL.Query().Get("interval"); v != "" {
+		ms, err := strconv.Atoi(v)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("bad interval %v", v), http.StatusBadRequest)
+			return
+		}
+		if d := time.Duration(ms) * time.Millisecond; d > interval {
+			interval = d
+		}
+	}
+
+	conn, err := h.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// the upgrader has replied to the client
+		return
+	}
+
+	c := &client{
+		conn:     conn,
+		filter:   filter,
+		interval: interval,
+		timeout:  time.Duration(h.config.WriteTimeout) * time.Millisecond,
+		mux:      &sync.Mutex{},
+		index:    make(map[key]int),
+		wake:     make(chan bool, 1),
+		done:     make(chan bool),
+		once:     &sync.Once{},
+	}
+	h.hub.register(c)
+	go c.read()
+	go func() {
+		c.write()
+		h.hub.unregister(c)
+	}()
+}
+
+// filter returns the filter of the query parameters of a client request
+func (h Handler) filter(r *http.Request) (msg.Filter, error) {
+	q := r.URL.Query()
+	filters := make([]msg.Filter, 0)
+
+	if pids := list(q.Get("pid")); len(pids) > 0 {
+		parsed := make([]uuid.UUID, len(pids))
+		for i, p := range pids {
+			pid, err := uuid.Parse(p)
+			if err != nil {
+				err := fmt.Sprintf("bad pid %v", p)
+				return nil, errors.New(err)
+			}
+			parsed[i] = pid
+		}
+		filters = append(filters, msg.FromSender(parsed...))
+	}
+
+	if archetypes := list(q.Get("archetype")); len(archetypes) > 0 {
+		filters = append(filters, msg.FromArchetype(archetypes...))
+	}
+
+	if buses := list(q.Get("bus")); len(buses) > 0 {
+		pids := make([]uuid.UUID, 0)
+		for _, name := range buses {
+			subtree, err := h.subtree(name)
+			if err != nil {
+				return nil, err
+			}
+			pids = append(pids, subtree...)
+		}
+		filters = append(filters, msg.FromSender(pids...))
+	}
+
+	if topics := list(q.Get("topic")); len(topics) > 0 {
+		set := make(map[msg.Topic]bool)
+		for _, name := range topics {
+			switch strings.ToLower(name) {
+			case "status":
+				set[msg.Status] = true
+			case "config":
+				set[msg.Config] = true
+			default:
+				err := fmt.Sprintf("bad topic %v", name)
+				return nil, errors.New(err)
+			}
+		}
+		filters = append(filters, func(m msg.Msg) bool {
+			return set[m.Topic()]
+		})
+	}
+	return msg.And(filters...), nil
+}
+
+// subtree returns the PIDs of the named bus and the nodes below it
+func (h Handler) subtree(busName string) ([]uuid.UUID, error) {
+	pids := make([]uuid.UUID, 0)
+	h.system.Topology().Walk(func(t bus.Topology) {
+		if t.Bus && t.Name == busName {
+			t.Walk(func(member bus.Topology) {
+				pids = append(pids, member.PID)
+			})
+		}
+	})
+	if len(pids) == 0 {
+		err := fmt.Sprintf("unknown bus %v", busName)
+		return nil, errors.New(err)
+	}
+	return pids, nil
+}
+
+func list(v string) []string {
+	out := make([]string, 0)
+	for _, s := range strings.Split(v, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// queue adds the message to the next batch, replacing an earlier message of the same
+// node and topic. Alarms are not replaced.
+func (c *client) queue(m msg.Msg) {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	if _, ok := m.Payload().(asset.Alarm); ok {
+		c.pending = append(c.pending, m)
+	} else if i, ok := c.index[key{m.PID(), m.Topic()}]; ok {
+		c.pending[i] = m
+	} else {
+		c.index[key{m.PID(), m.Topic()}] = len(c.pending)
+		c.pending = append(c.pending, m)
+	}
+
+	select {
+	case c.wake <- true:
+	default:
+	}
+}
+
+// write sends the queued batches, at most one per interval, until the client closes
+// or a write fails
+func (c *client) write() {
+	defer c.close()
+	for {
+		select {
+		case <-c.wake:
+		case <-c.done:
+			return
+		}
+
+		c.mux.Lock()
+		batch := c.pending
+		c.pending = make([]msg.Msg, 0, len(batch))
+		c.index = make(map[key]int)
+		c.mux.Unlock()
+
+		for _, m := range batch {
+			c.conn.SetWriteDeadline(time.Now().Add(c.timeout))
+			if err := c.conn.WriteJSON(m); err != nil {
+				return
+			}
+		}
+
+		select {
+		case <-time.After(c.interval):
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// read discards client messages, so that control frames are handled, until the
+// connection closes
+func (c *client) read() {
+	defer c.close()
+	for {
+		if _, _, err := c.conn.NextReader(); err != nil {
+			return
+		}
+	}
+}
+
+func (c *client) close() {
+	c.once.Do(func() {
+		close(c.done)
+		c.conn.Close()
+	})
+}
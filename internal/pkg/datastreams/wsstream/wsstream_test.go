@@ -0,0 +1,259 @@
+package wsstream
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+	"github.com/ohowland/cgc_core/internal/pkg/asset"
+	"github.com/ohowland/cgc_core/internal/pkg/asset/ess"
+	"github.com/ohowland/cgc_core/internal/pkg/asset/pv"
+	"github.com/ohowland/cgc_core/internal/pkg/bus"
+	"github.com/ohowland/cgc_core/internal/pkg/mocksystem"
+	"github.com/ohowland/cgc_core/internal/pkg/msg"
+	"gotest.tools/assert"
+)
+
+var (
+	bus1PID = uuid.New()
+	bus2PID = uuid.New()
+	essPID  = uuid.New()
+	pvPID   = uuid.New()
+)
+
+// topology is the tree of buses and assets of the mock system
+var topology = bus.Topology{PID: bus1PID, Name: "Bus-1", Bus: true, Members: []bus.Topology{
+	{PID: bus2PID, Name: "Bus-2", Bus: true, Members: []bus.Topology{
+		{PID: pvPID, Name: "pv", Members: []bus.Topology{}},
+	}},
+	{PID: essPID, Name: "ess", Members: []bus.Topology{}},
+}}
+
+// received is a message as decoded by a client
+type received struct {
+	Sender  uuid.UUID
+	Topic   msg.Topic
+	Type    string
+	Payload json.RawMessage
+}
+
+// configFile writes the handler config to a temporary file and returns its path. The
+// caller removes the file.
+func configFile(t *testing.T, config string) string {
+	t.Helper()
+	f, err := ioutil.TempFile("", "handler_config")
+	assert.NilError(t, err)
+	defer f.Close()
+	_, err = f.WriteString(config)
+	assert.NilError(t, err)
+	return f.Name()
+}
+
+// poll calls check until it returns nil, and fails the test with the last error of
+// check if it has not within a second. The handler processes messages on its own
+// goroutine, so a test waits for the effect of the messages it publishes.
+func poll(t *testing.T, check func() error) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for {
+		err := check()
+		if err == nil {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal(err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// newHandler returns a Handler with the config fields, and a test server of it
+func newHandler(t *testing.T, system *mocksystem.System, fields string) (Handler, *httptest.Server) {
+	path := configFile(t, fmt.Sprintf(`{"Listen": "127.0.0.1:0"%v}`, fields))
+	defer os.Remove(path)
+	h, err := New(path, system)
+	assert.NilError(t, err)
+	return h, httptest.NewServer(h)
+}
+
+func dial(t *testing.T, server *httptest.Server, query string) *websocket.Conn {
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws?" + query
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	assert.NilError(t, err)
+	return conn
+}
+
+// waitForClients waits until the count of registered clients is n
+func waitForClients(t *testing.T, h Handler, n int) {
+	poll(t, func() error {
+		h.hub.mux.Lock()
+		count := len(h.hub.clients)
+		h.hub.mux.Unlock()
+		if count != n {
+			return fmt.Errorf("%v clients registered, want %v", count, n)
+		}
+		return nil
+	})
+}
+
+func next(t *testing.T, conn *websocket.Conn) received {
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	r := received{}
+	assert.NilError(t, conn.ReadJSON(&r))
+	return r
+}
+
+// expectNone asserts that no message arrives within the wait. The connection cannot
+// be read after.
+func expectNone(t *testing.T, conn *websocket.Conn, wait time.Duration) {
+	conn.SetReadDeadline(time.Now().Add(wait))
+	r := received{}
+	err := conn.ReadJSON(&r)
+	assert.Assert(t, err != nil, "unexpected message %+v", r)
+}
+
+func TestSnapshotOnConnect(t *testing.T) {
+	system := mocksystem.New()
+	system.SetTopology(topology)
+	h, server := newHandler(t, system, "")
+	defer server.Close()
+	go h.Process()
+	defer h.StopProcess()
+
+	system.Forward(msg.New(essPID, msg.Config, ess.Config{Static: ess.StaticConfig{Name: "ess"}}))
+	system.Forward(msg.New(essPID, msg.Status, ess.Status{Machine: ess.MachineStatus{KW: 1}}))
+	system.Forward(msg.New(essPID, msg.Status, ess.Status{Machine: ess.MachineStatus{KW: 2}}))
+	system.Forward(msg.New(essPID, msg.Status, asset.Alarm{Name: asset.ControlTimeout, Active: true}))
+	poll(t, func() error {
+		h.hub.mux.Lock()
+		s, ok := h.hub.snapshot[key{essPID, msg.Status}]
+		h.hub.mux.Unlock()
+		if !ok || s.Payload().(ess.Status).Machine.KW != 2 {
+			return errors.New("status was not recorded")
+		}
+		return nil
+	})
+
+	conn := dial(t, server, "")
+	defer conn.Close()
+
+	r := next(t, conn)
+	assert.Equal(t, r.Topic, msg.Config)
+	r = next(t, conn)
+	assert.Equal(t, r.Topic, msg.Status)
+	assert.Equal(t, r.Sender, essPID)
+	status := ess.Status{}
+	assert.NilError(t, json.Unmarshal(r.Payload, &status))
+	assert.Equal(t, status.Machine.KW, 2.0)
+
+	// the snapshot holds no alarms or earlier status
+	system.Forward(msg.New(pvPID, msg.Status, pv.Status{}))
+	r = next(t, conn)
+	assert.Equal(t, r.Type, "pv.Status")
+}
+
+func TestFilters(t *testing.T) {
+	system := mocksystem.New()
+	system.SetTopology(topology)
+	h, server := newHandler(t, system, "")
+	defer server.Close()
+
+	tests := map[string][]uuid.UUID{
+		"pid=" + pvPID.String():                         {pvPID},
+		"pid=" + pvPID.String() + "," + essPID.String(): {essPID, pvPID},
+		"archetype=ess":                                 {essPID},
+		"bus=Bus-2":                                     {pvPID},
+		"bus=Bus-1&archetype=pv":                        {pvPID},
+		"topic=config":                                  {},
+	}
+	for query, want := range tests {
+		conn := dial(t, server, query)
+		waitForClients(t, h, 1)
+		h.hub.publish(msg.New(essPID, msg.Status, ess.Status{}))
+		h.hub.publish(msg.New(pvPID, msg.Status, pv.Status{}))
+		for _, pid := range want {
+			assert.Equal(t, next(t, conn).Sender, pid, query)
+		}
+		expectNone(t, conn, 20*time.Millisecond)
+		conn.Close()
+		waitForClients(t, h, 0)
+	}
+}
+
+func TestBadRequest(t *testing.T) {
+	system := mocksystem.New()
+	system.SetTopology(topology)
+	_, server := newHandler(t, system, "")
+	defer server.Close()
+
+	for query, want := range map[string]string{
+		"pid=ess":       "bad pid ess",
+		"bus=Bus-9":     "unknown bus Bus-9",
+		"topic=control": "bad topic control",
+		"interval=fast": "bad interval fast",
+	} {
+		url := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws?" + query
+		_, resp, err := websocket.DefaultDialer.Dial(url, nil)
+		assert.Equal(t, err, websocket.ErrBadHandshake)
+		assert.Equal(t, resp.StatusCode, http.StatusBadRequest)
+		body, _ := ioutil.ReadAll(resp.Body)
+		assert.Equal(t, strings.TrimSpace(string(body)), want)
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	system := mocksystem.New()
+	system.SetTopology(topology)
+	h, server := newHandler(t, system, "")
+	defer server.Close()
+
+	conn := dial(t, server, "interval=200")
+	defer conn.Close()
+	waitForClients(t, h, 1)
+
+	h.hub.publish(msg.New(essPID, msg.Status, ess.Status{Machine: ess.MachineStatus{KW: 0}}))
+	next(t, conn)
+	start := time.Now()
+	for i := 1; i <= 10; i++ {
+		h.hub.publish(msg.New(essPID, msg.Status, ess.Status{Machine: ess.MachineStatus{KW: float64(i)}}))
+		h.hub.publish(msg.New(essPID, msg.Status, asset.Alarm{Name: fmt.Sprint(i), Active: true}))
+	}
+
+	// the next batch holds the latest status and every alarm
+	r := next(t, conn)
+	assert.Assert(t, time.Since(start) >= 150*time.Millisecond)
+	status := ess.Status{}
+	assert.NilError(t, json.Unmarshal(r.Payload, &status))
+	assert.Equal(t, status.Machine.KW, 10.0)
+	for i := 1; i <= 10; i++ {
+		alarm := asset.Alarm{}
+		assert.NilError(t, json.Unmarshal(next(t, conn).Payload, &alarm))
+		assert.Equal(t, alarm.Name, fmt.Sprint(i))
+	}
+	expectNone(t, conn, 50*time.Millisecond)
+}
+
+func TestAllowedOrigins(t *testing.T) {
+	system := mocksystem.New()
+	system.SetTopology(topology)
+	_, server := newHandler(t, system, `, "AllowedOrigins": ["http://cgc-web.local"]`)
+	defer server.Close()
+
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws"
+	_, resp, err := websocket.DefaultDialer.Dial(url, http.Header{"Origin": {"http://evil.local"}})
+	assert.Equal(t, err, websocket.ErrBadHandshake)
+	assert.Equal(t, resp.StatusCode, http.StatusForbidden)
+
+	conn, _, err := websocket.DefaultDialer.Dial(url, http.Header{"Origin": {"http://cgc-web.local"}})
+	assert.NilError(t, err)
+	conn.Close()
+}
@@ -2,17 +2,27 @@ package dispatch
 
 import (
 	"github.com/google/uuid"
+	"github.com/ohowland/cgc_core/internal/pkg/dispatch/model"
+	"github.com/ohowland/cgc_core/internal/pkg/metrics"
 	"github.com/ohowland/cgc_core/internal/pkg/msg"
 )
 
+// Dispatcher decides the control of the member assets from their status. Model
+// returns the aggregate system model as of the last dispatch cycle.
 type Dispatcher interface {
 	PID() uuid.UUID
 	msg.Publisher
 	StartProcess(<-chan msg.Msg) error
+	Model() model.State
 }
 
-type State struct {
-	Status  interface{}
-	Control interface{}
-	Config  interface{}
+// State is the last status, control and config of a member asset
+type State = model.Member
+
+// CycleSeconds is the time taken by each dispatch cycle, labeled by dispatcher
+var CycleSeconds = metrics.NewHistogram("cgc_dispatch_cycle_seconds",
+	"Time taken by a dispatch cycle.", metrics.DefaultBuckets, "dispatcher")
+
+func init() {
+	metrics.Register(CycleSeconds)
 }
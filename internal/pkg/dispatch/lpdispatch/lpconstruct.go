@@ -1,45 +1,148 @@
 package lpdispatch
 
 import (
-	"math"
+	"encoding/json"
+	"io/ioutil"
 
 	"github.com/google/uuid"
 	"github.com/ohowland/cgc_core/internal/pkg/asset"
-	opt "github.com/ohowland/cgc_optimize"
+	"github.com/ohowland/cgc_core/internal/pkg/asset/pv"
 )
 
-func (lp *LPDispatch) ConstructUnit(pid uuid.UUID, a interface{})
+// unit holds the cost and capacity terms of a single asset in the linear program.
+// Xp is real power sourced by the unit, Xn is real power sunk, Xc is capacity held
+// in reserve and Xe is stored energy. Xe is the energy stored at present.
+type unit struct {
+	pid    uuid.UUID
+	status interface{}
 
-func asUnit(pid uuid.UUID, a interface{}) opt.Unit {
+	Cp float64
+	Cn float64
+	Cc float64
+	Ce float64
 
-	var Cp float64 = math.Inf(1)
-	var Cn float64 = math.Inf(1)
-	var Cc float64 = math.Inf(1)
+	XpUb float64
+	XnUb float64
+	XcUb float64
+	XeUb float64
+	Xe   float64
+}
+
+// Cost is the price of real energy sourced and sunk by an asset, the price of real
+// capacity held in reserve, and the value of energy left in storage, per kWh.
+type Cost struct {
+	RealPositiveEnergyCost float64 `json:"RealPositiveEnergyCost"`
+	RealNegativeEnergyCost float64 `json:"RealNegativeEnergyCost"`
+	RealCapacityCost       float64 `json:"RealCapacityCost"`
+	StoredEnergyValue      float64 `json:"StoredEnergyValue"`
+}
+
+// Config holds the energy costs of the assets, by asset name. An asset without a
+// listed cost is priced at the DefaultCost. ReserveKW is the spinning reserve held
+// above the dispatched output, and HorizonHours is the time a storage asset must
+// sustain its output and reserve from its stored energy.
+type Config struct {
+	DefaultCost  Cost            `json:"DefaultCost"`
+	Costs        map[string]Cost `json:"Costs"`
+	ReserveKW    float64         `json:"ReserveKW"`
+	HorizonHours float64         `json:"HorizonHours"`
+}
+
+// readConfig reads the JSON configuration file. An empty path prices every asset
+// at the same cost.
+func readConfig(configPath string) (Config, error) {
+	cfg := Config{
+		DefaultCost:  Cost{1.0, 1.0, 0.0, 0.0},
+		Costs:        make(map[string]Cost),
+		HorizonHours: 0.25,
+	}
+	if configPath == "" {
+		return cfg, nil
+	}
+	jsonConfig, err := ioutil.ReadFile(configPath)
+	if err != nil {
+		return Config{}, err
+	}
+	if err := json.Unmarshal(jsonConfig, &cfg); err != nil {
+		return Config{}, err
+	}
+	return cfg, nil
+}
+
+// cost returns the configured cost of the named asset
+func (c Config) cost(name string) Cost {
+	if cost, ok := c.Costs[name]; ok {
+		return cost
+	}
+	return c.DefaultCost
+}
+
+// named is implemented by the configuration payloads of assets
+type named interface {
+	Name() string
+}
+
+// ConstructUnit adds or replaces the unit for an asset from its status payload.
+func (d *LPDispatch) ConstructUnit(pid uuid.UUID, a interface{}) {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	name := ""
+	if n, ok := d.memberState[pid].Config.(named); ok {
+		name = n.Name()
+	}
+	d.units[pid] = asUnit(pid, a, d.config.cost(name))
+}
+
+// asUnit builds a unit from the capabilities the payload implements. A payload that
+// reports its own costs overrides the configured cost.
+func asUnit(pid uuid.UUID, a interface{}, cost Cost) unit {
+	Cp := cost.RealPositiveEnergyCost
+	Cn := cost.RealNegativeEnergyCost
+	Cc := cost.RealCapacityCost
 	c, ok := a.(asset.RealEnergyCost)
 	if ok {
 		Cp = c.RealPositiveEnergyCost()
 		Cn = c.RealNegativeEnergyCost()
 		Cc = c.RealCapacityCost()
 	}
+	Ce := cost.StoredEnergyValue
 
-	var Ce float64 = 0.0
+	// a unit of doubtful quality is given no capacity, so it is not dispatched
+	good := asset.IsGood(a)
 
 	var XpUb float64 = 0.0
 	var XnUb float64 = 0.0
 	var XcUb float64 = 0.0
 	xc, ok := a.(asset.RealCapacity)
-	if ok {
+	if ok && good {
 		XpUb = xc.RealPositiveCapacity()
 		XnUb = xc.RealNegativeCapacity()
-
 		XcUb = xc.RealPositiveCapacity()
 	}
 
 	var XeUb float64 = 0.0
+	var Xe float64 = 0.0
 	xe, ok := a.(asset.StoredEnergy)
-	if ok {
+	if ok && good {
 		XeUb = xe.StoredEnergyCapacity()
+		Xe = xe.StoredEnergy()
 	}
 
-	return opt.NewUnit(pid, Cp, Cn, Cc, Ce, XpUb, XnUb, XcUb, XeUb)
+	return unit{pid, a, Cp, Cn, Cc, Ce, XpUb, XnUb, XcUb, XeUb, Xe}
+}
+
+// isLoad reports whether the payload describes a load: a member that reports real
+// power but has no dispatchable capacity, such as a feeder.
+func isLoad(a interface{}) bool {
+	_, power := a.(asset.RealPower)
+	_, capacity := a.(asset.RealCapacity)
+	return power && !capacity
+}
+
+// isMustTake reports whether the payload describes a must-take source, such as PV.
+// The measured power of a must-take source offsets the load and the source is not
+// curtailed.
+func isMustTake(a interface{}) bool {
+	_, ok := a.(pv.Status)
+	return ok
 }
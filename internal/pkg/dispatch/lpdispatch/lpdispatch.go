@@ -6,38 +6,73 @@ import (
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/ohowland/cgc_core/internal/pkg/asset"
+	"github.com/ohowland/cgc_core/internal/pkg/asset/bms"
+	"github.com/ohowland/cgc_core/internal/pkg/asset/ess"
+	"github.com/ohowland/cgc_core/internal/pkg/asset/feeder"
+	"github.com/ohowland/cgc_core/internal/pkg/asset/grid"
+	"github.com/ohowland/cgc_core/internal/pkg/asset/pcs"
+	"github.com/ohowland/cgc_core/internal/pkg/asset/pv"
+	"github.com/ohowland/cgc_core/internal/pkg/dispatch"
+	"github.com/ohowland/cgc_core/internal/pkg/dispatch/model"
 	"github.com/ohowland/cgc_core/internal/pkg/msg"
-	opt "github.com/ohowland/cgc_optimize"
 )
 
+// LPDispatch solves the real power balance of the bus graph as a linear program and
+// publishes the resulting setpoints to each member asset.
 type LPDispatch struct {
-	mux       *sync.Mutex
-	pid       uuid.UUID
-	publisher *msg.PubSub
-	units     map[uuid.UUID]opt.Unit
-	//lp        opt.MipLinearProgram
+	mux         *sync.Mutex
+	pid         uuid.UUID
+	publisher   *msg.PubSub
+	model       *model.Model
+	config      Config
+	memberState map[uuid.UUID]dispatch.State
+	units       map[uuid.UUID]unit
+	loads       map[uuid.UUID]float64
+	mustTake    map[uuid.UUID]float64
 }
 
+// New returns a configured LPDispatch struct
 func New(configPath string) (*LPDispatch, error) {
+	config, err := readConfig(configPath)
+	if err != nil {
+		return nil, err
+	}
 	pid, err := uuid.NewUUID()
+	if err != nil {
+		return nil, err
+	}
 	pub := msg.NewPublisher(pid)
+	model, err := model.NewModel()
 	return &LPDispatch{
 			&sync.Mutex{},
 			pid,
 			pub,
-			make(map[uuid.UUID]opt.Unit),
+			&model,
+			config,
+			make(map[uuid.UUID]dispatch.State),
+			make(map[uuid.UUID]unit),
+			make(map[uuid.UUID]float64),
+			make(map[uuid.UUID]float64),
 		},
 		err
 }
 
+// PID ...
 func (d LPDispatch) PID() uuid.UUID {
-	return t.pid
+	return d.pid
 }
 
 func (d *LPDispatch) Subscribe(pid uuid.UUID, topic msg.Topic) (<-chan msg.Msg, error) {
 	return d.publisher.Subscribe(pid, topic)
 }
 
+// SubscribeFiltered returns a channel on which the subscribed topics are broadcast
+// when they pass the subscription filter
+func (d *LPDispatch) SubscribeFiltered(pid uuid.UUID, s msg.Subscription) (<-chan msg.Msg, error) {
+	return d.publisher.SubscribeFiltered(pid, s)
+}
+
 func (d *LPDispatch) Unsubscribe(pid uuid.UUID) {
 	d.publisher.Unsubscribe(pid)
 }
@@ -48,8 +83,10 @@ func (d *LPDispatch) StartProcess(ch <-chan msg.Msg) error {
 	return nil
 }
 
+// Process is the main loop
 func (d *LPDispatch) Process(ch <-chan msg.Msg) {
 	ticker := time.NewTicker(5000 * time.Millisecond)
+	defer ticker.Stop()
 loop:
 	for {
 		select {
@@ -58,21 +95,121 @@ loop:
 				log.Println("[LP Dispatch] disconnected from bus graph")
 				break loop
 			}
-			//d.ingress(m)
+			d.ingress(m)
 		case <-ticker.C:
-			//d.runSolver()
-
+			d.runSolver()
 		}
 	}
+	log.Println("[LP Dispatch] Goroutine Shutdown")
 }
 
 // ingress updates the state of the linear program
 func (d *LPDispatch) ingress(m msg.Msg) {
 	switch m.Topic() {
 	case msg.Status:
-		unit := asUnit(m.PID(), m.Payload())
-		d.units[m.PID()] = unit
+		if alarm, ok := m.Payload().(asset.Alarm); ok {
+			// alarms share the status topic, but do not replace the unit
+			log.Printf("[LP Dispatch] %v alarm %v active: %v", m.PID(), alarm.Name, alarm.Active)
+			return
+		}
+		d.mux.Lock()
+		state := d.memberState[m.PID()]
+		state.Status = m.Payload()
+		d.memberState[m.PID()] = state
+		if isLoad(m.Payload()) {
+			// a load of doubtful quality keeps its last good measurement
+			if asset.IsGood(m.Payload()) {
+				d.loads[m.PID()] = m.Payload().(asset.RealPower).KW()
+			}
+			d.mux.Unlock()
+			return
+		}
+		if isMustTake(m.Payload()) {
+			if asset.IsGood(m.Payload()) {
+				d.mustTake[m.PID()] = m.Payload().(asset.RealPower).KW()
+			}
+			d.mux.Unlock()
+			return
+		}
+		d.mux.Unlock()
+		d.ConstructUnit(m.PID(), m.Payload())
 	case msg.Config:
+		d.mux.Lock()
+		state := d.memberState[m.PID()]
+		state.Config = m.Payload()
+		d.memberState[m.PID()] = state
+		d.mux.Unlock()
+	default:
+	}
+}
+
+// runSolver balances the present load, less the output of the must-take sources,
+// across the units while holding the configured reserve, and publishes the setpoints
+func (d *LPDispatch) runSolver() {
+	start := time.Now()
+	defer func() {
+		dispatch.CycleSeconds.Observe(time.Since(start).Seconds(), "lp")
+	}()
+
+	d.mux.Lock()
+	d.model.Update(d.memberState)
+	units := make([]unit, 0, len(d.units))
+	for _, u := range d.units {
+		units = append(units, u)
+	}
+	loads := make([]uuid.UUID, 0, len(d.loads))
+	load := 0.0
+	for pid, kw := range d.loads {
+		loads = append(loads, pid)
+		load += kw
+	}
+	mustTake := make(map[uuid.UUID]interface{}, len(d.mustTake))
+	for pid, kw := range d.mustTake {
+		mustTake[pid] = d.memberState[pid].Config
+		load -= kw
+	}
+	d.mux.Unlock()
+
+	setpoints, err := solve(units, problem{load, d.config.ReserveKW, d.config.HorizonHours})
+	if err != nil {
+		log.Println("[LP Dispatch]", err)
+		return
+	}
+
+	for _, u := range units {
+		if control, ok := machineControl(u.status, setpoints[u.pid]); ok {
+			d.publisher.PublishTo(u.pid, msg.Control, control)
+		}
+	}
+	for _, pid := range loads {
+		d.publisher.PublishTo(pid, msg.Control, feeder.MachineControl{CloseFeeder: true})
+	}
+	for pid, config := range mustTake {
+		// a must-take source is limited only by its rating, once its config is known
+		if c, ok := config.(pv.Config); ok {
+			d.publisher.PublishTo(pid, msg.Control, pv.MachineControl{Run: true, KWLimit: c.Static.RatedKW})
+		}
+	}
+}
+
+// Model returns the aggregate system model as of the last dispatch cycle
+func (d LPDispatch) Model() model.State {
+	return d.model.State()
+}
+
+// machineControl returns the archetype control that realizes the setpoint, in kW.
+func machineControl(status interface{}, kw float64) (interface{}, bool) {
+	switch status.(type) {
+	case ess.Status:
+		return ess.MachineControl{Run: true, KW: kw}, true
+	case pcs.Status:
+		return pcs.MachineControl{Run: true, KW: kw}, true
+	case bms.Status:
+		return bms.MachineControl{Run: true, KW: kw}, true
+	case grid.Status:
+		// the grid is not setpoint controlled, it sources the balance while connected
+		return grid.MachineControl{CloseIntertie: true}, true
 	default:
+		return nil, false
 	}
 }
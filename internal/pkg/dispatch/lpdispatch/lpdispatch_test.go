@@ -0,0 +1,211 @@
+package lpdispatch
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/ohowland/cgc_core/internal/pkg/asset"
+	"github.com/ohowland/cgc_core/internal/pkg/asset/ess"
+	"github.com/ohowland/cgc_core/internal/pkg/asset/feeder"
+	"github.com/ohowland/cgc_core/internal/pkg/asset/grid"
+	"github.com/ohowland/cgc_core/internal/pkg/asset/pv"
+	"github.com/ohowland/cgc_core/internal/pkg/msg"
+	"gotest.tools/assert"
+)
+
+func TestNew(t *testing.T) {
+	d, err := New("")
+	assert.NilError(t, err)
+	assert.Equal(t, d.PID(), d.pid)
+}
+
+func TestSolveMeritOrder(t *testing.T) {
+	cheap, _ := uuid.NewUUID()
+	dear, _ := uuid.NewUUID()
+	units := []unit{
+		{pid: dear, Cp: 2, Cn: 2, XpUb: 100, XnUb: 100},
+		{pid: cheap, Cp: 1, Cn: 3, XpUb: 30, XnUb: 10},
+	}
+
+	setpoints, err := solve(units, problem{load: 50})
+	assert.NilError(t, err)
+	assert.Equal(t, setpoints[cheap], 30.0)
+	assert.Equal(t, setpoints[dear], 20.0)
+
+	setpoints, err = solve(units, problem{load: -20})
+	assert.NilError(t, err)
+	assert.Equal(t, setpoints[dear], -20.0)
+	assert.Equal(t, setpoints[cheap], 0.0)
+}
+
+func TestSolveEqualCostOrder(t *testing.T) {
+	a, _ := uuid.Parse("00000000-0000-0000-0000-00000000000a")
+	b, _ := uuid.Parse("00000000-0000-0000-0000-00000000000b")
+
+	for _, units := range [][]unit{
+		{{pid: a, Cp: 1, XpUb: 30}, {pid: b, Cp: 1, XpUb: 30}},
+		{{pid: b, Cp: 1, XpUb: 30}, {pid: a, Cp: 1, XpUb: 30}},
+	} {
+		setpoints, err := solve(units, problem{load: 40})
+		assert.NilError(t, err)
+		assert.Equal(t, setpoints[a], 30.0)
+		assert.Equal(t, setpoints[b], 10.0)
+	}
+}
+
+func TestSolveInfeasible(t *testing.T) {
+	pid, _ := uuid.NewUUID()
+	_, err := solve([]unit{{pid: pid, Cp: 1, XpUb: 10}}, problem{load: 20})
+	assert.ErrorContains(t, err, "infeasible")
+}
+
+func TestSolveReserve(t *testing.T) {
+	a, _ := uuid.Parse("00000000-0000-0000-0000-00000000000a")
+	b, _ := uuid.Parse("00000000-0000-0000-0000-00000000000b")
+	units := []unit{
+		{pid: a, Cp: 1, Cc: 1, XpUb: 50, XcUb: 50},
+		{pid: b, Cp: 2, Cc: 10, XpUb: 50, XcUb: 50},
+	}
+
+	setpoints, err := solve(units, problem{load: 40})
+	assert.NilError(t, err)
+	assert.Equal(t, setpoints[a], 40.0)
+	assert.Equal(t, setpoints[b], 0.0)
+
+	// reserve is cheaper to hold on a, so b serves part of the load
+	setpoints, err = solve(units, problem{load: 40, reserve: 20})
+	assert.NilError(t, err)
+	assert.Equal(t, setpoints[a], 30.0)
+	assert.Equal(t, setpoints[b], 10.0)
+
+	_, err = solve(units, problem{load: 40, reserve: 61})
+	assert.ErrorContains(t, err, "infeasible")
+}
+
+func TestSolveStoredEnergy(t *testing.T) {
+	essPID, _ := uuid.Parse("00000000-0000-0000-0000-00000000000a")
+	gridPID, _ := uuid.Parse("00000000-0000-0000-0000-00000000000b")
+	ess := unit{pid: essPID, Cp: 1, Cn: 1, XpUb: 100, XnUb: 100, XcUb: 100, XeUb: 100, Xe: 10}
+	grid := unit{pid: gridPID, Cp: 2, Cn: 2, XpUb: 100, XnUb: 100, XcUb: 100}
+
+	// the ess can sustain only 40 kW for the quarter hour horizon
+	setpoints, err := solve([]unit{ess, grid}, problem{load: 60, horizon: 0.25})
+	assert.NilError(t, err)
+	assert.Equal(t, setpoints[essPID], 40.0)
+	assert.Equal(t, setpoints[gridPID], 20.0)
+
+	// energy valued above the grid price is stored, up to the capacity of the grid
+	ess.Ce = 20
+	setpoints, err = solve([]unit{ess, grid}, problem{load: 60, horizon: 0.25})
+	assert.NilError(t, err)
+	assert.Equal(t, setpoints[essPID], -40.0)
+	assert.Equal(t, setpoints[gridPID], 100.0)
+}
+
+func TestDispatchPublishesSetpoints(t *testing.T) {
+	d, err := New("")
+	assert.NilError(t, err)
+
+	essPID, _ := uuid.NewUUID()
+	feederPID, _ := uuid.NewUUID()
+	d.ingress(msg.New(essPID, msg.Status, ess.Status{Machine: ess.MachineStatus{RealPositiveCapacity: 100, RealNegativeCapacity: 100}}))
+	d.ingress(msg.New(feederPID, msg.Status, feeder.Status{Machine: feeder.MachineStatus{KW: 40}}))
+
+	sub, _ := uuid.NewUUID()
+	ch, err := d.Subscribe(sub, msg.Control)
+	assert.NilError(t, err)
+
+	go d.runSolver()
+
+	got := make(map[uuid.UUID]interface{})
+	for len(got) < 2 {
+		select {
+		case m := <-ch:
+			got[m.Target()] = m.Payload()
+		case <-time.After(time.Second):
+			t.Fatal("setpoints were not published")
+		}
+	}
+	assert.Equal(t, got[essPID], ess.MachineControl{Run: true, KW: 40})
+	assert.Equal(t, got[feederPID], feeder.MachineControl{CloseFeeder: true})
+}
+
+func TestReadConfig(t *testing.T) {
+	cfg, err := readConfig("../../../../config/dispatch/lpdispatch.json")
+	assert.NilError(t, err)
+	assert.Equal(t, cfg.cost("grid"), Cost{RealPositiveEnergyCost: 2.0, RealNegativeEnergyCost: 2.0, RealCapacityCost: 0.1})
+	assert.Equal(t, cfg.HorizonHours, 0.25)
+	assert.Equal(t, cfg.cost("unlisted"), cfg.DefaultCost)
+}
+
+func TestDispatchConfiguredCost(t *testing.T) {
+	d, err := New("")
+	assert.NilError(t, err)
+	d.config.Costs["grid"] = Cost{RealPositiveEnergyCost: 2.0, RealNegativeEnergyCost: 2.0}
+
+	essPID, _ := uuid.NewUUID()
+	gridPID, _ := uuid.NewUUID()
+	gridConfig := grid.Config{Static: grid.StaticConfig{Name: "grid"}}
+	gridStatus := grid.Status{Machine: grid.MachineStatus{RealPositiveCapacity: 100, RealNegativeCapacity: 100}}
+	d.ingress(msg.New(gridPID, msg.Config, gridConfig))
+	d.ingress(msg.New(gridPID, msg.Status, gridStatus))
+	d.ingress(msg.New(essPID, msg.Status, ess.Status{Machine: ess.MachineStatus{RealPositiveCapacity: 30}}))
+
+	d.mux.Lock()
+	units := []unit{d.units[essPID], d.units[gridPID]}
+	d.mux.Unlock()
+	assert.Equal(t, units[1].Cp, 2.0)
+
+	setpoints, err := solve(units, problem{load: 40})
+	assert.NilError(t, err)
+	assert.Equal(t, setpoints[essPID], 30.0, "the cheaper ess is dispatched first")
+	assert.Equal(t, setpoints[gridPID], 10.0)
+}
+
+func TestDispatchMustTake(t *testing.T) {
+	d, err := New("")
+	assert.NilError(t, err)
+
+	essPID, _ := uuid.NewUUID()
+	pvPID, _ := uuid.NewUUID()
+	feederPID, _ := uuid.NewUUID()
+	d.ingress(msg.New(essPID, msg.Status, ess.Status{Machine: ess.MachineStatus{RealPositiveCapacity: 100, RealNegativeCapacity: 100}}))
+	d.ingress(msg.New(pvPID, msg.Config, pv.Config{Static: pv.StaticConfig{Name: "pv", RatedKW: 50}}))
+	d.ingress(msg.New(pvPID, msg.Status, pv.Status{Machine: pv.MachineStatus{KW: 25}}))
+	d.ingress(msg.New(feederPID, msg.Status, feeder.Status{Machine: feeder.MachineStatus{KW: 40}}))
+
+	sub, _ := uuid.NewUUID()
+	ch, err := d.Subscribe(sub, msg.Control)
+	assert.NilError(t, err)
+
+	go d.runSolver()
+
+	got := make(map[uuid.UUID]interface{})
+	for len(got) < 3 {
+		select {
+		case m := <-ch:
+			got[m.Target()] = m.Payload()
+		case <-time.After(time.Second):
+			t.Fatal("setpoints were not published")
+		}
+	}
+	assert.Equal(t, got[essPID], ess.MachineControl{Run: true, KW: 15})
+	assert.Equal(t, got[pvPID], pv.MachineControl{Run: true, KWLimit: 50})
+}
+
+func TestIngressBadQuality(t *testing.T) {
+	d, err := New("")
+	assert.NilError(t, err)
+
+	essPID, _ := uuid.NewUUID()
+	feederPID, _ := uuid.NewUUID()
+	d.ingress(msg.New(feederPID, msg.Status, feeder.Status{Machine: feeder.MachineStatus{KW: 40}}))
+	d.ingress(msg.New(feederPID, msg.Status, feeder.Status{Machine: feeder.MachineStatus{KW: 0xBEEF, Quality: asset.CommFail}}))
+	d.ingress(msg.New(essPID, msg.Status, ess.Status{Machine: ess.MachineStatus{RealPositiveCapacity: 100, Quality: asset.OutOfRange}}))
+
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	assert.Equal(t, d.loads[feederPID], 40.0, "the last good load is kept")
+	assert.Equal(t, d.units[essPID].XpUb, 0.0, "a unit of doubtful quality has no capacity")
+}
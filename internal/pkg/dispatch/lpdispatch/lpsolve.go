@@ -0,0 +1,91 @@
+package lpdispatch
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/google/uuid"
+)
+
+// problem holds the system wide terms of the linear program. Reserve is the real
+// power, in kW, held above the dispatched output of the units. Horizon is the time,
+// in hours, a storage unit must sustain its output and reserve from stored energy.
+type problem struct {
+	load    float64
+	reserve float64
+	horizon float64
+}
+
+// solve returns the real power setpoint of each unit that balances the load at
+// minimum cost. Each unit contributes the variables Xp (power sourced), Xn (power
+// sunk) and Xc (capacity held in reserve):
+//
+//	min  sum(Cp*Xp + Cn*Xn + Cc*Xc - Ce*Xe)
+//	s.t. sum(Xp - Xn) = load
+//	     sum(Xc) >= reserve
+//	     Xp + Xc <= XpUb, Xn <= XnUb, Xc <= XcUb
+//	     Xe = E - horizon*(Xp - Xn), 0 <= Xe <= XeUb
+//	     horizon*(Xp + Xc - Xn) <= E
+//
+// where E is the stored energy of the unit and Xe the energy left at the end of the
+// horizon. The stored energy terms apply only to units with a storage capacity.
+// Positive setpoints source power.
+func solve(units []unit, p problem) (map[uuid.UUID]float64, error) {
+	order := make([]unit, len(units))
+	copy(order, units)
+	sort.Slice(order, func(i, j int) bool {
+		return order[i].pid.String() < order[j].pid.String()
+	})
+
+	n := 3 * len(order)
+	c := make([]float64, n)
+	cons := make([]constraint, 0, 2+5*len(order))
+	row := func() []float64 { return make([]float64, n) }
+
+	balance, reserve := row(), row()
+	for i, u := range order {
+		xp, xn, xc := 3*i, 3*i+1, 3*i+2
+		// units of equal cost are ordered by PID, so the dispatch is the same each cycle
+		tie := eps * 10 * float64(i+1)
+		c[xp] = u.Cp + u.Ce*p.horizon + tie
+		c[xn] = u.Cn - u.Ce*p.horizon + tie
+		c[xc] = u.Cc + tie
+
+		balance[xp], balance[xn] = 1, -1
+		reserve[xc] = 1
+
+		upper := row()
+		upper[xp], upper[xc] = 1, 1
+		cons = append(cons, constraint{upper, le, u.XpUb})
+		sink := row()
+		sink[xn] = 1
+		cons = append(cons, constraint{sink, le, u.XnUb})
+		held := row()
+		held[xc] = 1
+		cons = append(cons, constraint{held, le, u.XcUb})
+
+		if u.XeUb > 0 {
+			empty := row()
+			empty[xp], empty[xn], empty[xc] = p.horizon, -p.horizon, p.horizon
+			cons = append(cons, constraint{empty, le, u.Xe})
+			full := row()
+			full[xp], full[xn] = -p.horizon, p.horizon
+			cons = append(cons, constraint{full, le, u.XeUb - u.Xe})
+		}
+	}
+	cons = append(cons, constraint{balance, eq, p.load})
+	cons = append(cons, constraint{reserve, ge, p.reserve})
+
+	x, err := simplex(c, cons)
+	if err != nil {
+		return nil, fmt.Errorf("%v dispatch of %.2f kW load with %.2f kW reserve", err, p.load, p.reserve)
+	}
+
+	// setpoints are rounded to the watt
+	setpoints := make(map[uuid.UUID]float64)
+	for i, u := range order {
+		setpoints[u.pid] = math.Round((x[3*i]-x[3*i+1])*1000) / 1000
+	}
+	return setpoints, nil
+}
@@ -0,0 +1,206 @@
+package lpdispatch
+
+import (
+	"errors"
+	"math"
+)
+
+const eps = 1e-9
+
+// sense is the relation of a constraint row to its right hand side
+type sense int
+
+const (
+	le sense = iota
+	ge
+	eq
+)
+
+// constraint is a single row of a linear program: coef . x (sense) rhs
+type constraint struct {
+	coef  []float64
+	sense sense
+	rhs   float64
+}
+
+var (
+	errInfeasible = errors.New("infeasible")
+	errUnbounded  = errors.New("unbounded")
+)
+
+// simplex minimizes c . x subject to the constraints and x >= 0. It is a dense two
+// phase simplex with Bland's rule, which is sufficient for the few variables of a
+// microgrid dispatch.
+func simplex(c []float64, cons []constraint) ([]float64, error) {
+	n := len(c)
+	m := len(cons)
+
+	nSlack, nArt := 0, 0
+	for _, con := range cons {
+		s := con.sense
+		if con.rhs < 0 {
+			s = flip(s)
+		}
+		switch s {
+		case le:
+			nSlack++
+		case ge:
+			nSlack++
+			nArt++
+		case eq:
+			nArt++
+		}
+	}
+	artStart := n + nSlack
+	cols := artStart + nArt
+
+	t := make([][]float64, m)
+	basis := make([]int, m)
+	slack, art := n, artStart
+	for i, con := range cons {
+		row := make([]float64, cols+1)
+		sign, s := 1.0, con.sense
+		if con.rhs < 0 {
+			sign, s = -1.0, flip(s)
+		}
+		for j, a := range con.coef {
+			row[j] = sign * a
+		}
+		row[cols] = sign * con.rhs
+		switch s {
+		case le:
+			row[slack] = 1
+			basis[i] = slack
+			slack++
+		case ge:
+			row[slack] = -1
+			slack++
+			row[art] = 1
+			basis[i] = art
+			art++
+		case eq:
+			row[art] = 1
+			basis[i] = art
+			art++
+		}
+		t[i] = row
+	}
+
+	if nArt > 0 {
+		phase1 := make([]float64, cols)
+		for j := artStart; j < cols; j++ {
+			phase1[j] = 1
+		}
+		if err := optimize(t, basis, phase1, cols); err != nil {
+			return nil, err
+		}
+		if objective(t, basis, phase1, cols) > eps {
+			return nil, errInfeasible
+		}
+		// drive the artificial variables left in the basis at zero out of it
+		for i, b := range basis {
+			if b < artStart {
+				continue
+			}
+			for j := 0; j < artStart; j++ {
+				if math.Abs(t[i][j]) > eps {
+					pivot(t, basis, i, j)
+					break
+				}
+			}
+		}
+	}
+
+	phase2 := make([]float64, cols)
+	copy(phase2, c)
+	if err := optimize(t, basis, phase2, artStart); err != nil {
+		return nil, err
+	}
+
+	x := make([]float64, n)
+	for i, b := range basis {
+		if b < n {
+			x[b] = t[i][len(t[i])-1]
+		}
+	}
+	return x, nil
+}
+
+// optimize pivots the tableau to the minimum of the cost. Only the columns below
+// entering may enter the basis.
+func optimize(t [][]float64, basis []int, cost []float64, entering int) error {
+	for iter := 0; iter < 10000; iter++ {
+		col := -1
+		for j := 0; j < entering; j++ {
+			if reducedCost(t, basis, cost, j) < -eps {
+				col = j
+				break
+			}
+		}
+		if col < 0 {
+			return nil
+		}
+
+		row := -1
+		ratio := math.Inf(1)
+		for i := range t {
+			a := t[i][col]
+			if a <= eps {
+				continue
+			}
+			r := t[i][len(t[i])-1] / a
+			if r < ratio-eps || (r < ratio+eps && row >= 0 && basis[i] < basis[row]) {
+				row, ratio = i, r
+			}
+		}
+		if row < 0 {
+			return errUnbounded
+		}
+		pivot(t, basis, row, col)
+	}
+	return errors.New("simplex did not converge")
+}
+
+func reducedCost(t [][]float64, basis []int, cost []float64, j int) float64 {
+	r := cost[j]
+	for i, b := range basis {
+		r -= cost[b] * t[i][j]
+	}
+	return r
+}
+
+func objective(t [][]float64, basis []int, cost []float64, cols int) float64 {
+	z := 0.0
+	for i, b := range basis {
+		z += cost[b] * t[i][cols]
+	}
+	return z
+}
+
+func pivot(t [][]float64, basis []int, row int, col int) {
+	p := t[row][col]
+	for j := range t[row] {
+		t[row][j] /= p
+	}
+	for i := range t {
+		if i == row || t[i][col] == 0 {
+			continue
+		}
+		f := t[i][col]
+		for j := range t[i] {
+			t[i][j] -= f * t[row][j]
+		}
+	}
+	basis[row] = col
+}
+
+func flip(s sense) sense {
+	switch s {
+	case le:
+		return ge
+	case ge:
+		return le
+	default:
+		return eq
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/ohowland/cgc_core/internal/pkg/asset"
 	"github.com/ohowland/cgc_core/internal/pkg/asset/feeder"
 	"github.com/ohowland/cgc_core/internal/pkg/asset/grid"
 	"github.com/ohowland/cgc_core/internal/pkg/dispatch"
@@ -48,6 +49,12 @@ func (d *ManualDispatch) Subscribe(pid uuid.UUID, topic msg.Topic) (<-chan msg.M
 	return d.publisher.Subscribe(pid, topic)
 }
 
+// SubscribeFiltered returns a channel on which the subscribed topics are broadcast
+// when they pass the subscription filter
+func (d *ManualDispatch) SubscribeFiltered(pid uuid.UUID, s msg.Subscription) (<-chan msg.Msg, error) {
+	return d.publisher.SubscribeFiltered(pid, s)
+}
+
 func (d *ManualDispatch) Unsubscribe(pid uuid.UUID) {
 	d.publisher.Unsubscribe(pid)
 }
@@ -66,21 +73,24 @@ loop:
 			}
 			d.ingress(m)
 		case <-ticker.C:
+			start := time.Now()
+			d.mux.Lock()
 			d.model.Update(d.memberState)
+			d.mux.Unlock()
 			// d.optimization.Run()
 			// d.stateMachine.Run()
-			if m, ok := d.gridRunMsg(); ok {
-				//log.Println("[Dispatch] Write", m)
-				d.publisher.Publish(msg.Control, m)
+			if pid, control, ok := d.gridRunControl(); ok {
+				d.publisher.PublishTo(pid, msg.Control, control)
 			} else {
 				log.Println("[Dispatch] No Grid Asset Found")
 			}
 
-			if m, ok := d.feederRunMsg(); ok {
-				d.publisher.Publish(msg.Control, m)
+			if pid, control, ok := d.feederRunControl(); ok {
+				d.publisher.PublishTo(pid, msg.Control, control)
 			} else {
 				log.Println("[Dispatch] No Feeder Asset Found")
 			}
+			dispatch.CycleSeconds.Observe(time.Since(start).Seconds(), "manual")
 		}
 	}
 	log.Println("[Dispatch] Goroutine Shutdown")
@@ -92,18 +102,23 @@ func (d *ManualDispatch) ingress(m msg.Msg) {
 
 	switch m.Topic() {
 	case msg.Status:
+		if alarm, ok := m.Payload().(asset.Alarm); ok {
+			// alarms share the status topic, but do not replace the member status
+			log.Printf("[Dispatch] %v alarm %v active: %v", m.PID(), alarm.Name, alarm.Active)
+			return
+		}
 		state := d.MemberState(m.PID())
 		state.Status = m.Payload()
 		d.memberState[m.PID()] = state
 
 	case msg.Config:
 		state := d.MemberState(m.PID())
-		state.Control = m.Payload()
+		state.Config = m.Payload()
 		d.memberState[m.PID()] = state
 
 	case msg.Control:
 		state := d.MemberState(m.PID())
-		state.Config = m.Payload()
+		state.Control = m.Payload()
 		d.memberState[m.PID()] = state
 	}
 }
@@ -117,28 +132,24 @@ func (d ManualDispatch) MemberState(pid uuid.UUID) dispatch.State {
 }
 
 /* THIS IS TEMPORARY */
-func (d *ManualDispatch) gridRunMsg() (msg.Msg, bool) {
+func (d *ManualDispatch) gridRunControl() (uuid.UUID, grid.MachineControl, bool) {
 	for pid, state := range d.memberState {
 		_, ok := state.Status.(grid.Status)
 		if ok {
-			control := grid.MachineControl{CloseIntertie: true}
-			m := msg.New(pid, msg.Control, control)
-			return m, true
+			return pid, grid.MachineControl{CloseIntertie: true}, true
 		}
 	}
-	return msg.Msg{}, false
+	return uuid.UUID{}, grid.MachineControl{}, false
 }
 
-func (d *ManualDispatch) feederRunMsg() (msg.Msg, bool) {
+func (d *ManualDispatch) feederRunControl() (uuid.UUID, feeder.MachineControl, bool) {
 	for pid, state := range d.memberState {
 		_, ok := state.Status.(feeder.Status)
 		if ok {
-			control := feeder.MachineControl{CloseFeeder: true}
-			m := msg.New(pid, msg.Control, control)
-			return m, true
+			return pid, feeder.MachineControl{CloseFeeder: true}, true
 		}
 	}
-	return msg.Msg{}, false
+	return uuid.UUID{}, feeder.MachineControl{}, false
 }
 
 // DropAsset ...
@@ -161,6 +172,11 @@ func (d ManualDispatch) GetStatus(pid uuid.UUID) (interface{}, bool) {
 	return state.Status, ok
 }
 
+// Model returns the aggregate system model as of the last dispatch cycle
+func (d ManualDispatch) Model() model.State {
+	return d.model.State()
+}
+
 // PID ...
 func (d ManualDispatch) PID() uuid.UUID {
 	return d.pid
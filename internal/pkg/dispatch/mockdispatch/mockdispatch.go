@@ -6,6 +6,7 @@ import (
 	"github.com/google/uuid"
 	"github.com/ohowland/cgc_core/internal/pkg/asset/mockasset"
 	"github.com/ohowland/cgc_core/internal/pkg/dispatch"
+	"github.com/ohowland/cgc_core/internal/pkg/dispatch/model"
 	"github.com/ohowland/cgc_core/internal/pkg/msg"
 )
 
@@ -51,6 +52,12 @@ func (d MockDispatch) MsgList() []msg.Msg {
 func (d MockDispatch) Subscribe(pid uuid.UUID, topic msg.Topic) (<-chan msg.Msg, error) {
 	return d.pub.Subscribe(pid, topic)
 }
+
+// SubscribeFiltered returns a channel on which the subscribed topics are broadcast
+// when they pass the subscription filter
+func (d MockDispatch) SubscribeFiltered(pid uuid.UUID, s msg.Subscription) (<-chan msg.Msg, error) {
+	return d.pub.SubscribeFiltered(pid, s)
+}
 func (d MockDispatch) Unsubscribe(pid uuid.UUID) {}
 
 func (d MockDispatch) PID() uuid.UUID {
@@ -60,3 +67,8 @@ func (d MockDispatch) PID() uuid.UUID {
 func (d MockDispatch) StartProcess(<-chan msg.Msg) error {
 	return nil
 }
+
+// Model returns an empty system model
+func (d MockDispatch) Model() model.State {
+	return model.State{}
+}
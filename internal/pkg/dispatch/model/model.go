@@ -4,17 +4,55 @@ import (
 	"sync"
 
 	"github.com/google/uuid"
-	"github.com/ohowland/cgc_core/internal/pkg/dispatch"
+	"github.com/ohowland/cgc_core/internal/pkg/asset"
+	"github.com/ohowland/cgc_core/internal/pkg/asset/ess"
+	"github.com/ohowland/cgc_core/internal/pkg/asset/feeder"
+	"github.com/ohowland/cgc_core/internal/pkg/asset/grid"
+	"github.com/ohowland/cgc_core/internal/pkg/asset/pv"
 )
 
+// Model aggregates the state of the dispatcher's member assets into the system
+// wide quantities shared by every dispatch strategy.
 type Model struct {
 	mux   *sync.Mutex
 	state State
 }
 
+// Member is the last status, control and config of a member asset
+type Member struct {
+	Status  interface{}
+	Control interface{}
+	Config  interface{}
+}
+
+// State is a snapshot of the aggregate system model
 type State struct {
 	power    Power
 	capacity Capacity
+	reserve  float64
+	buses    map[string]Balance
+}
+
+func (s State) Power() Power       { return s.power }
+func (s State) Capacity() Capacity { return s.capacity }
+
+// SpinningReserve returns the real power, in kW, that the online ESS and grid
+// assets can source above their present output.
+func (s State) SpinningReserve() float64 { return s.reserve }
+
+// Bus returns the power balance of the named bus
+func (s State) Bus(name string) (Balance, bool) {
+	b, ok := s.buses[name]
+	return b, ok
+}
+
+// Buses returns the names of the buses with members in the model
+func (s State) Buses() []string {
+	names := make([]string, 0, len(s.buses))
+	for name := range s.buses {
+		names = append(names, name)
+	}
+	return names
 }
 
 type Capacity struct {
@@ -35,10 +73,81 @@ func (p Power) PrimaryLoad() float64   { return p.primaryLoad }
 func (p Power) RenewableLoad() float64 { return p.renewableLoad }
 func (p Power) NetLoad() float64       { return p.netLoad }
 
+// Balance is the real power flow on a single bus. Supply is the output of the ESS,
+// grid and PV members and Load is the demand of the feeders.
+type Balance struct {
+	supply float64
+	load   float64
+}
+
+func (b Balance) Supply() float64 { return b.supply }
+func (b Balance) Load() float64   { return b.load }
+
+// Net returns the supply in excess of load, which is zero on a balanced bus
+func (b Balance) Net() float64 { return b.supply - b.load }
+
+// busName is implemented by asset config payloads
+type busName interface {
+	BusName() string
+}
+
 func NewModel() (Model, error) {
-	return Model{&sync.Mutex{}, State{}}, nil
+	return Model{&sync.Mutex{}, State{buses: make(map[string]Balance)}}, nil
+}
+
+// Update rebuilds the aggregate state from the member states. Members are assigned to
+// a bus once their config has been received.
+func (m *Model) Update(s map[uuid.UUID]Member) {
+	state := State{buses: make(map[string]Balance)}
+
+	for _, member := range s {
+		if !asset.IsGood(member.Status) {
+			// leave measurements of doubtful quality out of the model
+			continue
+		}
+		var supply, load float64
+		switch status := member.Status.(type) {
+		case feeder.Status:
+			load = status.KW()
+			state.power.primaryLoad += load
+		case pv.Status:
+			supply = status.KW()
+			state.power.renewableLoad += supply
+		case ess.Status:
+			supply = status.KW()
+			state.capacity.realPositiveCapacity += status.RealPositiveCapacity()
+			state.capacity.realNegativeCapacity += status.RealNegativeCapacity()
+			if status.Machine.Online {
+				state.reserve += status.RealPositiveCapacity() - supply
+			}
+		case grid.Status:
+			supply = status.KW()
+			state.capacity.realPositiveCapacity += status.RealPositiveCapacity()
+			state.capacity.realNegativeCapacity += status.RealNegativeCapacity()
+			if status.Machine.Online {
+				state.reserve += status.RealPositiveCapacity() - supply
+			}
+		default:
+			continue
+		}
+
+		if cfg, ok := member.Config.(busName); ok {
+			balance := state.buses[cfg.BusName()]
+			balance.supply += supply
+			balance.load += load
+			state.buses[cfg.BusName()] = balance
+		}
+	}
+	state.power.netLoad = state.power.primaryLoad - state.power.renewableLoad
+
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	m.state = state
 }
 
-func (m Model) Update(s map[uuid.UUID]dispatch.State) {
-	return
+// State returns the most recent snapshot of the model
+func (m *Model) State() State {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	return m.state
 }
@@ -1 +1,81 @@
-package model 
+package model
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/ohowland/cgc_core/internal/pkg/asset"
+	"github.com/ohowland/cgc_core/internal/pkg/asset/ess"
+	"github.com/ohowland/cgc_core/internal/pkg/asset/feeder"
+	"github.com/ohowland/cgc_core/internal/pkg/asset/grid"
+	"github.com/ohowland/cgc_core/internal/pkg/asset/pv"
+	"gotest.tools/assert"
+)
+
+func member(status interface{}, busName string) Member {
+	return Member{
+		Status: status,
+		Config: feeder.Config{Static: feeder.StaticConfig{BusName: busName}},
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	m, err := NewModel()
+	assert.NilError(t, err)
+
+	members := map[uuid.UUID]Member{
+		uuid.New(): member(feeder.Status{Machine: feeder.MachineStatus{KW: 60}}, "Bus-1"),
+		uuid.New(): member(feeder.Status{Machine: feeder.MachineStatus{KW: 20}}, "Bus-2"),
+		uuid.New(): member(pv.Status{Machine: pv.MachineStatus{KW: 30}}, "Bus-2"),
+		uuid.New(): member(ess.Status{Machine: ess.MachineStatus{KW: 10, RealPositiveCapacity: 50, RealNegativeCapacity: 50, Online: true}}, "Bus-1"),
+		uuid.New(): member(grid.Status{Machine: grid.MachineStatus{KW: 40, RealPositiveCapacity: 100, RealNegativeCapacity: 100, Online: false}}, "Bus-1"),
+	}
+	m.Update(members)
+	s := m.State()
+
+	assert.Equal(t, s.Power().PrimaryLoad(), 80.0)
+	assert.Equal(t, s.Power().RenewableLoad(), 30.0)
+	assert.Equal(t, s.Power().NetLoad(), 50.0)
+	assert.Equal(t, s.Capacity().RealPositiveCapacity(), 150.0)
+	assert.Equal(t, s.Capacity().RealNegativeCapacity(), 150.0)
+	assert.Equal(t, s.SpinningReserve(), 40.0)
+
+	bus1, ok := s.Bus("Bus-1")
+	assert.Assert(t, ok)
+	assert.Equal(t, bus1.Supply(), 50.0)
+	assert.Equal(t, bus1.Load(), 60.0)
+	assert.Equal(t, bus1.Net(), -10.0)
+
+	bus2, ok := s.Bus("Bus-2")
+	assert.Assert(t, ok)
+	assert.Equal(t, bus2.Net(), 10.0)
+	assert.Equal(t, len(s.Buses()), 2)
+}
+
+func TestUpdateReplacesState(t *testing.T) {
+	m, _ := NewModel()
+	pid := uuid.New()
+	m.Update(map[uuid.UUID]Member{
+		pid: member(feeder.Status{Machine: feeder.MachineStatus{KW: 60}}, "Bus-1"),
+	})
+	m.Update(map[uuid.UUID]Member{})
+
+	s := m.State()
+	assert.Equal(t, s.Power().PrimaryLoad(), 0.0)
+	_, ok := s.Bus("Bus-1")
+	assert.Assert(t, !ok)
+}
+
+func TestUpdateSkipsBadQuality(t *testing.T) {
+	m, _ := NewModel()
+	m.Update(map[uuid.UUID]Member{
+		uuid.New(): member(feeder.Status{Machine: feeder.MachineStatus{KW: 60}}, "Bus-1"),
+		uuid.New(): member(feeder.Status{Machine: feeder.MachineStatus{KW: 0xBEEF, Quality: asset.CommFail}}, "Bus-1"),
+		uuid.New(): member(ess.Status{Machine: ess.MachineStatus{RealPositiveCapacity: 50, Online: true, Quality: asset.Stale}}, "Bus-1"),
+	})
+
+	s := m.State()
+	assert.Equal(t, s.Power().PrimaryLoad(), 60.0)
+	assert.Equal(t, s.Capacity().RealPositiveCapacity(), 0.0)
+	assert.Equal(t, s.SpinningReserve(), 0.0)
+}
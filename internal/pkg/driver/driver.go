@@ -0,0 +1,100 @@
+/*
+driver.go A registry of named driver factories, shared by the asset and bus archetypes.
+Each archetype holds a Registry of its factory type, such as ess.DeviceFactory, and
+wraps it with typed Register, Drivers and NewWithDriver functions.
+*/
+
+package driver
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"sync"
+)
+
+// Registry holds the driver factories of an archetype by name
+type Registry struct {
+	mux       *sync.RWMutex
+	archetype string
+	factories map[string]interface{}
+}
+
+// NewRegistry returns an empty Registry of the archetype, which prefixes its errors
+func NewRegistry(archetype string) *Registry {
+	return &Registry{
+		mux:       &sync.RWMutex{},
+		archetype: archetype,
+		factories: make(map[string]interface{}),
+	}
+}
+
+// Register makes a driver factory available by the provided name.
+// Register panics if called twice with the same name or if factory is nil.
+func (r *Registry) Register(name string, factory interface{}) {
+	r.mux.Lock()
+	defer r.mux.Unlock()
+	if v := reflect.ValueOf(factory); !v.IsValid() || (v.Kind() == reflect.Func && v.IsNil()) {
+		panic(r.archetype + ": Register factory is nil")
+	}
+	if _, dup := r.factories[name]; dup {
+		panic(r.archetype + ": Register called twice for driver " + name)
+	}
+	r.factories[name] = factory
+}
+
+// Drivers returns a sorted list of the names of the registered drivers.
+func (r *Registry) Drivers() []string {
+	r.mux.RLock()
+	defer r.mux.RUnlock()
+	list := make([]string, 0, len(r.factories))
+	for name := range r.factories {
+		list = append(list, name)
+	}
+	sort.Strings(list)
+	return list
+}
+
+// Factory returns the factory of the named driver
+func (r *Registry) Factory(name string) (interface{}, error) {
+	r.mux.RLock()
+	defer r.mux.RUnlock()
+	factory, ok := r.factories[name]
+	if !ok {
+		err := fmt.Sprintf("%v: unknown driver %v", r.archetype, name)
+		return nil, errors.New(err)
+	}
+	return factory, nil
+}
+
+// ConfigDir is the key under which ReadConfig records the directory of the config file
+const ConfigDir = "ConfigDir"
+
+// ReadConfig returns the json configuration of the file at path, with the absolute
+// directory of the file set as its ConfigDir. Drivers resolve the relative paths in a
+// configuration, such as a register map, against the ConfigDir, so that they do not
+// depend on the working directory.
+func ReadConfig(path string) ([]byte, error) {
+	jsonConfig, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	dir, err := filepath.Abs(filepath.Dir(path))
+	if err != nil {
+		return nil, err
+	}
+
+	fields := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(jsonConfig, &fields); err != nil {
+		return nil, err
+	}
+	fields[ConfigDir], err = json.Marshal(dir)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(fields)
+}
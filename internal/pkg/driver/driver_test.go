@@ -0,0 +1,59 @@
+package driver
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"gotest.tools/assert"
+)
+
+type factory func() string
+
+func TestRegistry(t *testing.T) {
+	r := NewRegistry("test")
+	r.Register("b", factory(func() string { return "b" }))
+	r.Register("a", factory(func() string { return "a" }))
+	assert.DeepEqual(t, r.Drivers(), []string{"a", "b"})
+
+	f, err := r.Factory("a")
+	assert.NilError(t, err)
+	assert.Equal(t, f.(factory)(), "a")
+
+	_, err = r.Factory("c")
+	assert.Error(t, err, "test: unknown driver c")
+}
+
+func TestRegisterPanics(t *testing.T) {
+	r := NewRegistry("test")
+	r.Register("a", factory(func() string { return "a" }))
+
+	for name, f := range map[string]interface{}{
+		"a":   factory(func() string { return "a" }),
+		"nil": factory(nil),
+	} {
+		func() {
+			defer func() {
+				assert.Assert(t, recover() != nil, name)
+			}()
+			r.Register(name, f)
+		}()
+	}
+}
+
+func TestReadConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "driver")
+	assert.NilError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "asset.json")
+	assert.NilError(t, ioutil.WriteFile(path, []byte(`{"Name": "ess"}`), 0644))
+
+	jsonConfig, err := ReadConfig(path)
+	assert.NilError(t, err)
+	cfg := struct{ Name, ConfigDir string }{}
+	assert.NilError(t, json.Unmarshal(jsonConfig, &cfg))
+	assert.Equal(t, cfg.Name, "ess")
+	assert.Equal(t, cfg.ConfigDir, dir)
+}
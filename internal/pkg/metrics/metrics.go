@@ -0,0 +1,371 @@
+/*
+metrics.go Counters and histograms of the internal health of the control system, such
+as Modbus poll latency and dispatch cycle time. Packages register their metrics, and
+the Prometheus datastream writes every registered metric in the Prometheus text
+exposition format.
+*/
+
+package metrics
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// Metric types of the exposition format
+const (
+	CounterType   = "counter"
+	GaugeType     = "gauge"
+	HistogramType = "histogram"
+)
+
+// DefaultBuckets are the upper bounds, in seconds, of the buckets of a latency histogram
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Label is a name and value pair identifying a sample
+type Label struct {
+	Name  string
+	Value string
+}
+
+// Sample is a value of a metric. Name is the metric name, with the suffix of a
+// histogram series, such as _bucket.
+type Sample struct {
+	Name   string
+	Labels []Label
+	Value  float64
+}
+
+// Family is the samples of a metric
+type Family struct {
+	Name    string
+	Help    string
+	Type    string
+	Samples []Sample
+}
+
+// Collector returns the current families of its metrics
+type Collector interface {
+	Collect() []Family
+}
+
+var registry = struct {
+	mux        sync.Mutex
+	collectors []Collector
+}{}
+
+// Register adds the collector to the metrics written by the Prometheus datastream
+func Register(c Collector) {
+	registry.mux.Lock()
+	defer registry.mux.Unlock()
+	registry.collectors = append(registry.collectors, c)
+}
+
+// Gather returns the families of the registered collectors
+func Gather() []Family {
+	registry.mux.Lock()
+	collectors := make([]Collector, len(registry.collectors))
+	copy(collectors, registry.collectors)
+	registry.mux.Unlock()
+
+	families := make([]Family, 0)
+	for _, c := range collectors {
+		families = append(families, c.Collect()...)
+	}
+	return families
+}
+
+// series is the state of a metric for a set of label values
+type series struct {
+	values []string
+	value  float64
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+// vec holds the series of a metric by label values
+type vec struct {
+	mux    *sync.Mutex
+	name   string
+	help   string
+	labels []string
+	series map[string]*series
+}
+
+func newVec(name string, help string, labels []string) vec {
+	return vec{
+		mux:    &sync.Mutex{},
+		name:   name,
+		help:   help,
+		labels: labels,
+		series: make(map[string]*series),
+	}
+}
+
+// get returns the series of the label values. The caller holds the lock.
+func (v vec) get(values []string) *series {
+	if len(values) != len(v.labels) {
+		panic(fmt.Sprintf("metric %v: %v label values for labels %v", v.name, len(values), v.labels))
+	}
+	k := strings.Join(values, "\xff")
+	s, ok := v.series[k]
+	if !ok {
+		s = &series{values: values}
+		v.series[k] = s
+	}
+	return s
+}
+
+// sorted returns the series ordered by label values. The caller holds the lock.
+func (v vec) sorted() []*series {
+	keys := make([]string, 0, len(v.series))
+	for k := range v.series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	out := make([]*series, len(keys))
+	for i, k := range keys {
+		out[i] = v.series[k]
+	}
+	return out
+}
+
+func (v vec) labelled(values []string, extra ...Label) []Label {
+	labels := make([]Label, 0, len(values)+len(extra))
+	for i, name := range v.labels {
+		labels = append(labels, Label{name, values[i]})
+	}
+	return append(labels, extra...)
+}
+
+// Counter is a metric that only increases, such as a count of errors
+type Counter struct {
+	vec
+}
+
+// NewCounter returns a Counter with the label names
+func NewCounter(name string, help string, labels ...string) *Counter {
+	return &Counter{newVec(name, help, labels)}
+}
+
+// Inc adds one to the series of the label values
+func (c *Counter) Inc(values ...string) {
+	c.Add(1, values...)
+}
+
+// Add adds a non-negative value to the series of the label values
+func (c *Counter) Add(val float64, values ...string) {
+	if val < 0 {
+		return
+	}
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	c.get(values).value += val
+}
+
+// Collect returns the family of the counter
+func (c *Counter) Collect() []Family {
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	f := Family{Name: c.name, Help: c.help, Type: CounterType}
+	for _, s := range c.sorted() {
+		f.Samples = append(f.Samples, Sample{c.name, c.labelled(s.values), s.value})
+	}
+	return []Family{f}
+}
+
+// Histogram counts observations, such as latencies, in buckets
+type Histogram struct {
+	vec
+	buckets []float64
+}
+
+// NewHistogram returns a Histogram of the ascending bucket upper bounds with the
+// label names
+func NewHistogram(name string, help string, buckets []float64, labels ...string) *Histogram {
+	return &Histogram{newVec(name, help, labels), buckets}
+}
+
+// Observe adds the value to the series of the label values
+func (h *Histogram) Observe(val float64, values ...string) {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	s := h.get(values)
+	if s.counts == nil {
+		s.counts = make([]uint64, len(h.buckets))
+	}
+	for i, upper := range h.buckets {
+		if val <= upper {
+			s.counts[i]++
+		}
+	}
+	s.sum += val
+	s.count++
+}
+
+// Collect returns the family of the histogram, with the cumulative count of each
+// bucket, and the sum and count of the observations
+func (h *Histogram) Collect() []Family {
+	h.mux.Lock()
+	defer h.mux.Unlock()
+	f := Family{Name: h.name, Help: h.help, Type: HistogramType}
+	for _, s := range h.sorted() {
+		for i, upper := range h.buckets {
+			le := Label{"le", formatFloat(upper)}
+			f.Samples = append(f.Samples, Sample{h.name + "_bucket", h.labelled(s.values, le), float64(s.counts[i])})
+		}
+		inf := Label{"le", "+Inf"}
+		f.Samples = append(f.Samples,
+			Sample{h.name + "_bucket", h.labelled(s.values, inf), float64(s.count)},
+			Sample{h.name + "_sum", h.labelled(s.values), s.sum},
+			Sample{h.name + "_count", h.labelled(s.values), float64(s.count)},
+		)
+	}
+	return []Family{f}
+}
+
+// Write writes the families in the Prometheus text exposition format, each with its
+// HELP and TYPE lines. A family name may appear only once, and the families are
+// checked before any is written, so a bad family writes nothing.
+func Write(w io.Writer, families []Family) error {
+	seen := make(map[string]bool)
+	for _, f := range families {
+		if seen[f.Name] {
+			err := fmt.Sprintf("duplicate metric %v", f.Name)
+			return errors.New(err)
+		}
+		seen[f.Name] = true
+		if err := f.check(); err != nil {
+			return err
+		}
+	}
+
+	b := bufio.NewWriter(w)
+	for _, f := range families {
+		fmt.Fprintf(b, "# HELP %v %v\n", f.Name, escape(f.Help, false))
+		fmt.Fprintf(b, "# TYPE %v %v\n", f.Name, f.Type)
+		for _, s := range f.Samples {
+			b.WriteString(s.Name)
+			if len(s.Labels) > 0 {
+				b.WriteByte('{')
+				for i, l := range s.Labels {
+					if i > 0 {
+						b.WriteByte(',')
+					}
+					fmt.Fprintf(b, `%v="%v"`, l.Name, escape(l.Value, true))
+				}
+				b.WriteByte('}')
+			}
+			fmt.Fprintf(b, " %v\n", formatFloat(s.Value))
+		}
+	}
+	return b.Flush()
+}
+
+// check returns an error if the family has no help, an unknown type, or a name that
+// is not valid in the exposition format. The samples of a histogram are named for the
+// family with the suffix of their series, and the others for the family.
+func (f Family) check() error {
+	if !validName(f.Name, true) {
+		err := fmt.Sprintf("bad metric name %q", f.Name)
+		return errors.New(err)
+	}
+	if f.Help == "" {
+		err := fmt.Sprintf("metric %v has no help", f.Name)
+		return errors.New(err)
+	}
+	names := map[string]bool{f.Name: true}
+	switch f.Type {
+	case CounterType, GaugeType:
+	case HistogramType:
+		names = map[string]bool{f.Name + "_bucket": true, f.Name + "_sum": true, f.Name + "_count": true}
+	default:
+		err := fmt.Sprintf("metric %v has unsupported type %q", f.Name, f.Type)
+		return errors.New(err)
+	}
+
+	for _, s := range f.Samples {
+		if !names[s.Name] {
+			err := fmt.Sprintf("metric %v has sample %q", f.Name, s.Name)
+			return errors.New(err)
+		}
+		labels := make(map[string]bool)
+		for _, l := range s.Labels {
+			if !validName(l.Name, false) || strings.HasPrefix(l.Name, "__") || labels[l.Name] {
+				err := fmt.Sprintf("metric %v has bad label %q", f.Name, l.Name)
+				return errors.New(err)
+			}
+			labels[l.Name] = true
+		}
+	}
+	return nil
+}
+
+// validName reports whether the name is a valid label name, or a valid metric name
+// which may also contain colons
+func validName(name string, colons bool) bool {
+	if name == "" {
+		return false
+	}
+	for i, r := range name {
+		letter := (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || r == '_' || (colons && r == ':')
+		if !letter && (i == 0 || r < '0' || r > '9') {
+			return false
+		}
+	}
+	return true
+}
+
+// Name returns a metric name part of a field name or dotted path, in snake case, such
+// as machine_kw of Machine.KW
+func Name(field string) string {
+	runes := []rune(field)
+	var b strings.Builder
+	for i, r := range runes {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			b.WriteByte('_')
+			continue
+		}
+		if unicode.IsUpper(r) && i > 0 {
+			prev := runes[i-1]
+			nextLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if unicode.IsLower(prev) || unicode.IsDigit(prev) || (unicode.IsUpper(prev) && nextLower) {
+				b.WriteByte('_')
+			}
+		}
+		b.WriteRune(unicode.ToLower(r))
+	}
+	return b.String()
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// escape escapes the backslashes and line feeds of a help text, or a label value which
+// also escapes double quotes
+func escape(s string, quote bool) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, "\n", `\n`)
+	if quote {
+		s = strings.ReplaceAll(s, `"`, `\"`)
+	}
+	return s
+}
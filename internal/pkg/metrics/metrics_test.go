@@ -0,0 +1,114 @@
+package metrics
+
+import (
+	"bytes"
+	"math"
+	"testing"
+
+	"gotest.tools/assert"
+)
+
+func TestCounter(t *testing.T) {
+	c := NewCounter("cgc_errors_total", "Errors.", "target")
+	c.Inc("b")
+	c.Add(2, "a")
+	c.Add(-1, "a")
+	c.Inc("a")
+
+	buf := &bytes.Buffer{}
+	assert.NilError(t, Write(buf, c.Collect()))
+	assert.Equal(t, buf.String(), `# HELP cgc_errors_total Errors.
+# TYPE cgc_errors_total counter
+cgc_errors_total{target="a"} 3
+cgc_errors_total{target="b"} 1
+`)
+}
+
+func TestHistogram(t *testing.T) {
+	h := NewHistogram("cgc_poll_seconds", "Poll latency.", []float64{0.1, 1}, "target")
+	h.Observe(0.05, "a")
+	h.Observe(0.5, "a")
+	h.Observe(2, "a")
+
+	buf := &bytes.Buffer{}
+	assert.NilError(t, Write(buf, h.Collect()))
+	assert.Equal(t, buf.String(), `# HELP cgc_poll_seconds Poll latency.
+# TYPE cgc_poll_seconds histogram
+cgc_poll_seconds_bucket{target="a",le="0.1"} 1
+cgc_poll_seconds_bucket{target="a",le="1"} 2
+cgc_poll_seconds_bucket{target="a",le="+Inf"} 3
+cgc_poll_seconds_sum{target="a"} 2.55
+cgc_poll_seconds_count{target="a"} 3
+`)
+}
+
+func TestWrite(t *testing.T) {
+	families := []Family{{
+		Name: "cgc_value",
+		Help: "A \\ help\ntext.",
+		Type: GaugeType,
+		Samples: []Sample{
+			{"cgc_value", nil, math.Inf(1)},
+			{"cgc_value", []Label{{"name", "a \"b\"\n"}}, 1.5},
+			{"cgc_value", []Label{{"name", `C:\ess "north"\`}, {"bus", `\"`}}, -2},
+		},
+	}}
+	buf := &bytes.Buffer{}
+	assert.NilError(t, Write(buf, families))
+	assert.Equal(t, buf.String(), `# HELP cgc_value A \\ help\ntext.
+# TYPE cgc_value gauge
+cgc_value +Inf
+cgc_value{name="a \"b\"\n"} 1.5
+cgc_value{name="C:\\ess \"north\"\\",bus="\\\""} -2
+`)
+
+	err := Write(&bytes.Buffer{}, append(families, families[0]))
+	assert.Error(t, err, "duplicate metric cgc_value")
+}
+
+func TestWriteBadFamily(t *testing.T) {
+	good := Family{Name: "cgc_value", Help: "A value.", Type: GaugeType}
+	tests := map[string]func(f *Family){
+		`bad metric name "cgc-value"`:                    func(f *Family) { f.Name = "cgc-value" },
+		`bad metric name "1cgc"`:                         func(f *Family) { f.Name = "1cgc" },
+		"metric cgc_value has no help":                   func(f *Family) { f.Help = "" },
+		`metric cgc_value has unsupported type "gauges"`: func(f *Family) { f.Type = "gauges" },
+		`metric cgc_value has sample "cgc_other"`: func(f *Family) {
+			f.Samples = []Sample{{"cgc_other", nil, 1}}
+		},
+		`metric cgc_value has sample "cgc_value_bucket"`: func(f *Family) {
+			f.Samples = []Sample{{"cgc_value_bucket", nil, 1}}
+		},
+		`metric cgc_value has bad label "a:b"`: func(f *Family) {
+			f.Samples = []Sample{{"cgc_value", []Label{{"a:b", "x"}}, 1}}
+		},
+		`metric cgc_value has bad label "__name__"`: func(f *Family) {
+			f.Samples = []Sample{{"cgc_value", []Label{{"__name__", "x"}}, 1}}
+		},
+		`metric cgc_value has bad label "a"`: func(f *Family) {
+			f.Samples = []Sample{{"cgc_value", []Label{{"a", "x"}, {"a", "y"}}, 1}}
+		},
+	}
+	// the good family before the bad one is not written either
+	first := Family{Name: "cgc_first", Help: "A first value.", Type: GaugeType}
+	for want, change := range tests {
+		f := good
+		change(&f)
+		buf := &bytes.Buffer{}
+		assert.Error(t, Write(buf, []Family{first, f}), want)
+		assert.Equal(t, buf.Len(), 0)
+	}
+}
+
+func TestName(t *testing.T) {
+	for field, want := range map[string]string{
+		"KW":                           "kw",
+		"Machine.KW":                   "machine_kw",
+		"Machine.SOC":                  "machine_soc",
+		"Machine.RealPositiveCapacity": "machine_real_positive_capacity",
+		"KWHour":                       "kw_hour",
+		"Phase1Volts":                  "phase1_volts",
+	} {
+		assert.Equal(t, Name(field), want)
+	}
+}
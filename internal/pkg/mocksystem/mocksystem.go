@@ -0,0 +1,66 @@
+/*
+mocksystem.go A stand-in for the root of the control system, for testing operators and
+datastream handlers. The System publishes whatever the test publishes, arbitrates
+control requests like the root bus and records the control messages it is written.
+*/
+
+package mocksystem
+
+import (
+	"sync"
+
+	"github.com/google/uuid"
+	"github.com/ohowland/cgc_core/internal/pkg/bus"
+	"github.com/ohowland/cgc_core/internal/pkg/control"
+	"github.com/ohowland/cgc_core/internal/pkg/msg"
+)
+
+// System is a mock root of the control system
+type System struct {
+	*msg.PubSub
+	*control.Arbiter
+	// Written receives each control message handled by the arbiter
+	Written  chan msg.Msg
+	mux      *sync.Mutex
+	topology bus.Topology
+}
+
+// New returns a System with an empty topology
+func New() *System {
+	written := make(chan msg.Msg, 10)
+	return &System{
+		PubSub:  msg.NewPublisher(uuid.New()),
+		Arbiter: control.NewArbiter(func(m msg.Msg) { written <- m }),
+		Written: written,
+		mux:     &sync.Mutex{},
+	}
+}
+
+// RequestControl asks for control of the system
+func (s *System) RequestControl(pid uuid.UUID, priority control.Priority, ch <-chan msg.Msg) error {
+	return s.Request(pid, priority, ch)
+}
+
+// RequestTargetControl asks for control of the messages to the target
+func (s *System) RequestTargetControl(pid uuid.UUID, priority control.Priority, target uuid.UUID, ch <-chan msg.Msg) error {
+	return s.RequestTarget(pid, priority, target, ch)
+}
+
+// ReleaseControl gives up control of the system
+func (s *System) ReleaseControl(pid uuid.UUID) error {
+	return s.Release(pid)
+}
+
+// SetTopology replaces the tree of buses and assets returned by Topology
+func (s *System) SetTopology(topology bus.Topology) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.topology = topology
+}
+
+// Topology returns the tree of buses and assets of the system
+func (s *System) Topology() bus.Topology {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	return s.topology
+}
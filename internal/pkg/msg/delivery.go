@@ -0,0 +1,128 @@
+package msg
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Delivery selects what a publisher does when a subscriber's buffer is full
+type Delivery int
+
+const (
+	// DropNewest discards the message being published
+	DropNewest Delivery = iota
+	// LatestValue discards the oldest buffered message to make room, so the
+	// subscriber always reads the most recent values
+	LatestValue
+	// BlockTimeout waits up to the policy Timeout for room, then discards the message
+	BlockTimeout
+	// Guaranteed waits until the subscriber has room. A subscriber that stops reading
+	// stalls the publisher.
+	Guaranteed
+)
+
+// Policy configures delivery to a single subscription
+type Policy struct {
+	Buffer   int
+	Delivery Delivery
+	Timeout  time.Duration
+}
+
+// DefaultPolicy returns the policy used by Subscribe for the topic. Status and config
+// are lossy, and control is lossless.
+func DefaultPolicy(topic Topic) Policy {
+	if topic == Control {
+		return Policy{Buffer: 1, Delivery: Guaranteed}
+	}
+	return Policy{Buffer: 1, Delivery: DropNewest}
+}
+
+type subscription struct {
+	dropped uint64 // accessed atomically, kept first for 64-bit alignment
+	mux     sync.RWMutex
+	ch      chan Msg
+	done    chan struct{}
+	closed  bool
+	once    sync.Once
+	policy  Policy
+	filter  Filter
+}
+
+func newSubscription(policy Policy, filter Filter) *subscription {
+	if policy.Buffer < 0 {
+		policy.Buffer = 0
+	}
+	return &subscription{
+		ch:     make(chan Msg, policy.Buffer),
+		done:   make(chan struct{}),
+		policy: policy,
+		filter: filter,
+	}
+}
+
+// deliver writes the message to the subscription channel according to the policy
+func (s *subscription) deliver(m Msg) {
+	if s.filter != nil && !s.filter(m) {
+		return
+	}
+
+	s.mux.RLock()
+	defer s.mux.RUnlock()
+
+	if s.closed {
+		return
+	}
+
+	switch s.policy.Delivery {
+	case LatestValue:
+		for {
+			select {
+			case s.ch <- m:
+				return
+			default:
+			}
+			select {
+			case <-s.ch:
+				atomic.AddUint64(&s.dropped, 1)
+			default:
+			}
+		}
+	case BlockTimeout:
+		timer := time.NewTimer(s.policy.Timeout)
+		defer timer.Stop()
+		select {
+		case s.ch <- m:
+		case <-timer.C:
+			atomic.AddUint64(&s.dropped, 1)
+		case <-s.done:
+		}
+	case Guaranteed:
+		select {
+		case s.ch <- m:
+		case <-s.done:
+		}
+	default:
+		select {
+		case s.ch <- m:
+		default:
+			atomic.AddUint64(&s.dropped, 1)
+		}
+	}
+}
+
+// close releases any blocked delivery, then closes the subscription channel.
+// A subscription to several topics is closed once.
+func (s *subscription) close() {
+	s.once.Do(func() {
+		close(s.done)
+		s.mux.Lock()
+		defer s.mux.Unlock()
+		s.closed = true
+		close(s.ch)
+	})
+}
+
+func (s *subscription) droppedCount() uint64 {
+	return atomic.LoadUint64(&s.dropped)
+}
@@ -0,0 +1,78 @@
+package msg
+
+import (
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// Filter reports whether a message is delivered to a subscription
+type Filter func(Msg) bool
+
+// Subscription describes a filtered subscription. An empty Topics list subscribes to
+// every topic on one channel, a nil Filter accepts every message, and a zero Policy
+// uses the DefaultPolicy of the topics.
+type Subscription struct {
+	Topics []Topic
+	Filter Filter
+	Policy Policy
+}
+
+// FromSender accepts messages sent by any of the PIDs
+func FromSender(pids ...uuid.UUID) Filter {
+	set := make(map[uuid.UUID]bool)
+	for _, pid := range pids {
+		set[pid] = true
+	}
+	return func(m Msg) bool {
+		return set[m.PID()]
+	}
+}
+
+// OfType accepts messages whose payload type tag is one of types, for example "ess.Status"
+func OfType(types ...string) Filter {
+	set := make(map[string]bool)
+	for _, t := range types {
+		set[t] = true
+	}
+	return func(m Msg) bool {
+		return set[m.Type()]
+	}
+}
+
+// FromArchetype accepts messages whose payload is defined by one of the archetype
+// packages, for example "ess" accepts ess.Status and ess.Config.
+func FromArchetype(archetypes ...string) Filter {
+	return func(m Msg) bool {
+		for _, archetype := range archetypes {
+			if strings.HasPrefix(m.Type(), archetype+".") {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// And accepts messages accepted by all of the filters
+func And(filters ...Filter) Filter {
+	return func(m Msg) bool {
+		for _, f := range filters {
+			if f != nil && !f(m) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// Or accepts messages accepted by any of the filters
+func Or(filters ...Filter) Filter {
+	return func(m Msg) bool {
+		for _, f := range filters {
+			if f != nil && f(m) {
+				return true
+			}
+		}
+		return false
+	}
+}
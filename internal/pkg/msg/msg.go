@@ -1,40 +1,85 @@
 package msg
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
 	"sync"
+	"time"
 
 	"github.com/google/uuid"
 )
 
 type Publisher interface {
 	Subscribe(pid uuid.UUID, topic Topic) (<-chan Msg, error)
+	SubscribeFiltered(pid uuid.UUID, s Subscription) (<-chan Msg, error)
 	Unsubscribe(pid uuid.UUID)
 }
 
 type PubSub struct {
 	mux    sync.RWMutex
+	pubMux sync.Mutex // serializes publishing, so messages are delivered in sequence
+	seq    uint64
 	sender uuid.UUID
-	subs   map[Topic]map[uuid.UUID]chan<- Msg
+	subs   map[Topic]map[uuid.UUID]*subscription
 }
 
 func NewPublisher(pid uuid.UUID) *PubSub {
-	subs := map[Topic]map[uuid.UUID]chan<- Msg{}
-	subs[Status] = make(map[uuid.UUID]chan<- Msg)
-	subs[Config] = make(map[uuid.UUID]chan<- Msg)
-	subs[Control] = make(map[uuid.UUID]chan<- Msg)
-	p := &PubSub{sync.RWMutex{}, pid, subs}
+	subs := map[Topic]map[uuid.UUID]*subscription{}
+	subs[Status] = make(map[uuid.UUID]*subscription)
+	subs[Config] = make(map[uuid.UUID]*subscription)
+	subs[Control] = make(map[uuid.UUID]*subscription)
+	p := &PubSub{sender: pid, subs: subs}
 	return p
 }
 
-// Subscribe returns a channel on which the pubisher writes the specified topic
+// Subscribe returns a channel on which the pubisher writes the specified topic.
+// The subscription uses the DefaultPolicy of the topic.
 func (p *PubSub) Subscribe(pid uuid.UUID, topic Topic) (<-chan Msg, error) {
+	return p.SubscribeWithPolicy(pid, topic, DefaultPolicy(topic))
+}
+
+// SubscribeWithPolicy returns a channel on which the publisher writes the specified
+// topic, using the policy to size the buffer and handle a full buffer.
+func (p *PubSub) SubscribeWithPolicy(pid uuid.UUID, topic Topic, policy Policy) (<-chan Msg, error) {
+	return p.SubscribeFiltered(pid, Subscription{Topics: []Topic{topic}, Policy: policy})
+}
+
+// SubscribeFiltered returns a single channel on which the publisher writes the
+// messages of the subscribed topics that pass the subscription filter.
+func (p *PubSub) SubscribeFiltered(pid uuid.UUID, s Subscription) (<-chan Msg, error) {
 	p.mux.Lock()
 	defer p.mux.Unlock()
 
-	// Subscribers given a buffer of 1 msg
-	ch := make(chan Msg, 1)
-	p.subs[topic][pid] = ch
-	return ch, nil
+	topics := s.Topics
+	if len(topics) == 0 {
+		topics = []Topic{Status, Control, Config}
+	}
+
+	for _, topic := range topics {
+		if _, ok := p.subs[topic]; !ok {
+			err := fmt.Sprintf("unknown topic %v", topic)
+			return nil, errors.New(err)
+		}
+	}
+
+	policy := s.Policy
+	if policy == (Policy{}) {
+		policy = DefaultPolicy(Status)
+		for _, topic := range topics {
+			if topic == Control {
+				policy = DefaultPolicy(Control)
+			}
+		}
+	}
+
+	sub := newSubscription(policy, s.Filter)
+	for _, topic := range topics {
+		p.subs[topic][pid] = sub
+	}
+	return sub.ch, nil
 }
 
 // Unsubscribe closes all channels associated with a PID
@@ -43,36 +88,104 @@ func (p *PubSub) Unsubscribe(pid uuid.UUID) {
 	defer p.mux.Unlock()
 
 	for _, topic := range p.subs {
-		if _, ok := topic[pid]; ok {
-			close(topic[pid])
+		if sub, ok := topic[pid]; ok {
+			sub.close()
 			delete(topic, pid)
 		}
 	}
 }
 
-func (p *PubSub) Publish(topic Topic, payload interface{}) {
+// Dropped returns the number of messages on the topic that were discarded
+// because the subscriber's buffer was full.
+func (p *PubSub) Dropped(pid uuid.UUID, topic Topic) uint64 {
 	p.mux.RLock()
 	defer p.mux.RUnlock()
 
-	msg := New(p.sender, topic, payload)
-	for _, ch := range p.subs[topic] {
-		select {
-		case ch <- msg:
-		default:
-		}
+	if sub, ok := p.subs[topic][pid]; ok {
+		return sub.droppedCount()
 	}
+	return 0
 }
 
-func (p *PubSub) Forward(m Msg) {
+// SubscriberStats is the delivery state of a subscription
+type SubscriberStats struct {
+	PID    uuid.UUID
+	Topics []Topic
+	// Queued is the number of messages waiting in the subscription buffer
+	Queued int
+	// Dropped is the number of messages discarded because the buffer was full
+	Dropped uint64
+}
+
+// Stats returns the delivery state of each subscriber, ordered by PID
+func (p *PubSub) Stats() []SubscriberStats {
 	p.mux.RLock()
 	defer p.mux.RUnlock()
 
-	for _, ch := range p.subs[m.topic] {
-		select {
-		case ch <- m:
-		default:
+	byPID := make(map[uuid.UUID]*SubscriberStats)
+	for _, topic := range []Topic{Status, Control, Config} {
+		for pid, sub := range p.subs[topic] {
+			s, ok := byPID[pid]
+			if !ok {
+				s = &SubscriberStats{PID: pid, Queued: len(sub.ch), Dropped: sub.droppedCount()}
+				byPID[pid] = s
+			}
+			s.Topics = append(s.Topics, topic)
 		}
 	}
+
+	stats := make([]SubscriberStats, 0, len(byPID))
+	for _, s := range byPID {
+		stats = append(stats, *s)
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		return stats[i].PID.String() < stats[j].PID.String()
+	})
+	return stats
+}
+
+// Publish broadcasts the payload to the topic subscribers. Each published message
+// carries the next sequence number of the publisher.
+func (p *PubSub) Publish(topic Topic, payload interface{}) {
+	p.publish(New(p.sender, topic, payload))
+}
+
+// PublishTo broadcasts a payload addressed to the target PID. Subscribers that route
+// messages, such as buses, deliver it to the target.
+func (p *PubSub) PublishTo(target uuid.UUID, topic Topic, payload interface{}) {
+	p.publish(New(p.sender, topic, payload).WithTarget(target))
+}
+
+// publish assigns the next sequence number to the message and delivers it. Numbering
+// and delivery are one critical section, so that concurrent publishers deliver to
+// each subscriber in sequence.
+func (p *PubSub) publish(m Msg) {
+	p.pubMux.Lock()
+	defer p.pubMux.Unlock()
+	p.seq++
+	m.seq = p.seq
+	p.deliver(m)
+}
+
+// Forward broadcasts a message received from another publisher. The envelope,
+// including the original sender and sequence number, is unchanged.
+func (p *PubSub) Forward(m Msg) {
+	p.deliver(m)
+}
+
+// deliver writes the message to each subscriber of its topic. The subscription
+// list is copied so a blocking delivery does not hold the subscription lock.
+func (p *PubSub) deliver(m Msg) {
+	p.mux.RLock()
+	subs := make([]*subscription, 0, len(p.subs[m.topic]))
+	for _, sub := range p.subs[m.topic] {
+		subs = append(subs, sub)
+	}
+	p.mux.RUnlock()
+
+	for _, sub := range subs {
+		sub.deliver(m)
+	}
 }
 
 // Stop closes all subscription channels owned by the publisher
@@ -80,8 +193,8 @@ func (p *PubSub) Stop() {
 	p.mux.Lock()
 	defer p.mux.Unlock()
 	for _, topic := range p.subs {
-		for pid, ch := range topic {
-			close(ch)
+		for pid, sub := range topic {
+			sub.close()
 			delete(topic, pid)
 		}
 	}
@@ -95,16 +208,68 @@ const (
 	Config
 )
 
-// Msg is
+var topicNames = map[Topic]string{
+	Status:  "Status",
+	Control: "Control",
+	Config:  "Config",
+}
+
+func (t Topic) String() string {
+	if name, ok := topicNames[t]; ok {
+		return name
+	}
+	return fmt.Sprintf("Topic(%d)", int(t))
+}
+
+// MarshalJSON encodes the topic by name
+func (t Topic) MarshalJSON() ([]byte, error) {
+	return json.Marshal(t.String())
+}
+
+// UnmarshalJSON decodes a topic name
+func (t *Topic) UnmarshalJSON(data []byte) error {
+	var name string
+	if err := json.Unmarshal(data, &name); err != nil {
+		return err
+	}
+	for topic, topicName := range topicNames {
+		if topicName == name {
+			*t = topic
+			return nil
+		}
+	}
+	err := fmt.Sprintf("unknown topic %v", name)
+	return errors.New(err)
+}
+
+// Msg is the envelope for data passed between publishers and subscribers.
+// The target is the zero UUID unless the message is addressed to a single node.
 type Msg struct {
-	sender  uuid.UUID
-	topic   Topic
-	payload interface{}
+	sender    uuid.UUID
+	target    uuid.UUID
+	topic     Topic
+	kind      string
+	timestamp time.Time
+	seq       uint64
+	payload   interface{}
 }
 
-// New is the Msg factor function
+// New is the Msg factor function. The message is stamped with the current time and
+// a type tag derived from the payload. Sequence numbers are assigned by the PubSub.
 func New(sender uuid.UUID, topic Topic, payload interface{}) Msg {
-	return Msg{sender, topic, payload}
+	return Msg{
+		sender:    sender,
+		topic:     topic,
+		kind:      typeTag(payload),
+		timestamp: time.Now(),
+		payload:   payload,
+	}
+}
+
+// WithTarget returns a copy of the message addressed to the target PID
+func (m Msg) WithTarget(target uuid.UUID) Msg {
+	m.target = target
+	return m
 }
 
 // PID returns the sender's PID
@@ -112,6 +277,11 @@ func (m Msg) PID() uuid.UUID {
 	return m.sender
 }
 
+// Target returns the PID the message is addressed to, or the zero UUID
+func (m Msg) Target() uuid.UUID {
+	return m.target
+}
+
 // Payload returns the message data
 func (m Msg) Payload() interface{} {
 	return m.payload
@@ -121,3 +291,57 @@ func (m Msg) Payload() interface{} {
 func (m Msg) Topic() Topic {
 	return m.topic
 }
+
+// Type returns the type tag of the payload, for example "ess.Status"
+func (m Msg) Type() string {
+	return m.kind
+}
+
+// Timestamp returns the time the message was created
+func (m Msg) Timestamp() time.Time {
+	return m.timestamp
+}
+
+// Sequence returns the sender's sequence number of the message. Sequence numbers
+// increase by one with each message a PubSub publishes, which lets a subscriber
+// order messages and detect drops. Messages not sent through a PubSub have sequence 0.
+func (m Msg) Sequence() uint64 {
+	return m.seq
+}
+
+type envelope struct {
+	Sender    uuid.UUID       `json:"Sender"`
+	Target    uuid.UUID       `json:"Target"`
+	Topic     Topic           `json:"Topic"`
+	Type      string          `json:"Type"`
+	Timestamp time.Time       `json:"Timestamp"`
+	Sequence  uint64          `json:"Sequence"`
+	Payload   json.RawMessage `json:"Payload"`
+}
+
+// MarshalJSON encodes the message envelope and payload
+func (m Msg) MarshalJSON() ([]byte, error) {
+	payload, err := json.Marshal(m.payload)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(envelope{m.sender, m.target, m.topic, m.kind, m.timestamp, m.seq, payload})
+}
+
+// UnmarshalJSON decodes a message envelope. The payload is left as a json.RawMessage,
+// which the receiver decodes according to the message Type.
+func (m *Msg) UnmarshalJSON(data []byte) error {
+	e := envelope{}
+	if err := json.Unmarshal(data, &e); err != nil {
+		return err
+	}
+	*m = Msg{e.Sender, e.Target, e.Topic, e.Type, e.Timestamp, e.Sequence, e.Payload}
+	return nil
+}
+
+func typeTag(payload interface{}) string {
+	if payload == nil {
+		return ""
+	}
+	return reflect.TypeOf(payload).String()
+}
@@ -1,6 +1,7 @@
 package msg
 
 import (
+	"encoding/json"
 	"math/rand"
 	"sync"
 	"testing"
@@ -83,3 +84,309 @@ func TestUnsubscribe(t *testing.T) {
 }
 
 func TestPublish(t *testing.T) {}
+
+func TestPublishSequence(t *testing.T) {
+	pid, _ := uuid.NewUUID()
+	pubsub := NewPublisher(pid)
+
+	sub, _ := uuid.NewUUID()
+	ch, err := pubsub.Subscribe(sub, Status)
+	assert.NilError(t, err)
+
+	for i := 1; i <= 3; i++ {
+		pubsub.Publish(Status, i)
+		m := <-ch
+		assert.Equal(t, m.Sequence(), uint64(i))
+		assert.Equal(t, m.PID(), pid)
+		assert.Equal(t, m.Type(), "int")
+	}
+}
+
+func TestPublishSequenceConcurrent(t *testing.T) {
+	pid, _ := uuid.NewUUID()
+	pubsub := NewPublisher(pid)
+
+	sub, _ := uuid.NewUUID()
+	ch, err := pubsub.SubscribeWithPolicy(sub, Status, Policy{Buffer: 1, Delivery: Guaranteed})
+	assert.NilError(t, err)
+
+	publishers, each := 8, 100
+	wg := sync.WaitGroup{}
+	for i := 0; i < publishers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < each; j++ {
+				pubsub.Publish(Status, j)
+			}
+		}()
+	}
+
+	for i := 1; i <= publishers*each; i++ {
+		m := <-ch
+		assert.Equal(t, m.Sequence(), uint64(i))
+	}
+	wg.Wait()
+}
+
+func TestPublishTo(t *testing.T) {
+	pid, _ := uuid.NewUUID()
+	pubsub := NewPublisher(pid)
+
+	sub, _ := uuid.NewUUID()
+	ch, err := pubsub.Subscribe(sub, Control)
+	assert.NilError(t, err)
+
+	target, _ := uuid.NewUUID()
+	before := time.Now()
+	pubsub.PublishTo(target, Control, 1.0)
+
+	m := <-ch
+	assert.Equal(t, m.Target(), target)
+	assert.Equal(t, m.Topic(), Control)
+	assert.Equal(t, m.Type(), "float64")
+	assert.Assert(t, !m.Timestamp().Before(before))
+}
+
+func TestForwardKeepsEnvelope(t *testing.T) {
+	pid, _ := uuid.NewUUID()
+	origin := NewPublisher(pid)
+	relay := NewPublisher(uuid.New())
+
+	originCh, _ := origin.Subscribe(uuid.New(), Status)
+	relayCh, _ := relay.Subscribe(uuid.New(), Status)
+
+	origin.Publish(Status, "status")
+	m := <-originCh
+	relay.Forward(m)
+
+	forwarded := <-relayCh
+	assert.Equal(t, forwarded.PID(), pid)
+	assert.Equal(t, forwarded.Sequence(), m.Sequence())
+	assert.Equal(t, forwarded.Timestamp(), m.Timestamp())
+}
+
+func TestMsgJSON(t *testing.T) {
+	type payload struct {
+		KW float64
+	}
+
+	pid, _ := uuid.NewUUID()
+	target, _ := uuid.NewUUID()
+	m := New(pid, Control, payload{12.5}).WithTarget(target)
+	m.seq = 7
+
+	data, err := json.Marshal(m)
+	assert.NilError(t, err)
+
+	decoded := Msg{}
+	err = json.Unmarshal(data, &decoded)
+	assert.NilError(t, err)
+
+	assert.Equal(t, decoded.PID(), pid)
+	assert.Equal(t, decoded.Target(), target)
+	assert.Equal(t, decoded.Topic(), Control)
+	assert.Equal(t, decoded.Type(), "msg.payload")
+	assert.Equal(t, decoded.Sequence(), uint64(7))
+	assert.Assert(t, decoded.Timestamp().Equal(m.Timestamp()))
+
+	p := payload{}
+	err = json.Unmarshal(decoded.Payload().(json.RawMessage), &p)
+	assert.NilError(t, err)
+	assert.Equal(t, p.KW, 12.5)
+}
+
+func TestDropNewestCountsDrops(t *testing.T) {
+	pubsub := NewPublisher(uuid.New())
+
+	sub := uuid.New()
+	ch, err := pubsub.Subscribe(sub, Status)
+	assert.NilError(t, err)
+
+	pubsub.Publish(Status, 1)
+	pubsub.Publish(Status, 2)
+	pubsub.Publish(Status, 3)
+
+	assert.Equal(t, pubsub.Dropped(sub, Status), uint64(2))
+	assert.Equal(t, (<-ch).Payload(), 1)
+}
+
+func TestLatestValue(t *testing.T) {
+	pubsub := NewPublisher(uuid.New())
+
+	sub := uuid.New()
+	ch, err := pubsub.SubscribeWithPolicy(sub, Status, Policy{Buffer: 2, Delivery: LatestValue})
+	assert.NilError(t, err)
+
+	for i := 1; i <= 5; i++ {
+		pubsub.Publish(Status, i)
+	}
+
+	assert.Equal(t, pubsub.Dropped(sub, Status), uint64(3))
+	assert.Equal(t, (<-ch).Payload(), 4)
+	assert.Equal(t, (<-ch).Payload(), 5)
+}
+
+func TestStats(t *testing.T) {
+	pubsub := NewPublisher(uuid.New())
+
+	sub := uuid.New()
+	_, err := pubsub.SubscribeFiltered(sub, Subscription{Topics: []Topic{Config, Status}, Policy: Policy{Buffer: 2}})
+	assert.NilError(t, err)
+	other := uuid.New()
+	_, err = pubsub.Subscribe(other, Control)
+	assert.NilError(t, err)
+
+	for i := 1; i <= 3; i++ {
+		pubsub.Publish(Status, i)
+	}
+
+	stats := pubsub.Stats()
+	assert.Equal(t, len(stats), 2)
+	for _, s := range stats {
+		switch s.PID {
+		case sub:
+			assert.DeepEqual(t, s, SubscriberStats{PID: sub, Topics: []Topic{Status, Config}, Queued: 2, Dropped: 1})
+		case other:
+			assert.DeepEqual(t, s, SubscriberStats{PID: other, Topics: []Topic{Control}})
+		default:
+			t.Fatalf("unknown subscriber %v", s.PID)
+		}
+	}
+	assert.Assert(t, stats[0].PID.String() < stats[1].PID.String())
+}
+
+func TestBlockTimeout(t *testing.T) {
+	pubsub := NewPublisher(uuid.New())
+
+	sub := uuid.New()
+	ch, err := pubsub.SubscribeWithPolicy(sub, Status, Policy{Buffer: 1, Delivery: BlockTimeout, Timeout: 10 * time.Millisecond})
+	assert.NilError(t, err)
+
+	pubsub.Publish(Status, 1)
+	pubsub.Publish(Status, 2) // times out
+	assert.Equal(t, pubsub.Dropped(sub, Status), uint64(1))
+
+	go func() {
+		time.Sleep(2 * time.Millisecond)
+		<-ch
+	}()
+	pubsub.Publish(Status, 3) // room is made before the timeout
+	assert.Equal(t, pubsub.Dropped(sub, Status), uint64(1))
+	assert.Equal(t, (<-ch).Payload(), 3)
+}
+
+func TestGuaranteedControl(t *testing.T) {
+	pubsub := NewPublisher(uuid.New())
+
+	sub := uuid.New()
+	ch, err := pubsub.Subscribe(sub, Control)
+	assert.NilError(t, err)
+
+	n := 10
+	go func() {
+		for i := 0; i < n; i++ {
+			pubsub.Publish(Control, i)
+		}
+	}()
+
+	for i := 0; i < n; i++ {
+		m := <-ch
+		assert.Equal(t, m.Payload(), i)
+	}
+	assert.Equal(t, pubsub.Dropped(sub, Control), uint64(0))
+}
+
+func TestUnsubscribeReleasesBlockedPublisher(t *testing.T) {
+	pubsub := NewPublisher(uuid.New())
+
+	sub := uuid.New()
+	_, err := pubsub.Subscribe(sub, Control)
+	assert.NilError(t, err)
+
+	done := make(chan bool)
+	go func() {
+		pubsub.Publish(Control, 1)
+		pubsub.Publish(Control, 2) // blocks, the subscriber never reads
+		done <- true
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+	pubsub.Unsubscribe(sub)
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("publisher remained blocked after unsubscribe")
+	}
+}
+
+type filterStatus struct{}
+
+func TestSubscribeFilteredBySender(t *testing.T) {
+	pubsub := NewPublisher(uuid.New())
+
+	wanted, other := uuid.New(), uuid.New()
+	ch, err := pubsub.SubscribeFiltered(uuid.New(), Subscription{
+		Topics: []Topic{Status},
+		Filter: FromSender(wanted),
+		Policy: Policy{Buffer: 2},
+	})
+	assert.NilError(t, err)
+
+	pubsub.Forward(New(other, Status, 1))
+	pubsub.Forward(New(wanted, Status, 2))
+
+	m := <-ch
+	assert.Equal(t, m.PID(), wanted)
+	assert.Equal(t, len(ch), 0)
+}
+
+func TestSubscribeMultipleTopics(t *testing.T) {
+	pubsub := NewPublisher(uuid.New())
+
+	sub := uuid.New()
+	ch, err := pubsub.SubscribeFiltered(sub, Subscription{
+		Topics: []Topic{Status, Config},
+		Policy: Policy{Buffer: 3},
+	})
+	assert.NilError(t, err)
+
+	pubsub.Publish(Status, 1)
+	pubsub.Publish(Config, 2)
+	pubsub.Publish(Control, 3)
+
+	assert.Equal(t, (<-ch).Topic(), Status)
+	assert.Equal(t, (<-ch).Topic(), Config)
+	assert.Equal(t, len(ch), 0)
+
+	// a multi-topic subscription closes once
+	pubsub.Unsubscribe(sub)
+	_, ok := <-ch
+	assert.Assert(t, !ok)
+}
+
+func TestSubscribeAllTopics(t *testing.T) {
+	pubsub := NewPublisher(uuid.New())
+
+	ch, err := pubsub.SubscribeFiltered(uuid.New(), Subscription{Filter: OfType("msg.filterStatus")})
+	assert.NilError(t, err)
+
+	go pubsub.Publish(Control, 1)
+	go pubsub.Publish(Config, filterStatus{})
+
+	m := <-ch
+	assert.Equal(t, m.Type(), "msg.filterStatus")
+}
+
+func TestFilterCombinators(t *testing.T) {
+	sender := uuid.New()
+	m := New(sender, Status, filterStatus{})
+
+	assert.Assert(t, FromArchetype("msg")(m))
+	assert.Assert(t, !FromArchetype("ess")(m))
+	assert.Assert(t, And(FromSender(sender), FromArchetype("msg"))(m))
+	assert.Assert(t, !And(FromSender(uuid.New()), FromArchetype("msg"))(m))
+	assert.Assert(t, Or(FromSender(uuid.New()), FromArchetype("msg"))(m))
+	assert.Assert(t, !Or(FromSender(uuid.New()), OfType("int"))(m))
+}
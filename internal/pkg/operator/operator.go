@@ -0,0 +1,405 @@
+/*
+operator.go Manual control of the system from outside the process. Datastream handlers
+receive operator control requests as JSON message envelopes, and an Operator decodes
+the payload, takes control of the target asset at operator priority and forwards the
+request to it.
+*/
+
+package operator
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/ohowland/cgc_core/internal/pkg/asset/bms"
+	"github.com/ohowland/cgc_core/internal/pkg/asset/ess"
+	"github.com/ohowland/cgc_core/internal/pkg/asset/feeder"
+	"github.com/ohowland/cgc_core/internal/pkg/asset/grid"
+	"github.com/ohowland/cgc_core/internal/pkg/asset/pcs"
+	"github.com/ohowland/cgc_core/internal/pkg/asset/pv"
+	"github.com/ohowland/cgc_core/internal/pkg/control"
+	"github.com/ohowland/cgc_core/internal/pkg/msg"
+)
+
+// System is the root of the control system, as seen by an operator
+type System interface {
+	msg.Publisher
+	RequestTargetControl(uuid.UUID, control.Priority, uuid.UUID, <-chan msg.Msg) error
+	ReleaseControl(uuid.UUID) error
+}
+
+const (
+	// DefaultLease is the time an operator holds control after its last control request
+	DefaultLease = 5 * time.Minute
+	// DefaultRefresh is the interval at which the held controls are written again. It
+	// is well within the ControlTimeout of the assets, so the asset watchdogs do not
+	// write their FailSafe over the operator's control.
+	DefaultRefresh = 1 * time.Second
+)
+
+// Operator holds operator control of the targeted assets on behalf of a datastream
+// handler. Control is held per target, so the dispatcher keeps control of the assets
+// the operator has not written to. While a target is held, its last control is
+// written again each refresh. The operator gives up control when released, or when
+// no request is made for the lease. A JSON control that omits fields keeps the values
+// of the last control the operator commanded to the target since control was last
+// given up.
+type Operator struct {
+	mux       *sync.Mutex
+	pid       uuid.UUID
+	system    System
+	lease     time.Duration
+	refresh   time.Duration
+	targets   map[uuid.UUID]*held
+	commanded map[uuid.UUID]interface{}
+	expiry    *time.Timer
+	renewed   int
+	stop      chan struct{}
+}
+
+// held is the control channel and last control of a target
+type held struct {
+	ch      chan msg.Msg
+	control interface{}
+}
+
+// write replaces the control waiting on the channel with the message, so a write never
+// blocks on an asset that has not read the last control. The caller holds the lock of
+// the operator, which is the only sender on the channel.
+func (h *held) write(m msg.Msg) {
+	select {
+	case <-h.ch:
+	default:
+	}
+	h.ch <- m
+}
+
+// New returns an Operator for the system that holds control for the DefaultLease.
+// Control is not requested until the first control message is sent.
+func New(system System) (*Operator, error) {
+	return NewWithTiming(system, DefaultLease, DefaultRefresh)
+}
+
+// NewWithTiming returns an Operator for the system that holds control for the lease
+// after each control request, and writes the held controls again each refresh. A
+// lease of zero holds control until released, and a refresh of zero writes each
+// control once.
+func NewWithTiming(system System, lease time.Duration, refresh time.Duration) (*Operator, error) {
+	pid, err := uuid.NewUUID()
+	return &Operator{
+		mux:       &sync.Mutex{},
+		pid:       pid,
+		system:    system,
+		lease:     lease,
+		refresh:   refresh,
+		targets:   make(map[uuid.UUID]*held),
+		commanded: make(map[uuid.UUID]interface{}),
+	}, err
+}
+
+// PID returns the operator's control identity
+func (o *Operator) PID() uuid.UUID {
+	return o.pid
+}
+
+// Control decodes the operator request and writes it to the target asset, taking
+// control of the target first if it is not already held. Control of a target held by
+// another operator is denied with control.ErrDenied. Operator is the highest priority,
+// so a target the operator holds cannot be taken by another source until the lease
+// expires.
+func (o *Operator) Control(request msg.Msg) error {
+	if request.Target() == (uuid.UUID{}) {
+		return errors.New("operator control request has no target")
+	}
+
+	o.mux.Lock()
+	defer o.mux.Unlock()
+
+	payload, err := decodeControl(request, o.commanded[request.Target()])
+	if err != nil {
+		return err
+	}
+
+	h, ok := o.targets[request.Target()]
+	if !ok {
+		h = &held{ch: make(chan msg.Msg, 1)}
+		if err := o.system.RequestTargetControl(o.pid, control.Operator, request.Target(), h.ch); err != nil {
+			return err
+		}
+		o.targets[request.Target()] = h
+	}
+	h.control = payload
+	o.commanded[request.Target()] = payload
+	o.renew()
+	if o.stop == nil && o.refresh > 0 {
+		o.stop = make(chan struct{})
+		go o.refreshLoop(o.stop)
+	}
+
+	h.write(msg.New(o.pid, msg.Control, payload).WithTarget(request.Target()))
+	return nil
+}
+
+// Commanded returns the last control the operator commanded to the target, or nil
+// when it has not commanded the target since control was last given up
+func (o *Operator) Commanded(target uuid.UUID) interface{} {
+	o.mux.Lock()
+	defer o.mux.Unlock()
+	return o.commanded[target]
+}
+
+// refreshLoop writes the last control of each held target every refresh, until stop
+// is closed
+func (o *Operator) refreshLoop(stop chan struct{}) {
+	ticker := time.NewTicker(o.refresh)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			o.mux.Lock()
+			for target, h := range o.targets {
+				h.write(msg.New(o.pid, msg.Control, h.control).WithTarget(target))
+			}
+			o.mux.Unlock()
+		case <-stop:
+			return
+		}
+	}
+}
+
+// renew restarts the lease. The caller holds the lock.
+func (o *Operator) renew() {
+	if o.lease <= 0 {
+		return
+	}
+	if o.expiry != nil {
+		o.expiry.Stop()
+	}
+	o.renewed++
+	renewed := o.renewed
+	o.expiry = time.AfterFunc(o.lease, func() { o.expire(renewed) })
+}
+
+// expire releases control when the lease has not been renewed since it started
+func (o *Operator) expire(renewed int) {
+	o.mux.Lock()
+	defer o.mux.Unlock()
+	if renewed != o.renewed {
+		return
+	}
+	if err := o.release(); err != nil {
+		log.Printf("[Operator] %v lease expired: %v", o.pid, err)
+	}
+}
+
+// Release returns control of the targets to the dispatcher
+func (o *Operator) Release() error {
+	o.mux.Lock()
+	defer o.mux.Unlock()
+	return o.release()
+}
+
+// release gives up control of the targets, and forgets the controls commanded to
+// them. The caller holds the lock.
+func (o *Operator) release() error {
+	o.commanded = make(map[uuid.UUID]interface{})
+	if len(o.targets) == 0 {
+		return nil
+	}
+	if o.expiry != nil {
+		o.expiry.Stop()
+	}
+	o.renewed++
+	if o.stop != nil {
+		close(o.stop)
+		o.stop = nil
+	}
+	o.targets = make(map[uuid.UUID]*held)
+	return o.system.ReleaseControl(o.pid)
+}
+
+// Holding reports whether the operator has control of any target
+func (o *Operator) Holding() bool {
+	o.mux.Lock()
+	defer o.mux.Unlock()
+	return len(o.targets) > 0
+}
+
+// DecodeControl returns the machine control carried by the request. Payloads decoded
+// from JSON are unmarshalled into the control type named by the message Type, and
+// must set every field of the control.
+func DecodeControl(request msg.Msg) (interface{}, error) {
+	return decodeControl(request, nil)
+}
+
+// decodeControl returns the machine control carried by the request. A JSON payload
+// is merged onto the base control.
+func decodeControl(request msg.Msg, base interface{}) (interface{}, error) {
+	raw, ok := request.Payload().(json.RawMessage)
+	if !ok {
+		return request.Payload(), nil
+	}
+	return MergeControl(base, request.Type(), raw)
+}
+
+// ParseControl returns the machine control of the type decoded from JSON. Fields
+// that are not fields of the control type are rejected, and every field of the
+// control type must be set, so an omitted field is never read as its zero value.
+func ParseControl(controlType string, data []byte) (interface{}, error) {
+	return MergeControl(nil, controlType, data)
+}
+
+// MergeControl returns the base control with the fields decoded from JSON. Fields
+// omitted from the JSON keep the value of the base. When the base is nil, or not of
+// the control type, every field must be set as for ParseControl.
+func MergeControl(base interface{}, controlType string, data []byte) (interface{}, error) {
+	decode, ok := controlTypes[controlType]
+	if !ok {
+		err := fmt.Sprintf("unsupported control type %v", controlType)
+		return nil, errors.New(err)
+	}
+	zero, err := decode([]byte("{}"))
+	if err != nil {
+		return nil, err
+	}
+
+	t := reflect.TypeOf(zero)
+	c := reflect.New(t)
+	merge := base != nil && reflect.TypeOf(base) == t
+	if merge {
+		c.Elem().Set(reflect.ValueOf(base))
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(c.Interface()); err != nil {
+		return nil, err
+	}
+	if missing := missingFields(t, data); !merge && len(missing) > 0 {
+		err := fmt.Sprintf("%v omits %v", controlType, strings.Join(missing, ", "))
+		return nil, errors.New(err)
+	}
+	return c.Elem().Interface(), nil
+}
+
+// missingFields returns the fields of the struct type that are not keys of the JSON
+// object. Keys match fields without regard to case, as in encoding/json. Data that is
+// not an object is left for the decoder to reject.
+func missingFields(t reflect.Type, data []byte) []string {
+	keys := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return nil
+	}
+	missing := []string{}
+	for i := 0; i < t.NumField(); i++ {
+		name := t.Field(i).Name
+		found := false
+		for key := range keys {
+			if strings.EqualFold(key, name) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			missing = append(missing, name)
+		}
+	}
+	return missing
+}
+
+// NewRequest returns an operator control request to the target, carrying the fields
+// as the payload of the control type
+func NewRequest(target uuid.UUID, controlType string, fields map[string]interface{}) (msg.Msg, error) {
+	envelope := map[string]interface{}{
+		"Target":  target,
+		"Topic":   msg.Control,
+		"Type":    controlType,
+		"Payload": fields,
+	}
+	data, err := json.Marshal(envelope)
+	if err != nil {
+		return msg.Msg{}, err
+	}
+	request := msg.Msg{}
+	err = json.Unmarshal(data, &request)
+	return request, err
+}
+
+// FieldKind returns the kind of the named field of the control type
+func FieldKind(controlType string, field string) (reflect.Kind, error) {
+	decode, ok := controlTypes[controlType]
+	if !ok {
+		err := fmt.Sprintf("unsupported control type %v", controlType)
+		return reflect.Invalid, errors.New(err)
+	}
+	zero, err := decode([]byte("{}"))
+	if err != nil {
+		return reflect.Invalid, err
+	}
+	f, ok := reflect.TypeOf(zero).FieldByName(field)
+	if !ok {
+		err := fmt.Sprintf("%v has no field %v", controlType, field)
+		return reflect.Invalid, errors.New(err)
+	}
+	return f.Type.Kind(), nil
+}
+
+// Fields returns the field names of the control type
+func Fields(controlType string) ([]string, error) {
+	decode, ok := controlTypes[controlType]
+	if !ok {
+		err := fmt.Sprintf("unsupported control type %v", controlType)
+		return nil, errors.New(err)
+	}
+	zero, err := decode([]byte("{}"))
+	if err != nil {
+		return nil, err
+	}
+	t := reflect.TypeOf(zero)
+	fields := make([]string, t.NumField())
+	for i := range fields {
+		fields[i] = t.Field(i).Name
+	}
+	return fields, nil
+}
+
+var controlTypes = map[string]func([]byte) (interface{}, error){
+	"ess.MachineControl": func(b []byte) (interface{}, error) {
+		c := ess.MachineControl{}
+		err := json.Unmarshal(b, &c)
+		return c, err
+	},
+	"grid.MachineControl": func(b []byte) (interface{}, error) {
+		c := grid.MachineControl{}
+		err := json.Unmarshal(b, &c)
+		return c, err
+	},
+	"feeder.MachineControl": func(b []byte) (interface{}, error) {
+		c := feeder.MachineControl{}
+		err := json.Unmarshal(b, &c)
+		return c, err
+	},
+	"pv.MachineControl": func(b []byte) (interface{}, error) {
+		c := pv.MachineControl{}
+		err := json.Unmarshal(b, &c)
+		return c, err
+	},
+	"bms.MachineControl": func(b []byte) (interface{}, error) {
+		c := bms.MachineControl{}
+		err := json.Unmarshal(b, &c)
+		return c, err
+	},
+	"pcs.MachineControl": func(b []byte) (interface{}, error) {
+		c := pcs.MachineControl{}
+		err := json.Unmarshal(b, &c)
+		return c, err
+	},
+}
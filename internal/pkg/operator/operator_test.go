@@ -0,0 +1,284 @@
+package operator
+
+import (
+	"encoding/json"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/ohowland/cgc_core/internal/pkg/asset/ess"
+	"github.com/ohowland/cgc_core/internal/pkg/asset/grid"
+	"github.com/ohowland/cgc_core/internal/pkg/bus/ac"
+	"github.com/ohowland/cgc_core/internal/pkg/control"
+	"github.com/ohowland/cgc_core/internal/pkg/mocksystem"
+	"github.com/ohowland/cgc_core/internal/pkg/msg"
+	"gotest.tools/assert"
+)
+
+func request(t *testing.T, target uuid.UUID, payload interface{}) msg.Msg {
+	data, err := json.Marshal(msg.New(uuid.New(), msg.Control, payload).WithTarget(target))
+	assert.NilError(t, err)
+
+	m := msg.Msg{}
+	assert.NilError(t, json.Unmarshal(data, &m))
+	return m
+}
+
+func TestControl(t *testing.T) {
+	system := mocksystem.New()
+	op, err := New(system)
+	assert.NilError(t, err)
+
+	dispatch := uuid.New()
+	err = system.RequestControl(dispatch, control.Dispatch, make(chan msg.Msg))
+	assert.NilError(t, err)
+
+	target := uuid.New()
+	err = op.Control(request(t, target, ess.MachineControl{Run: true, KW: 25}))
+	assert.NilError(t, err)
+	assert.Assert(t, op.Holding())
+
+	owner, priority := system.OwnerOf(target)
+	assert.Equal(t, owner, op.PID())
+	assert.Equal(t, priority, control.Operator)
+
+	select {
+	case m := <-system.Written:
+		assert.Equal(t, m.Target(), target)
+		assert.Equal(t, m.Payload(), ess.MachineControl{Run: true, KW: 25})
+	case <-time.After(time.Second):
+		t.Fatal("operator control was not written")
+	}
+
+	owner, _ = system.Owner()
+	assert.Equal(t, owner, dispatch, "the dispatcher keeps control of the other targets")
+
+	assert.NilError(t, op.Release())
+	owner, _ = system.OwnerOf(target)
+	assert.Equal(t, owner, dispatch)
+	assert.Equal(t, op.Commanded(target), nil, "a released control is not merged onto")
+}
+
+func TestControlCompetingOperator(t *testing.T) {
+	system := mocksystem.New()
+	first, err := New(system)
+	assert.NilError(t, err)
+	second, err := New(system)
+	assert.NilError(t, err)
+
+	target := uuid.New()
+	assert.NilError(t, first.Control(request(t, target, ess.MachineControl{Run: true})))
+	<-system.Written
+
+	err = second.Control(request(t, target, ess.MachineControl{}))
+	assert.Equal(t, err, control.ErrDenied)
+	assert.Assert(t, !second.Holding())
+
+	assert.NilError(t, second.Control(request(t, uuid.New(), ess.MachineControl{})))
+	<-system.Written
+}
+
+func TestControlLeaseExpires(t *testing.T) {
+	system := mocksystem.New()
+	op, err := NewWithTiming(system, 50*time.Millisecond, time.Second)
+	assert.NilError(t, err)
+
+	target := uuid.New()
+	assert.NilError(t, op.Control(request(t, target, ess.MachineControl{Run: true})))
+	<-system.Written
+	assert.Assert(t, op.Holding())
+
+	time.Sleep(100 * time.Millisecond)
+	assert.Assert(t, !op.Holding())
+	owner, _ := system.OwnerOf(target)
+	assert.Equal(t, owner, uuid.UUID{})
+	assert.Equal(t, op.Commanded(target), nil)
+}
+
+// stalledSystem grants control, but never reads the control channels
+type stalledSystem struct {
+	*mocksystem.System
+}
+
+func (s stalledSystem) RequestTargetControl(uuid.UUID, control.Priority, uuid.UUID, <-chan msg.Msg) error {
+	return nil
+}
+
+func (s stalledSystem) ReleaseControl(uuid.UUID) error {
+	return nil
+}
+
+func TestControlStalledTarget(t *testing.T) {
+	op, err := NewWithTiming(stalledSystem{mocksystem.New()}, DefaultLease, 10*time.Millisecond)
+	assert.NilError(t, err)
+
+	target := uuid.New()
+	done := make(chan error)
+	go func() {
+		for _, kw := range []float64{10, 20, 30} {
+			if err := op.Control(request(t, target, ess.MachineControl{KW: kw})); err != nil {
+				done <- err
+				return
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+		done <- op.Release()
+	}()
+
+	select {
+	case err := <-done:
+		assert.NilError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("operator blocked on a target that does not read its control")
+	}
+	assert.Assert(t, !op.Holding())
+}
+
+type relay struct{}
+
+func (r relay) Hz() float64    { return 60 }
+func (r relay) Volts() float64 { return 480 }
+
+type gridDevice struct {
+	mux     sync.Mutex
+	written []grid.MachineControl
+}
+
+func (d *gridDevice) ReadDeviceStatus() (grid.MachineStatus, error) {
+	return grid.MachineStatus{}, nil
+}
+
+func (d *gridDevice) WriteDeviceControl(c grid.MachineControl) error {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	d.written = append(d.written, c)
+	return nil
+}
+
+func (d *gridDevice) Stop() error { return nil }
+
+func (d *gridDevice) last() grid.MachineControl {
+	d.mux.Lock()
+	defer d.mux.Unlock()
+	return d.written[len(d.written)-1]
+}
+
+func TestControlHeldPastControlTimeout(t *testing.T) {
+	device := &gridDevice{}
+	config := `{"Name": "grid", "BusName": "Bus", "ControlTimeout": 100, "FailSafe": {"CloseIntertie": true}}`
+	g, err := grid.New([]byte(config), device)
+	assert.NilError(t, err)
+	b, err := ac.New([]byte(`{"Name": "Bus"}`), relay{})
+	assert.NilError(t, err)
+	assert.NilError(t, b.AddMember(&g))
+
+	// the dispatcher holds the bus, but its control to the grid is overridden
+	dispatch := uuid.New()
+	assert.NilError(t, b.RequestControl(dispatch, control.Dispatch, make(chan msg.Msg)))
+
+	op, err := NewWithTiming(&b, DefaultLease, 20*time.Millisecond)
+	assert.NilError(t, err)
+	defer op.Release()
+	assert.NilError(t, op.Control(request(t, g.PID(), grid.MachineControl{CloseIntertie: false})))
+
+	time.Sleep(500 * time.Millisecond)
+	assert.Equal(t, device.last(), grid.MachineControl{CloseIntertie: false}, "the fail-safe was written over the operator control")
+}
+
+func TestControlUnsupportedType(t *testing.T) {
+	op, err := New(mocksystem.New())
+	assert.NilError(t, err)
+
+	err = op.Control(request(t, uuid.New(), struct{ KW float64 }{10}))
+	assert.ErrorContains(t, err, "unsupported control type")
+	assert.Assert(t, !op.Holding())
+}
+
+func TestControlNoTarget(t *testing.T) {
+	op, err := New(mocksystem.New())
+	assert.NilError(t, err)
+
+	err = op.Control(msg.New(uuid.New(), msg.Control, ess.MachineControl{}))
+	assert.ErrorContains(t, err, "no target")
+}
+
+func TestNewRequest(t *testing.T) {
+	target := uuid.New()
+	fields := map[string]interface{}{"Run": true, "KW": 10.0, "KVAR": 0.0, "Gridform": false}
+	request, err := NewRequest(target, "ess.MachineControl", fields)
+	assert.NilError(t, err)
+	assert.Equal(t, request.Target(), target)
+
+	ctrl, err := DecodeControl(request)
+	assert.NilError(t, err)
+	assert.Equal(t, ctrl, ess.MachineControl{Run: true, KW: 10})
+
+	request, err = NewRequest(target, "ess.MachineControl", map[string]interface{}{"KW": 10.0})
+	assert.NilError(t, err)
+	_, err = DecodeControl(request)
+	assert.Error(t, err, "ess.MachineControl omits Run, KVAR, Gridform")
+}
+
+func TestFieldKind(t *testing.T) {
+	kind, err := FieldKind("grid.MachineControl", "CloseIntertie")
+	assert.NilError(t, err)
+	assert.Equal(t, kind, reflect.Bool)
+
+	_, err = FieldKind("ess.MachineControl", "Volts")
+	assert.Error(t, err, "ess.MachineControl has no field Volts")
+
+	_, err = FieldKind("ess.Status", "KW")
+	assert.Error(t, err, "unsupported control type ess.Status")
+}
+
+func TestParseControl(t *testing.T) {
+	c, err := ParseControl("ess.MachineControl", []byte(`{"Run": true, "KW": 25, "kvar": 0, "Gridform": false}`))
+	assert.NilError(t, err)
+	assert.Equal(t, c, ess.MachineControl{Run: true, KW: 25})
+
+	_, err = ParseControl("ess.MachineControl", []byte(`{"KW": 10}`))
+	assert.Error(t, err, "ess.MachineControl omits Run, KVAR, Gridform")
+
+	_, err = ParseControl("ess.MachineControl", []byte(`{"Volts": 480}`))
+	assert.Error(t, err, `json: unknown field "Volts"`)
+
+	_, err = ParseControl("ess.MachineControl", []byte(`{"KW": "high"}`))
+	assert.ErrorContains(t, err, "cannot unmarshal string")
+
+	_, err = ParseControl("ess.Status", []byte(`{}`))
+	assert.Error(t, err, "unsupported control type ess.Status")
+}
+
+func TestMergeControl(t *testing.T) {
+	base := ess.MachineControl{Run: true, KW: 25, Gridform: true}
+	c, err := MergeControl(base, "ess.MachineControl", []byte(`{"KW": 10}`))
+	assert.NilError(t, err)
+	assert.Equal(t, c, ess.MachineControl{Run: true, KW: 10, Gridform: true})
+
+	_, err = MergeControl(grid.MachineControl{}, "ess.MachineControl", []byte(`{"KW": 10}`))
+	assert.Error(t, err, "ess.MachineControl omits Run, KVAR, Gridform")
+}
+
+func TestControlMergesOntoCommanded(t *testing.T) {
+	system := mocksystem.New()
+	op, err := NewWithTiming(system, 0, 0)
+	assert.NilError(t, err)
+
+	target := uuid.New()
+	partial, err := NewRequest(target, "ess.MachineControl", map[string]interface{}{"KW": 10.0})
+	assert.NilError(t, err)
+	assert.Error(t, op.Control(partial), "ess.MachineControl omits Run, KVAR, Gridform")
+
+	assert.NilError(t, op.Control(request(t, target, ess.MachineControl{Run: true, KW: 25})))
+	<-system.Written
+	assert.NilError(t, op.Control(partial))
+	select {
+	case m := <-system.Written:
+		assert.Equal(t, m.Payload(), ess.MachineControl{Run: true, KW: 10})
+	case <-time.After(time.Second):
+		t.Fatal("operator control was not written")
+	}
+	assert.Equal(t, op.Commanded(target), ess.MachineControl{Run: true, KW: 10})
+}
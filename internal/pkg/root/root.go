@@ -1,8 +1,11 @@
 package root
 
 import (
+	"sync"
+
 	"github.com/google/uuid"
 	"github.com/ohowland/cgc_core/internal/pkg/bus"
+	"github.com/ohowland/cgc_core/internal/pkg/control"
 	"github.com/ohowland/cgc_core/internal/pkg/dispatch"
 	"github.com/ohowland/cgc_core/internal/pkg/msg"
 )
@@ -32,7 +35,7 @@ func NewSystem(g *bus.BusGraph, d dispatch.Dispatcher) (System, error) {
 		}
 	}(chStatus)
 
-	chConfig, err := g.Subscribe(pid, msg.Config)
+	chConfig, err := g.SubscribeFiltered(pid, configSubscription)
 	if err != nil {
 		panic(err)
 	}
@@ -59,16 +62,22 @@ func (s *System) setDispatch(d dispatch.Dispatcher) error {
 	}
 
 	// request control of the root bus for dispatch
-	err = s.busGraph.RequestControl(s.pid, ch)
+	err = s.busGraph.RequestControl(s.pid, control.Dispatch, ch)
 	if err != nil {
 		return err
 	}
 
-	// subscribe Dispatch to the system status
-	chControl, err := s.Subscribe(d.PID(), msg.Status)
+	// subscribe Dispatch to the system status and config. Status is lossy, but each
+	// config is published once, and names the member for the dispatch configuration
+	chStatus, err := s.Subscribe(d.PID(), msg.Status)
+	if err != nil {
+		panic(err)
+	}
+	chConfig, err := s.SubscribeFiltered(d.PID(), configSubscription)
 	if err != nil {
 		panic(err)
 	}
+	chControl := merge(chStatus, chConfig)
 
 	// start the dispatch process
 	// TODO: this seems too tightly coupled
@@ -80,14 +89,75 @@ func (s *System) setDispatch(d dispatch.Dispatcher) error {
 	return nil
 }
 
+// configSubscription delivers every config message. Config is published once, when a
+// node joins the system, so a dropped config is never replaced.
+var configSubscription = msg.Subscription{
+	Topics: []msg.Topic{msg.Config},
+	Policy: msg.Policy{Buffer: 16, Delivery: msg.Guaranteed},
+}
+
+// merge returns a channel that receives the messages of both channels. The channel is
+// closed once both are closed.
+func merge(a <-chan msg.Msg, b <-chan msg.Msg) <-chan msg.Msg {
+	out := make(chan msg.Msg)
+	var wg sync.WaitGroup
+	forward := func(ch <-chan msg.Msg) {
+		defer wg.Done()
+		for m := range ch {
+			out <- m
+		}
+	}
+	wg.Add(2)
+	go forward(a)
+	go forward(b)
+	go func() {
+		wg.Wait()
+		close(out)
+	}()
+	return out
+}
+
 func (s *System) Subscribe(pid uuid.UUID, topic msg.Topic) (<-chan msg.Msg, error) {
 	return s.publisher.Subscribe(pid, topic)
 }
 
+// SubscribeFiltered returns a channel on which the subscribed topics are broadcast
+// when they pass the subscription filter
+func (s *System) SubscribeFiltered(pid uuid.UUID, sub msg.Subscription) (<-chan msg.Msg, error) {
+	return s.publisher.SubscribeFiltered(pid, sub)
+}
+
 func (s *System) Unsubscribe(pid uuid.UUID) {
 	s.publisher.Unsubscribe(pid)
 }
 
+// RequestControl asks for control of the root bus. An operator requesting control at
+// a higher priority than dispatch overrides the dispatcher until released.
+func (s *System) RequestControl(pid uuid.UUID, priority control.Priority, ch <-chan msg.Msg) error {
+	return s.busGraph.RequestControl(pid, priority, ch)
+}
+
+// RequestTargetControl asks for control of the messages to a single target. An operator
+// overriding one asset leaves the dispatcher in control of the others.
+func (s *System) RequestTargetControl(pid uuid.UUID, priority control.Priority, target uuid.UUID, ch <-chan msg.Msg) error {
+	return s.busGraph.RequestTargetControl(pid, priority, target, ch)
+}
+
+// ReleaseControl gives up control of the root bus
+func (s *System) ReleaseControl(pid uuid.UUID) error {
+	return s.busGraph.ReleaseControl(pid)
+}
+
+// Topology returns the tree of buses and assets of the system
+func (s *System) Topology() bus.Topology {
+	return s.busGraph.Topology()
+}
+
+// Stats returns the delivery state of the subscribers to the system
+func (s *System) Stats() []msg.SubscriberStats {
+	return s.publisher.Stats()
+}
+
 func (s *System) Shutdown() {
 	s.publisher.Stop()
 }
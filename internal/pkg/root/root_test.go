@@ -1,17 +1,133 @@
 package root
 
 import (
+	"fmt"
+	"io/ioutil"
+	"os"
 	"testing"
+	"time"
 
+	"github.com/google/uuid"
+	"github.com/ohowland/cgc_core/internal/pkg/asset"
+	"github.com/ohowland/cgc_core/internal/pkg/asset/ess"
+	"github.com/ohowland/cgc_core/internal/pkg/asset/feeder"
+	"github.com/ohowland/cgc_core/internal/pkg/asset/grid"
 	"github.com/ohowland/cgc_core/internal/pkg/bus"
+	"github.com/ohowland/cgc_core/internal/pkg/bus/ac"
+	"github.com/ohowland/cgc_core/internal/pkg/dispatch/lpdispatch"
 	"github.com/ohowland/cgc_core/internal/pkg/dispatch/mockdispatch"
 	"gotest.tools/assert"
 )
 
 func TestNewRootSystem(t *testing.T) {
-	bg, err := bus.NewBusGraph()
+	root, err := ac.New([]byte(`{"Name": "Bus"}`), relay{})
+	assert.NilError(t, err)
+	bg, err := bus.BuildBusGraph(&root, map[uuid.UUID]bus.Bus{}, map[uuid.UUID]asset.Asset{})
 	assert.NilError(t, err)
 
 	_, err = NewSystem(&bg, mockdispatch.NewMockDispatch())
 	assert.NilError(t, err)
 }
+
+type relay struct{}
+
+func (r relay) Hz() float64    { return 60 }
+func (r relay) Volts() float64 { return 480 }
+
+type essDevice struct {
+	written chan ess.MachineControl
+}
+
+func (d essDevice) ReadDeviceStatus() (ess.MachineStatus, error) {
+	return ess.MachineStatus{RealPositiveCapacity: 100, RealNegativeCapacity: 100, Online: true}, nil
+}
+
+func (d essDevice) WriteDeviceControl(c ess.MachineControl) error {
+	d.written <- c
+	return nil
+}
+
+func (d essDevice) Stop() error { return nil }
+
+type gridDevice struct{}
+
+func (d gridDevice) ReadDeviceStatus() (grid.MachineStatus, error) {
+	return grid.MachineStatus{RealPositiveCapacity: 100, RealNegativeCapacity: 100, Online: true}, nil
+}
+
+func (d gridDevice) WriteDeviceControl(grid.MachineControl) error { return nil }
+func (d gridDevice) Stop() error                                  { return nil }
+
+type feederDevice struct{}
+
+func (d feederDevice) ReadDeviceStatus() (feeder.MachineStatus, error) {
+	return feeder.MachineStatus{KW: 40, Online: true}, nil
+}
+
+func (d feederDevice) WriteDeviceControl(feeder.MachineControl) error { return nil }
+func (d feederDevice) Stop() error                                    { return nil }
+
+// dispatchedKW builds an lp dispatched system of an ess, grid and 40 kW feeder and
+// returns the first ess setpoint.
+func dispatchedKW(t *testing.T, essCost float64, gridCost float64) float64 {
+	f, err := ioutil.TempFile("", "lpdispatch_config")
+	assert.NilError(t, err)
+	defer os.Remove(f.Name())
+	fmt.Fprintf(f, `{"Costs": {"ess": {"RealPositiveEnergyCost": %v}, "grid": {"RealPositiveEnergyCost": %v}}}`, essCost, gridCost)
+	f.Close()
+
+	root, err := ac.New([]byte(`{"Name": "Bus"}`), relay{})
+	assert.NilError(t, err)
+	written := make(chan ess.MachineControl, 10)
+	essAsset, err := ess.New([]byte(`{"Name": "ess", "BusName": "Bus"}`), essDevice{written})
+	assert.NilError(t, err)
+	gridAsset, err := grid.New([]byte(`{"Name": "grid", "BusName": "Bus"}`), gridDevice{})
+	assert.NilError(t, err)
+	feederAsset, err := feeder.New([]byte(`{"Name": "feeder", "BusName": "Bus"}`), feederDevice{})
+	assert.NilError(t, err)
+	assets := map[uuid.UUID]asset.Asset{
+		essAsset.PID():    &essAsset,
+		gridAsset.PID():   &gridAsset,
+		feederAsset.PID(): &feederAsset,
+	}
+	bg, err := bus.BuildBusGraph(&root, map[uuid.UUID]bus.Bus{}, assets)
+	assert.NilError(t, err)
+
+	d, err := lpdispatch.New(f.Name())
+	assert.NilError(t, err)
+	_, err = NewSystem(&bg, d)
+	assert.NilError(t, err)
+
+	for _, a := range assets {
+		a.UpdateConfig()
+	}
+
+	// status is lossy, so it is published each cycle as in cgc
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	timeout := time.After(10 * time.Second)
+	for {
+		select {
+		case <-ticker.C:
+			for _, a := range assets {
+				a.UpdateStatus()
+			}
+		case c := <-written:
+			return c.KW
+		case <-timeout:
+			t.Fatal("the ess was not dispatched")
+			return 0
+		}
+	}
+}
+
+func TestDispatchConfiguredCost(t *testing.T) {
+	t.Run("ess", func(t *testing.T) {
+		t.Parallel()
+		assert.Equal(t, dispatchedKW(t, 1, 2), 40.0)
+	})
+	t.Run("grid", func(t *testing.T) {
+		t.Parallel()
+		assert.Equal(t, dispatchedKW(t, 2, 1), 0.0)
+	})
+}
@@ -0,0 +1,170 @@
+/*
+site.go Declarative description of a microgrid site. A site file lists the buses,
+assets, dispatcher and datastreams that make up the control system, which allows
+one binary to run any site without a code change.
+*/
+
+package site
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+)
+
+// Config is the top level site configuration
+type Config struct {
+	Name        string             `json:"Name"`
+	RootBus     string             `json:"RootBus"`
+	Buses       []BusConfig        `json:"Buses"`
+	Assets      []AssetConfig      `json:"Assets"`
+	Dispatch    DispatchConfig     `json:"Dispatch"`
+	Datastreams []DatastreamConfig `json:"Datastreams"`
+}
+
+// BusConfig describes a single bus and its position in the bus topology.
+// Parent is the name of the bus this bus is a member of, and is empty for the root bus.
+type BusConfig struct {
+	Name   string `json:"Name"`
+	Type   string `json:"Type"`
+	Driver string `json:"Driver"`
+	Parent string `json:"Parent"`
+	Config string `json:"Config"`
+}
+
+// AssetConfig describes a single asset. The asset's bus is read from the asset configuration.
+type AssetConfig struct {
+	Archetype string `json:"Archetype"`
+	Driver    string `json:"Driver"`
+	Config    string `json:"Config"`
+}
+
+// DispatchConfig describes the dispatch strategy
+type DispatchConfig struct {
+	Type   string `json:"Type"`
+	Config string `json:"Config"`
+}
+
+// DatastreamConfig describes a datastream handler
+type DatastreamConfig struct {
+	Type   string `json:"Type"`
+	Config string `json:"Config"`
+}
+
+// ReadConfig loads and validates the site file at path. Relative component
+// configuration paths are resolved against the directory of the site file.
+func ReadConfig(path string) (Config, error) {
+	jsonConfig, err := ioutil.ReadFile(path)
+	if err != nil {
+		return Config{}, err
+	}
+
+	cfg, err := New(jsonConfig)
+	if err != nil {
+		return Config{}, err
+	}
+
+	cfg.resolvePaths(filepath.Dir(path))
+	return cfg, nil
+}
+
+// New parses and validates a site configuration
+func New(jsonConfig []byte) (Config, error) {
+	cfg := Config{}
+	err := json.Unmarshal(jsonConfig, &cfg)
+	if err != nil {
+		return Config{}, err
+	}
+
+	err = cfg.validate()
+	if err != nil {
+		return Config{}, err
+	}
+	return cfg, nil
+}
+
+// OrderedBuses returns the bus configurations ordered such that every bus
+// appears after its parent. The root bus is the first element.
+func (c Config) OrderedBuses() ([]BusConfig, error) {
+	ordered := make([]BusConfig, 0, len(c.Buses))
+	placed := make(map[string]bool)
+
+	for len(ordered) < len(c.Buses) {
+		n := len(ordered)
+		for _, bus := range c.Buses {
+			if placed[bus.Name] {
+				continue
+			}
+			if bus.Name == c.RootBus || placed[bus.Parent] {
+				ordered = append(ordered, bus)
+				placed[bus.Name] = true
+			}
+		}
+		if len(ordered) == n {
+			return nil, errors.New("site bus topology is not connected to the root bus")
+		}
+	}
+	return ordered, nil
+}
+
+func (c Config) validate() error {
+	if c.RootBus == "" {
+		return errors.New("site configuration does not name a root bus")
+	}
+
+	names := make(map[string]bool)
+	for _, bus := range c.Buses {
+		if bus.Name == "" {
+			return errors.New("site configuration contains a bus without a name")
+		}
+		if names[bus.Name] {
+			err := fmt.Sprintf("site configuration contains duplicate bus %v", bus.Name)
+			return errors.New(err)
+		}
+		names[bus.Name] = true
+	}
+
+	if !names[c.RootBus] {
+		err := fmt.Sprintf("site configuration does not contain root bus %v", c.RootBus)
+		return errors.New(err)
+	}
+
+	for _, bus := range c.Buses {
+		if bus.Name == c.RootBus {
+			if bus.Parent != "" {
+				err := fmt.Sprintf("root bus %v cannot have a parent", bus.Name)
+				return errors.New(err)
+			}
+			continue
+		}
+		if !names[bus.Parent] {
+			err := fmt.Sprintf("bus %v has unknown parent bus %v", bus.Name, bus.Parent)
+			return errors.New(err)
+		}
+	}
+
+	_, err := c.OrderedBuses()
+	return err
+}
+
+func (c *Config) resolvePaths(dir string) {
+	for i := range c.Buses {
+		c.Buses[i].Config = resolve(dir, c.Buses[i].Config)
+	}
+	for i := range c.Assets {
+		c.Assets[i].Config = resolve(dir, c.Assets[i].Config)
+	}
+	c.Dispatch.Config = resolve(dir, c.Dispatch.Config)
+	for i := range c.Datastreams {
+		c.Datastreams[i].Config = resolve(dir, c.Datastreams[i].Config)
+	}
+}
+
+func resolve(dir string, path string) string {
+	if path == "" || filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(dir, path)
+}
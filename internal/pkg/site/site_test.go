@@ -0,0 +1,73 @@
+package site
+
+import (
+	"testing"
+
+	"gotest.tools/assert"
+)
+
+func TestReadConfigFile(t *testing.T) {
+	cfg, err := ReadConfig("./site_test_config.json")
+	assert.NilError(t, err)
+
+	assert.Equal(t, cfg.Name, "TEST_Site")
+	assert.Equal(t, cfg.RootBus, "TEST_Bus-1")
+	assert.Assert(t, len(cfg.Buses) == 3)
+	assert.Assert(t, len(cfg.Assets) == 1)
+	assert.Assert(t, len(cfg.Datastreams) == 1)
+	assert.Equal(t, cfg.Dispatch.Type, "manual")
+}
+
+func TestResolvePaths(t *testing.T) {
+	cfg, err := ReadConfig("./site_test_config.json")
+	assert.NilError(t, err)
+
+	assert.Equal(t, cfg.Buses[0].Config, "bus/bus2.json")
+	assert.Equal(t, cfg.Buses[2].Config, "/etc/cgc/bus3.json")
+	assert.Equal(t, cfg.Assets[0].Config, "asset/ess.json")
+	assert.Equal(t, cfg.Dispatch.Config, "dispatch/manual.json")
+	assert.Equal(t, cfg.Datastreams[0].Config, "datastream/mongodb.json")
+
+	cfg.resolvePaths("/opt/site")
+	assert.Equal(t, cfg.Buses[0].Config, "/opt/site/bus/bus2.json")
+	assert.Equal(t, cfg.Buses[2].Config, "/etc/cgc/bus3.json")
+}
+
+func TestOrderedBuses(t *testing.T) {
+	cfg, err := ReadConfig("./site_test_config.json")
+	assert.NilError(t, err)
+
+	ordered, err := cfg.OrderedBuses()
+	assert.NilError(t, err)
+	assert.Assert(t, len(ordered) == 3)
+	assert.Equal(t, ordered[0].Name, "TEST_Bus-1")
+	assert.Equal(t, ordered[1].Name, "TEST_Bus-2")
+	assert.Equal(t, ordered[2].Name, "TEST_Bus-3")
+}
+
+func TestRejectMissingRootBus(t *testing.T) {
+	jsonConfig := []byte(`{"RootBus": "Bus-3", "Buses": [{"Name": "Bus-1"}]}`)
+	_, err := New(jsonConfig)
+	assert.Error(t, err, "site configuration does not contain root bus Bus-3")
+}
+
+func TestRejectDuplicateBus(t *testing.T) {
+	jsonConfig := []byte(`{"RootBus": "Bus-1", "Buses": [{"Name": "Bus-1"}, {"Name": "Bus-1"}]}`)
+	_, err := New(jsonConfig)
+	assert.Error(t, err, "site configuration contains duplicate bus Bus-1")
+}
+
+func TestRejectUnknownParent(t *testing.T) {
+	jsonConfig := []byte(`{"RootBus": "Bus-1", "Buses": [{"Name": "Bus-1"}, {"Name": "Bus-2", "Parent": "Bus-3"}]}`)
+	_, err := New(jsonConfig)
+	assert.Error(t, err, "bus Bus-2 has unknown parent bus Bus-3")
+}
+
+func TestRejectDisconnectedTopology(t *testing.T) {
+	jsonConfig := []byte(`{"RootBus": "Bus-1", "Buses": [
+		{"Name": "Bus-1"},
+		{"Name": "Bus-2", "Parent": "Bus-3"},
+		{"Name": "Bus-3", "Parent": "Bus-2"}]}`)
+	_, err := New(jsonConfig)
+	assert.Error(t, err, "site bus topology is not connected to the root bus")
+}
@@ -15,6 +15,7 @@ import (
 	"github.com/ohowland/cgc_core/internal/pkg/asset/feeder"
 	"github.com/ohowland/cgc_core/internal/pkg/asset/grid"
 	"github.com/ohowland/cgc_core/internal/pkg/bus/ac"
+	"github.com/ohowland/cgc_core/internal/pkg/control"
 	"github.com/ohowland/cgc_core/internal/pkg/msg"
 )
 
@@ -42,7 +43,7 @@ func TestSimpleVirtualBus(t *testing.T) {
 	read, err := bus1.Subscribe(pid, msg.Status)
 
 	write := make(chan msg.Msg)
-	bus1.RequestControl(pid, write)
+	bus1.RequestControl(pid, control.Dispatch, write)
 
 	go func(ess1 *ess.Asset) {
 		ticker := time.NewTicker(1 * time.Second)
@@ -62,7 +63,7 @@ func TestSimpleVirtualBus(t *testing.T) {
 		}
 	}(&ess1)
 
-	write <- msg.New(pid, msg.Control, msg.New(ess1.PID(), msg.Control, ess.MachineControl{Run: true, KW: 0.0, KVAR: 0.0, Gridform: true}))
+	write <- msg.New(pid, msg.Control, ess.MachineControl{Run: true, KW: 0.0, KVAR: 0.0, Gridform: true}).WithTarget(ess1.PID())
 
 	time.Sleep(5 * time.Second)
 }
@@ -130,14 +131,14 @@ func TestVirtualBusVirtualEss(t *testing.T) {
 	wPID, _ := uuid.NewUUID()
 	writer := make(chan msg.Msg)
 
-	bus1.RequestControl(wPID, writer)
+	bus1.RequestControl(wPID, control.Dispatch, writer)
 
-	writer <- msg.New(wPID, msg.Control, msg.New(ess1.PID(), msg.Control, ess.MachineControl{Run: true, KW: 0.0, KVAR: 0.0, Gridform: true}))
-	writer <- msg.New(wPID, msg.Control, msg.New(ess2.PID(), msg.Control, ess.MachineControl{Run: true, KW: 0.0, KVAR: 0.0, Gridform: false}))
-	writer <- msg.New(wPID, msg.Control, msg.New(ess3.PID(), msg.Control, ess.MachineControl{Run: true, KW: 0.0, KVAR: 0.0, Gridform: false}))
-	writer <- msg.New(wPID, msg.Control, msg.New(ess4.PID(), msg.Control, ess.MachineControl{Run: true, KW: 0.0, KVAR: 0.0, Gridform: false}))
-	writer <- msg.New(wPID, msg.Control, msg.New(ess5.PID(), msg.Control, ess.MachineControl{Run: true, KW: 0.0, KVAR: 0.0, Gridform: false}))
-	writer <- msg.New(wPID, msg.Control, msg.New(ess6.PID(), msg.Control, ess.MachineControl{Run: true, KW: 0.0, KVAR: 0.0, Gridform: false}))
+	writer <- msg.New(wPID, msg.Control, ess.MachineControl{Run: true, KW: 0.0, KVAR: 0.0, Gridform: true}).WithTarget(ess1.PID())
+	writer <- msg.New(wPID, msg.Control, ess.MachineControl{Run: true, KW: 0.0, KVAR: 0.0, Gridform: false}).WithTarget(ess2.PID())
+	writer <- msg.New(wPID, msg.Control, ess.MachineControl{Run: true, KW: 0.0, KVAR: 0.0, Gridform: false}).WithTarget(ess3.PID())
+	writer <- msg.New(wPID, msg.Control, ess.MachineControl{Run: true, KW: 0.0, KVAR: 0.0, Gridform: false}).WithTarget(ess4.PID())
+	writer <- msg.New(wPID, msg.Control, ess.MachineControl{Run: true, KW: 0.0, KVAR: 0.0, Gridform: false}).WithTarget(ess5.PID())
+	writer <- msg.New(wPID, msg.Control, ess.MachineControl{Run: true, KW: 0.0, KVAR: 0.0, Gridform: false}).WithTarget(ess6.PID())
 
 	time.Sleep(1 * time.Second)
 
@@ -200,15 +201,15 @@ func TestVirtualBusAllAssets(t *testing.T) {
 
 	wpid, _ := uuid.NewUUID()
 	essWriter := make(chan msg.Msg)
-	_ = ess1.RequestControl(wpid, essWriter)
+	_ = ess1.RequestControl(wpid, control.Operator, essWriter)
 	essWriter <- msg.New(wpid, msg.Control, ess.MachineControl{Run: true, KW: kwSp, KVAR: 0.0, Gridform: false})
 
 	feederWriter := make(chan msg.Msg)
-	_ = feeder1.RequestControl(wpid, feederWriter)
+	_ = feeder1.RequestControl(wpid, control.Operator, feederWriter)
 	feederWriter <- msg.New(wpid, msg.Control, feeder.MachineControl{CloseFeeder: true})
 
 	gridWriter := make(chan msg.Msg)
-	_ = grid1.RequestControl(wpid, gridWriter)
+	_ = grid1.RequestControl(wpid, control.Operator, gridWriter)
 	gridWriter <- msg.New(wpid, msg.Control, grid.MachineControl{CloseIntertie: true})
 
 	pid, _ := uuid.NewUUID()
@@ -302,11 +303,11 @@ func TestDispatchCalculatedStatusAggregate(t *testing.T) {
 
 	wpid, _ := uuid.NewUUID()
 	essWriter := make(chan msg.Msg)
-	_ = ess1.RequestControl(wpid, essWriter)
+	_ = ess1.RequestControl(wpid, control.Operator, essWriter)
 	essWriter <- msg.New(wpid, ess.MachineControl{Run: true, KW: kwSp, KVAR: 0.0, Gridform: false})
 
 	gridWriter := make(chan msg.Msg)
-	_ = grid1.RequestControl(wpid, gridWriter)
+	_ = grid1.RequestControl(wpid, control.Operator, gridWriter)
 	gridWriter <- msg.New(wpid, grid.MachineControl{CloseIntertie: true})
 
 	//assert.Assert(t, memberStatus[ess1.PID()].(asset.Status).KW() == kwSp)